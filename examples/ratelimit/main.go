@@ -0,0 +1,61 @@
+// Command ratelimit runs a server whose rate limit is shared through
+// PostgreSQL. Start two instances on different ports against the same
+// database and the quota is enforced across both:
+//
+//	go run ./examples/ratelimit -addr :50051
+//	go run ./examples/ratelimit -addr :50052
+//	curl -i http://localhost:50051/ && curl -i http://localhost:50052/
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/sys-apps-go/gorouter/pkg/router"
+)
+
+func main() {
+	addr := flag.String("addr", ":50051", "Address to listen on")
+	host := flag.String("host", "localhost", "PostgreSQL host")
+	port := flag.Int("port", 5432, "PostgreSQL port")
+	user := flag.String("user", "postgres", "PostgreSQL user")
+	password := flag.String("password", "postgres", "PostgreSQL password")
+	dbName := flag.String("db", "postgres", "PostgreSQL database")
+	rate := flag.Int("rate", 5, "Requests allowed per period")
+	period := flag.Duration("period", 10*time.Second, "Rate limit period")
+	flag.Parse()
+
+	err := router.InitDB(router.Config{
+		Host:     *host,
+		Port:     *port,
+		User:     *user,
+		Password: *password,
+		DBName:   *dbName,
+		SSLMode:  "disable",
+	})
+	if err != nil {
+		log.Fatalf("Error connecting to database: %v", err)
+	}
+	defer router.GetDB().Close()
+
+	store, err := router.NewPostgresRateLimitStore(router.GetDB(), router.PostgresRateLimitConfig{})
+	if err != nil {
+		log.Fatalf("Error creating rate limit store: %v", err)
+	}
+	defer store.Close()
+
+	r := router.NewRouter()
+	r.Use(router.RateLimit(router.RateLimitConfig{
+		Limit: router.Limit{Algorithm: router.SlidingWindow, Rate: *rate, Period: *period},
+		Store: store,
+	}))
+	r.GET("/", func(c *router.Context) {
+		c.String(http.StatusOK, "Hello from %s\n", *addr)
+	})
+
+	fmt.Printf("Server is running on http://localhost%s\n", *addr)
+	log.Fatal(http.ListenAndServe(*addr, r))
+}
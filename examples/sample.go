@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"net/http"
@@ -25,6 +26,11 @@ type User struct {
 }
 
 var UserList []User
+
+var (
+	errInvalidJSON   = router.NewHTTPError(http.StatusBadRequest, "Invalid JSON")
+	errInvalidPostID = router.NewHTTPError(http.StatusBadRequest, "Invalid post ID")
+)
 var semaphore chan struct{}
 var getCount int
 
@@ -57,13 +63,20 @@ func main() {
 		posts := api.Group("/posts")
 		{
 			posts.GET("", listPosts)
-			posts.POST("", createPost)
-			posts.GET("/:id", getPost)
+			// Retries carrying the same Idempotency-Key get the first response
+			// instead of creating the post again
+			posts.POST("", router.Idempotency(router.IdempotencyConfig{}), createPost)
+			posts.GET("/:id", router.Typed(getPost)).Doc("Get a post", "Returns a single post by ID").Tag("posts")
 			posts.PUT("/:id", updatePost)
 			posts.DELETE("/:id", deletePost)
 		}
 	}
 
+	// API documentation at /docs, generated from the routes above
+	routerTest.ServeOpenAPI(router.OpenAPIConfig{
+		Info: router.OpenAPIInfo{Title: "Sample API", Version: "1.0.0"},
+	})
+
 	routerTest.PrintRoutes()
 	u := User{
 		Name: "John Doe",
@@ -95,20 +108,18 @@ func listUsers(c *router.Context) {
 	})
 }
 
-func createUser(c *router.Context) {
+func createUser(c *router.Context) error {
 	var newUser User
 
 	// Parse the JSON body
 	err := json.NewDecoder(c.Request.Body).Decode(&newUser)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid JSON"})
-		return
+		return errInvalidJSON
 	}
 
 	// Validate the new user data
 	if newUser.Name == "" || newUser.EMail == "" {
-		c.JSON(http.StatusBadRequest, map[string]string{"error": "Name and email are required"})
-		return
+		return router.NewHTTPError(http.StatusBadRequest, "Name and email are required")
 	}
 
 	// Append the new user to UserList
@@ -116,6 +127,7 @@ func createUser(c *router.Context) {
 
 	// Respond with success message
 	c.JSON(http.StatusCreated, map[string]string{"message": "User created successfully"})
+	return nil
 }
 
 func getUser(c *router.Context) {
@@ -129,21 +141,21 @@ func getUser(c *router.Context) {
 	c.JSON(http.StatusNotFound, map[string]string{"message": fmt.Sprintf("User %s not found", id)})
 }
 
-func updateUser(c *router.Context) {
+func updateUser(c *router.Context) error {
 	id := c.Param("id")
 	var updatedUser User
 	if err := json.NewDecoder(c.Request.Body).Decode(&updatedUser); err != nil {
-		c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid JSON"})
-		return
+		return errInvalidJSON
 	}
 	for i, user := range UserList {
 		if user.EMail == id {
 			UserList[i] = updatedUser
 			c.JSON(http.StatusOK, map[string]string{"message": fmt.Sprintf("User %s updated", id)})
-			return
+			return nil
 		}
 	}
 	c.JSON(http.StatusNotFound, map[string]string{"message": fmt.Sprintf("User %s not found", id)})
+	return nil
 }
 
 func deleteUser(c *router.Context) {
@@ -165,70 +177,67 @@ func listPosts(c *router.Context) {
 	})
 }
 
-func createPost(c *router.Context) {
+func createPost(c *router.Context) error {
 	var newPost Post
 	if err := json.NewDecoder(c.Request.Body).Decode(&newPost); err != nil {
-		c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid JSON"})
-		return
+		return errInvalidJSON
 	}
 	lastPostID++
 	newPost.ID = lastPostID
 	PostList = append(PostList, newPost)
 	c.JSON(http.StatusCreated, map[string]string{"message": "Post created", "id": fmt.Sprintf("%d", newPost.ID)})
+	return nil
 }
 
-func getPost(c *router.Context) {
-	id := c.Param("id")
-	postID, err := strconv.Atoi(id)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid post ID"})
-		return
-	}
+// getPostRequest is bound from the URL by router.Typed
+type getPostRequest struct {
+	ID int `path:"id" validate:"min=1"`
+}
+
+func getPost(ctx context.Context, req getPostRequest) (Post, error) {
 	for _, post := range PostList {
-		if post.ID == postID {
-			c.JSON(http.StatusOK, post)
-			return
+		if post.ID == req.ID {
+			return post, nil
 		}
 	}
-	c.JSON(http.StatusNotFound, map[string]string{"message": fmt.Sprintf("Post %s not found", id)})
+	return Post{}, router.NewHTTPError(http.StatusNotFound, fmt.Sprintf("Post %d not found", req.ID))
 }
 
-func updatePost(c *router.Context) {
+func updatePost(c *router.Context) error {
 	id := c.Param("id")
 	postID, err := strconv.Atoi(id)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid post ID"})
-		return
+		return errInvalidPostID
 	}
 	var updatedPost Post
 	if err := json.NewDecoder(c.Request.Body).Decode(&updatedPost); err != nil {
-		c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid JSON"})
-		return
+		return errInvalidJSON
 	}
 	for i, post := range PostList {
 		if post.ID == postID {
 			updatedPost.ID = postID
 			PostList[i] = updatedPost
 			c.JSON(http.StatusOK, map[string]string{"message": fmt.Sprintf("Post %s updated", id)})
-			return
+			return nil
 		}
 	}
 	c.JSON(http.StatusNotFound, map[string]string{"message": fmt.Sprintf("Post %s not found", id)})
+	return nil
 }
 
-func deletePost(c *router.Context) {
+func deletePost(c *router.Context) error {
 	id := c.Param("id")
 	postID, err := strconv.Atoi(id)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid post ID"})
-		return
+		return errInvalidPostID
 	}
 	for i, post := range PostList {
 		if post.ID == postID {
 			PostList = append(PostList[:i], PostList[i+1:]...)
 			c.JSON(http.StatusOK, map[string]string{"message": fmt.Sprintf("Post %s deleted", id)})
-			return
+			return nil
 		}
 	}
 	c.JSON(http.StatusNotFound, map[string]string{"message": fmt.Sprintf("Post %s not found", id)})
+	return nil
 }
@@ -0,0 +1,393 @@
+package router
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// AuthUserKey is the Context key under which BasicAuth, APIKey and
+// HMACAuth store the authenticated user, principal or key ID
+const AuthUserKey = "AuthUser"
+
+// ErrInvalidCredentials is returned by credential lookups for unknown
+// users or keys. Other lookup errors are passed to the error handler.
+var ErrInvalidCredentials = errors.New("invalid credentials")
+
+// AuthUser returns the user stored by an authentication middleware, or ""
+func (c *Context) AuthUser() string {
+	return c.GetString(AuthUserKey)
+}
+
+// secureCompare compares two secrets in constant time. Both are hashed
+// first so the comparison does not leak their lengths either.
+func secureCompare(given, expected string) bool {
+	a := sha256.Sum256([]byte(given))
+	b := sha256.Sum256([]byte(expected))
+	return subtle.ConstantTimeCompare(a[:], b[:]) == 1
+}
+
+// unauthorized sends a 401 with a WWW-Authenticate challenge
+func unauthorized(c *Context, challenge, message string) {
+	c.SetHeader("WWW-Authenticate", challenge)
+	c.Error(NewHTTPError(http.StatusUnauthorized, message))
+}
+
+// BasicAuthConfig configures BasicAuthWithConfig
+type BasicAuthConfig struct {
+	// Users maps user names to passwords
+	Users map[string]string
+	// Validator checks credentials for users not in Users, for example
+	// against a password hash in a database
+	Validator func(c *Context, user, password string) bool
+	// Realm is sent in the challenge, default "Restricted"
+	Realm string
+	// Skip exempts requests from authentication when it returns true
+	Skip func(*Context) bool
+}
+
+// BasicAuth is a middleware that requires HTTP Basic authentication with
+// one of the given user names and passwords
+func BasicAuth(users map[string]string) MiddlewareFunc {
+	return BasicAuthWithConfig(BasicAuthConfig{Users: users})
+}
+
+// BasicAuthWithConfig is a middleware that implements HTTP Basic
+// authentication. Passwords are compared in constant time. The user name
+// is available with c.AuthUser().
+func BasicAuthWithConfig(config BasicAuthConfig) MiddlewareFunc {
+	if config.Users == nil && config.Validator == nil {
+		panic("router: BasicAuth requires Users or a Validator")
+	}
+	if config.Realm == "" {
+		config.Realm = "Restricted"
+	}
+	challenge := fmt.Sprintf("Basic realm=%q, charset=\"UTF-8\"", config.Realm)
+
+	return func(next HandlerFunc) HandlerFunc {
+		return func(c *Context) {
+			if config.Skip != nil && config.Skip(c) {
+				next(c)
+				return
+			}
+			user, password, ok := c.Request.BasicAuth()
+			if !ok {
+				unauthorized(c, challenge, "authentication required")
+				return
+			}
+			expected, known := config.Users[user]
+			// Compare even for unknown users so timing does not reveal them
+			valid := secureCompare(password, expected) && known
+			if !valid && !known && config.Validator != nil {
+				valid = config.Validator(c, user, password)
+			}
+			if !valid {
+				unauthorized(c, challenge, "invalid credentials")
+				return
+			}
+			c.Set(AuthUserKey, user)
+			next(c)
+		}
+	}
+}
+
+// APIKeyLookupFunc returns the principal owning an API key, or
+// ErrInvalidCredentials if the key is unknown
+type APIKeyLookupFunc func(ctx context.Context, key string) (string, error)
+
+// StaticAPIKeys returns a lookup for a fixed map of API keys to principals.
+// Keys are compared in constant time.
+func StaticAPIKeys(keys map[string]string) APIKeyLookupFunc {
+	return func(ctx context.Context, key string) (string, error) {
+		principal := ""
+		found := false
+		for k, p := range keys {
+			if secureCompare(key, k) {
+				principal, found = p, true
+			}
+		}
+		if !found {
+			return "", ErrInvalidCredentials
+		}
+		return principal, nil
+	}
+}
+
+// APIKeyConfig configures APIKeyWithConfig
+type APIKeyConfig struct {
+	// Lookup resolves a key to its principal
+	Lookup APIKeyLookupFunc
+	// Header carries the key, default X-API-Key
+	Header string
+	// Query names a query parameter that may carry the key instead. Keys in
+	// URLs end up in logs, so it is disabled by default.
+	Query string
+	// Realm is sent in the challenge, default "api"
+	Realm string
+	// Skip exempts requests from authentication when it returns true
+	Skip func(*Context) bool
+}
+
+// APIKey is a middleware that requires a known key in the X-API-Key header
+func APIKey(lookup APIKeyLookupFunc) MiddlewareFunc {
+	return APIKeyWithConfig(APIKeyConfig{Lookup: lookup})
+}
+
+// APIKeyWithConfig is a middleware that authenticates requests with an API
+// key. The principal returned by the lookup is available with c.AuthUser().
+func APIKeyWithConfig(config APIKeyConfig) MiddlewareFunc {
+	if config.Lookup == nil {
+		panic("router: APIKey requires a Lookup")
+	}
+	if config.Header == "" {
+		config.Header = "X-API-Key"
+	}
+	if config.Realm == "" {
+		config.Realm = "api"
+	}
+	challenge := fmt.Sprintf("APIKey realm=%q, header=%q", config.Realm, config.Header)
+	extract := KeyByAPIKey(config.Header, config.Query)
+
+	return func(next HandlerFunc) HandlerFunc {
+		return func(c *Context) {
+			if config.Skip != nil && config.Skip(c) {
+				next(c)
+				return
+			}
+			key := extract(c)
+			if key == "" {
+				unauthorized(c, challenge, "API key required")
+				return
+			}
+			principal, err := config.Lookup(c.Request.Context(), key)
+			if errors.Is(err, ErrInvalidCredentials) {
+				unauthorized(c, challenge, "invalid API key")
+				return
+			}
+			if err != nil {
+				c.Error(err)
+				return
+			}
+			c.Set(AuthUserKey, principal)
+			next(c)
+		}
+	}
+}
+
+// HMACScheme is the Authorization scheme of HMAC signed requests
+const HMACScheme = "HMAC-SHA256"
+
+// HMACSecretFunc returns the shared secret of a key ID, or
+// ErrInvalidCredentials if the key ID is unknown
+type HMACSecretFunc func(ctx context.Context, keyID string) ([]byte, error)
+
+// HMACAuthConfig configures HMACAuth
+type HMACAuthConfig struct {
+	// Secret resolves key IDs to shared secrets
+	Secret HMACSecretFunc
+	// RequiredHeaders lists headers every signature must cover in addition
+	// to host
+	RequiredHeaders []string
+	// MaxSkew is how far the signature timestamp may be from the server
+	// clock, default five minutes. It bounds the window for replays.
+	MaxSkew time.Duration
+	// MaxBodySize is the largest body that is read to verify its digest,
+	// default 10 MB
+	MaxBodySize int64
+	// Realm is sent in the challenge, default "api"
+	Realm string
+	// Skip exempts requests from authentication when it returns true
+	Skip func(*Context) bool
+}
+
+// HMACAuth is a middleware that verifies requests signed with SignRequest.
+// The Authorization header has the form
+//
+//	HMAC-SHA256 keyId="...", timestamp="<unix seconds>", headers="host content-type", signature="<hex>"
+//
+// where the signature is the HMAC-SHA256 of the canonical request: the
+// scheme, timestamp, method, escaped path, sorted query, each signed
+// header as name:value and the hex SHA-256 digest of the body, joined by
+// newlines. The key ID is available with c.AuthUser().
+func HMACAuth(config HMACAuthConfig) MiddlewareFunc {
+	if config.Secret == nil {
+		panic("router: HMACAuth requires a Secret")
+	}
+	if config.MaxSkew <= 0 {
+		config.MaxSkew = 5 * time.Minute
+	}
+	if config.MaxBodySize <= 0 {
+		config.MaxBodySize = 10 << 20
+	}
+	if config.Realm == "" {
+		config.Realm = "api"
+	}
+	required := append([]string{"host"}, config.RequiredHeaders...)
+	for i, h := range required {
+		required[i] = strings.ToLower(h)
+	}
+	challenge := fmt.Sprintf("%s realm=%q, headers=%q", HMACScheme, config.Realm, strings.Join(required, " "))
+
+	return func(next HandlerFunc) HandlerFunc {
+		return func(c *Context) {
+			if config.Skip != nil && config.Skip(c) {
+				next(c)
+				return
+			}
+			scheme, rest, _ := strings.Cut(c.GetHeader("Authorization"), " ")
+			if !strings.EqualFold(scheme, HMACScheme) {
+				unauthorized(c, challenge, "signature required")
+				return
+			}
+			params := parseAuthParams(rest)
+			keyID, signature := params["keyid"], params["signature"]
+			headers := strings.Fields(strings.ToLower(params["headers"]))
+			timestamp, err := strconv.ParseInt(params["timestamp"], 10, 64)
+			if keyID == "" || signature == "" || err != nil {
+				unauthorized(c, challenge, "malformed signature")
+				return
+			}
+			if skew := time.Since(time.Unix(timestamp, 0)); skew > config.MaxSkew || skew < -config.MaxSkew {
+				unauthorized(c, challenge, "signature timestamp outside the allowed window")
+				return
+			}
+			for _, h := range required {
+				if !containsExact(headers, h) {
+					unauthorized(c, challenge, "header not signed: "+h)
+					return
+				}
+			}
+
+			secret, err := config.Secret(c.Request.Context(), keyID)
+			if errors.Is(err, ErrInvalidCredentials) {
+				unauthorized(c, challenge, "invalid signature")
+				return
+			}
+			if err != nil {
+				c.Error(err)
+				return
+			}
+
+			body, err := readBody(c.Request, config.MaxBodySize)
+			if err != nil {
+				c.Error(err)
+				return
+			}
+			expected := signCanonical(secret, canonicalRequest(c.Request, body, timestamp, headers))
+			given, err := hex.DecodeString(signature)
+			if err != nil || !hmac.Equal(given, expected) {
+				unauthorized(c, challenge, "invalid signature")
+				return
+			}
+			c.Set(AuthUserKey, keyID)
+			next(c)
+		}
+	}
+}
+
+// SignRequest signs req for HMACAuth with the shared secret of keyID. The
+// host header and the given headers are signed. The body is read and
+// replaced so it can still be sent.
+func SignRequest(req *http.Request, keyID string, secret []byte, headers ...string) error {
+	body, err := readBody(req, -1)
+	if err != nil {
+		return err
+	}
+	signed := []string{"host"}
+	for _, h := range headers {
+		if h = strings.ToLower(h); h != "host" {
+			signed = append(signed, h)
+		}
+	}
+	timestamp := time.Now().Unix()
+	signature := signCanonical(secret, canonicalRequest(req, body, timestamp, signed))
+	req.Header.Set("Authorization", fmt.Sprintf("%s keyId=%q, timestamp=\"%d\", headers=%q, signature=%q",
+		HMACScheme, keyID, timestamp, strings.Join(signed, " "), hex.EncodeToString(signature)))
+	return nil
+}
+
+// readBody reads the request body and replaces it with a copy. A positive
+// limit rejects larger bodies with 413.
+func readBody(req *http.Request, limit int64) ([]byte, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil, nil
+	}
+	reader := io.Reader(req.Body)
+	if limit > 0 {
+		reader = io.LimitReader(req.Body, limit+1)
+	}
+	body, err := io.ReadAll(reader)
+	req.Body.Close()
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return nil, &HTTPError{Code: http.StatusRequestEntityTooLarge, Message: "request body too large", Err: err}
+	}
+	if err != nil {
+		return nil, WrapHTTPError(http.StatusBadRequest, err)
+	}
+	if limit > 0 && int64(len(body)) > limit {
+		return nil, NewHTTPError(http.StatusRequestEntityTooLarge, "request body too large")
+	}
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	return body, nil
+}
+
+// canonicalRequest builds the string that is signed for a request
+func canonicalRequest(req *http.Request, body []byte, timestamp int64, headers []string) string {
+	digest := sha256.Sum256(body)
+	query := req.URL.Query()
+	for _, values := range query {
+		sort.Strings(values)
+	}
+
+	var b strings.Builder
+	b.WriteString(HMACScheme + "\n")
+	b.WriteString(strconv.FormatInt(timestamp, 10) + "\n")
+	b.WriteString(req.Method + "\n")
+	b.WriteString(req.URL.EscapedPath() + "\n")
+	b.WriteString(query.Encode() + "\n")
+	for _, h := range headers {
+		value := req.Header.Get(h)
+		if h == "host" {
+			value = req.Host
+		}
+		b.WriteString(h + ":" + strings.TrimSpace(value) + "\n")
+	}
+	b.WriteString(hex.EncodeToString(digest[:]))
+	return b.String()
+}
+
+// signCanonical computes the HMAC-SHA256 of a canonical request
+func signCanonical(secret []byte, canonical string) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(canonical))
+	return mac.Sum(nil)
+}
+
+// parseAuthParams parses comma separated key="value" pairs of an
+// Authorization header. Keys are lowercased.
+func parseAuthParams(s string) map[string]string {
+	params := make(map[string]string)
+	for _, part := range strings.Split(s, ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			continue
+		}
+		if unquoted, err := strconv.Unquote(value); err == nil {
+			value = unquoted
+		}
+		params[strings.ToLower(strings.TrimSpace(key))] = value
+	}
+	return params
+}
@@ -0,0 +1,406 @@
+package router
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sys-apps-go/gorouter/pkg/router/routertest"
+)
+
+// authRouter returns a router that answers GET and POST /me with the
+// authenticated user
+func authRouter(auth MiddlewareFunc) *Router {
+	r := NewRouter()
+	r.Use(auth)
+	me := func(c *Context) {
+		c.JSON(http.StatusOK, map[string]string{"user": c.AuthUser()})
+	}
+	r.GET("/me", me)
+	r.POST("/me", me)
+	r.GET("/health", func(c *Context) { c.String(http.StatusOK, "ok") })
+	return r
+}
+
+func TestBasicAuth(t *testing.T) {
+	r := authRouter(BasicAuthWithConfig(BasicAuthConfig{
+		Users: map[string]string{"ann": "secret"},
+		Validator: func(c *Context, user, password string) bool {
+			return user == "bob" && password == "hunter2"
+		},
+		Realm: "admin",
+		Skip:  func(c *Context) bool { return c.Request.URL.Path == "/health" },
+	}))
+	challenge := `Basic realm="admin", charset="UTF-8"`
+
+	tests := []struct {
+		name     string
+		user     string
+		password string
+		header   string
+		status   int
+		want     string
+	}{
+		{name: "user from the map", user: "ann", password: "secret", status: http.StatusOK, want: "ann"},
+		{name: "user from the validator", user: "bob", password: "hunter2", status: http.StatusOK, want: "bob"},
+		{name: "wrong password", user: "ann", password: "wrong", status: http.StatusUnauthorized, want: "invalid credentials"},
+		{name: "map users skip the validator", user: "ann", password: "hunter2", status: http.StatusUnauthorized, want: "invalid credentials"},
+		{name: "unknown user", user: "eve", password: "secret", status: http.StatusUnauthorized, want: "invalid credentials"},
+		{name: "empty password", user: "ann", status: http.StatusUnauthorized, want: "invalid credentials"},
+		{name: "no credentials", status: http.StatusUnauthorized, want: "authentication required"},
+		{name: "other scheme", header: "Bearer token", status: http.StatusUnauthorized, want: "authentication required"},
+		{name: "malformed", header: "Basic !!!", status: http.StatusUnauthorized, want: "authentication required"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := routertest.New(t, r).GET("/me")
+			switch {
+			case tt.header != "":
+				req.Header("Authorization", tt.header)
+			case tt.user != "":
+				credentials := base64.StdEncoding.EncodeToString([]byte(tt.user + ":" + tt.password))
+				req.Header("Authorization", "Basic "+credentials)
+			}
+			resp := req.Expect().Status(tt.status)
+			if tt.status == http.StatusOK {
+				resp.JSONPath("$.user", tt.want).NoHeader("WWW-Authenticate")
+				return
+			}
+			resp.Header("WWW-Authenticate", challenge).JSONPath("$.error", tt.want)
+		})
+	}
+
+	routertest.New(t, r).GET("/health").Expect().Status(http.StatusOK)
+}
+
+func TestStaticAPIKeys(t *testing.T) {
+	lookup := StaticAPIKeys(map[string]string{"k1": "ann", "k2": "bob"})
+	for key, want := range map[string]string{"k1": "ann", "k2": "bob"} {
+		if got, err := lookup(context.Background(), key); err != nil || got != want {
+			t.Errorf("lookup(%q) = %q, %v, want %q", key, got, err, want)
+		}
+	}
+	for _, key := range []string{"k3", "k", "k11", ""} {
+		if _, err := lookup(context.Background(), key); !errors.Is(err, ErrInvalidCredentials) {
+			t.Errorf("lookup(%q) error = %v, want ErrInvalidCredentials", key, err)
+		}
+	}
+}
+
+func TestAPIKey(t *testing.T) {
+	keys := StaticAPIKeys(map[string]string{"k1": "ann"})
+	failing := func(ctx context.Context, key string) (string, error) {
+		return "", errors.New("database down")
+	}
+	tests := []struct {
+		name   string
+		config APIKeyConfig
+		header map[string]string
+		query  string
+		status int
+		want   string
+	}{
+		{name: "header", header: map[string]string{"X-API-Key": "k1"}, status: http.StatusOK, want: "ann"},
+		{name: "wrong key", header: map[string]string{"X-API-Key": "k2"}, status: http.StatusUnauthorized, want: "invalid API key"},
+		{name: "missing key", status: http.StatusUnauthorized, want: "API key required"},
+		{name: "query disabled by default", query: "api_key=k1", status: http.StatusUnauthorized, want: "API key required"},
+		{
+			name:   "query",
+			config: APIKeyConfig{Query: "api_key"},
+			query:  "api_key=k1",
+			status: http.StatusOK,
+			want:   "ann",
+		},
+		{
+			name:   "header wins over query",
+			config: APIKeyConfig{Query: "api_key"},
+			header: map[string]string{"X-API-Key": "k2"},
+			query:  "api_key=k1",
+			status: http.StatusUnauthorized,
+			want:   "invalid API key",
+		},
+		{
+			name:   "custom header",
+			config: APIKeyConfig{Header: "X-Token"},
+			header: map[string]string{"X-Token": "k1", "X-API-Key": "k2"},
+			status: http.StatusOK,
+			want:   "ann",
+		},
+		{
+			name:   "lookup error",
+			config: APIKeyConfig{Lookup: failing},
+			header: map[string]string{"X-API-Key": "k1"},
+			status: http.StatusInternalServerError,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := tt.config
+			if config.Lookup == nil {
+				config.Lookup = keys
+			}
+			config.Realm = "public"
+			req := routertest.New(t, authRouter(APIKeyWithConfig(config))).GET("/me?" + tt.query)
+			for name, value := range tt.header {
+				req.Header(name, value)
+			}
+			resp := req.Expect().Status(tt.status)
+			switch tt.status {
+			case http.StatusOK:
+				resp.JSONPath("$.user", tt.want)
+			case http.StatusUnauthorized:
+				header := config.Header
+				if header == "" {
+					header = "X-API-Key"
+				}
+				resp.Header("WWW-Authenticate", fmt.Sprintf("APIKey realm=\"public\", header=%q", header)).
+					JSONPath("$.error", tt.want)
+			default:
+				resp.NoHeader("WWW-Authenticate")
+			}
+		})
+	}
+}
+
+// signAt signs req like SignRequest but with the given timestamp
+func signAt(req *http.Request, keyID string, secret []byte, timestamp int64, headers ...string) {
+	body, _ := readBody(req, -1)
+	signed := append([]string{"host"}, headers...)
+	signature := signCanonical(secret, canonicalRequest(req, body, timestamp, signed))
+	req.Header.Set("Authorization", fmt.Sprintf("%s keyId=%q, timestamp=\"%d\", headers=%q, signature=%q",
+		HMACScheme, keyID, timestamp, strings.Join(signed, " "), hex.EncodeToString(signature)))
+}
+
+func TestHMACAuth(t *testing.T) {
+	secret := []byte("shared secret")
+	r := authRouter(HMACAuth(HMACAuthConfig{
+		Secret: func(ctx context.Context, keyID string) ([]byte, error) {
+			switch keyID {
+			case "key1":
+				return secret, nil
+			case "broken":
+				return nil, errors.New("vault down")
+			}
+			return nil, ErrInvalidCredentials
+		},
+		RequiredHeaders: []string{"Content-Type"},
+		MaxSkew:         time.Minute,
+		MaxBodySize:     16,
+	}))
+	challenge := `HMAC-SHA256 realm="api", headers="host content-type"`
+	now := time.Now().Unix()
+
+	// newRequest returns an unsigned POST /me?b=2&a=1&a=0 with a JSON body
+	newRequest := func() *http.Request {
+		req := httptest.NewRequest(http.MethodPost, "/me?b=2&a=1&a=0", strings.NewReader(`{"n":1}`))
+		req.Header.Set("Content-Type", "application/json")
+		return req
+	}
+	tests := []struct {
+		name    string
+		prepare func(req *http.Request)
+		status  int
+		want    string
+	}{
+		{
+			name:    "SignRequest",
+			prepare: func(req *http.Request) { SignRequest(req, "key1", secret, "Content-Type") },
+			status:  http.StatusOK,
+			want:    "key1",
+		},
+		{
+			name: "query order and header case do not matter",
+			prepare: func(req *http.Request) {
+				SignRequest(req, "key1", secret, "content-type")
+				req.URL.RawQuery = "a=0&a=1&b=2"
+				req.Header.Set("Content-Type", " application/json ")
+			},
+			status: http.StatusOK,
+			want:   "key1",
+		},
+		{
+			name: "changed body",
+			prepare: func(req *http.Request) {
+				SignRequest(req, "key1", secret, "Content-Type")
+				req.Body = http.NoBody
+			},
+			status: http.StatusUnauthorized,
+			want:   "invalid signature",
+		},
+		{
+			name: "changed signed header",
+			prepare: func(req *http.Request) {
+				SignRequest(req, "key1", secret, "Content-Type")
+				req.Header.Set("Content-Type", "text/plain")
+			},
+			status: http.StatusUnauthorized,
+			want:   "invalid signature",
+		},
+		{
+			name: "changed query",
+			prepare: func(req *http.Request) {
+				SignRequest(req, "key1", secret, "Content-Type")
+				req.URL.RawQuery = "a=0&a=1&b=3"
+			},
+			status: http.StatusUnauthorized,
+			want:   "invalid signature",
+		},
+		{
+			name: "changed host",
+			prepare: func(req *http.Request) {
+				SignRequest(req, "key1", secret, "Content-Type")
+				req.Host = "evil.example"
+			},
+			status: http.StatusUnauthorized,
+			want:   "invalid signature",
+		},
+		{
+			name: "changed method",
+			prepare: func(req *http.Request) {
+				SignRequest(req, "key1", secret, "Content-Type")
+				req.Method = http.MethodGet
+			},
+			status: http.StatusUnauthorized,
+			want:   "invalid signature",
+		},
+		{
+			name:    "required header not signed",
+			prepare: func(req *http.Request) { SignRequest(req, "key1", secret) },
+			status:  http.StatusUnauthorized,
+			want:    "header not signed: content-type",
+		},
+		{
+			name:    "wrong secret",
+			prepare: func(req *http.Request) { SignRequest(req, "key1", []byte("guess"), "Content-Type") },
+			status:  http.StatusUnauthorized,
+			want:    "invalid signature",
+		},
+		{
+			name:    "unknown key",
+			prepare: func(req *http.Request) { SignRequest(req, "key2", secret, "Content-Type") },
+			status:  http.StatusUnauthorized,
+			want:    "invalid signature",
+		},
+		{
+			name:    "secret lookup error",
+			prepare: func(req *http.Request) { SignRequest(req, "broken", secret, "Content-Type") },
+			status:  http.StatusInternalServerError,
+		},
+		{
+			name:    "within the skew",
+			prepare: func(req *http.Request) { signAt(req, "key1", secret, now-50, "content-type") },
+			status:  http.StatusOK,
+			want:    "key1",
+		},
+		{
+			name:    "too old",
+			prepare: func(req *http.Request) { signAt(req, "key1", secret, now-120, "content-type") },
+			status:  http.StatusUnauthorized,
+			want:    "signature timestamp outside the allowed window",
+		},
+		{
+			name:    "from the future",
+			prepare: func(req *http.Request) { signAt(req, "key1", secret, now+120, "content-type") },
+			status:  http.StatusUnauthorized,
+			want:    "signature timestamp outside the allowed window",
+		},
+		{
+			name: "body too large",
+			prepare: func(req *http.Request) {
+				req.Body = io.NopCloser(strings.NewReader(strings.Repeat("x", 17)))
+				SignRequest(req, "key1", secret, "Content-Type")
+			},
+			status: http.StatusRequestEntityTooLarge,
+		},
+		{
+			name:    "no signature",
+			prepare: func(req *http.Request) {},
+			status:  http.StatusUnauthorized,
+			want:    "signature required",
+		},
+		{
+			name:    "other scheme",
+			prepare: func(req *http.Request) { req.Header.Set("Authorization", "Bearer token") },
+			status:  http.StatusUnauthorized,
+			want:    "signature required",
+		},
+		{
+			name: "missing key ID",
+			prepare: func(req *http.Request) {
+				req.Header.Set("Authorization", fmt.Sprintf(`HMAC-SHA256 timestamp="%d", headers="host content-type", signature="00"`, now))
+			},
+			status: http.StatusUnauthorized,
+			want:   "malformed signature",
+		},
+		{
+			name: "bad timestamp",
+			prepare: func(req *http.Request) {
+				req.Header.Set("Authorization", `HMAC-SHA256 keyId="key1", timestamp="soon", headers="host content-type", signature="00"`)
+			},
+			status: http.StatusUnauthorized,
+			want:   "malformed signature",
+		},
+		{
+			name: "signature not hex",
+			prepare: func(req *http.Request) {
+				req.Header.Set("Authorization", fmt.Sprintf(`hmac-sha256 keyId="key1", timestamp="%d", headers="host content-type", signature="zz"`, now))
+			},
+			status: http.StatusUnauthorized,
+			want:   "invalid signature",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := newRequest()
+			tt.prepare(req)
+			rec := httptest.NewRecorder()
+			r.ServeHTTP(rec, req)
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d; body %q", rec.Code, tt.status, rec.Body.String())
+			}
+			body := rec.Body.String()
+			switch tt.status {
+			case http.StatusOK:
+				if want := fmt.Sprintf(`{"user":%q}`, tt.want); strings.TrimSpace(body) != want {
+					t.Fatalf("body = %q, want %q", body, want)
+				}
+			case http.StatusUnauthorized:
+				if got := rec.Header().Get("WWW-Authenticate"); got != challenge {
+					t.Fatalf("WWW-Authenticate = %q, want %q", got, challenge)
+				}
+				if !strings.Contains(body, tt.want) {
+					t.Fatalf("body = %q, want %q", body, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestHMACAuthBodyStaysReadable(t *testing.T) {
+	secret := []byte("shared secret")
+	r := NewRouter()
+	r.Use(HMACAuth(HMACAuthConfig{Secret: func(ctx context.Context, keyID string) ([]byte, error) {
+		return secret, nil
+	}}))
+	r.POST("/echo", func(c *Context) {
+		body, _ := readBody(c.Request, -1)
+		c.Data(http.StatusOK, "text/plain", body)
+	})
+	req := httptest.NewRequest(http.MethodPost, "/echo", strings.NewReader("payload"))
+	if err := SignRequest(req, "key1", secret); err != nil {
+		t.Fatal(err)
+	}
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK || rec.Body.String() != "payload" {
+		t.Fatalf("got %d %q, want 200 payload", rec.Code, rec.Body.String())
+	}
+}
@@ -0,0 +1,336 @@
+package router
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// SubjectKey is the Context key of the *Subject that Authorize checks.
+// Authentication middleware such as Auth can store one there; otherwise it
+// is derived from JWT claims or the authenticated user.
+const SubjectKey = "Subject"
+
+// Subject is the authenticated caller as seen by authorization
+type Subject struct {
+	ID          string
+	Roles       []string
+	Scopes      []string
+	Permissions []string
+	// Attrs holds further attributes for policies, such as JWT claims
+	Attrs map[string]interface{}
+}
+
+// Authorization lists what a route requires of the subject. Roles holds
+// one set of roles per RequireRoles call on the route and its groups; the
+// subject needs one role of every set, so a route or subgroup can only
+// narrow the roles of its group. The subject also needs all of the Scopes
+// and Permissions, and every named policy must allow the request.
+type Authorization struct {
+	Roles       [][]string
+	Scopes      []string
+	Permissions []string
+	Policies    []string
+}
+
+// empty reports whether the authorization requires nothing
+func (a Authorization) empty() bool {
+	return len(a.Roles) == 0 && len(a.Scopes) == 0 && len(a.Permissions) == 0 && len(a.Policies) == 0
+}
+
+// merge returns the requirements of a and b combined
+func (a Authorization) merge(b Authorization) Authorization {
+	return Authorization{
+		Roles:       append(append([][]string(nil), a.Roles...), b.Roles...),
+		Scopes:      append(append([]string(nil), a.Scopes...), b.Scopes...),
+		Permissions: append(append([]string(nil), a.Permissions...), b.Permissions...),
+		Policies:    append(append([]string(nil), a.Policies...), b.Policies...),
+	}
+}
+
+// RequireRoles requires the subject to have one of the roles, in addition
+// to the roles required by the route's groups and earlier calls
+func (rt *Route) RequireRoles(roles ...string) *Route {
+	if len(roles) == 0 {
+		return rt
+	}
+	rt.Meta.Authorization.Roles = append(rt.Meta.Authorization.Roles, append([]string(nil), roles...))
+	return rt
+}
+
+// RequireScopes requires the subject to have all of the scopes
+func (rt *Route) RequireScopes(scopes ...string) *Route {
+	rt.Meta.Authorization.Scopes = append(rt.Meta.Authorization.Scopes, scopes...)
+	return rt
+}
+
+// RequirePermissions requires the subject to have all of the permissions
+func (rt *Route) RequirePermissions(permissions ...string) *Route {
+	rt.Meta.Authorization.Permissions = append(rt.Meta.Authorization.Permissions, permissions...)
+	return rt
+}
+
+// RequirePolicies requires every named policy to allow the request
+func (rt *Route) RequirePolicies(names ...string) *Route {
+	rt.Meta.Authorization.Policies = append(rt.Meta.Authorization.Policies, names...)
+	return rt
+}
+
+// RequireRoles requires one of the roles for routes registered on the
+// group and its subgroups afterwards, in addition to the roles required by
+// parent groups and earlier calls
+func (group *RouterGroup) RequireRoles(roles ...string) *RouterGroup {
+	if len(roles) == 0 {
+		return group
+	}
+	group.authorization.Roles = append(group.authorization.Roles, append([]string(nil), roles...))
+	return group
+}
+
+// RequireScopes requires all of the scopes for routes registered on the
+// group and its subgroups afterwards
+func (group *RouterGroup) RequireScopes(scopes ...string) *RouterGroup {
+	group.authorization.Scopes = append(group.authorization.Scopes, scopes...)
+	return group
+}
+
+// RequirePermissions requires all of the permissions for routes registered
+// on the group and its subgroups afterwards
+func (group *RouterGroup) RequirePermissions(permissions ...string) *RouterGroup {
+	group.authorization.Permissions = append(group.authorization.Permissions, permissions...)
+	return group
+}
+
+// RequirePolicies requires every named policy to allow requests to routes
+// registered on the group and its subgroups afterwards
+func (group *RouterGroup) RequirePolicies(names ...string) *RouterGroup {
+	group.authorization.Policies = append(group.authorization.Policies, names...)
+	return group
+}
+
+// inheritedAuthorization returns the requirements of the group and all of
+// its parents
+func (group *RouterGroup) inheritedAuthorization() Authorization {
+	if group.parent == nil {
+		return group.authorization.merge(Authorization{})
+	}
+	return group.parent.inheritedAuthorization().merge(group.authorization)
+}
+
+// Policy is a named attribute-based rule evaluated by Authorize
+type Policy interface {
+	Allow(c *Context, subject *Subject) (bool, error)
+}
+
+// PolicyFunc adapts a function to a Policy
+type PolicyFunc func(c *Context, subject *Subject) bool
+
+// Allow calls f
+func (f PolicyFunc) Allow(c *Context, subject *Subject) (bool, error) {
+	return f(c, subject), nil
+}
+
+// PolicyEngine decides whether a subject meets the requirements of a
+// route. It returns nil to allow the request, a *ForbiddenError to deny it
+// or another error if the decision failed.
+type PolicyEngine interface {
+	Authorize(c *Context, subject *Subject, required Authorization) error
+}
+
+// ForbiddenError is returned by policy engines to deny a request
+type ForbiddenError struct {
+	Reason string
+}
+
+func (e *ForbiddenError) Error() string {
+	return "forbidden: " + e.Reason
+}
+
+// StatusCode returns 403
+func (e *ForbiddenError) StatusCode() int {
+	return http.StatusForbidden
+}
+
+// RBAC is a role-based policy engine. Roles grant permissions and may
+// inherit the roles below them, so a role requirement is also met by any
+// role inheriting it. Named policies are evaluated as attribute-based
+// rules, for example policies built with Expr.
+type RBAC struct {
+	permissions map[string][]string
+	parents     map[string][]string
+	policies    map[string]Policy
+}
+
+// NewRBAC returns an engine without roles or policies
+func NewRBAC() *RBAC {
+	return &RBAC{
+		permissions: make(map[string][]string),
+		parents:     make(map[string][]string),
+		policies:    make(map[string]Policy),
+	}
+}
+
+// Grant gives a role permissions
+func (r *RBAC) Grant(role string, permissions ...string) *RBAC {
+	r.permissions[role] = append(r.permissions[role], permissions...)
+	return r
+}
+
+// Inherit makes role include the roles it inherits and their permissions,
+// for example Inherit("admin", "editor")
+func (r *RBAC) Inherit(role string, inherits ...string) *RBAC {
+	r.parents[role] = append(r.parents[role], inherits...)
+	return r
+}
+
+// Policy registers a named policy
+func (r *RBAC) Policy(name string, policy Policy) *RBAC {
+	r.policies[name] = policy
+	return r
+}
+
+// expandRoles returns the roles and every role they inherit
+func (r *RBAC) expandRoles(roles []string) map[string]bool {
+	expanded := make(map[string]bool)
+	var visit func(role string)
+	visit = func(role string) {
+		if expanded[role] {
+			return
+		}
+		expanded[role] = true
+		for _, parent := range r.parents[role] {
+			visit(parent)
+		}
+	}
+	for _, role := range roles {
+		visit(role)
+	}
+	return expanded
+}
+
+// Authorize implements PolicyEngine
+func (r *RBAC) Authorize(c *Context, subject *Subject, required Authorization) error {
+	roles := r.expandRoles(subject.Roles)
+	for _, anyOf := range required.Roles {
+		allowed := false
+		for _, role := range anyOf {
+			if roles[role] {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return &ForbiddenError{Reason: "requires role " + strings.Join(anyOf, " or ")}
+		}
+	}
+
+	for _, scope := range required.Scopes {
+		if !containsExact(subject.Scopes, scope) {
+			return &ForbiddenError{Reason: "missing scope " + scope}
+		}
+	}
+
+	if len(required.Permissions) > 0 {
+		granted := make(map[string]bool)
+		for _, p := range subject.Permissions {
+			granted[p] = true
+		}
+		for role := range roles {
+			for _, p := range r.permissions[role] {
+				granted[p] = true
+			}
+		}
+		for _, p := range required.Permissions {
+			if !granted[p] && !granted["*"] {
+				return &ForbiddenError{Reason: "missing permission " + p}
+			}
+		}
+	}
+
+	for _, name := range required.Policies {
+		policy, ok := r.policies[name]
+		if !ok {
+			return fmt.Errorf("authorization policy %q is not registered", name)
+		}
+		allowed, err := policy.Allow(c, subject)
+		if err != nil {
+			return fmt.Errorf("authorization policy %q: %w", name, err)
+		}
+		if !allowed {
+			return &ForbiddenError{Reason: "denied by policy " + name}
+		}
+	}
+	return nil
+}
+
+// AuthorizeConfig configures Authorize
+type AuthorizeConfig struct {
+	// Engine evaluates the requirements, default an empty RBAC
+	Engine PolicyEngine
+	// Subject returns the caller, or nil for anonymous requests. By
+	// default DefaultSubject is used.
+	Subject func(*Context) *Subject
+}
+
+// Authorize is a middleware that enforces the requirements attached to
+// routes with RequireRoles and the like. It must run after the
+// authentication middleware. Anonymous requests to routes with
+// requirements are rejected with 401, denied requests with 403 and the
+// reason.
+func Authorize(config AuthorizeConfig) MiddlewareFunc {
+	if config.Engine == nil {
+		config.Engine = NewRBAC()
+	}
+	if config.Subject == nil {
+		config.Subject = DefaultSubject
+	}
+
+	return func(next HandlerFunc) HandlerFunc {
+		return func(c *Context) {
+			route := c.Route()
+			if route == nil || route.Meta.Authorization.empty() {
+				next(c)
+				return
+			}
+			subject := config.Subject(c)
+			if subject == nil {
+				c.Error(NewHTTPError(http.StatusUnauthorized, "authentication required"))
+				return
+			}
+			if err := config.Engine.Authorize(c, subject, route.Meta.Authorization); err != nil {
+				c.Error(err)
+				return
+			}
+			next(c)
+		}
+	}
+}
+
+// DefaultSubject returns the *Subject stored under SubjectKey, one built
+// from the JWT claims sub, roles, scope or scp and permissions, or one
+// with only the ID of the user authenticated by BasicAuth, APIKey or
+// HMACAuth. It returns nil for anonymous requests.
+func DefaultSubject(c *Context) *Subject {
+	if value, ok := c.Get(SubjectKey); ok {
+		if subject, ok := value.(*Subject); ok {
+			return subject
+		}
+	}
+	if claims := c.Claims(); claims != nil {
+		scopes := strings.Fields(claims.String("scope"))
+		if len(scopes) == 0 {
+			scopes = claims.Strings("scp")
+		}
+		return &Subject{
+			ID:          claims.Subject(),
+			Roles:       claims.Strings("roles"),
+			Scopes:      scopes,
+			Permissions: claims.Strings("permissions"),
+			Attrs:       claims,
+		}
+	}
+	if user := c.AuthUser(); user != "" {
+		return &Subject{ID: user}
+	}
+	return nil
+}
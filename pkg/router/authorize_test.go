@@ -0,0 +1,192 @@
+package router
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/sys-apps-go/gorouter/pkg/router/routertest"
+)
+
+// subjectFromHeaders authenticates requests by trusting the X-User and
+// X-Roles headers
+func subjectFromHeaders(c *Context) {
+	id := c.GetHeader("X-User")
+	if id == "" {
+		return
+	}
+	subject := &Subject{ID: id, Attrs: map[string]interface{}{"tenant": c.GetHeader("X-User-Tenant")}}
+	if roles := c.GetHeader("X-Roles"); roles != "" {
+		subject.Roles = strings.Split(roles, ",")
+	}
+	if scopes := c.GetHeader("X-Scopes"); scopes != "" {
+		subject.Scopes = strings.Split(scopes, ",")
+	}
+	c.Set(SubjectKey, subject)
+}
+
+func TestAuthorize(t *testing.T) {
+	rbac := NewRBAC().
+		Grant("editor", "posts:write").
+		Grant("root", "*").
+		Inherit("admin", "editor").
+		Policy("owner", MustExpr(`subject.id == param.id || "admin" in subject.roles`)).
+		Policy("same-tenant", MustExpr(`subject.tenant == header.X-Tenant`)).
+		Policy("weekday", PolicyFunc(func(c *Context, subject *Subject) bool {
+			return c.Query("day") != "sunday"
+		}))
+
+	r := NewRouter()
+	r.Use(toMiddleware(subjectFromHeaders), Authorize(AuthorizeConfig{Engine: rbac}))
+	ok := func(c *Context) {
+		c.Status(http.StatusNoContent)
+	}
+	r.GET("/public", ok)
+	r.GET("/users/:id", ok).RequirePolicies("owner")
+	r.GET("/tenant", ok).RequirePolicies("same-tenant")
+	r.GET("/broken", ok).RequirePolicies("missing")
+	r.POST("/posts", ok).RequirePermissions("posts:write")
+	r.GET("/reports", ok).RequireScopes("reports:read").RequirePolicies("weekday")
+
+	admin := r.Group("/admin")
+	admin.RequireRoles("admin")
+	admin.GET("/users", ok)
+	admin.GET("/audit", ok).RequireRoles("auditor", "root")
+	staff := admin.Group("/staff")
+	staff.RequireRoles("hr")
+	staff.GET("/salaries", ok)
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		header map[string]string
+		status int
+		reason string
+	}{
+		{"public", http.MethodGet, "/public", nil, http.StatusNoContent, ""},
+		{"anonymous", http.MethodGet, "/admin/users", nil, http.StatusUnauthorized, "authentication required"},
+		{"group role", http.MethodGet, "/admin/users", map[string]string{"X-User": "ann", "X-Roles": "admin"}, http.StatusNoContent, ""},
+		{"missing group role", http.MethodGet, "/admin/users", map[string]string{"X-User": "bob", "X-Roles": "editor"}, http.StatusForbidden, "forbidden: requires role admin"},
+		{"route narrows group", http.MethodGet, "/admin/audit", map[string]string{"X-User": "ann", "X-Roles": "admin"}, http.StatusForbidden, "forbidden: requires role auditor or root"},
+		{"group and route roles", http.MethodGet, "/admin/audit", map[string]string{"X-User": "ann", "X-Roles": "admin,auditor"}, http.StatusNoContent, ""},
+		{"route role alone", http.MethodGet, "/admin/audit", map[string]string{"X-User": "eve", "X-Roles": "auditor"}, http.StatusForbidden, "forbidden: requires role admin"},
+		{"subgroup narrows parent", http.MethodGet, "/admin/staff/salaries", map[string]string{"X-User": "kim", "X-Roles": "hr"}, http.StatusForbidden, "forbidden: requires role admin"},
+		{"subgroup and parent roles", http.MethodGet, "/admin/staff/salaries", map[string]string{"X-User": "kim", "X-Roles": "hr,admin"}, http.StatusNoContent, ""},
+		{"permission from role", http.MethodPost, "/posts", map[string]string{"X-User": "bob", "X-Roles": "editor"}, http.StatusNoContent, ""},
+		{"permission from inherited role", http.MethodPost, "/posts", map[string]string{"X-User": "ann", "X-Roles": "admin"}, http.StatusNoContent, ""},
+		{"wildcard permission", http.MethodPost, "/posts", map[string]string{"X-User": "sys", "X-Roles": "root"}, http.StatusNoContent, ""},
+		{"missing permission", http.MethodPost, "/posts", map[string]string{"X-User": "joe"}, http.StatusForbidden, "forbidden: missing permission posts:write"},
+		{"owner", http.MethodGet, "/users/joe", map[string]string{"X-User": "joe"}, http.StatusNoContent, ""},
+		{"not owner", http.MethodGet, "/users/ann", map[string]string{"X-User": "joe"}, http.StatusForbidden, "forbidden: denied by policy owner"},
+		{"admin passes owner policy", http.MethodGet, "/users/joe", map[string]string{"X-User": "ann", "X-Roles": "admin"}, http.StatusNoContent, ""},
+		{"same tenant", http.MethodGet, "/tenant", map[string]string{"X-User": "joe", "X-User-Tenant": "acme", "X-Tenant": "acme"}, http.StatusNoContent, ""},
+		{"other tenant", http.MethodGet, "/tenant", map[string]string{"X-User": "joe", "X-User-Tenant": "acme", "X-Tenant": "globex"}, http.StatusForbidden, "forbidden: denied by policy same-tenant"},
+		{"missing attributes never match", http.MethodGet, "/tenant", map[string]string{"X-User": "joe"}, http.StatusForbidden, "forbidden: denied by policy same-tenant"},
+		{"scope and policy func", http.MethodGet, "/reports?day=monday", map[string]string{"X-User": "joe", "X-Scopes": "reports:read"}, http.StatusNoContent, ""},
+		{"missing scope", http.MethodGet, "/reports", map[string]string{"X-User": "joe"}, http.StatusForbidden, "forbidden: missing scope reports:read"},
+		{"policy func denies", http.MethodGet, "/reports?day=sunday", map[string]string{"X-User": "joe", "X-Scopes": "reports:read"}, http.StatusForbidden, "forbidden: denied by policy weekday"},
+		{"unregistered policy", http.MethodGet, "/broken", map[string]string{"X-User": "joe"}, http.StatusInternalServerError, "Internal Server Error"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := routertest.New(t, r).Request(tt.method, tt.path)
+			for key, value := range tt.header {
+				req.Header(key, value)
+			}
+			resp := req.Expect().Status(tt.status)
+			if tt.reason != "" {
+				resp.JSONPath("$.error", tt.reason)
+			}
+		})
+	}
+}
+
+func TestAuthorizeJWTSubject(t *testing.T) {
+	keys := newTestKeys(t)
+	r := NewRouter()
+	r.Use(JWT(JWTConfig{Key: keys.secret}), Authorize(AuthorizeConfig{}))
+	r.GET("/reports", func(c *Context) {
+		c.Status(http.StatusNoContent)
+	}).RequireScopes("reports:read").RequireRoles("analyst")
+
+	tests := []struct {
+		name   string
+		claims map[string]interface{}
+		status int
+	}{
+		{"scope claim", map[string]interface{}{"sub": "ann", "roles": []string{"analyst"}, "scope": "profile reports:read"}, http.StatusNoContent},
+		{"scp claim", map[string]interface{}{"sub": "ann", "roles": "analyst", "scp": []string{"reports:read"}}, http.StatusNoContent},
+		{"missing role", map[string]interface{}{"sub": "ann", "scope": "reports:read"}, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token := signTestJWT(t, keys.secret, "", tt.claims)
+			routertest.New(t, r).GET("/reports").Header("Authorization", "Bearer "+token).Expect().Status(tt.status)
+		})
+	}
+}
+
+func TestExpr(t *testing.T) {
+	subject := &Subject{
+		ID:    "42",
+		Roles: []string{"admin", "dev"},
+		Attrs: map[string]interface{}{"level": float64(3), "team": "core", "empty": ""},
+	}
+	tests := []struct {
+		expr string
+		want bool
+	}{
+		{`subject.id == "42"`, true},
+		{`subject.id == 42`, true},
+		{`subject.id != "7"`, true},
+		{`"admin" in subject.roles`, true},
+		{`"ops" in subject.roles`, false},
+		{`subject.team in ["core", "web"]`, true},
+		{`subject.level >= 3 && subject.level < 4`, true},
+		{`subject.level > 3 || subject.team == "core"`, true},
+		{`!(subject.team == "core")`, false},
+		{`query.page == 2`, true},
+		{`header.X-Env == "prod" || request.method == "GET"`, true},
+		{`subject.missing != "x"`, false},
+		{`subject.empty != "x"`, false},
+		{`header.X-Missing != "x"`, false},
+		{`subject.missing in ["x"]`, false},
+		{`!(subject.missing == "x")`, false},
+		{`!(header.X-Missing == "y")`, false},
+		{`!!(header.X-Missing == "y")`, false},
+		{`!(subject.missing == "x") && true`, false},
+		{`!(subject.missing == "x") || false`, false},
+		{`subject.missing == "x" || subject.team == "core"`, true},
+		{`subject.missing == "x" && subject.team == "web"`, false},
+		{`!(subject.missing == "x" && subject.team == "web")`, true},
+		{`!(subject.missing == "x" || subject.team == "core")`, false},
+		{`(subject.missing == "x") == false`, false},
+		{`!header.X-Missing`, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			policy, err := Expr(tt.expr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req := httptest.NewRequest(http.MethodGet, "/?page=2", nil)
+			req.Header.Set("X-Env", "dev")
+			c := newContext(httptest.NewRecorder(), req)
+			got, err := policy.Allow(c, subject)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	for _, invalid := range []string{`subject.id ==`, `unknown.name == 1`, `subject.id == "open`, `(subject.id == "1"`, `subject.id == 1 1`} {
+		if _, err := Expr(invalid); err == nil {
+			t.Errorf("Expr(%q) succeeded", invalid)
+		}
+	}
+}
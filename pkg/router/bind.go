@@ -0,0 +1,187 @@
+package router
+
+import (
+	"encoding"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Bind fills the struct pointed to by obj from the request. The JSON body is
+// decoded first, then fields tagged with `path`, `query` or `header` are set
+// from the URL params, the query string and the request headers. Binding
+// failures are returned as an HTTPError with status 400.
+func (c *Context) Bind(obj interface{}) error {
+	v := reflect.ValueOf(obj)
+	if v.Kind() != reflect.Ptr || v.IsNil() {
+		return fmt.Errorf("router: Bind requires a non-nil pointer, got %T", obj)
+	}
+
+	if hasBody(c.Request) {
+		if err := c.BindJSON(obj); err != nil && !errors.Is(err, io.EOF) {
+			var he *HTTPError
+			if errors.As(err, &he) {
+				return err
+			}
+			return WrapHTTPError(http.StatusBadRequest, fmt.Errorf("invalid JSON body: %w", err))
+		}
+	}
+
+	v = v.Elem()
+	if v.Kind() != reflect.Struct {
+		return nil
+	}
+	if err := c.bindFields(v); err != nil {
+		return WrapHTTPError(http.StatusBadRequest, err)
+	}
+	return nil
+}
+
+// hasBody reports whether the request may carry a JSON body worth decoding
+func hasBody(req *http.Request) bool {
+	if req.Body == nil || req.Body == http.NoBody {
+		return false
+	}
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodDelete, http.MethodOptions:
+		return req.ContentLength > 0
+	}
+	return true
+}
+
+// bindFields sets the tagged fields of the struct v from the request
+func (c *Context) bindFields(v reflect.Value) error {
+	t := v.Type()
+	var query map[string][]string
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		fv := v.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		if field.Anonymous && fv.Kind() == reflect.Struct {
+			if err := c.bindFields(fv); err != nil {
+				return err
+			}
+			continue
+		}
+
+		var values []string
+		var name string
+		if name = field.Tag.Get("path"); name != "" {
+			if value, ok := c.Params[name]; ok {
+				values = []string{value}
+			}
+		} else if name = field.Tag.Get("query"); name != "" {
+			if query == nil {
+				query = c.Request.URL.Query()
+			}
+			values = query[name]
+		} else if name = field.Tag.Get("header"); name != "" {
+			values = c.Request.Header.Values(name)
+		} else {
+			continue
+		}
+
+		if len(values) == 0 {
+			continue
+		}
+		if err := setField(fv, values); err != nil {
+			return fmt.Errorf("invalid value for %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+var (
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+	durationType        = reflect.TypeOf(time.Duration(0))
+	timeType            = reflect.TypeOf(time.Time{})
+)
+
+// setField assigns the string values to fv, converting them to its type
+func setField(fv reflect.Value, values []string) error {
+	if fv.Kind() == reflect.Slice && !fv.Type().Implements(textUnmarshalerType) && !reflect.PointerTo(fv.Type()).Implements(textUnmarshalerType) {
+		slice := reflect.MakeSlice(fv.Type(), len(values), len(values))
+		for i, value := range values {
+			if err := setValue(slice.Index(i), value); err != nil {
+				return err
+			}
+		}
+		fv.Set(slice)
+		return nil
+	}
+	return setValue(fv, values[0])
+}
+
+// setValue parses value into fv according to its kind
+func setValue(fv reflect.Value, value string) error {
+	if fv.Kind() == reflect.Ptr {
+		if fv.IsNil() {
+			fv.Set(reflect.New(fv.Type().Elem()))
+		}
+		return setValue(fv.Elem(), value)
+	}
+
+	if fv.CanAddr() && fv.Addr().Type().Implements(textUnmarshalerType) {
+		return fv.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(value))
+	}
+
+	if fv.Type() == durationType {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		fv.SetInt(int64(d))
+		return nil
+	}
+
+	switch fv.Kind() {
+	case reflect.String:
+		fv.SetString(value)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		fv.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(value, 10, fv.Type().Bits())
+		if err != nil {
+			return err
+		}
+		fv.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(value, 10, fv.Type().Bits())
+		if err != nil {
+			return err
+		}
+		fv.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(value, fv.Type().Bits())
+		if err != nil {
+			return err
+		}
+		fv.SetFloat(f)
+	default:
+		return fmt.Errorf("unsupported field type %s", fv.Type())
+	}
+	return nil
+}
+
+// fieldName returns the name used for a struct field in error messages
+func fieldName(field reflect.StructField) string {
+	for _, key := range []string{"json", "path", "query", "header"} {
+		if tag := field.Tag.Get(key); tag != "" && tag != "-" {
+			if name := strings.Split(tag, ",")[0]; name != "" {
+				return name
+			}
+		}
+	}
+	return field.Name
+}
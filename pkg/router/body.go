@@ -0,0 +1,274 @@
+package router
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// DefaultMaxBodySize is the body limit of BodyLimit and Decompress when
+// none is configured
+const DefaultMaxBodySize int64 = 4 << 20
+
+// MaxBodySize sets the largest request body accepted for the route,
+// overriding the limit of BodyLimit and Decompress
+func (rt *Route) MaxBodySize(n int64) *Route {
+	rt.Meta.MaxBodySize = n
+	return rt
+}
+
+// bodyLimit returns the route's body limit if it has one, else def
+func bodyLimit(c *Context, def int64) int64 {
+	if rt := c.Route(); rt != nil && rt.Meta.MaxBodySize > 0 {
+		return rt.Meta.MaxBodySize
+	}
+	return def
+}
+
+// BodyLimit is a middleware that limits request bodies to limit bytes, or
+// DefaultMaxBodySize if limit is 0. Routes can set their own limit with
+// Route.MaxBodySize. Requests declaring a larger Content-Length are
+// rejected with 413 at once; for others reading past the limit fails with
+// an *http.MaxBytesError, which Bind and BindJSON report as 413.
+func BodyLimit(limit int64) MiddlewareFunc {
+	if limit <= 0 {
+		limit = DefaultMaxBodySize
+	}
+	return func(next HandlerFunc) HandlerFunc {
+		return func(c *Context) {
+			n := bodyLimit(c, limit)
+			if c.Request.ContentLength > n {
+				c.Error(NewHTTPError(http.StatusRequestEntityTooLarge, "request body too large"))
+				return
+			}
+			if c.Request.Body != nil && c.Request.Body != http.NoBody {
+				c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, n)
+			}
+			next(c)
+		}
+	}
+}
+
+// DecompressConfig configures DecompressWithConfig
+type DecompressConfig struct {
+	// Encodings lists the accepted content codings, default gzip, deflate
+	// and zstd. Bodies in other codings are rejected with 415.
+	Encodings []string
+	// MaxSize limits the decompressed body, default DefaultMaxBodySize.
+	// Route.MaxBodySize overrides it per route.
+	MaxSize int64
+}
+
+// decodings are the content codings Decompress can decode
+var decodings = []string{"gzip", "deflate", "zstd"}
+
+// decompressorPools holds reusable decoders keyed by content coding;
+// deflate readers are cheap and not pooled
+var decompressorPools = map[string]*sync.Pool{
+	"gzip": {New: func() interface{} { return new(gzip.Reader) }},
+	"zstd": {New: func() interface{} {
+		// A small window keeps hostile frames from reserving much memory
+		d, _ := zstd.NewReader(nil,
+			zstd.WithDecoderConcurrency(1),
+			zstd.WithDecoderLowmem(true),
+			zstd.WithDecoderMaxWindow(8<<20))
+		return d
+	}},
+}
+
+// Decompress is a middleware that decodes compressed request bodies with
+// the default configuration
+func Decompress() MiddlewareFunc {
+	return DecompressWithConfig(DecompressConfig{})
+}
+
+// DecompressWithConfig is a middleware that transparently decodes request
+// bodies sent with Content-Encoding gzip, deflate or zstd. The decoded
+// body is limited to MaxSize bytes so small compressed payloads cannot
+// expand without bound; reading past it fails with an *http.MaxBytesError.
+// Content-Encoding and Content-Length are removed from the request since
+// they no longer describe the body.
+func DecompressWithConfig(config DecompressConfig) MiddlewareFunc {
+	if config.Encodings == nil {
+		config.Encodings = decodings
+	}
+	for _, enc := range config.Encodings {
+		if !containsExact(decodings, enc) {
+			panic(fmt.Sprintf("router: unsupported decompression encoding %q", enc))
+		}
+	}
+	if config.MaxSize <= 0 {
+		config.MaxSize = DefaultMaxBodySize
+	}
+
+	return func(next HandlerFunc) HandlerFunc {
+		return func(c *Context) {
+			header := c.Request.Header.Get("Content-Encoding")
+			if header == "" || c.Request.Body == nil || c.Request.Body == http.NoBody {
+				next(c)
+				return
+			}
+
+			// Codings are listed in the order they were applied
+			var codings []string
+			for _, enc := range strings.Split(header, ",") {
+				enc = strings.ToLower(strings.TrimSpace(enc))
+				if enc == "x-gzip" {
+					enc = "gzip"
+				}
+				if enc == "" || enc == "identity" {
+					continue
+				}
+				if !containsExact(config.Encodings, enc) {
+					c.Writer.Header().Set("Accept-Encoding", strings.Join(config.Encodings, ", "))
+					c.Error(NewHTTPError(http.StatusUnsupportedMediaType, "unsupported content encoding "+enc))
+					return
+				}
+				codings = append(codings, enc)
+			}
+
+			limit := bodyLimit(c, config.MaxSize)
+			original := c.Request.Body
+			// The compressed body cannot usefully be larger than the result
+			body := &decodedBody{Reader: http.MaxBytesReader(c.Writer, original, limit), raw: original}
+			for i := len(codings) - 1; i >= 0; i-- {
+				if err := body.decode(codings[i]); err != nil {
+					body.Close()
+					c.Error(WrapHTTPError(http.StatusBadRequest, fmt.Errorf("invalid %s body: %w", codings[i], err)))
+					return
+				}
+			}
+			body.Reader = &maxBytesReader{r: body.Reader, n: limit}
+
+			c.Request.Body = body
+			c.Request.Header.Del("Content-Encoding")
+			c.Request.Header.Del("Content-Length")
+			c.Request.ContentLength = -1
+			defer func() {
+				body.Close()
+				c.Request.Body = original
+			}()
+			next(c)
+		}
+	}
+}
+
+// decodedBody is a request body read through one or more pooled decoders
+type decodedBody struct {
+	io.Reader
+	raw      io.ReadCloser
+	decoders []pooledDecoder
+	// closers are the decoders that are not pooled
+	closers []io.Closer
+	closed  bool
+}
+
+// pooledDecoder is a decoder to return to its pool when the body closes
+type pooledDecoder struct {
+	encoding string
+	decoder  interface{}
+}
+
+// decode wraps the body in a decoder for encoding
+func (b *decodedBody) decode(encoding string) error {
+	switch encoding {
+	case "gzip":
+		zr := decompressorPools["gzip"].Get().(*gzip.Reader)
+		if err := zr.Reset(b.Reader); err != nil {
+			decompressorPools["gzip"].Put(zr)
+			return err
+		}
+		// Concatenated members are part of the same body
+		zr.Multistream(true)
+		b.decoders = append(b.decoders, pooledDecoder{encoding, zr})
+		b.Reader = zr
+	case "deflate":
+		// deflate should be zlib-wrapped but many clients send raw data
+		br := bufio.NewReader(b.Reader)
+		if head, err := br.Peek(2); err == nil && head[0]&0x0f == 8 && (uint16(head[0])<<8|uint16(head[1]))%31 == 0 {
+			zr, err := zlib.NewReader(br)
+			if err != nil {
+				return err
+			}
+			b.closers = append(b.closers, zr)
+			b.Reader = zr
+		} else {
+			fr := flate.NewReader(br)
+			b.closers = append(b.closers, fr)
+			b.Reader = fr
+		}
+	case "zstd":
+		d, _ := decompressorPools["zstd"].Get().(*zstd.Decoder)
+		if d == nil {
+			return fmt.Errorf("zstd decoder unavailable")
+		}
+		if err := d.Reset(b.Reader); err != nil {
+			decompressorPools["zstd"].Put(d)
+			return err
+		}
+		b.decoders = append(b.decoders, pooledDecoder{encoding, d})
+		b.Reader = d
+	}
+	return nil
+}
+
+// Close closes the original body and returns the decoders to their pools
+func (b *decodedBody) Close() error {
+	if b.closed {
+		return nil
+	}
+	b.closed = true
+	for _, d := range b.decoders {
+		switch dec := d.decoder.(type) {
+		case *gzip.Reader:
+			dec.Close()
+		case *zstd.Decoder:
+			dec.Reset(nil)
+		}
+		decompressorPools[d.encoding].Put(d.decoder)
+	}
+	for _, closer := range b.closers {
+		closer.Close()
+	}
+	b.decoders, b.closers = nil, nil
+	b.Reader = http.NoBody
+	return b.raw.Close()
+}
+
+// maxBytesReader fails with an *http.MaxBytesError once more than n bytes
+// have been read, like http.MaxBytesReader but for decoded data
+type maxBytesReader struct {
+	r    io.Reader
+	n    int64
+	read int64
+	err  error
+}
+
+func (m *maxBytesReader) Read(p []byte) (int, error) {
+	if m.err != nil {
+		return 0, m.err
+	}
+	if remaining := m.n - m.read + 1; int64(len(p)) > remaining {
+		p = p[:remaining]
+	}
+	n, err := m.r.Read(p)
+	m.read += int64(n)
+	if m.read > m.n {
+		n -= int(m.read - m.n)
+		m.read = m.n
+		m.err = &http.MaxBytesError{Limit: m.n}
+		return n, m.err
+	}
+	if err != nil {
+		m.err = err
+	}
+	return n, err
+}
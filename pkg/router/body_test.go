@@ -0,0 +1,123 @@
+package router
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+
+	"github.com/sys-apps-go/gorouter/pkg/router/routertest"
+)
+
+// encodeBody applies a request content coding
+func encodeBody(t *testing.T, encoding string, data []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	var w io.WriteCloser
+	switch encoding {
+	case "gzip", "x-gzip":
+		w = gzip.NewWriter(&buf)
+	case "deflate":
+		w = zlib.NewWriter(&buf)
+	case "zstd":
+		var err error
+		if w, err = zstd.NewWriter(&buf); err != nil {
+			t.Fatal(err)
+		}
+	default:
+		t.Fatalf("unknown encoding %q", encoding)
+	}
+	w.Write(data)
+	w.Close()
+	return buf.Bytes()
+}
+
+func TestDecompress(t *testing.T) {
+	r := NewRouter()
+	r.Use(DecompressWithConfig(DecompressConfig{MaxSize: 1024}))
+	echo := func(c *Context) {
+		var body map[string]interface{}
+		if err := c.BindJSON(&body); err != nil {
+			c.Error(err)
+			return
+		}
+		c.JSON(http.StatusOK, body)
+	}
+	r.POST("/echo", echo)
+	r.POST("/large", echo).MaxBodySize(64 << 10)
+
+	payload := []byte(`{"name":"gopher"}`)
+	// Highly compressible, so small on the wire but past the limit
+	bomb := []byte(`{"padding":"` + strings.Repeat("a", 16<<10) + `"}`)
+
+	tests := []struct {
+		name     string
+		path     string
+		encoding string
+		body     []byte
+		status   int
+	}{
+		{"gzip", "/echo", "gzip", encodeBody(t, "gzip", payload), http.StatusOK},
+		{"x-gzip", "/echo", "x-gzip", encodeBody(t, "gzip", payload), http.StatusOK},
+		{"deflate", "/echo", "deflate", encodeBody(t, "deflate", payload), http.StatusOK},
+		{"zstd", "/echo", "zstd", encodeBody(t, "zstd", payload), http.StatusOK},
+		{"stacked", "/echo", "gzip, zstd", encodeBody(t, "zstd", encodeBody(t, "gzip", payload)), http.StatusOK},
+		{"identity", "/echo", "identity", payload, http.StatusOK},
+		{"plain", "/echo", "", payload, http.StatusOK},
+		{"unsupported", "/echo", "br", payload, http.StatusUnsupportedMediaType},
+		{"corrupt", "/echo", "gzip", []byte("not gzip"), http.StatusBadRequest},
+		{"expands past the limit", "/echo", "gzip", encodeBody(t, "gzip", bomb), http.StatusRequestEntityTooLarge},
+		{"route limit", "/large", "gzip", encodeBody(t, "gzip", bomb), http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := routertest.New(t, r).POST(tt.path).Body("application/json", tt.body)
+			if tt.encoding != "" {
+				req.Header("Content-Encoding", tt.encoding)
+			}
+			resp := req.Expect().Status(tt.status)
+			if tt.status == http.StatusOK && tt.path == "/echo" {
+				resp.JSONPath("$.name", "gopher")
+			}
+			if tt.status == http.StatusUnsupportedMediaType {
+				resp.Header("Accept-Encoding", "gzip, deflate, zstd")
+			}
+		})
+	}
+}
+
+func TestBodyLimit(t *testing.T) {
+	r := NewRouter()
+	r.Use(BodyLimit(16))
+	echo := func(c *Context) {
+		data, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			c.Error(err)
+			return
+		}
+		c.Data(http.StatusOK, "text/plain", data)
+	}
+	r.POST("/small", echo)
+	r.POST("/large", echo).MaxBodySize(1024)
+
+	tests := []struct {
+		path   string
+		size   int
+		status int
+	}{
+		{"/small", 16, http.StatusOK},
+		{"/small", 17, http.StatusRequestEntityTooLarge},
+		{"/large", 1024, http.StatusOK},
+		{"/large", 1025, http.StatusRequestEntityTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			routertest.New(t, r).POST(tt.path).Body("text/plain", bytes.Repeat([]byte("x"), tt.size)).Expect().Status(tt.status)
+		})
+	}
+}
@@ -0,0 +1,394 @@
+// Package breaker implements circuit breakers that stop calls to a failing
+// dependency for a while instead of piling up requests that are bound to
+// fail.
+//
+//	b := breaker.New(breaker.Config{Name: "postgres"})
+//	err := b.Execute(ctx, func(ctx context.Context) error {
+//		return db.QueryRowContext(ctx, query, id).Scan(&post.Title)
+//	})
+//
+// A breaker starts closed and counts successes and failures in a rolling
+// window. When the window trips it, the breaker opens and fails calls at
+// once with ErrOpen. After OpenTimeout it is half-open and lets a few
+// trial calls through: if they succeed it closes again, otherwise it
+// reopens.
+package breaker
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// State is the state of a circuit breaker
+type State int
+
+const (
+	// Closed lets all calls through
+	Closed State = iota
+	// Open fails all calls until OpenTimeout has passed
+	Open
+	// HalfOpen lets a limited number of trial calls through
+	HalfOpen
+)
+
+// String returns the name of the state
+func (s State) String() string {
+	switch s {
+	case Closed:
+		return "closed"
+	case Open:
+		return "open"
+	case HalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+var (
+	// ErrOpen is returned for calls rejected by an open breaker
+	ErrOpen = errors.New("breaker: circuit open")
+	// ErrTooManyRequests is returned for calls rejected by a half-open
+	// breaker whose trial calls are all in progress
+	ErrTooManyRequests = errors.New("breaker: too many trial requests")
+)
+
+// Counts holds the outcome of calls in the rolling window, plus the
+// current streaks
+type Counts struct {
+	Requests             int
+	Successes            int
+	Failures             int
+	ConsecutiveSuccesses int
+	ConsecutiveFailures  int
+}
+
+// FailureRatio returns the share of failed calls, or 0 without calls
+func (c Counts) FailureRatio() float64 {
+	if c.Requests == 0 {
+		return 0
+	}
+	return float64(c.Failures) / float64(c.Requests)
+}
+
+// Config configures a Breaker
+type Config struct {
+	// Name identifies the breaker in state change hooks
+	Name string
+	// Window is the length of the rolling window, default 10 seconds,
+	// split into Buckets buckets, default 10
+	Window  time.Duration
+	Buckets int
+	// MinRequests is the number of calls in the window needed before the
+	// breaker can trip, default 20
+	MinRequests int
+	// FailureRatio trips the breaker once this share of the calls in the
+	// window failed, default 0.5
+	FailureRatio float64
+	// ReadyToTrip replaces MinRequests and FailureRatio as trip condition.
+	// It is called with the counts after every failure while closed.
+	ReadyToTrip func(Counts) bool
+	// OpenTimeout is how long the breaker stays open, default 30 seconds
+	OpenTimeout time.Duration
+	// HalfOpenRequests is the number of trial calls let through while
+	// half-open; the breaker closes once they all succeed. Default 1.
+	HalfOpenRequests int
+	// IsFailure decides whether an error counts against the dependency,
+	// by default every error does. Calls that end with context.Canceled
+	// are not counted at all, since the caller gave up before the
+	// dependency answered.
+	IsFailure func(error) bool
+	// OnStateChange is called after the state changed, for example to
+	// update metrics. It must not call back into the breaker.
+	OnStateChange func(name string, from, to State)
+}
+
+// bucket holds the counts of one slice of the rolling window
+type bucket struct {
+	start     time.Time
+	successes int
+	failures  int
+}
+
+// Breaker is a circuit breaker. It is safe for concurrent use.
+type Breaker struct {
+	config Config
+	width  time.Duration
+
+	mu         sync.Mutex
+	state      State
+	generation uint64
+	buckets    []bucket
+	counts     Counts
+	openedAt   time.Time
+	trials     int
+	// changes holds state changes not yet passed to OnStateChange
+	changes [][2]State
+}
+
+// New returns a closed breaker
+func New(config Config) *Breaker {
+	if config.Window <= 0 {
+		config.Window = 10 * time.Second
+	}
+	if config.Buckets <= 0 {
+		config.Buckets = 10
+	}
+	if time.Duration(config.Buckets) > config.Window {
+		// Keep the buckets at least a nanosecond wide
+		config.Buckets = int(config.Window)
+	}
+	if config.MinRequests <= 0 {
+		config.MinRequests = 20
+	}
+	if config.FailureRatio <= 0 {
+		config.FailureRatio = 0.5
+	}
+	if config.OpenTimeout <= 0 {
+		config.OpenTimeout = 30 * time.Second
+	}
+	if config.HalfOpenRequests <= 0 {
+		config.HalfOpenRequests = 1
+	}
+	if config.IsFailure == nil {
+		config.IsFailure = func(err error) bool {
+			return err != nil
+		}
+	}
+	return &Breaker{
+		config:  config,
+		width:   config.Window / time.Duration(config.Buckets),
+		buckets: make([]bucket, config.Buckets),
+	}
+}
+
+// Name returns the name of the breaker
+func (b *Breaker) Name() string {
+	return b.config.Name
+}
+
+// State returns the current state
+func (b *Breaker) State() State {
+	b.mu.Lock()
+	defer b.unlock()
+	b.advance(time.Now())
+	return b.state
+}
+
+// Counts returns the counts of the rolling window
+func (b *Breaker) Counts() Counts {
+	b.mu.Lock()
+	defer b.unlock()
+	now := time.Now()
+	b.advance(now)
+	return b.windowCounts(now)
+}
+
+// RetryAfter returns the time until an open breaker lets trial calls
+// through, or 0 if it is not open
+func (b *Breaker) RetryAfter() time.Duration {
+	b.mu.Lock()
+	defer b.unlock()
+	now := time.Now()
+	b.advance(now)
+	if b.state != Open {
+		return 0
+	}
+	return b.openedAt.Add(b.config.OpenTimeout).Sub(now)
+}
+
+// Reset closes the breaker and clears its counts
+func (b *Breaker) Reset() {
+	b.mu.Lock()
+	b.setState(Closed, time.Now())
+	b.unlock()
+}
+
+// Allow asks to make a call. If the call may proceed, done must be called
+// with its result; otherwise err is ErrOpen or ErrTooManyRequests.
+func (b *Breaker) Allow() (done func(err error), err error) {
+	b.mu.Lock()
+	b.advance(time.Now())
+	switch b.state {
+	case Open:
+		b.unlock()
+		return nil, ErrOpen
+	case HalfOpen:
+		if b.trials >= b.config.HalfOpenRequests {
+			b.unlock()
+			return nil, ErrTooManyRequests
+		}
+		b.trials++
+	}
+	generation := b.generation
+	b.unlock()
+
+	var once sync.Once
+	return func(err error) {
+		once.Do(func() {
+			if errors.Is(err, context.Canceled) {
+				b.release(generation)
+				return
+			}
+			b.record(generation, b.config.IsFailure(err))
+		})
+	}, nil
+}
+
+// Execute calls fn unless the breaker is open and records its result.
+// Panics in fn count as failures.
+func (b *Breaker) Execute(ctx context.Context, fn func(context.Context) error) error {
+	done, err := b.Allow()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if p := recover(); p != nil {
+			done(errors.New("breaker: panic"))
+			panic(p)
+		}
+	}()
+	err = fn(ctx)
+	done(err)
+	return err
+}
+
+// ExecuteWithFallback is like Execute but calls fallback with the error
+// when the breaker rejects the call or fn fails
+func (b *Breaker) ExecuteWithFallback(ctx context.Context, fn func(context.Context) error, fallback func(context.Context, error) error) error {
+	if err := b.Execute(ctx, fn); err != nil {
+		return fallback(ctx, err)
+	}
+	return nil
+}
+
+// Do calls fn through the breaker and returns its result
+func Do[T any](ctx context.Context, b *Breaker, fn func(context.Context) (T, error)) (T, error) {
+	var result T
+	err := b.Execute(ctx, func(ctx context.Context) error {
+		var err error
+		result, err = fn(ctx)
+		return err
+	})
+	return result, err
+}
+
+// record counts the result of a call made in generation; results of calls
+// started before the last state change are ignored
+func (b *Breaker) record(generation uint64, failed bool) {
+	b.mu.Lock()
+	defer b.unlock()
+	now := time.Now()
+	b.advance(now)
+	if generation != b.generation {
+		return
+	}
+
+	cur := b.bucket(now)
+	if failed {
+		cur.failures++
+		b.counts.ConsecutiveFailures++
+		b.counts.ConsecutiveSuccesses = 0
+	} else {
+		cur.successes++
+		b.counts.ConsecutiveSuccesses++
+		b.counts.ConsecutiveFailures = 0
+	}
+
+	switch b.state {
+	case Closed:
+		if failed && b.readyToTrip(b.windowCounts(now)) {
+			b.setState(Open, now)
+		}
+	case HalfOpen:
+		if failed {
+			b.setState(Open, now)
+		} else if b.counts.ConsecutiveSuccesses >= b.config.HalfOpenRequests {
+			b.setState(Closed, now)
+		}
+	}
+}
+
+// release frees the trial slot of a call made in generation without
+// counting its result
+func (b *Breaker) release(generation uint64) {
+	b.mu.Lock()
+	defer b.unlock()
+	if generation == b.generation && b.state == HalfOpen && b.trials > 0 {
+		b.trials--
+	}
+}
+
+// readyToTrip applies the trip condition
+func (b *Breaker) readyToTrip(counts Counts) bool {
+	if b.config.ReadyToTrip != nil {
+		return b.config.ReadyToTrip(counts)
+	}
+	return counts.Requests >= b.config.MinRequests && counts.FailureRatio() >= b.config.FailureRatio
+}
+
+// advance moves an open breaker to half-open once OpenTimeout has passed;
+// b.mu must be held
+func (b *Breaker) advance(now time.Time) {
+	if b.state == Open && !now.Before(b.openedAt.Add(b.config.OpenTimeout)) {
+		b.setState(HalfOpen, now)
+	}
+}
+
+// setState switches to state and starts a new generation with empty
+// counts; b.mu must be held
+func (b *Breaker) setState(state State, now time.Time) {
+	if state != b.state {
+		b.changes = append(b.changes, [2]State{b.state, state})
+	}
+	b.state = state
+	b.generation++
+	b.counts = Counts{}
+	b.trials = 0
+	for i := range b.buckets {
+		b.buckets[i] = bucket{}
+	}
+	if state == Open {
+		b.openedAt = now
+	}
+}
+
+// bucket returns the bucket for now, clearing it if it is stale; b.mu
+// must be held
+func (b *Breaker) bucket(now time.Time) *bucket {
+	start := now.Truncate(b.width)
+	cur := &b.buckets[int(start.UnixNano()/int64(b.width))%len(b.buckets)]
+	if !cur.start.Equal(start) {
+		*cur = bucket{start: start}
+	}
+	return cur
+}
+
+// windowCounts sums the buckets inside the window; b.mu must be held
+func (b *Breaker) windowCounts(now time.Time) Counts {
+	counts := b.counts
+	counts.Requests, counts.Successes, counts.Failures = 0, 0, 0
+	oldest := now.Truncate(b.width).Add(-b.config.Window + b.width)
+	for _, bk := range b.buckets {
+		if !bk.start.IsZero() && !bk.start.Before(oldest) {
+			counts.Successes += bk.successes
+			counts.Failures += bk.failures
+		}
+	}
+	counts.Requests = counts.Successes + counts.Failures
+	return counts
+}
+
+// unlock releases b.mu and then reports the pending state changes
+func (b *Breaker) unlock() {
+	changes := b.changes
+	b.changes = nil
+	b.mu.Unlock()
+	if b.config.OnStateChange == nil {
+		return
+	}
+	for _, change := range changes {
+		b.config.OnStateChange(b.config.Name, change[0], change[1])
+	}
+}
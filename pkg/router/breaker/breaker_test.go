@@ -0,0 +1,310 @@
+package breaker
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+var (
+	errFailed   = errors.New("failed")
+	errNotFound = errors.New("not found")
+)
+
+// step makes one call through the breaker after sleeping for wait and
+// checks the outcome
+type step struct {
+	wait   time.Duration
+	result error
+	reject error
+	state  State
+}
+
+func TestBreaker(t *testing.T) {
+	tests := []struct {
+		name   string
+		config Config
+		steps  []step
+	}{
+		{
+			name:   "trips at the failure ratio",
+			config: Config{MinRequests: 4, FailureRatio: 0.5},
+			steps: []step{
+				{result: nil, state: Closed},
+				{result: errFailed, state: Closed},
+				{result: nil, state: Closed},
+				{result: errFailed, state: Open},
+				{reject: ErrOpen, state: Open},
+			},
+		},
+		{
+			name:   "needs MinRequests",
+			config: Config{MinRequests: 3},
+			steps: []step{
+				{result: errFailed, state: Closed},
+				{result: errFailed, state: Closed},
+				{result: errFailed, state: Open},
+			},
+		},
+		{
+			name:   "below the failure ratio",
+			config: Config{MinRequests: 2, FailureRatio: 0.6},
+			steps: []step{
+				{result: nil, state: Closed},
+				{result: errFailed, state: Closed},
+				{result: nil, state: Closed},
+				{result: errFailed, state: Closed},
+			},
+		},
+		{
+			name: "ReadyToTrip",
+			config: Config{ReadyToTrip: func(counts Counts) bool {
+				return counts.ConsecutiveFailures >= 2
+			}},
+			steps: []step{
+				{result: errFailed, state: Closed},
+				{result: nil, state: Closed},
+				{result: errFailed, state: Closed},
+				{result: errFailed, state: Open},
+			},
+		},
+		{
+			name:   "IsFailure",
+			config: Config{MinRequests: 1, FailureRatio: 0.3, IsFailure: func(err error) bool { return err != nil && err != errNotFound }},
+			steps: []step{
+				{result: errNotFound, state: Closed},
+				{result: errNotFound, state: Closed},
+				{result: errFailed, state: Open},
+			},
+		},
+		{
+			name:   "canceled calls are not counted",
+			config: Config{MinRequests: 1},
+			steps: []step{
+				{result: context.Canceled, state: Closed},
+				{result: context.Canceled, state: Closed},
+				{result: context.DeadlineExceeded, state: Open},
+			},
+		},
+		{
+			name:   "old failures leave the window",
+			config: Config{MinRequests: 2, Window: 40 * time.Millisecond, Buckets: 4},
+			steps: []step{
+				{result: errFailed, state: Closed},
+				{wait: 60 * time.Millisecond, result: errFailed, state: Closed},
+				{result: errFailed, state: Open},
+			},
+		},
+		{
+			name:   "half-open success closes",
+			config: Config{MinRequests: 1, OpenTimeout: 20 * time.Millisecond},
+			steps: []step{
+				{result: errFailed, state: Open},
+				{reject: ErrOpen, state: Open},
+				{wait: 30 * time.Millisecond, result: nil, state: Closed},
+				{result: nil, state: Closed},
+			},
+		},
+		{
+			name:   "half-open failure reopens",
+			config: Config{MinRequests: 1, OpenTimeout: 20 * time.Millisecond},
+			steps: []step{
+				{result: errFailed, state: Open},
+				{wait: 30 * time.Millisecond, result: errFailed, state: Open},
+				{reject: ErrOpen, state: Open},
+			},
+		},
+		{
+			name:   "every trial must succeed",
+			config: Config{MinRequests: 1, OpenTimeout: 20 * time.Millisecond, HalfOpenRequests: 2},
+			steps: []step{
+				{result: errFailed, state: Open},
+				{wait: 30 * time.Millisecond, result: nil, state: HalfOpen},
+				{result: nil, state: Closed},
+			},
+		},
+		{
+			name:   "more buckets than nanoseconds",
+			config: Config{MinRequests: 1, Window: 5, Buckets: 10},
+			steps: []step{
+				{result: nil, state: Closed},
+				{result: errFailed, state: Open},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := New(tt.config)
+			for i, s := range tt.steps {
+				time.Sleep(s.wait)
+				done, err := b.Allow()
+				if err != s.reject {
+					t.Fatalf("step %d: Allow() error = %v, want %v", i, err, s.reject)
+				}
+				if err == nil {
+					done(s.result)
+				}
+				if state := b.State(); state != s.state {
+					t.Fatalf("step %d: state = %v, want %v", i, state, s.state)
+				}
+			}
+		})
+	}
+}
+
+func TestBreakerTrials(t *testing.T) {
+	b := New(Config{MinRequests: 1, OpenTimeout: 20 * time.Millisecond})
+	done, _ := b.Allow()
+	done(errFailed)
+	if retry := b.RetryAfter(); retry <= 0 || retry > 20*time.Millisecond {
+		t.Fatalf("RetryAfter() = %v, want up to 20ms", retry)
+	}
+	time.Sleep(30 * time.Millisecond)
+	if state := b.State(); state != HalfOpen {
+		t.Fatalf("state = %v, want half-open", state)
+	}
+	if retry := b.RetryAfter(); retry != 0 {
+		t.Fatalf("RetryAfter() = %v while half-open", retry)
+	}
+
+	// The trial slot is taken until its call finishes
+	trial, err := b.Allow()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.Allow(); err != ErrTooManyRequests {
+		t.Fatalf("second trial error = %v, want ErrTooManyRequests", err)
+	}
+
+	// A canceled trial frees the slot without deciding anything
+	trial(context.Canceled)
+	trial(nil)
+	if state := b.State(); state != HalfOpen {
+		t.Fatalf("state after canceled trial = %v, want half-open", state)
+	}
+	trial, err = b.Allow()
+	if err != nil {
+		t.Fatalf("trial after cancel: %v", err)
+	}
+
+	// Results of calls from before the last state change are ignored
+	b.Reset()
+	trial(errFailed)
+	if counts := b.Counts(); counts.Requests != 0 || b.State() != Closed {
+		t.Fatalf("stale result counted: %+v, state %v", counts, b.State())
+	}
+}
+
+func TestBreakerOnStateChange(t *testing.T) {
+	var mu sync.Mutex
+	var changes []string
+	b := New(Config{
+		Name:        "db",
+		MinRequests: 1,
+		OpenTimeout: 10 * time.Millisecond,
+		OnStateChange: func(name string, from, to State) {
+			mu.Lock()
+			defer mu.Unlock()
+			changes = append(changes, name+": "+from.String()+" -> "+to.String())
+		},
+	})
+
+	b.Execute(context.Background(), func(context.Context) error { return errFailed })
+	time.Sleep(20 * time.Millisecond)
+	b.Execute(context.Background(), func(context.Context) error { return errFailed })
+	time.Sleep(20 * time.Millisecond)
+	b.Execute(context.Background(), func(context.Context) error { return nil })
+	b.Execute(context.Background(), func(context.Context) error { return errFailed })
+	b.Reset()
+	b.Reset()
+
+	want := []string{
+		"db: closed -> open",
+		"db: open -> half-open",
+		"db: half-open -> open",
+		"db: open -> half-open",
+		"db: half-open -> closed",
+		"db: closed -> open",
+		"db: open -> closed",
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if !reflect.DeepEqual(changes, want) {
+		t.Fatalf("changes = %q, want %q", changes, want)
+	}
+}
+
+func TestExecute(t *testing.T) {
+	b := New(Config{MinRequests: 1})
+
+	got, err := Do(context.Background(), b, func(context.Context) (int, error) { return 42, nil })
+	if got != 42 || err != nil {
+		t.Fatalf("Do() = %d, %v", got, err)
+	}
+
+	func() {
+		defer func() {
+			if recover() != "boom" {
+				t.Error("panic was not re-raised")
+			}
+		}()
+		b.Execute(context.Background(), func(context.Context) error { panic("boom") })
+	}()
+	if state := b.State(); state != Open {
+		t.Fatalf("state after panic = %v, want open", state)
+	}
+
+	err = b.ExecuteWithFallback(context.Background(),
+		func(context.Context) error { t.Error("call went through an open breaker"); return nil },
+		func(_ context.Context, err error) error {
+			if err != ErrOpen {
+				t.Errorf("fallback error = %v, want ErrOpen", err)
+			}
+			return nil
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTransport(t *testing.T) {
+	status := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	b := New(Config{MinRequests: 2, FailureRatio: 0.3})
+	client := &http.Client{Transport: &Transport{Breaker: b}}
+	tests := []struct {
+		status int
+		err    error
+		state  State
+	}{
+		{http.StatusOK, nil, Closed},
+		{http.StatusNotFound, nil, Closed},
+		{http.StatusBadGateway, nil, Open},
+		{http.StatusOK, ErrOpen, Open},
+	}
+	for _, tt := range tests {
+		status = tt.status
+		resp, err := client.Get(server.URL)
+		if !errors.Is(err, tt.err) {
+			t.Fatalf("status %d: error = %v, want %v", tt.status, err, tt.err)
+		}
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode != tt.status {
+				t.Fatalf("status = %d, want %d", resp.StatusCode, tt.status)
+			}
+		}
+		if state := b.State(); state != tt.state {
+			t.Fatalf("status %d: state = %v, want %v", tt.status, state, tt.state)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package breaker
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// StatusError is recorded as the failure of a call whose response status
+// counted as a failure
+type StatusError struct {
+	StatusCode int
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("breaker: response status %d", e.StatusCode)
+}
+
+// Transport is an http.RoundTripper that sends requests through a
+// breaker, for outbound calls with an http.Client:
+//
+//	client := &http.Client{Transport: &breaker.Transport{Breaker: b}}
+//
+// Requests rejected by the breaker fail with ErrOpen or
+// ErrTooManyRequests without reaching the network.
+type Transport struct {
+	Breaker *Breaker
+	// Base sends the requests, default http.DefaultTransport
+	Base http.RoundTripper
+	// IsFailure decides whether a response counts as a failure, default
+	// a 5xx status
+	IsFailure func(*http.Response) bool
+}
+
+// RoundTrip sends the request unless the breaker is open
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.Breaker == nil {
+		return nil, errors.New("breaker: Transport without Breaker")
+	}
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	done, err := t.Breaker.Allow()
+	if err != nil {
+		return nil, err
+	}
+	resp, err := base.RoundTrip(req)
+	if err != nil {
+		done(err)
+		return nil, err
+	}
+	failed := resp.StatusCode >= 500
+	if t.IsFailure != nil {
+		failed = t.IsFailure(resp)
+	}
+	if failed {
+		done(&StatusError{StatusCode: resp.StatusCode})
+	} else {
+		done(nil)
+	}
+	return resp, nil
+}
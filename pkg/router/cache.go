@@ -0,0 +1,611 @@
+package router
+
+import (
+	"bufio"
+	"bytes"
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// cacheKey is the Context key of the cache state of the request
+const cacheKey = "Cache"
+
+// CachedResponse is a stored response
+type CachedResponse struct {
+	Status   int
+	Header   http.Header
+	Body     []byte
+	Tags     []string
+	StoredAt time.Time
+	Expires  time.Time
+}
+
+// CacheStore keeps cached responses
+type CacheStore interface {
+	// Get returns the response stored under key, or nil if there is none
+	// or it has expired
+	Get(ctx context.Context, key string) (*CachedResponse, error)
+	// Set stores the response under key until resp.Expires
+	Set(ctx context.Context, key string, resp *CachedResponse) error
+	// Delete removes the response stored under key
+	Delete(ctx context.Context, key string) error
+	// InvalidateTags removes every response stored with one of the tags
+	InvalidateTags(ctx context.Context, tags ...string) error
+}
+
+// CacheConfig configures Cache
+type CacheConfig struct {
+	// Store keeps the responses, default a new MemoryCacheStore
+	Store CacheStore
+	// TTL is how long responses are kept when they set no max-age,
+	// default one minute
+	TTL time.Duration
+	// VaryHeaders lists the request headers that select different
+	// responses, such as Accept or Accept-Language. They are part of the
+	// key. Requests with an Authorization or Cookie header are only cached
+	// if it is listed, and responses with a Vary header naming other
+	// request headers are not stored.
+	VaryHeaders []string
+	// Prefix is prepended to keys so several caches can share a store
+	Prefix string
+	// MaxBodySize is the largest response body stored, default 1 MB.
+	// Larger responses are streamed and not cached.
+	MaxBodySize int
+	// WeakETags makes the generated ETags weak
+	WeakETags bool
+	// Skip bypasses the cache when it returns true
+	Skip func(*Context) bool
+}
+
+// cacheState is stored in the Context for CacheTags and InvalidateCache
+type cacheState struct {
+	store CacheStore
+	tags  []string
+}
+
+// Cache is a middleware that caches GET responses. Responses are keyed
+// by path, query and the configured request headers and kept for their
+// Cache-Control max-age, s-maxage or the configured TTL. Responses that
+// are private, no-store, set cookies, vary on headers not in VaryHeaders
+// or have a status that is not cacheable are not stored, and requests
+// sending Cache-Control no-cache skip the lookup. Every cacheable
+// response gets an ETag and a Last-Modified header, and conditional
+// requests with If-None-Match or If-Modified-Since are answered with 304,
+// whether cached or not.
+//
+// Handlers attach tags to a response with c.CacheTags and drop all
+// responses with a tag with c.InvalidateCache, for example after an
+// update. HEAD requests are answered from cached GET responses.
+func Cache(config CacheConfig) MiddlewareFunc {
+	if config.Store == nil {
+		config.Store = NewMemoryCacheStore(MemoryCacheConfig{})
+	}
+	if config.TTL <= 0 {
+		config.TTL = time.Minute
+	}
+	if config.MaxBodySize <= 0 {
+		config.MaxBodySize = 1 << 20
+	}
+	varies := make(map[string]bool, len(config.VaryHeaders))
+	vary := make([]string, len(config.VaryHeaders))
+	for i, name := range config.VaryHeaders {
+		vary[i] = http.CanonicalHeaderKey(name)
+		varies[vary[i]] = true
+	}
+	config.VaryHeaders = vary
+
+	return func(next HandlerFunc) HandlerFunc {
+		return func(c *Context) {
+			state := &cacheState{store: config.Store}
+			c.Set(cacheKey, state)
+
+			method := c.Request.Method
+			// Credentials select a user's own response, which must not be
+			// served to others
+			if method != http.MethodGet && method != http.MethodHead ||
+				(config.Skip != nil && config.Skip(c)) ||
+				(!varies["Authorization"] && c.GetHeader("Authorization") != "") ||
+				(!varies["Cookie"] && c.GetHeader("Cookie") != "") {
+				next(c)
+				return
+			}
+			directives := parseCacheControl(c.Request.Header.Get("Cache-Control"))
+			if _, ok := directives["no-store"]; ok {
+				next(c)
+				return
+			}
+
+			ctx := c.Request.Context()
+			key := config.key(c.Request)
+			_, noCache := directives["no-cache"]
+			if maxAge, ok := directives["max-age"]; ok && maxAge == "0" {
+				noCache = true
+			}
+			if c.Request.Header.Get("Pragma") == "no-cache" {
+				noCache = true
+			}
+			if !noCache {
+				resp, err := config.Store.Get(ctx, key)
+				if err != nil {
+					log.Printf("Cache: store error: %v", err)
+				} else if resp != nil {
+					serveCached(c, resp, "HIT")
+					return
+				}
+			}
+			if method == http.MethodHead {
+				next(c)
+				return
+			}
+
+			// Headers set by earlier middleware, such as X-Request-ID, belong
+			// to this request only and are not stored
+			before := c.Writer.Header().Clone()
+			cw := &cacheWriter{ResponseWriter: c.Writer, limit: config.MaxBodySize, status: http.StatusOK}
+			c.Writer = cw
+			next(c)
+			c.Writer = cw.ResponseWriter
+			if cw.passthrough || !cw.wroteHeader {
+				return
+			}
+
+			now := time.Now()
+			resp := &CachedResponse{
+				Status:   cw.status,
+				Header:   headerChanges(before, c.Writer.Header()),
+				Body:     cw.buf.Bytes(),
+				Tags:     sortedTags(state.tags),
+				StoredAt: now,
+			}
+			// A response the handler varied on headers that are not part of
+			// the key, such as Accept-Encoding set by Compress, would be
+			// served to clients that did not ask for it
+			ttl, ok := cacheLifetime(resp, config.TTL)
+			if ok && !coversVary(varies, before, c.Writer.Header()) {
+				ok = false
+			}
+			if ok {
+				if resp.Header.Get("ETag") == "" && resp.Status == http.StatusOK {
+					resp.Header.Set("ETag", computeETag(resp.Body, config.WeakETags))
+				}
+				if resp.Header.Get("Last-Modified") == "" {
+					resp.Header.Set("Last-Modified", now.UTC().Format(http.TimeFormat))
+				}
+				resp.Expires = now.Add(ttl)
+				if err := config.Store.Set(ctx, key, resp); err != nil {
+					log.Printf("Cache: store error: %v", err)
+				}
+			}
+			serveCached(c, resp, "MISS")
+		}
+	}
+}
+
+// key returns the store key of a request
+func (config *CacheConfig) key(req *http.Request) string {
+	var b strings.Builder
+	b.WriteString(http.MethodGet)
+	b.WriteByte(' ')
+	b.WriteString(req.URL.Path)
+	if req.URL.RawQuery != "" {
+		// Encode sorts the parameters so their order does not matter
+		b.WriteByte('?')
+		b.WriteString(req.URL.Query().Encode())
+	}
+	for _, name := range config.VaryHeaders {
+		b.WriteByte('\n')
+		b.WriteString(name)
+		b.WriteByte(':')
+		b.WriteString(strings.Join(req.Header.Values(name), ","))
+	}
+	sum := sha256.Sum256([]byte(b.String()))
+	return config.Prefix + hex.EncodeToString(sum[:])
+}
+
+// coversVary reports whether every request header named in the Vary values
+// added to the response after before was taken is in varies
+func coversVary(varies map[string]bool, before, after http.Header) bool {
+	existing := make(map[string]bool)
+	for _, name := range headerTokens(before.Values("Vary")) {
+		existing[name] = true
+	}
+	for _, name := range headerTokens(after.Values("Vary")) {
+		if !existing[name] && !varies[name] {
+			return false
+		}
+	}
+	return true
+}
+
+// headerTokens splits comma-separated header values into canonical names
+func headerTokens(values []string) []string {
+	var names []string
+	for _, value := range values {
+		for _, name := range strings.Split(value, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				names = append(names, http.CanonicalHeaderKey(name))
+			}
+		}
+	}
+	return names
+}
+
+// headerChanges returns the headers of after that differ from before
+func headerChanges(before, after http.Header) http.Header {
+	changed := make(http.Header)
+	for name, values := range after {
+		if old, ok := before[name]; !ok || strings.Join(old, "\n") != strings.Join(values, "\n") {
+			changed[name] = append([]string(nil), values...)
+		}
+	}
+	return changed
+}
+
+// cacheableStatus lists the status codes that may be stored
+var cacheableStatus = map[int]bool{
+	http.StatusOK:                   true,
+	http.StatusNonAuthoritativeInfo: true,
+	http.StatusNoContent:            true,
+	http.StatusMultipleChoices:      true,
+	http.StatusMovedPermanently:     true,
+	http.StatusPermanentRedirect:    true,
+	http.StatusNotFound:             true,
+	http.StatusGone:                 true,
+}
+
+// cacheLifetime reports whether the response may be stored and for how long
+func cacheLifetime(resp *CachedResponse, ttl time.Duration) (time.Duration, bool) {
+	if !cacheableStatus[resp.Status] || len(resp.Header.Values("Set-Cookie")) > 0 {
+		return 0, false
+	}
+	for _, vary := range resp.Header.Values("Vary") {
+		if strings.TrimSpace(vary) == "*" {
+			return 0, false
+		}
+	}
+	directives := parseCacheControl(resp.Header.Get("Cache-Control"))
+	for _, d := range []string{"no-store", "no-cache", "private"} {
+		if _, ok := directives[d]; ok {
+			return 0, false
+		}
+	}
+	for _, d := range []string{"s-maxage", "max-age"} {
+		if v, ok := directives[d]; ok {
+			seconds, err := strconv.Atoi(v)
+			if err != nil || seconds <= 0 {
+				return 0, false
+			}
+			return time.Duration(seconds) * time.Second, true
+		}
+	}
+	return ttl, true
+}
+
+// parseCacheControl returns the directives of a Cache-Control header with
+// lowercase names
+func parseCacheControl(header string) map[string]string {
+	directives := make(map[string]string)
+	for _, part := range strings.Split(header, ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		if name != "" {
+			directives[strings.ToLower(name)] = strings.Trim(value, `"`)
+		}
+	}
+	return directives
+}
+
+// computeETag returns an ETag derived from the body
+func computeETag(body []byte, weak bool) string {
+	sum := sha256.Sum256(body)
+	etag := `"` + base64.RawURLEncoding.EncodeToString(sum[:16]) + `"`
+	if weak {
+		return "W/" + etag
+	}
+	return etag
+}
+
+// serveCached writes resp, or 304 if the request's validators match it
+func serveCached(c *Context, resp *CachedResponse, status string) {
+	header := c.Writer.Header()
+	for name, values := range resp.Header {
+		header[name] = append([]string(nil), values...)
+	}
+	header.Set("X-Cache", status)
+	if status == "HIT" {
+		header.Set("Age", strconv.Itoa(int(time.Since(resp.StoredAt).Seconds())))
+	}
+	if resp.Status == http.StatusOK && notModified(c.Request, header) {
+		for _, name := range []string{"Content-Type", "Content-Length", "Content-Encoding"} {
+			header.Del(name)
+		}
+		c.Writer.WriteHeader(http.StatusNotModified)
+		return
+	}
+	header.Set("Content-Length", strconv.Itoa(len(resp.Body)))
+	c.Writer.WriteHeader(resp.Status)
+	if c.Request.Method != http.MethodHead {
+		c.Writer.Write(resp.Body)
+	}
+}
+
+// notModified evaluates If-None-Match, or failing that If-Modified-Since,
+// against the validators of a response
+func notModified(req *http.Request, header http.Header) bool {
+	if inm := req.Header.Get("If-None-Match"); inm != "" {
+		etag := strings.TrimPrefix(header.Get("ETag"), "W/")
+		if etag == "" {
+			return false
+		}
+		for _, candidate := range strings.Split(inm, ",") {
+			candidate = strings.TrimSpace(candidate)
+			if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+				return true
+			}
+		}
+		return false
+	}
+	since, err := http.ParseTime(req.Header.Get("If-Modified-Since"))
+	if err != nil {
+		return false
+	}
+	modified, err := http.ParseTime(header.Get("Last-Modified"))
+	return err == nil && !modified.After(since)
+}
+
+// CacheTags attaches tags to the response being cached, so it can be
+// dropped with InvalidateCache
+func (c *Context) CacheTags(tags ...string) {
+	value, _ := c.Get(cacheKey)
+	if state, ok := value.(*cacheState); ok {
+		state.tags = append(state.tags, tags...)
+	}
+}
+
+// InvalidateCache removes every cached response with one of the tags
+func (c *Context) InvalidateCache(tags ...string) error {
+	value, _ := c.Get(cacheKey)
+	state, ok := value.(*cacheState)
+	if !ok {
+		return errors.New("router: InvalidateCache requires the Cache middleware")
+	}
+	return state.store.InvalidateTags(c.Request.Context(), tags...)
+}
+
+// cacheWriter buffers a response so it can be stored. Responses larger
+// than the limit or flushed early are streamed instead.
+type cacheWriter struct {
+	http.ResponseWriter
+	limit int
+
+	status      int
+	wroteHeader bool
+	passthrough bool
+	buf         bytes.Buffer
+}
+
+// WriteHeader records the status
+func (w *cacheWriter) WriteHeader(code int) {
+	if w.passthrough {
+		w.ResponseWriter.WriteHeader(code)
+		return
+	}
+	if w.wroteHeader {
+		return
+	}
+	if code < 200 {
+		w.ResponseWriter.WriteHeader(code)
+		return
+	}
+	w.status = code
+	w.wroteHeader = true
+}
+
+// Write buffers data until the limit is reached
+func (w *cacheWriter) Write(data []byte) (int, error) {
+	w.wroteHeader = true
+	if !w.passthrough && w.buf.Len()+len(data) > w.limit {
+		if err := w.stream(); err != nil {
+			return 0, err
+		}
+	}
+	if w.passthrough {
+		return w.ResponseWriter.Write(data)
+	}
+	return w.buf.Write(data)
+}
+
+// Flush gives up caching and sends everything written so far
+func (w *cacheWriter) Flush() {
+	w.wroteHeader = true
+	if !w.passthrough {
+		w.stream()
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// stream sends the buffered response and passes later writes through
+func (w *cacheWriter) stream() error {
+	w.passthrough = true
+	w.ResponseWriter.WriteHeader(w.status)
+	if w.buf.Len() == 0 {
+		return nil
+	}
+	_, err := w.ResponseWriter.Write(w.buf.Bytes())
+	w.buf = bytes.Buffer{}
+	return err
+}
+
+// Hijack lets the caller take over the connection
+func (w *cacheWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if h, ok := w.ResponseWriter.(http.Hijacker); ok {
+		w.passthrough = true
+		return h.Hijack()
+	}
+	return nil, nil, fmt.Errorf("router: %T does not support hijacking", w.ResponseWriter)
+}
+
+// Unwrap returns the underlying writer for http.ResponseController
+func (w *cacheWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// MemoryCacheConfig configures a MemoryCacheStore
+type MemoryCacheConfig struct {
+	// MaxEntries is the number of responses kept, default 10000
+	MaxEntries int
+	// MaxBytes bounds the total size of the stored bodies, default 64 MB
+	MaxBytes int64
+}
+
+// MemoryCacheStore keeps responses in memory and evicts the least
+// recently used ones when full
+type MemoryCacheStore struct {
+	mu      sync.Mutex
+	config  MemoryCacheConfig
+	lru     *list.List
+	entries map[string]*list.Element
+	tags    map[string]map[string]struct{}
+	bytes   int64
+}
+
+type memoryCacheEntry struct {
+	key  string
+	resp *CachedResponse
+}
+
+// NewMemoryCacheStore returns an empty in-memory store
+func NewMemoryCacheStore(config MemoryCacheConfig) *MemoryCacheStore {
+	if config.MaxEntries <= 0 {
+		config.MaxEntries = 10000
+	}
+	if config.MaxBytes <= 0 {
+		config.MaxBytes = 64 << 20
+	}
+	return &MemoryCacheStore{
+		config:  config,
+		lru:     list.New(),
+		entries: make(map[string]*list.Element),
+		tags:    make(map[string]map[string]struct{}),
+	}
+}
+
+// Get returns the response stored under key
+func (s *MemoryCacheStore) Get(ctx context.Context, key string) (*CachedResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	elem, ok := s.entries[key]
+	if !ok {
+		return nil, nil
+	}
+	entry := elem.Value.(*memoryCacheEntry)
+	if time.Now().After(entry.resp.Expires) {
+		s.remove(elem)
+		return nil, nil
+	}
+	s.lru.MoveToFront(elem)
+	return entry.resp, nil
+}
+
+// Set stores the response, evicting old ones to make room
+func (s *MemoryCacheStore) Set(ctx context.Context, key string, resp *CachedResponse) error {
+	size := int64(len(resp.Body))
+	if size > s.config.MaxBytes {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if elem, ok := s.entries[key]; ok {
+		s.remove(elem)
+	}
+	for s.lru.Len() >= s.config.MaxEntries || s.bytes+size > s.config.MaxBytes {
+		s.remove(s.lru.Back())
+	}
+	s.entries[key] = s.lru.PushFront(&memoryCacheEntry{key: key, resp: resp})
+	s.bytes += size
+	for _, tag := range resp.Tags {
+		if s.tags[tag] == nil {
+			s.tags[tag] = make(map[string]struct{})
+		}
+		s.tags[tag][key] = struct{}{}
+	}
+	return nil
+}
+
+// Delete removes the response stored under key
+func (s *MemoryCacheStore) Delete(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if elem, ok := s.entries[key]; ok {
+		s.remove(elem)
+	}
+	return nil
+}
+
+// InvalidateTags removes the responses stored with any of the tags
+func (s *MemoryCacheStore) InvalidateTags(ctx context.Context, tags ...string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, tag := range tags {
+		for key := range s.tags[tag] {
+			if elem, ok := s.entries[key]; ok {
+				s.remove(elem)
+			}
+		}
+		delete(s.tags, tag)
+	}
+	return nil
+}
+
+// Len returns the number of stored responses
+func (s *MemoryCacheStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lru.Len()
+}
+
+// remove drops an entry and its tag references; s.mu must be held
+func (s *MemoryCacheStore) remove(elem *list.Element) {
+	entry := s.lru.Remove(elem).(*memoryCacheEntry)
+	delete(s.entries, entry.key)
+	s.bytes -= int64(len(entry.resp.Body))
+	for _, tag := range entry.resp.Tags {
+		if keys := s.tags[tag]; keys != nil {
+			delete(keys, entry.key)
+			if len(keys) == 0 {
+				delete(s.tags, tag)
+			}
+		}
+	}
+}
+
+// sortedTags returns the tags sorted without duplicates
+func sortedTags(tags []string) []string {
+	if len(tags) == 0 {
+		return tags
+	}
+	out := append([]string(nil), tags...)
+	sort.Strings(out)
+	n := 1
+	for _, tag := range out[1:] {
+		if tag != out[n-1] {
+			out[n] = tag
+			n++
+		}
+	}
+	return out[:n]
+}
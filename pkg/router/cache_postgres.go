@@ -0,0 +1,129 @@
+package router
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// PostgresCacheStore keeps cached responses in a PostgreSQL table shared
+// by all instances, so an invalidation on one instance applies to all
+type PostgresCacheStore struct {
+	*postgresTable
+}
+
+// PostgresCacheConfig configures a PostgresCacheStore
+type PostgresCacheConfig struct {
+	// Table is the name of the table, default http_cache. It is created
+	// if it does not exist.
+	Table string
+	// CleanupInterval is how often expired responses are deleted, default
+	// five minutes. A negative value disables the cleanup.
+	CleanupInterval time.Duration
+}
+
+// NewPostgresCacheStore creates the cache table if needed and starts the
+// periodic cleanup of expired responses. Call Close to stop it.
+func NewPostgresCacheStore(db *DB, config PostgresCacheConfig) (*PostgresCacheStore, error) {
+	if config.Table == "" {
+		config.Table = "http_cache"
+	}
+	if config.CleanupInterval == 0 {
+		config.CleanupInterval = 5 * time.Minute
+	}
+
+	t, err := newPostgresTable(db, config.Table, func(table string) []string {
+		return []string{
+			`CREATE TABLE IF NOT EXISTS ` + table + ` (
+				key        TEXT PRIMARY KEY,
+				status     INTEGER NOT NULL,
+				header     JSONB NOT NULL,
+				body       BYTEA NOT NULL,
+				tags       TEXT[] NOT NULL DEFAULT '{}',
+				stored_at  TIMESTAMPTZ NOT NULL,
+				expires_at TIMESTAMPTZ NOT NULL
+			)`,
+			`CREATE INDEX IF NOT EXISTS ` +
+				pq.QuoteIdentifier(config.Table+"_tags_idx") + ` ON ` + table + ` USING GIN (tags)`,
+		}
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error creating cache table: %w", err)
+	}
+	s := &PostgresCacheStore{postgresTable: t}
+	s.startCleanup("PostgresCacheStore", config.CleanupInterval, s.DeleteExpired)
+	return s, nil
+}
+
+// DeleteExpired removes expired responses
+func (s *PostgresCacheStore) DeleteExpired(ctx context.Context) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM `+s.table+` WHERE expires_at < now()`)
+	return err
+}
+
+// Get returns the response stored under key
+func (s *PostgresCacheStore) Get(ctx context.Context, key string) (*CachedResponse, error) {
+	var resp CachedResponse
+	var header []byte
+	err := s.db.QueryRowContext(ctx,
+		`SELECT status, header, body, tags, stored_at, expires_at FROM `+s.table+`
+		WHERE key = $1 AND expires_at > now()`,
+		key,
+	).Scan(&resp.Status, &header, &resp.Body, pq.Array(&resp.Tags), &resp.StoredAt, &resp.Expires)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error loading cached response: %w", err)
+	}
+	resp.Header = make(http.Header)
+	if err := json.Unmarshal(header, &resp.Header); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Set stores the response under key
+func (s *PostgresCacheStore) Set(ctx context.Context, key string, resp *CachedResponse) error {
+	header, err := json.Marshal(resp.Header)
+	if err != nil {
+		return err
+	}
+	tags := resp.Tags
+	if tags == nil {
+		tags = []string{}
+	}
+	_, err = s.db.ExecContext(ctx, `INSERT INTO `+s.table+`
+		(key, status, header, body, tags, stored_at, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (key) DO UPDATE SET status = EXCLUDED.status, header = EXCLUDED.header,
+			body = EXCLUDED.body, tags = EXCLUDED.tags, stored_at = EXCLUDED.stored_at,
+			expires_at = EXCLUDED.expires_at`,
+		key, resp.Status, header, resp.Body, pq.Array(tags), resp.StoredAt, resp.Expires,
+	)
+	if err != nil {
+		return fmt.Errorf("error saving cached response: %w", err)
+	}
+	return nil
+}
+
+// Delete removes the response stored under key
+func (s *PostgresCacheStore) Delete(ctx context.Context, key string) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM `+s.table+` WHERE key = $1`, key)
+	return err
+}
+
+// InvalidateTags removes the responses stored with any of the tags
+func (s *PostgresCacheStore) InvalidateTags(ctx context.Context, tags ...string) error {
+	if len(tags) == 0 {
+		return nil
+	}
+	_, err := s.db.ExecContext(ctx, `DELETE FROM `+s.table+` WHERE tags && $1`, pq.Array(tags))
+	return err
+}
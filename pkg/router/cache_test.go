@@ -0,0 +1,286 @@
+package router
+
+import (
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sys-apps-go/gorouter/pkg/router/routertest"
+)
+
+// cacheRouter returns a router whose handlers report how often they ran
+// in the body, so cached responses repeat an earlier count
+func cacheRouter(config CacheConfig) *Router {
+	var calls atomic.Int32
+	respond := func(c *Context, status int) {
+		c.String(status, "call %d", calls.Add(1))
+	}
+
+	r := NewRouter()
+	r.Use(Cache(config))
+	items := func(c *Context) {
+		respond(c, http.StatusOK)
+	}
+	r.GET("/items", items)
+	r.Group("").HEAD("/items", items)
+	r.GET("/private", func(c *Context) {
+		c.SetHeader("Cache-Control", "private")
+		respond(c, http.StatusOK)
+	})
+	r.GET("/no-store", func(c *Context) {
+		c.SetHeader("Cache-Control", "no-store")
+		respond(c, http.StatusOK)
+	})
+	r.GET("/expired", func(c *Context) {
+		c.SetHeader("Cache-Control", "max-age=0")
+		respond(c, http.StatusOK)
+	})
+	r.GET("/cookie", func(c *Context) {
+		http.SetCookie(c.Writer, &http.Cookie{Name: "seen", Value: "1"})
+		respond(c, http.StatusOK)
+	})
+	r.GET("/vary", func(c *Context) {
+		c.SetHeader("Vary", "Accept-Encoding")
+		respond(c, http.StatusOK)
+	})
+	r.GET("/vary-all", func(c *Context) {
+		c.SetHeader("Vary", "*")
+		respond(c, http.StatusOK)
+	})
+	r.GET("/failing", func(c *Context) {
+		respond(c, http.StatusInternalServerError)
+	})
+	r.GET("/missing", func(c *Context) {
+		respond(c, http.StatusNotFound)
+	})
+	r.GET("/tagged/:id", func(c *Context) {
+		c.CacheTags("items", "item:"+c.Param("id"))
+		respond(c, http.StatusOK)
+	})
+	r.POST("/tagged/:id", func(c *Context) {
+		if err := c.InvalidateCache("item:" + c.Param("id")); err != nil {
+			c.Error(err)
+			return
+		}
+		c.Status(http.StatusNoContent)
+	})
+	return r
+}
+
+func TestCache(t *testing.T) {
+	tests := []struct {
+		name   string
+		config CacheConfig
+		method string
+		first  string
+		second string
+		header map[string]string
+		status int
+		cache  string
+		body   string
+	}{
+		{name: "hit", first: "/items", second: "/items", status: http.StatusOK, cache: "HIT", body: "call 1"},
+		{name: "query order", first: "/items?a=1&b=2", second: "/items?b=2&a=1", status: http.StatusOK, cache: "HIT", body: "call 1"},
+		{name: "other query", first: "/items?page=1", second: "/items?page=2", status: http.StatusOK, cache: "MISS", body: "call 2"},
+		{name: "head from get", method: http.MethodHead, first: "/items", second: "/items", status: http.StatusOK, cache: "HIT"},
+		{name: "not found is cached", first: "/missing", second: "/missing", status: http.StatusNotFound, cache: "HIT", body: "call 1"},
+		{name: "server error", first: "/failing", second: "/failing", status: http.StatusInternalServerError, cache: "MISS", body: "call 2"},
+		{name: "private", first: "/private", second: "/private", status: http.StatusOK, cache: "MISS", body: "call 2"},
+		{name: "no-store response", first: "/no-store", second: "/no-store", status: http.StatusOK, cache: "MISS", body: "call 2"},
+		{name: "zero max-age", first: "/expired", second: "/expired", status: http.StatusOK, cache: "MISS", body: "call 2"},
+		{name: "set cookie", first: "/cookie", second: "/cookie", status: http.StatusOK, cache: "MISS", body: "call 2"},
+		{name: "unlisted vary", first: "/vary", second: "/vary", status: http.StatusOK, cache: "MISS", body: "call 2"},
+		{name: "listed vary", config: CacheConfig{VaryHeaders: []string{"accept-encoding"}}, first: "/vary", second: "/vary", status: http.StatusOK, cache: "HIT", body: "call 1"},
+		{name: "vary star", first: "/vary-all", second: "/vary-all", status: http.StatusOK, cache: "MISS", body: "call 2"},
+		{name: "no-cache request", first: "/items", second: "/items", header: map[string]string{"Cache-Control": "no-cache"}, status: http.StatusOK, cache: "MISS", body: "call 2"},
+		{name: "max-age=0 request", first: "/items", second: "/items", header: map[string]string{"Cache-Control": "max-age=0"}, status: http.StatusOK, cache: "MISS", body: "call 2"},
+		{name: "pragma", first: "/items", second: "/items", header: map[string]string{"Pragma": "no-cache"}, status: http.StatusOK, cache: "MISS", body: "call 2"},
+		{name: "no-store request", first: "/items", second: "/items", header: map[string]string{"Cache-Control": "no-store"}, status: http.StatusOK, body: "call 2"},
+		{name: "authorization bypasses", first: "/items", second: "/items", header: map[string]string{"Authorization": "Bearer secret"}, status: http.StatusOK, body: "call 2"},
+		{name: "cookie bypasses", first: "/items", second: "/items", header: map[string]string{"Cookie": "session=1"}, status: http.StatusOK, body: "call 2"},
+		{
+			name:   "listed cookie is part of the key",
+			config: CacheConfig{VaryHeaders: []string{"Cookie"}},
+			first:  "/items",
+			second: "/items",
+			header: map[string]string{"Cookie": "session=1"},
+			status: http.StatusOK,
+			cache:  "MISS",
+			body:   "call 2",
+		},
+		{
+			name:   "skip",
+			config: CacheConfig{Skip: func(c *Context) bool { return c.Query("fresh") != "" }},
+			first:  "/items",
+			second: "/items?fresh=1",
+			status: http.StatusOK,
+			body:   "call 2",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := cacheRouter(tt.config)
+			routertest.New(t, r).GET(tt.first).Expect().Header("X-Cache", "MISS").BodyEquals("call 1")
+
+			method := tt.method
+			if method == "" {
+				method = http.MethodGet
+			}
+			// A new client, so cookies set by the first response are not sent
+			req := routertest.New(t, r).Request(method, tt.second)
+			for key, value := range tt.header {
+				req.Header(key, value)
+			}
+			resp := req.Expect().Status(tt.status).Header("X-Cache", tt.cache).BodyEquals(tt.body)
+			if tt.cache == "HIT" {
+				resp.Header("Age", "0")
+			}
+		})
+	}
+}
+
+func TestCacheVaryHeaders(t *testing.T) {
+	rt := routertest.New(t, cacheRouter(CacheConfig{VaryHeaders: []string{"Accept-Language"}}))
+
+	rt.GET("/items").Header("Accept-Language", "en").Expect().Header("X-Cache", "MISS").BodyEquals("call 1")
+	rt.GET("/items").Header("Accept-Language", "fr").Expect().Header("X-Cache", "MISS").BodyEquals("call 2")
+	rt.GET("/items").Header("Accept-Language", "en").Expect().Header("X-Cache", "HIT").BodyEquals("call 1")
+	rt.GET("/items").Header("Accept-Language", "fr").Expect().Header("X-Cache", "HIT").BodyEquals("call 2")
+}
+
+func TestCacheConditional(t *testing.T) {
+	etag := computeETag([]byte("call 1"), false)
+	past := time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat)
+	future := time.Now().Add(time.Hour).UTC().Format(http.TimeFormat)
+
+	tests := []struct {
+		name   string
+		header map[string]string
+		status int
+	}{
+		{"no validators", nil, http.StatusOK},
+		{"matching etag", map[string]string{"If-None-Match": etag}, http.StatusNotModified},
+		{"weak comparison", map[string]string{"If-None-Match": "W/" + etag}, http.StatusNotModified},
+		{"etag list", map[string]string{"If-None-Match": `"other", ` + etag}, http.StatusNotModified},
+		{"any etag", map[string]string{"If-None-Match": "*"}, http.StatusNotModified},
+		{"other etag", map[string]string{"If-None-Match": `"other"`}, http.StatusOK},
+		{"not modified since", map[string]string{"If-Modified-Since": future}, http.StatusNotModified},
+		{"modified since", map[string]string{"If-Modified-Since": past}, http.StatusOK},
+		{"etag wins over date", map[string]string{"If-None-Match": `"other"`, "If-Modified-Since": future}, http.StatusOK},
+		{"invalid date", map[string]string{"If-Modified-Since": "yesterday"}, http.StatusOK},
+	}
+	for _, tt := range tests {
+		for _, cached := range []bool{false, true} {
+			name := tt.name + "/uncached"
+			if cached {
+				name = tt.name + "/cached"
+			}
+			t.Run(name, func(t *testing.T) {
+				rt := routertest.New(t, cacheRouter(CacheConfig{}))
+				if cached {
+					rt.GET("/items").Expect().Header("ETag", etag).Header("X-Cache", "MISS")
+				}
+				req := rt.GET("/items")
+				for key, value := range tt.header {
+					req.Header(key, value)
+				}
+				resp := req.Expect().Status(tt.status).Header("ETag", etag).HeaderContains("Last-Modified", "GMT")
+				if tt.status == http.StatusNotModified {
+					resp.BodyEquals("").NoHeader("Content-Type")
+				} else {
+					resp.BodyEquals("call 1").Header("Content-Length", "6")
+				}
+			})
+		}
+	}
+
+	// The handler's own validators are kept
+	r := NewRouter()
+	r.Use(Cache(CacheConfig{WeakETags: true}))
+	r.GET("/own", func(c *Context) {
+		c.SetHeader("ETag", `"v2"`)
+		c.String(http.StatusOK, "own")
+	})
+	r.GET("/generated", func(c *Context) {
+		c.String(http.StatusOK, "generated")
+	})
+	rt := routertest.New(t, r)
+	rt.GET("/own").Expect().Header("ETag", `"v2"`)
+	rt.GET("/own").Header("If-None-Match", `"v2"`).Expect().Status(http.StatusNotModified).Header("X-Cache", "HIT")
+	rt.GET("/generated").Expect().Header("ETag", computeETag([]byte("generated"), true))
+}
+
+func TestCacheTags(t *testing.T) {
+	rt := routertest.New(t, cacheRouter(CacheConfig{}))
+
+	rt.GET("/tagged/1").Expect().Header("X-Cache", "MISS").BodyEquals("call 1")
+	rt.GET("/tagged/2").Expect().Header("X-Cache", "MISS").BodyEquals("call 2")
+	rt.GET("/tagged/1").Expect().Header("X-Cache", "HIT")
+
+	rt.POST("/tagged/1").Expect().Status(http.StatusNoContent)
+	rt.GET("/tagged/1").Expect().Header("X-Cache", "MISS").BodyEquals("call 3")
+	rt.GET("/tagged/2").Expect().Header("X-Cache", "HIT").BodyEquals("call 2")
+
+	// InvalidateCache needs the middleware to know the store
+	r := NewRouter()
+	r.POST("/invalidate", func(c *Context) {
+		if err := c.InvalidateCache("items"); err != nil {
+			c.Error(err)
+		}
+	})
+	routertest.New(t, r).POST("/invalidate").Expect().Status(http.StatusInternalServerError)
+}
+
+func TestCacheCompress(t *testing.T) {
+	large := strings.Repeat("cacheable ", 200)
+	tests := []struct {
+		name  string
+		vary  []string
+		cache string
+	}{
+		// The compressed body must not reach clients without gzip
+		{"encoding not in key", nil, "MISS"},
+		{"encoding in key", []string{"Accept-Encoding"}, "HIT"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewRouter()
+			r.Use(Cache(CacheConfig{VaryHeaders: tt.vary}), Compress())
+			r.GET("/large", func(c *Context) {
+				c.Data(http.StatusOK, "text/plain", []byte(large))
+			})
+			rt := routertest.New(t, r)
+			rt.GET("/large").Header("Accept-Encoding", "gzip").Expect().Header("Content-Encoding", "gzip")
+			resp := rt.GET("/large").Header("Accept-Encoding", "gzip").Expect().
+				Header("X-Cache", tt.cache).
+				Header("Content-Encoding", "gzip")
+			if got := decodeBody(t, "gzip", resp.Body()); got != large {
+				t.Fatalf("body = %q", got)
+			}
+			rt.GET("/large").Expect().NoHeader("Content-Encoding").BodyEquals(large)
+		})
+	}
+}
+
+func TestMemoryCacheStore(t *testing.T) {
+	store := NewMemoryCacheStore(MemoryCacheConfig{MaxEntries: 2})
+	r := NewRouter()
+	r.Use(Cache(CacheConfig{Store: store}))
+	r.GET("/:name", func(c *Context) {
+		c.String(http.StatusOK, "%s", c.Param("name"))
+	})
+	rt := routertest.New(t, r)
+
+	rt.GET("/a").Expect().Header("X-Cache", "MISS")
+	rt.GET("/b").Expect().Header("X-Cache", "MISS")
+	rt.GET("/a").Expect().Header("X-Cache", "HIT")
+	rt.GET("/c").Expect().Header("X-Cache", "MISS")
+	if store.Len() != 2 {
+		t.Fatalf("Len() = %d, want 2", store.Len())
+	}
+	// b was the least recently used
+	rt.GET("/a").Expect().Header("X-Cache", "HIT")
+	rt.GET("/b").Expect().Header("X-Cache", "MISS")
+}
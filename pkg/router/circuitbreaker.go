@@ -0,0 +1,92 @@
+package router
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"github.com/sys-apps-go/gorouter/pkg/router/breaker"
+)
+
+// CircuitBreakerConfig configures CircuitBreaker
+type CircuitBreakerConfig struct {
+	// Breaker is shared by all requests through the middleware. If nil,
+	// every route gets its own breaker configured by Settings and named
+	// after the method and route pattern.
+	Breaker  *breaker.Breaker
+	Settings breaker.Config
+	// IsFailure decides whether a finished request counts as a failure,
+	// default a 5xx response status
+	IsFailure func(*Context) bool
+	// Fallback writes the response while the breaker rejects requests. By
+	// default a 503 HTTPError goes to the error handler, with Retry-After
+	// set while the breaker is open.
+	Fallback HandlerFunc
+}
+
+// CircuitBreaker is a middleware that stops calling the handler while it
+// keeps failing, so clients get a fast answer and the handler's
+// dependencies a chance to recover. Use it on a route, a group or the
+// whole router; see package breaker for the states and trip conditions.
+// Panics in the handler count as failures; requests whose client went
+// away before the handler finished are not counted.
+func CircuitBreaker(config CircuitBreakerConfig) MiddlewareFunc {
+	if config.IsFailure == nil {
+		config.IsFailure = func(c *Context) bool {
+			return c.ResponseStatus() >= 500
+		}
+	}
+	var breakers sync.Map
+	breakerFor := func(c *Context) *breaker.Breaker {
+		if config.Breaker != nil {
+			return config.Breaker
+		}
+		name := c.Request.Method + " " + c.FullPath()
+		if b, ok := breakers.Load(name); ok {
+			return b.(*breaker.Breaker)
+		}
+		settings := config.Settings
+		if settings.Name == "" {
+			settings.Name = name
+		}
+		b, _ := breakers.LoadOrStore(name, breaker.New(settings))
+		return b.(*breaker.Breaker)
+	}
+
+	return func(next HandlerFunc) HandlerFunc {
+		return func(c *Context) {
+			b := breakerFor(c)
+			done, err := b.Allow()
+			if err != nil {
+				if config.Fallback != nil {
+					config.Fallback(c)
+					return
+				}
+				if retry := b.RetryAfter(); retry > 0 {
+					c.SetHeader("Retry-After", strconv.Itoa(ceilSeconds(retry)))
+				}
+				c.Error(&HTTPError{Code: http.StatusServiceUnavailable, Message: "service unavailable", Err: err})
+				return
+			}
+			defer func() {
+				if p := recover(); p != nil {
+					done(errors.New("router: handler panicked"))
+					panic(p)
+				}
+			}()
+			next(c)
+			switch err := c.Request.Context().Err(); {
+			case errors.Is(err, context.Canceled):
+				// The client went away; the result says nothing about
+				// the handler's dependencies
+				done(err)
+			case config.IsFailure(c):
+				done(errors.New("router: handler failed with status " + strconv.Itoa(c.ResponseStatus())))
+			default:
+				done(nil)
+			}
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package router
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/sys-apps-go/gorouter/pkg/router/breaker"
+	"github.com/sys-apps-go/gorouter/pkg/router/routertest"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	r := NewRouter()
+	r.Use(CircuitBreaker(CircuitBreakerConfig{
+		Settings: breaker.Config{MinRequests: 2, OpenTimeout: time.Minute},
+	}))
+	r.GET("/status/:code", func(c *Context) {
+		code := http.StatusOK
+		if c.Param("code") == "500" {
+			code = http.StatusInternalServerError
+		}
+		c.Status(code)
+	})
+	r.GET("/other", func(c *Context) {
+		c.Status(http.StatusOK)
+	})
+
+	tests := []struct {
+		path       string
+		status     int
+		retryAfter string
+	}{
+		{"/status/500", http.StatusInternalServerError, ""},
+		{"/status/200", http.StatusOK, ""},
+		{"/status/500", http.StatusInternalServerError, ""},
+		// Every path of the route shares its breaker
+		{"/status/200", http.StatusServiceUnavailable, "60"},
+		// Other routes have breakers of their own
+		{"/other", http.StatusOK, ""},
+	}
+	rt := routertest.New(t, r)
+	for _, tt := range tests {
+		resp := rt.GET(tt.path).Expect().Status(tt.status).Header("Retry-After", tt.retryAfter)
+		if tt.status == http.StatusServiceUnavailable {
+			resp.JSONPath("$.error", "service unavailable")
+		}
+	}
+}
+
+func TestCircuitBreakerShared(t *testing.T) {
+	b := breaker.New(breaker.Config{MinRequests: 1, OpenTimeout: time.Minute})
+	r := NewRouter()
+	r.Use(CircuitBreaker(CircuitBreakerConfig{
+		Breaker:   b,
+		IsFailure: func(c *Context) bool { return c.ResponseStatus() == http.StatusBadGateway },
+		Fallback: func(c *Context) {
+			c.String(http.StatusOK, "cached")
+		},
+	}))
+	r.GET("/canceled", func(c *Context) {
+		c.Status(http.StatusInternalServerError)
+	})
+	r.GET("/gateway", func(c *Context) {
+		c.Status(http.StatusBadGateway)
+	})
+	r.GET("/ok", func(c *Context) {
+		c.Status(http.StatusOK)
+	})
+
+	// A request the client gave up on says nothing about the handler
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	req := httptest.NewRequest(http.MethodGet, "/canceled", nil).WithContext(ctx)
+	r.ServeHTTP(httptest.NewRecorder(), req)
+	if counts := b.Counts(); counts.Requests != 0 {
+		t.Fatalf("canceled request was counted: %+v", counts)
+	}
+
+	rt := routertest.New(t, r)
+	rt.GET("/gateway").Expect().Status(http.StatusBadGateway)
+	if state := b.State(); state != breaker.Open {
+		t.Fatalf("state = %v, want open", state)
+	}
+	rt.GET("/ok").Expect().Status(http.StatusOK).BodyEquals("cached").NoHeader("Retry-After")
+}
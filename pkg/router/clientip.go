@@ -0,0 +1,188 @@
+package router
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// DefaultClientIPHeaders are the headers consulted by ClientIP for
+// requests from trusted proxies, in order. Only X-Forwarded-For, which
+// nearly every proxy appends to; use SetClientIPHeaders for proxies that
+// set Forwarded or X-Real-IP instead.
+var DefaultClientIPHeaders = []string{"X-Forwarded-For"}
+
+// clientIPConfig is the trusted proxy configuration of a Router
+type clientIPConfig struct {
+	proxies ipSet
+	headers []string
+}
+
+// SetTrustedProxies sets the addresses of the proxies whose forwarding
+// headers ClientIP believes, as IPs or CIDR ranges such as 10.0.0.0/8.
+// Without trusted proxies ClientIP returns the address of the peer. It can
+// be called while the router is serving to reload the list.
+func (r *Router) SetTrustedProxies(proxies ...string) error {
+	set, err := parseIPSet(proxies)
+	if err != nil {
+		return err
+	}
+	current := r.clientIP.Load()
+	r.clientIP.Store(&clientIPConfig{proxies: set, headers: current.headers})
+	return nil
+}
+
+// SetClientIPHeaders sets the headers ClientIP reads the client address
+// from, in order, default DefaultClientIPHeaders. Only list headers your
+// proxies set or overwrite.
+func (r *Router) SetClientIPHeaders(headers ...string) {
+	current := r.clientIP.Load()
+	canonical := make([]string, len(headers))
+	for i, h := range headers {
+		canonical[i] = http.CanonicalHeaderKey(h)
+	}
+	r.clientIP.Store(&clientIPConfig{proxies: current.proxies, headers: canonical})
+}
+
+// ClientIP returns the IP address of the client, without the port. When
+// the peer is a trusted proxy the address is taken from the forwarding
+// headers: the chain is read from the right and the first address that is
+// not a trusted proxy is the client, so clients cannot spoof it by
+// sending the header themselves. The first of the configured headers
+// present is used. Entries left of the client are never parsed; if an
+// entry that cannot be parsed is reached first, the peer address is
+// returned rather than falling back to a header the proxy may not control.
+func (c *Context) ClientIP() string {
+	remote := c.Request.RemoteAddr
+	if host, _, err := net.SplitHostPort(remote); err == nil {
+		remote = host
+	}
+	if c.router == nil {
+		return remote
+	}
+	config := c.router.clientIP.Load()
+	addr, err := parseIP(remote)
+	if err != nil || len(config.proxies) == 0 || !config.proxies.contains(addr) {
+		return remote
+	}
+
+	for _, name := range config.headers {
+		values := c.Request.Header.Values(name)
+		if len(values) == 0 {
+			continue
+		}
+		chain := forwardedChain(name, values)
+		for i := len(chain) - 1; i >= 0; i-- {
+			addr, err := parseIP(chain[i])
+			if err != nil {
+				return remote
+			}
+			if i == 0 || !config.proxies.contains(addr) {
+				return addr.String()
+			}
+		}
+		return remote
+	}
+	return remote
+}
+
+// forwardedChain returns the entries of a forwarding header, client first
+func forwardedChain(name string, values []string) []string {
+	var chain []string
+	for _, value := range values {
+		for _, element := range strings.Split(value, ",") {
+			element = strings.TrimSpace(element)
+			if name == "Forwarded" {
+				element = forwardedFor(element)
+			}
+			chain = append(chain, element)
+		}
+	}
+	return chain
+}
+
+// forwardedFor returns the for= node of a Forwarded header element
+func forwardedFor(element string) string {
+	for _, pair := range strings.Split(element, ";") {
+		key, value, _ := strings.Cut(strings.TrimSpace(pair), "=")
+		if strings.EqualFold(key, "for") {
+			return strings.Trim(value, `"`)
+		}
+	}
+	return ""
+}
+
+// parseIP parses an address with an optional port, brackets or zone, and
+// unmaps IPv4-mapped IPv6 addresses
+func parseIP(s string) (netip.Addr, error) {
+	if host, _, err := net.SplitHostPort(s); err == nil {
+		s = host
+	}
+	s = strings.TrimSuffix(strings.TrimPrefix(s, "["), "]")
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	return addr.WithZone("").Unmap(), nil
+}
+
+// ipSet is a list of address ranges
+type ipSet []netip.Prefix
+
+// parseIPSet parses IPs and CIDR ranges
+func parseIPSet(entries []string) (ipSet, error) {
+	set := make(ipSet, 0, len(entries))
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if strings.Contains(entry, "/") {
+			prefix, err := netip.ParsePrefix(entry)
+			if err != nil {
+				return nil, fmt.Errorf("invalid CIDR %q: %w", entry, err)
+			}
+			if prefix.Addr().Is4In6() && prefix.Bits() >= 96 {
+				prefix = netip.PrefixFrom(prefix.Addr().Unmap(), prefix.Bits()-96)
+			}
+			set = append(set, prefix.Masked())
+			continue
+		}
+		addr, err := parseIP(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid IP %q: %w", entry, err)
+		}
+		set = append(set, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+	return set, nil
+}
+
+// contains reports whether addr is in one of the ranges
+func (s ipSet) contains(addr netip.Addr) bool {
+	for _, prefix := range s {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseIPList reads IPs and CIDR ranges, one per line, as used by
+// SetTrustedProxies and IPFilter. Blank lines and # comments are skipped.
+func ParseIPList(r io.Reader) ([]string, error) {
+	var entries []string
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text, _, _ := strings.Cut(scanner.Text(), "#")
+		text = strings.TrimSpace(text)
+		if text == "" {
+			continue
+		}
+		if _, err := parseIPSet([]string{text}); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		entries = append(entries, text)
+	}
+	return entries, scanner.Err()
+}
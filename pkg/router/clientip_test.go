@@ -0,0 +1,133 @@
+package router
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// clientIPRouter returns a router that answers GET /ip with c.ClientIP()
+func clientIPRouter() *Router {
+	r := NewRouter()
+	r.GET("/ip", func(c *Context) {
+		c.String(http.StatusOK, "%s", c.ClientIP())
+	})
+	return r
+}
+
+// clientIPOf returns what ClientIP resolves for a request from remote
+// with the given forwarding headers
+func clientIPOf(r *Router, remote string, header http.Header) string {
+	req := httptest.NewRequest(http.MethodGet, "/ip", nil)
+	req.RemoteAddr = remote
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+	return rec.Body.String()
+}
+
+func TestClientIP(t *testing.T) {
+	tests := []struct {
+		name    string
+		headers []string
+		remote  string
+		header  http.Header
+		want    string
+	}{
+		{"direct client", nil, "203.0.113.9:5000", nil, "203.0.113.9"},
+		{"header from an untrusted peer", nil, "203.0.113.9:5000", http.Header{"X-Forwarded-For": {"10.0.0.1"}}, "203.0.113.9"},
+		{"trusted proxy without header", nil, "10.0.0.5:5000", nil, "10.0.0.5"},
+		{"trusted proxy", nil, "10.0.0.5:5000", http.Header{"X-Forwarded-For": {"203.0.113.9"}}, "203.0.113.9"},
+		{"spoofed entry", nil, "10.0.0.5:5000", http.Header{"X-Forwarded-For": {"10.0.0.1, 203.0.113.9"}}, "203.0.113.9"},
+		{"garbage left of the client", nil, "10.0.0.5:5000", http.Header{"X-Forwarded-For": {"garbage, 203.0.113.9"}}, "203.0.113.9"},
+		{"garbage before any client", nil, "10.0.0.5:5000", http.Header{"X-Forwarded-For": {"203.0.113.9, garbage"}}, "10.0.0.5"},
+		{"empty header", nil, "10.0.0.5:5000", http.Header{"X-Forwarded-For": {""}}, "10.0.0.5"},
+		{"proxy chain", nil, "10.0.0.5:5000", http.Header{"X-Forwarded-For": {"203.0.113.9, 10.0.0.7"}}, "203.0.113.9"},
+		{"several header lines", nil, "10.0.0.5:5000", http.Header{"X-Forwarded-For": {"198.51.100.1", "203.0.113.9, 10.0.0.7"}}, "203.0.113.9"},
+		{"only proxies", nil, "10.0.0.5:5000", http.Header{"X-Forwarded-For": {"10.0.0.8, 10.0.0.7"}}, "10.0.0.8"},
+		{"port in entry", nil, "10.0.0.5:5000", http.Header{"X-Forwarded-For": {"203.0.113.9:4711"}}, "203.0.113.9"},
+		{"IPv6 proxy and client", nil, "[fd00::1]:443", http.Header{"X-Forwarded-For": {"2001:db8::1"}}, "2001:db8::1"},
+		{"bracketed IPv6 with port", nil, "[fd00::1]:443", http.Header{"X-Forwarded-For": {"[2001:db8::1]:4711"}}, "2001:db8::1"},
+		{"IPv4-mapped IPv6", nil, "[::ffff:10.0.0.5]:443", http.Header{"X-Forwarded-For": {"::ffff:203.0.113.9"}}, "203.0.113.9"},
+		{"X-Real-IP not read by default", nil, "10.0.0.5:5000", http.Header{"X-Real-Ip": {"203.0.113.9"}}, "10.0.0.5"},
+		{
+			name:    "Forwarded",
+			headers: []string{"Forwarded"},
+			remote:  "10.0.0.5:5000",
+			header:  http.Header{"Forwarded": {`for=198.51.100.1;proto=https, for="[2001:db8::2]:4711";by=10.0.0.5`}},
+			want:    "2001:db8::2",
+		},
+		{
+			name:    "Forwarded with an unknown node",
+			headers: []string{"Forwarded"},
+			remote:  "10.0.0.5:5000",
+			header:  http.Header{"Forwarded": {"for=203.0.113.9, for=unknown"}},
+			want:    "10.0.0.5",
+		},
+		{
+			name:    "Forwarded with garbage left of the client",
+			headers: []string{"Forwarded"},
+			remote:  "10.0.0.5:5000",
+			header:  http.Header{"Forwarded": {`for=_hidden, for=203.0.113.9;proto=http`}},
+			want:    "203.0.113.9",
+		},
+		{
+			name:    "first present header wins",
+			headers: []string{"Forwarded", "X-Forwarded-For"},
+			remote:  "10.0.0.5:5000",
+			header:  http.Header{"X-Forwarded-For": {"203.0.113.9"}},
+			want:    "203.0.113.9",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := clientIPRouter()
+			if err := r.SetTrustedProxies("10.0.0.0/8", "fd00::/8"); err != nil {
+				t.Fatal(err)
+			}
+			if tt.headers != nil {
+				r.SetClientIPHeaders(tt.headers...)
+			}
+			if got := clientIPOf(r, tt.remote, tt.header); got != tt.want {
+				t.Fatalf("ClientIP() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSetTrustedProxies(t *testing.T) {
+	header := http.Header{"X-Forwarded-For": {"203.0.113.9"}}
+	r := clientIPRouter()
+	if got := clientIPOf(r, "10.0.0.5:5000", header); got != "10.0.0.5" {
+		t.Fatalf("without trusted proxies ClientIP() = %q", got)
+	}
+
+	// Reloading takes effect for the next request
+	if err := r.SetTrustedProxies("10.0.0.5"); err != nil {
+		t.Fatal(err)
+	}
+	if got := clientIPOf(r, "10.0.0.5:5000", header); got != "203.0.113.9" {
+		t.Fatalf("after reload ClientIP() = %q", got)
+	}
+	if got := clientIPOf(r, "10.0.0.6:5000", header); got != "10.0.0.6" {
+		t.Fatalf("untrusted neighbour ClientIP() = %q", got)
+	}
+
+	// Invalid lists are rejected and the old one kept
+	if err := r.SetTrustedProxies("10.0.0.0/33"); err == nil {
+		t.Fatal("SetTrustedProxies accepted an invalid CIDR")
+	}
+	if err := r.SetTrustedProxies("proxy.local"); err == nil {
+		t.Fatal("SetTrustedProxies accepted a host name")
+	}
+	if got := clientIPOf(r, "10.0.0.5:5000", header); got != "203.0.113.9" {
+		t.Fatalf("after failed reload ClientIP() = %q", got)
+	}
+
+	r.SetTrustedProxies()
+	if got := clientIPOf(r, "10.0.0.5:5000", header); got != "10.0.0.5" {
+		t.Fatalf("after clearing ClientIP() = %q", got)
+	}
+}
@@ -0,0 +1,311 @@
+package router
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+// compressor is implemented by the pooled encoders of every encoding
+type compressor interface {
+	io.WriteCloser
+	Flush() error
+	Reset(w io.Writer)
+}
+
+// compressorPools holds reusable encoders keyed by content coding
+var compressorPools = map[string]*sync.Pool{
+	"gzip": {New: func() interface{} {
+		w, _ := gzip.NewWriterLevel(io.Discard, gzip.DefaultCompression)
+		return w
+	}},
+	"deflate": {New: func() interface{} {
+		w, _ := flate.NewWriter(io.Discard, flate.DefaultCompression)
+		return w
+	}},
+	"br": {New: func() interface{} {
+		return brotli.NewWriterLevel(io.Discard, 4)
+	}},
+	"zstd": {New: func() interface{} {
+		w, _ := zstd.NewWriter(io.Discard,
+			zstd.WithEncoderLevel(zstd.SpeedDefault),
+			zstd.WithEncoderConcurrency(1),
+			zstd.WithLowerEncoderMem(true))
+		return w
+	}},
+}
+
+// CompressConfig configures CompressWithConfig
+type CompressConfig struct {
+	// Encodings lists the content codings offered, in order of
+	// preference among those the client weighs equally. The default is
+	// br, zstd, gzip, deflate.
+	Encodings []string
+	// MinLength is the smallest response compressed, default 1024 bytes.
+	// Smaller responses are sent as is unless they are flushed.
+	MinLength int
+	// ContentTypes lists the media types compressed. Entries ending in /
+	// match a prefix such as text/. The default covers text, JSON,
+	// JavaScript, XML, SVG and WebAssembly.
+	ContentTypes []string
+	// Skip leaves responses uncompressed when it returns true
+	Skip func(*Context) bool
+}
+
+// defaultCompressTypes are the media types compressed by default
+var defaultCompressTypes = []string{
+	"text/",
+	"application/json",
+	"application/problem+json",
+	"application/javascript",
+	"application/xml",
+	"application/xhtml+xml",
+	"application/rss+xml",
+	"application/atom+xml",
+	"application/wasm",
+	"image/svg+xml",
+}
+
+// Compress is a middleware that compresses responses with the default
+// configuration
+func Compress() MiddlewareFunc {
+	return CompressWithConfig(CompressConfig{})
+}
+
+// CompressWithConfig is a middleware that compresses responses with the
+// best coding the client accepts. The first MinLength bytes are buffered
+// to decide; responses that are too small, of another content type, or
+// already encoded are sent unchanged. Compressed responses lose their
+// Content-Length and strong ETags are made weak. Flush sends buffered
+// data at once, so streaming and server-sent events keep working.
+func CompressWithConfig(config CompressConfig) MiddlewareFunc {
+	if config.Encodings == nil {
+		config.Encodings = []string{"br", "zstd", "gzip", "deflate"}
+	}
+	for _, enc := range config.Encodings {
+		if compressorPools[enc] == nil {
+			panic(fmt.Sprintf("router: unsupported compression encoding %q", enc))
+		}
+	}
+	if config.MinLength <= 0 {
+		config.MinLength = 1024
+	}
+	if config.ContentTypes == nil {
+		config.ContentTypes = defaultCompressTypes
+	}
+
+	return func(next HandlerFunc) HandlerFunc {
+		return func(c *Context) {
+			c.Writer.Header().Add("Vary", "Accept-Encoding")
+			if c.Request.Method == http.MethodHead || (config.Skip != nil && config.Skip(c)) {
+				next(c)
+				return
+			}
+			encoding := negotiateEncoding(c.GetHeader("Accept-Encoding"), config.Encodings)
+			if encoding == "" {
+				next(c)
+				return
+			}
+
+			cw := &compressWriter{ResponseWriter: c.Writer, config: &config, encoding: encoding, status: http.StatusOK}
+			c.Writer = cw
+			defer func() {
+				cw.close()
+				c.Writer = cw.ResponseWriter
+			}()
+			next(c)
+		}
+	}
+}
+
+// negotiateEncoding picks the offered coding with the highest quality in
+// an Accept-Encoding header, preferring earlier offers on ties
+func negotiateEncoding(header string, offers []string) string {
+	if header == "" {
+		return ""
+	}
+	quality := make(map[string]float64)
+	wildcard := -1.0
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				q = f
+			}
+		}
+		if name == "*" {
+			wildcard = q
+		} else if name != "" {
+			quality[name] = q
+		}
+	}
+
+	best, bestQ := "", 0.0
+	for _, offer := range offers {
+		q, ok := quality[offer]
+		if !ok {
+			q = wildcard
+		}
+		if q > bestQ {
+			best, bestQ = offer, q
+		}
+	}
+	return best
+}
+
+// compressWriter buffers the start of a response to decide whether to
+// compress it, then streams it through a pooled encoder
+type compressWriter struct {
+	http.ResponseWriter
+	config   *CompressConfig
+	encoding string
+
+	status      int
+	wroteHeader bool
+	decided     bool
+	buf         []byte
+	encoder     compressor
+}
+
+// WriteHeader records the status; it is sent once the coding is decided
+func (w *compressWriter) WriteHeader(code int) {
+	if w.wroteHeader {
+		return
+	}
+	if code < 200 && code != http.StatusSwitchingProtocols {
+		// Informational responses such as 103 Early Hints pass through
+		w.ResponseWriter.WriteHeader(code)
+		return
+	}
+	w.status = code
+	w.wroteHeader = true
+	if code == http.StatusNoContent || code == http.StatusNotModified || code < 200 {
+		w.decide(false)
+	}
+}
+
+// Write buffers data until MinLength bytes are available
+func (w *compressWriter) Write(data []byte) (int, error) {
+	w.wroteHeader = true
+	if w.decided {
+		if w.encoder != nil {
+			return w.encoder.Write(data)
+		}
+		return w.ResponseWriter.Write(data)
+	}
+	w.buf = append(w.buf, data...)
+	if len(w.buf) >= w.config.MinLength {
+		if err := w.decide(true); err != nil {
+			return 0, err
+		}
+	}
+	return len(data), nil
+}
+
+// Flush compresses and sends everything written so far
+func (w *compressWriter) Flush() {
+	if !w.decided {
+		w.decide(true)
+	}
+	if w.encoder != nil {
+		w.encoder.Flush()
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack lets the caller take over the connection
+func (w *compressWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if h, ok := w.ResponseWriter.(http.Hijacker); ok {
+		return h.Hijack()
+	}
+	return nil, nil, fmt.Errorf("router: %T does not support hijacking", w.ResponseWriter)
+}
+
+// Unwrap returns the underlying writer for http.ResponseController
+func (w *compressWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// decide sends the header, compressed if allowed and wanted, followed by
+// the buffered data. Partial content is never compressed, since its byte
+// ranges refer to the uncompressed representation.
+func (w *compressWriter) decide(compress bool) error {
+	w.decided = true
+	header := w.ResponseWriter.Header()
+	if header.Get("Content-Type") == "" && len(w.buf) > 0 {
+		header.Set("Content-Type", http.DetectContentType(w.buf))
+	}
+	partial := w.status == http.StatusPartialContent || header.Get("Content-Range") != ""
+	if compress && !partial && header.Get("Content-Encoding") == "" && w.compressibleType(header.Get("Content-Type")) {
+		header.Del("Content-Length")
+		header.Del("Accept-Ranges")
+		header.Set("Content-Encoding", w.encoding)
+		if etag := header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+			header.Set("ETag", "W/"+etag)
+		}
+		w.encoder = compressorPools[w.encoding].Get().(compressor)
+		w.encoder.Reset(w.ResponseWriter)
+	}
+
+	w.ResponseWriter.WriteHeader(w.status)
+	buf := w.buf
+	w.buf = nil
+	if len(buf) == 0 {
+		return nil
+	}
+	var err error
+	if w.encoder != nil {
+		_, err = w.encoder.Write(buf)
+	} else {
+		_, err = w.ResponseWriter.Write(buf)
+	}
+	return err
+}
+
+// compressibleType reports whether the content type is in the allowlist
+func (w *compressWriter) compressibleType(contentType string) bool {
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+	for _, t := range w.config.ContentTypes {
+		if strings.HasSuffix(t, "/") && strings.HasPrefix(mediaType, t) || mediaType == t {
+			return true
+		}
+	}
+	return false
+}
+
+// close finishes the response: small responses are sent as is with their
+// length, compressed ones are terminated and the encoder returned to the
+// pool
+func (w *compressWriter) close() {
+	if !w.decided {
+		if !w.wroteHeader {
+			// Nothing was written; let the next writer send the defaults
+			return
+		}
+		if w.ResponseWriter.Header().Get("Content-Encoding") == "" {
+			w.ResponseWriter.Header().Set("Content-Length", strconv.Itoa(len(w.buf)))
+		}
+		w.decide(false)
+	}
+	if w.encoder != nil {
+		w.encoder.Close()
+		w.encoder.Reset(io.Discard)
+		compressorPools[w.encoding].Put(w.encoder)
+		w.encoder = nil
+	}
+}
@@ -0,0 +1,182 @@
+package router
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+
+	"github.com/sys-apps-go/gorouter/pkg/router/routertest"
+)
+
+// decodeBody reverses a response content coding
+func decodeBody(t *testing.T, encoding string, body []byte) string {
+	t.Helper()
+	var r io.Reader
+	var err error
+	switch encoding {
+	case "gzip":
+		r, err = gzip.NewReader(bytes.NewReader(body))
+	case "deflate":
+		r = flate.NewReader(bytes.NewReader(body))
+	case "br":
+		r = brotli.NewReader(bytes.NewReader(body))
+	case "zstd":
+		var d *zstd.Decoder
+		d, err = zstd.NewReader(bytes.NewReader(body))
+		if err == nil {
+			defer d.Close()
+		}
+		r = d
+	case "":
+		return string(body)
+	default:
+		t.Fatalf("unknown encoding %q", encoding)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("decoding %s body: %v", encoding, err)
+	}
+	return string(data)
+}
+
+func TestCompress(t *testing.T) {
+	large := strings.Repeat(`{"name":"gopher"},`, 200)
+	r := NewRouter()
+	r.Use(Compress())
+	r.GET("/json", func(c *Context) {
+		c.SetHeader("ETag", `"v1"`)
+		c.Data(http.StatusOK, "application/json", []byte(large))
+	})
+	r.GET("/small", func(c *Context) {
+		c.Data(http.StatusOK, "application/json", []byte(`{"ok":true}`))
+	})
+	r.GET("/image", func(c *Context) {
+		c.Data(http.StatusOK, "image/png", []byte(large))
+	})
+	r.GET("/encoded", func(c *Context) {
+		c.SetHeader("Content-Encoding", "gzip")
+		c.Data(http.StatusOK, "application/json", []byte(large))
+	})
+	r.GET("/empty", func(c *Context) {
+		c.Status(http.StatusNoContent)
+	})
+	r.GET("/file", func(c *Context) {
+		c.SetHeader("Content-Type", "application/json")
+		http.ServeContent(c.Writer, c.Request, "", time.Time{}, strings.NewReader(large))
+	})
+	r.GET("/content-range", func(c *Context) {
+		c.SetHeader("Content-Range", "bytes */3600")
+		c.Data(http.StatusRequestedRangeNotSatisfiable, "application/json", []byte(large))
+	})
+	r.Group("").HEAD("/json", func(c *Context) {
+		c.SetHeader("Content-Type", "application/json")
+		c.Status(http.StatusOK)
+	})
+
+	tests := []struct {
+		name           string
+		path           string
+		acceptEncoding string
+		encoding       string
+		etag           string
+	}{
+		{"brotli preferred", "/json", "gzip, deflate, br, zstd", "br", `W/"v1"`},
+		{"gzip", "/json", "gzip", "gzip", `W/"v1"`},
+		{"deflate", "/json", "deflate", "deflate", `W/"v1"`},
+		{"zstd", "/json", "zstd", "zstd", `W/"v1"`},
+		{"quality", "/json", "br;q=0.5, gzip;q=0.8", "gzip", `W/"v1"`},
+		{"rejected coding", "/json", "br;q=0, gzip;q=0", "", `"v1"`},
+		{"wildcard", "/json", "*", "br", `W/"v1"`},
+		{"not accepted", "/json", "", "", `"v1"`},
+		{"unknown coding", "/json", "compress", "", `"v1"`},
+		{"too small", "/small", "gzip", "", ""},
+		{"content type", "/image", "gzip", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := routertest.New(t, r).GET(tt.path)
+			if tt.acceptEncoding != "" {
+				req.Header("Accept-Encoding", tt.acceptEncoding)
+			}
+			resp := req.Expect().
+				Status(http.StatusOK).
+				Header("Content-Encoding", tt.encoding).
+				Header("Vary", "Accept-Encoding").
+				Header("ETag", tt.etag)
+			body := decodeBody(t, tt.encoding, resp.Body())
+			if want := large; tt.path == "/small" {
+				want = `{"ok":true}`
+				if body != want {
+					t.Fatalf("body = %q, want %q", body, want)
+				}
+				resp.Header("Content-Length", "11")
+			} else if body != want {
+				t.Fatalf("body of %d bytes does not round trip", len(body))
+			}
+			if tt.encoding != "" {
+				resp.NoHeader("Content-Length")
+			}
+		})
+	}
+
+	rt := routertest.New(t, r)
+	resp := rt.GET("/encoded").Header("Accept-Encoding", "br").Expect().Header("Content-Encoding", "gzip")
+	if string(resp.Body()) != large {
+		t.Error("already encoded response was compressed again")
+	}
+	rt.GET("/empty").Header("Accept-Encoding", "gzip").Expect().
+		Status(http.StatusNoContent).
+		NoHeader("Content-Encoding").
+		BodyEquals("")
+	rt.HEAD("/json").Header("Accept-Encoding", "gzip").Expect().
+		Status(http.StatusOK).
+		NoHeader("Content-Encoding")
+
+	// Byte ranges refer to the uncompressed body, so partial responses
+	// are sent as is
+	rt.GET("/file").Header("Accept-Encoding", "gzip").Expect().
+		Status(http.StatusOK).
+		Header("Content-Encoding", "gzip").
+		NoHeader("Accept-Ranges")
+	rt.GET("/file").Header("Accept-Encoding", "gzip").Header("Range", "bytes=0-1199").Expect().
+		Status(http.StatusPartialContent).
+		NoHeader("Content-Encoding").
+		Header("Content-Range", fmt.Sprintf("bytes 0-1199/%d", len(large))).
+		Header("Content-Length", "1200").
+		BodyEquals(large[:1200])
+	rt.GET("/content-range").Header("Accept-Encoding", "gzip").Expect().
+		Status(http.StatusRequestedRangeNotSatisfiable).
+		NoHeader("Content-Encoding").
+		BodyEquals(large)
+}
+
+func TestCompressFlush(t *testing.T) {
+	r := NewRouter()
+	r.Use(CompressWithConfig(CompressConfig{Encodings: []string{"gzip"}}))
+	r.GET("/events", func(c *Context) {
+		c.SetHeader("Content-Type", "text/event-stream")
+		for _, event := range []string{"data: one\n\n", "data: two\n\n"} {
+			io.WriteString(c.Writer, event)
+			c.Writer.(http.Flusher).Flush()
+		}
+	})
+
+	resp := routertest.New(t, r).GET("/events").Header("Accept-Encoding", "gzip").Expect().
+		Status(http.StatusOK).
+		Header("Content-Encoding", "gzip")
+	if got := decodeBody(t, "gzip", resp.Body()); got != "data: one\n\ndata: two\n\n" {
+		t.Fatalf("body = %q", got)
+	}
+}
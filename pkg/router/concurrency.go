@@ -0,0 +1,302 @@
+package router
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ConcurrencyConfig configures a ConcurrencyLimiter
+type ConcurrencyConfig struct {
+	// MaxInFlight is the number of requests handled at once
+	MaxInFlight int
+	// MaxQueue is the number of requests that may wait for a slot,
+	// default MaxInFlight. Requests beyond it are rejected at once.
+	MaxQueue int
+	// MaxWait is the longest a request waits for a slot, default one
+	// second
+	MaxWait time.Duration
+	// PerRoute caps the requests handled at once per route, and the
+	// requests waiting for it, so a slow endpoint cannot take every slot.
+	// Zero leaves routes unlimited; Route.MaxConcurrency overrides it.
+	PerRoute int
+	// TargetDelay and Interval drive the CoDel load shedding: when no
+	// request got a slot in less than TargetDelay during an Interval the
+	// limiter counts as overloaded. It then serves the newest waiters
+	// first and lets waiters time out after TargetDelay instead of
+	// MaxWait. The defaults are 5 ms and 100 ms.
+	TargetDelay time.Duration
+	Interval    time.Duration
+	// RetryAfter is sent with rejected requests, default one second
+	RetryAfter time.Duration
+	// Skip exempts requests from the limit when it returns true
+	Skip func(*Context) bool
+}
+
+// ConcurrencyStats is a snapshot of a ConcurrencyLimiter
+type ConcurrencyStats struct {
+	InFlight   int
+	Queued     int
+	Rejected   uint64
+	Overloaded bool
+	// Routes holds the counts of routes with requests in flight or queued,
+	// keyed by method and route pattern
+	Routes map[string]RouteConcurrency
+}
+
+// RouteConcurrency holds the counts of one route
+type RouteConcurrency struct {
+	InFlight int
+	Queued   int
+}
+
+// ConcurrencyLimiter caps the number of requests handled at once and
+// sheds load when requests wait too long. Use its Middleware on the
+// router and Stats to export the gauges.
+type ConcurrencyLimiter struct {
+	config ConcurrencyConfig
+
+	mu         sync.Mutex
+	inFlight   int
+	queue      []*concurrencyWaiter
+	routes     map[string]*RouteConcurrency
+	rejected   uint64
+	overloaded bool
+	// minDelay is the lowest queue delay seen in the CoDel interval
+	// ending at intervalEnd
+	minDelay    time.Duration
+	intervalEnd time.Time
+}
+
+// concurrencyWaiter is a request waiting for a slot
+type concurrencyWaiter struct {
+	route    string
+	limit    int
+	enqueued time.Time
+	ready    chan struct{}
+	granted  bool
+}
+
+// NewConcurrencyLimiter returns a limiter for the configuration
+func NewConcurrencyLimiter(config ConcurrencyConfig) *ConcurrencyLimiter {
+	if config.MaxInFlight <= 0 {
+		panic("router: ConcurrencyLimiter requires a positive MaxInFlight")
+	}
+	if config.MaxQueue == 0 {
+		config.MaxQueue = config.MaxInFlight
+	}
+	if config.MaxWait <= 0 {
+		config.MaxWait = time.Second
+	}
+	if config.TargetDelay <= 0 {
+		config.TargetDelay = 5 * time.Millisecond
+	}
+	if config.Interval <= 0 {
+		config.Interval = 100 * time.Millisecond
+	}
+	if config.RetryAfter <= 0 {
+		config.RetryAfter = time.Second
+	}
+	return &ConcurrencyLimiter{
+		config: config,
+		routes: make(map[string]*RouteConcurrency),
+	}
+}
+
+// MaxConcurrency caps the requests to the route handled at once,
+// overriding ConcurrencyConfig.PerRoute
+func (rt *Route) MaxConcurrency(n int) *Route {
+	rt.Meta.MaxConcurrency = n
+	return rt
+}
+
+// Middleware returns a middleware that holds requests until a slot is
+// free and rejects them with 503 and Retry-After when the queue is full
+// or they waited too long
+func (l *ConcurrencyLimiter) Middleware() MiddlewareFunc {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(c *Context) {
+			if l.config.Skip != nil && l.config.Skip(c) {
+				next(c)
+				return
+			}
+			route, limit := "", 0
+			if rt := c.Route(); rt != nil {
+				route = c.Request.Method + " " + rt.Path
+				limit = l.config.PerRoute
+				if rt.Meta.MaxConcurrency > 0 {
+					limit = rt.Meta.MaxConcurrency
+				}
+			}
+			if !l.acquire(c, route, limit) {
+				c.SetHeader("Retry-After", strconv.Itoa(ceilSeconds(l.config.RetryAfter)))
+				c.Error(NewHTTPError(http.StatusServiceUnavailable, "server overloaded"))
+				return
+			}
+			defer l.release(route)
+			next(c)
+		}
+	}
+}
+
+// Stats returns the current gauges
+func (l *ConcurrencyLimiter) Stats() ConcurrencyStats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	stats := ConcurrencyStats{
+		InFlight:   l.inFlight,
+		Queued:     len(l.queue),
+		Rejected:   l.rejected,
+		Overloaded: l.overloaded,
+		Routes:     make(map[string]RouteConcurrency, len(l.routes)),
+	}
+	for route, counts := range l.routes {
+		stats.Routes[route] = *counts
+	}
+	return stats
+}
+
+// InFlight returns the number of requests being handled
+func (l *ConcurrencyLimiter) InFlight() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.inFlight
+}
+
+// Queued returns the number of requests waiting for a slot
+func (l *ConcurrencyLimiter) Queued() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.queue)
+}
+
+// acquire takes a slot for the request, waiting if needed. It reports
+// false if the request is shed.
+func (l *ConcurrencyLimiter) acquire(c *Context, route string, limit int) bool {
+	now := time.Now()
+	l.mu.Lock()
+	counts := l.routeCounts(route)
+	if l.inFlight < l.config.MaxInFlight && (limit <= 0 || counts.InFlight < limit) {
+		// Free slots only remain while every waiter is held by its route
+		// limit, so the request does not jump ahead of anyone
+		l.take(route, counts, 0, now)
+		l.mu.Unlock()
+		return true
+	}
+	if len(l.queue) >= l.config.MaxQueue || (limit > 0 && counts.Queued >= limit) {
+		l.rejected++
+		l.dropRoute(route, counts)
+		l.mu.Unlock()
+		return false
+	}
+	w := &concurrencyWaiter{route: route, limit: limit, enqueued: now, ready: make(chan struct{})}
+	l.queue = append(l.queue, w)
+	counts.Queued++
+	wait := l.config.MaxWait
+	if l.overloaded {
+		wait = l.config.TargetDelay
+	}
+	l.mu.Unlock()
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-w.ready:
+		return true
+	case <-timer.C:
+	case <-c.Request.Context().Done():
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if w.granted {
+		return true
+	}
+	for i, queued := range l.queue {
+		if queued == w {
+			l.queue = append(l.queue[:i], l.queue[i+1:]...)
+			break
+		}
+	}
+	counts.Queued--
+	l.rejected++
+	l.dropRoute(route, counts)
+	return false
+}
+
+// release frees the slot of a finished request and hands it on
+func (l *ConcurrencyLimiter) release(route string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.inFlight--
+	counts := l.routes[route]
+	counts.InFlight--
+	l.dropRoute(route, counts)
+	l.dispatch()
+}
+
+// dispatch grants free slots to waiters whose route has room. The oldest
+// waiter goes first, or the newest one while overloaded (adaptive LIFO),
+// since it is the most likely to still have a client waiting.
+func (l *ConcurrencyLimiter) dispatch() {
+	now := time.Now()
+	for l.inFlight < l.config.MaxInFlight && len(l.queue) > 0 {
+		next := -1
+		for i := range l.queue {
+			j := i
+			if l.overloaded {
+				j = len(l.queue) - 1 - i
+			}
+			w := l.queue[j]
+			if w.limit <= 0 || l.routes[w.route].InFlight < w.limit {
+				next = j
+				break
+			}
+		}
+		if next < 0 {
+			return
+		}
+		w := l.queue[next]
+		l.queue = append(l.queue[:next], l.queue[next+1:]...)
+		counts := l.routes[w.route]
+		counts.Queued--
+		l.take(w.route, counts, now.Sub(w.enqueued), now)
+		w.granted = true
+		close(w.ready)
+	}
+}
+
+// take counts a request in flight and feeds its queue delay to CoDel;
+// l.mu must be held
+func (l *ConcurrencyLimiter) take(route string, counts *RouteConcurrency, delay time.Duration, now time.Time) {
+	l.inFlight++
+	counts.InFlight++
+	if now.After(l.intervalEnd) {
+		// Overloaded if even the fastest request of the last interval
+		// waited longer than the target
+		l.overloaded = now.Sub(l.intervalEnd) < l.config.Interval && l.minDelay > l.config.TargetDelay
+		l.minDelay = delay
+		l.intervalEnd = now.Add(l.config.Interval)
+	} else if delay < l.minDelay {
+		l.minDelay = delay
+	}
+}
+
+// routeCounts returns the counts of a route, creating them if needed;
+// l.mu must be held
+func (l *ConcurrencyLimiter) routeCounts(route string) *RouteConcurrency {
+	counts := l.routes[route]
+	if counts == nil {
+		counts = &RouteConcurrency{}
+		l.routes[route] = counts
+	}
+	return counts
+}
+
+// dropRoute forgets the counts of an idle route; l.mu must be held
+func (l *ConcurrencyLimiter) dropRoute(route string, counts *RouteConcurrency) {
+	if counts.InFlight == 0 && counts.Queued == 0 {
+		delete(l.routes, route)
+	}
+}
@@ -0,0 +1,397 @@
+package router
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// concurrencyRouter is a router limited by limiter whose handlers report
+// their id on started and then block until the test finishes them
+type concurrencyRouter struct {
+	*Router
+	limiter *ConcurrencyLimiter
+	started chan string
+
+	mu    sync.Mutex
+	gates map[string]chan struct{}
+	open  bool
+}
+
+func newConcurrencyRouter(config ConcurrencyConfig) *concurrencyRouter {
+	cr := &concurrencyRouter{
+		Router:  NewRouter(),
+		limiter: NewConcurrencyLimiter(config),
+		started: make(chan string, 32),
+		gates:   make(map[string]chan struct{}),
+	}
+	quiet := slog.New(slog.NewTextHandler(io.Discard, nil))
+	cr.Use(RecoverWithConfig(RecoverConfig{Logger: quiet}), cr.limiter.Middleware())
+	block := func(c *Context) {
+		id := c.Query("id")
+		cr.started <- id
+		<-cr.gate(id)
+		if c.Query("panic") != "" {
+			panic("handler failed")
+		}
+		c.String(http.StatusOK, "%s", id)
+	}
+	cr.GET("/a", block)
+	cr.GET("/b", block)
+	cr.GET("/c", block).MaxConcurrency(2)
+	cr.GET("/health", func(c *Context) { c.String(http.StatusOK, "ok") })
+	return cr
+}
+
+// gate returns the channel the handler of request id waits on
+func (cr *concurrencyRouter) gate(id string) chan struct{} {
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+	gate, ok := cr.gates[id]
+	if !ok {
+		gate = make(chan struct{})
+		if cr.open {
+			close(gate)
+		}
+		cr.gates[id] = gate
+	}
+	return gate
+}
+
+// finish lets the handler of request id return
+func (cr *concurrencyRouter) finish(id string) {
+	close(cr.gate(id))
+}
+
+// finishAll lets every handler return, now and from here on
+func (cr *concurrencyRouter) finishAll() {
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+	for id, gate := range cr.gates {
+		select {
+		case <-gate:
+		default:
+			close(gate)
+		}
+		delete(cr.gates, id)
+	}
+	cr.open = true
+}
+
+// send serves a request in the background and delivers the response
+func (cr *concurrencyRouter) send(ctx context.Context, target string) <-chan *httptest.ResponseRecorder {
+	done := make(chan *httptest.ResponseRecorder, 1)
+	go func() {
+		req := httptest.NewRequest(http.MethodGet, target, nil).WithContext(ctx)
+		rec := httptest.NewRecorder()
+		cr.ServeHTTP(rec, req)
+		done <- rec
+	}()
+	return done
+}
+
+// start sends a request and waits until its handler runs
+func (cr *concurrencyRouter) start(t *testing.T, target string) <-chan *httptest.ResponseRecorder {
+	t.Helper()
+	done := cr.send(context.Background(), target)
+	select {
+	case <-cr.started:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("%s did not start", target)
+	}
+	return done
+}
+
+// enqueue sends a request and waits until it is queued
+func (cr *concurrencyRouter) enqueue(t *testing.T, ctx context.Context, target string) <-chan *httptest.ResponseRecorder {
+	t.Helper()
+	queued := cr.limiter.Queued()
+	done := cr.send(ctx, target)
+	waitFor(t, func() bool { return cr.limiter.Queued() == queued+1 })
+	return done
+}
+
+// waitFor polls cond until it holds or two seconds pass
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met in time")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// expectStatus waits for a response and checks its status
+func expectStatus(t *testing.T, done <-chan *httptest.ResponseRecorder, status int) *httptest.ResponseRecorder {
+	t.Helper()
+	select {
+	case rec := <-done:
+		if rec.Code != status {
+			t.Fatalf("status = %d, want %d; body %q", rec.Code, status, rec.Body.String())
+		}
+		return rec
+	case <-time.After(2 * time.Second):
+		t.Fatal("no response")
+		return nil
+	}
+}
+
+// expectIdle checks that the gauges went back to zero
+func expectIdle(t *testing.T, l *ConcurrencyLimiter) {
+	t.Helper()
+	waitFor(t, func() bool { return l.InFlight() == 0 })
+	stats := l.Stats()
+	if stats.InFlight != 0 || stats.Queued != 0 || len(stats.Routes) != 0 {
+		t.Fatalf("limiter not idle: %+v", stats)
+	}
+}
+
+func TestConcurrencyLimiterQueue(t *testing.T) {
+	cr := newConcurrencyRouter(ConcurrencyConfig{MaxInFlight: 2, MaxQueue: 1, RetryAfter: 1500 * time.Millisecond})
+	first := cr.start(t, "/a?id=1")
+	second := cr.start(t, "/b?id=2")
+	queued := cr.enqueue(t, context.Background(), "/a?id=3")
+
+	rec := expectStatus(t, cr.send(context.Background(), "/b?id=4"), http.StatusServiceUnavailable)
+	if got := rec.Header().Get("Retry-After"); got != "2" {
+		t.Fatalf("Retry-After = %q, want 2", got)
+	}
+	stats := cr.limiter.Stats()
+	if stats.InFlight != 2 || stats.Queued != 1 || stats.Rejected != 1 {
+		t.Fatalf("stats = %+v", stats)
+	}
+	if got := stats.Routes["GET /a"]; got != (RouteConcurrency{InFlight: 1, Queued: 1}) {
+		t.Fatalf("GET /a counts = %+v", got)
+	}
+
+	cr.finish("1")
+	expectStatus(t, first, http.StatusOK)
+	if id := <-cr.started; id != "3" {
+		t.Fatalf("request %s got the slot, want 3", id)
+	}
+	cr.finishAll()
+	expectStatus(t, second, http.StatusOK)
+	expectStatus(t, queued, http.StatusOK)
+	expectIdle(t, cr.limiter)
+}
+
+func TestConcurrencyLimiterSkip(t *testing.T) {
+	cr := newConcurrencyRouter(ConcurrencyConfig{
+		MaxInFlight: 1,
+		MaxQueue:    1,
+		Skip:        func(c *Context) bool { return c.Request.URL.Path == "/health" },
+	})
+	running := cr.start(t, "/a?id=1")
+	queued := cr.enqueue(t, context.Background(), "/a?id=2")
+	expectStatus(t, cr.send(context.Background(), "/b?id=3"), http.StatusServiceUnavailable)
+
+	// Skipped requests are neither queued nor rejected
+	expectStatus(t, cr.send(context.Background(), "/health"), http.StatusOK)
+	if stats := cr.limiter.Stats(); stats.InFlight != 1 || stats.Queued != 1 || stats.Rejected != 1 {
+		t.Fatalf("stats = %+v", stats)
+	}
+
+	cr.finishAll()
+	expectStatus(t, running, http.StatusOK)
+	expectStatus(t, queued, http.StatusOK)
+	expectIdle(t, cr.limiter)
+}
+
+func TestConcurrencyLimiterMaxWait(t *testing.T) {
+	cr := newConcurrencyRouter(ConcurrencyConfig{MaxInFlight: 1, MaxWait: 20 * time.Millisecond})
+	running := cr.start(t, "/a?id=1")
+
+	rec := expectStatus(t, cr.send(context.Background(), "/a?id=2"), http.StatusServiceUnavailable)
+	if got := rec.Header().Get("Retry-After"); got != "1" {
+		t.Fatalf("Retry-After = %q, want 1", got)
+	}
+	if stats := cr.limiter.Stats(); stats.Queued != 0 || stats.Rejected != 1 {
+		t.Fatalf("stats after timeout = %+v", stats)
+	}
+
+	cr.finishAll()
+	expectStatus(t, running, http.StatusOK)
+	select {
+	case id := <-cr.started:
+		t.Fatalf("timed out request %s ran", id)
+	default:
+	}
+	expectIdle(t, cr.limiter)
+}
+
+func TestConcurrencyLimiterPerRoute(t *testing.T) {
+	cr := newConcurrencyRouter(ConcurrencyConfig{MaxInFlight: 4, MaxQueue: 8, PerRoute: 1})
+	a1 := cr.start(t, "/a?id=a1")
+
+	// The route's queue is bounded by its limit too
+	a2 := cr.enqueue(t, context.Background(), "/a?id=a2")
+	expectStatus(t, cr.send(context.Background(), "/a?id=a3"), http.StatusServiceUnavailable)
+
+	// Other routes still get slots, and MaxConcurrency overrides PerRoute
+	b1 := cr.start(t, "/b?id=b1")
+	c1 := cr.start(t, "/c?id=c1")
+	c2 := cr.start(t, "/c?id=c2")
+	c3 := cr.enqueue(t, context.Background(), "/c?id=c3")
+	stats := cr.limiter.Stats()
+	if stats.InFlight != 4 || stats.Queued != 2 {
+		t.Fatalf("stats = %+v", stats)
+	}
+	want := map[string]RouteConcurrency{
+		"GET /a": {InFlight: 1, Queued: 1},
+		"GET /b": {InFlight: 1},
+		"GET /c": {InFlight: 2, Queued: 1},
+	}
+	for route, counts := range want {
+		if got := stats.Routes[route]; got != counts {
+			t.Errorf("%s counts = %+v, want %+v", route, got, counts)
+		}
+	}
+
+	// The slot freed by /b stays free: both waiters are held by their
+	// route limit. A request to /b takes it without queueing.
+	cr.finish("b1")
+	expectStatus(t, b1, http.StatusOK)
+	waitFor(t, func() bool { return cr.limiter.InFlight() == 3 })
+	if queued := cr.limiter.Queued(); queued != 2 {
+		t.Fatalf("%d queued after /b finished, want 2", queued)
+	}
+	b2 := cr.start(t, "/b?id=b2")
+
+	// A slot on /c goes to its waiter
+	cr.finish("c1")
+	expectStatus(t, c1, http.StatusOK)
+	if id := <-cr.started; id != "c3" {
+		t.Fatalf("request %s got the slot, want c3", id)
+	}
+
+	cr.finishAll()
+	for _, done := range []<-chan *httptest.ResponseRecorder{a1, a2, b2, c2, c3} {
+		expectStatus(t, done, http.StatusOK)
+	}
+	expectIdle(t, cr.limiter)
+}
+
+func TestConcurrencyLimiterDispatchOrder(t *testing.T) {
+	tests := []struct {
+		name       string
+		overloaded bool
+		want       string
+	}{
+		{"oldest first", false, "2 3 4"},
+		{"newest first while overloaded", true, "4 3 2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cr := newConcurrencyRouter(ConcurrencyConfig{MaxInFlight: 1, MaxQueue: 3, TargetDelay: time.Second, Interval: time.Hour})
+			// CoDel keeps the state until the interval ends
+			cr.limiter.mu.Lock()
+			cr.limiter.overloaded = tt.overloaded
+			cr.limiter.intervalEnd = time.Now().Add(time.Hour)
+			cr.limiter.mu.Unlock()
+
+			done := []<-chan *httptest.ResponseRecorder{cr.start(t, "/a?id=1")}
+			for _, id := range []string{"2", "3", "4"} {
+				done = append(done, cr.enqueue(t, context.Background(), "/a?id="+id))
+			}
+			var order []string
+			running := "1"
+			for range done[1:] {
+				cr.finish(running)
+				running = <-cr.started
+				order = append(order, running)
+			}
+			cr.finishAll()
+			for _, d := range done {
+				expectStatus(t, d, http.StatusOK)
+			}
+			if got := strings.Join(order, " "); got != tt.want {
+				t.Fatalf("dispatch order = %s, want %s", got, tt.want)
+			}
+			expectIdle(t, cr.limiter)
+		})
+	}
+}
+
+func TestConcurrencyLimiterOverload(t *testing.T) {
+	l := NewConcurrencyLimiter(ConcurrencyConfig{MaxInFlight: 10})
+	start := time.Now()
+	counts := &RouteConcurrency{}
+	steps := []struct {
+		at         time.Duration
+		delay      time.Duration
+		overloaded bool
+	}{
+		{0, 20 * time.Millisecond, false},
+		{50 * time.Millisecond, 10 * time.Millisecond, false},
+		// Every request of the last interval waited over the target
+		{110 * time.Millisecond, 0, true},
+		// The request above was fast, which clears it
+		{250 * time.Millisecond, 0, false},
+		{260 * time.Millisecond, 30 * time.Millisecond, false},
+		{360 * time.Millisecond, 30 * time.Millisecond, false},
+		// The interval before an idle gap does not count
+		{900 * time.Millisecond, 30 * time.Millisecond, false},
+	}
+	for i, s := range steps {
+		l.take("", counts, s.delay, start.Add(s.at))
+		if l.overloaded != s.overloaded {
+			t.Fatalf("step %d: overloaded = %v, want %v", i, l.overloaded, s.overloaded)
+		}
+	}
+}
+
+func TestConcurrencyLimiterPanic(t *testing.T) {
+	cr := newConcurrencyRouter(ConcurrencyConfig{MaxInFlight: 1})
+	failing := cr.start(t, "/a?id=1&panic=1")
+	queued := cr.enqueue(t, context.Background(), "/a?id=2")
+
+	// The panicking request hands its slot on
+	cr.finish("1")
+	expectStatus(t, failing, http.StatusInternalServerError)
+	if id := <-cr.started; id != "2" {
+		t.Fatalf("request %s got the slot, want 2", id)
+	}
+	cr.finish("2")
+	expectStatus(t, queued, http.StatusOK)
+	expectIdle(t, cr.limiter)
+}
+
+func TestConcurrencyLimiterCanceled(t *testing.T) {
+	cr := newConcurrencyRouter(ConcurrencyConfig{MaxInFlight: 1, MaxWait: time.Minute})
+	running := cr.start(t, "/a?id=1")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	waiting := cr.enqueue(t, ctx, "/a?id=2")
+	cancel()
+	expectStatus(t, waiting, http.StatusServiceUnavailable)
+	stats := cr.limiter.Stats()
+	if stats.Queued != 0 || stats.Rejected != 1 || stats.Routes["GET /a"] != (RouteConcurrency{InFlight: 1}) {
+		t.Fatalf("stats after cancel = %+v", stats)
+	}
+
+	cr.finishAll()
+	expectStatus(t, running, http.StatusOK)
+	select {
+	case id := <-cr.started:
+		t.Fatalf("canceled request %s ran", id)
+	default:
+	}
+	expectIdle(t, cr.limiter)
+}
+
+func TestNewConcurrencyLimiterPanics(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Fatal("NewConcurrencyLimiter accepted MaxInFlight 0")
+		}
+	}()
+	NewConcurrencyLimiter(ConcurrencyConfig{})
+}
@@ -0,0 +1,211 @@
+package router
+
+import (
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CORSConfig configures CORSWithConfig
+type CORSConfig struct {
+	// AllowOrigins lists the allowed origins. Entries are exact origins
+	// such as https://example.com, wildcard subdomains such as
+	// https://*.example.com, or "*" for any origin.
+	AllowOrigins []string
+	// AllowOriginPatterns lists regular expressions matched against the
+	// whole origin
+	AllowOriginPatterns []string
+	// AllowOriginFunc decides about origins not matched by the lists above
+	AllowOriginFunc func(origin string) bool
+	// AllowMethods lists the methods allowed in preflight responses. By
+	// default the methods with a route for the requested path are allowed.
+	AllowMethods []string
+	// AllowHeaders lists the request headers allowed in preflight
+	// responses. By default the headers requested by the browser are allowed.
+	AllowHeaders []string
+	// ExposeHeaders lists the response headers scripts may read
+	ExposeHeaders []string
+	// AllowCredentials allows cookies and authorization headers. The
+	// matching origin is then echoed instead of "*". It cannot be combined
+	// with the "*" origin, which would let any site make credentialed
+	// requests.
+	AllowCredentials bool
+	// MaxAge is how long browsers may cache preflight responses
+	MaxAge time.Duration
+}
+
+// corsPolicy is a compiled CORSConfig
+type corsPolicy struct {
+	config       CORSConfig
+	anyOrigin    bool
+	origins      map[string]bool
+	wildcards    [][2]string
+	patterns     []*regexp.Regexp
+	allowHeaders map[string]bool
+}
+
+// CORS is a middleware that allows cross-origin requests from any origin
+// without credentials
+func CORS() MiddlewareFunc {
+	return CORSWithConfig(CORSConfig{
+		AllowOrigins: []string{"*"},
+		AllowHeaders: []string{"Origin", "Content-Type", "Content-Length", "Accept-Encoding", "X-CSRF-Token", "Authorization"},
+	})
+}
+
+// CORSWithConfig is a middleware that implements Cross-Origin Resource
+// Sharing. Preflight requests are answered with 204 and aborted; preflights
+// from disallowed origins or asking for disallowed methods or headers are
+// rejected with 403. Other requests from disallowed origins are passed on
+// without CORS headers, so the browser withholds the response.
+func CORSWithConfig(config CORSConfig) MiddlewareFunc {
+	p := &corsPolicy{config: config, origins: make(map[string]bool)}
+	for _, origin := range config.AllowOrigins {
+		origin = strings.ToLower(origin)
+		if origin == "*" {
+			if config.AllowCredentials {
+				panic("router: CORS AllowOrigins \"*\" cannot be used with AllowCredentials; list the allowed origins")
+			}
+			p.anyOrigin = true
+		} else if i := strings.Index(origin, "*"); i >= 0 {
+			p.wildcards = append(p.wildcards, [2]string{origin[:i], origin[i+1:]})
+		} else {
+			p.origins[origin] = true
+		}
+	}
+	for _, pattern := range config.AllowOriginPatterns {
+		p.patterns = append(p.patterns, regexp.MustCompile("^(?:"+pattern+")$"))
+	}
+	if config.AllowHeaders != nil {
+		p.allowHeaders = make(map[string]bool)
+		for _, h := range config.AllowHeaders {
+			p.allowHeaders[http.CanonicalHeaderKey(h)] = true
+		}
+	}
+
+	return func(next HandlerFunc) HandlerFunc {
+		return func(c *Context) {
+			origin := c.GetHeader("Origin")
+			header := c.Writer.Header()
+			if !p.anyOrigin {
+				header.Add("Vary", "Origin")
+			}
+			if origin == "" {
+				next(c)
+				return
+			}
+
+			preflight := c.Request.Method == http.MethodOptions && c.GetHeader("Access-Control-Request-Method") != ""
+			if !p.allowOrigin(origin) {
+				if preflight {
+					c.Error(NewHTTPError(http.StatusForbidden, "CORS origin not allowed"))
+					return
+				}
+				next(c)
+				return
+			}
+
+			if p.anyOrigin {
+				c.SetHeader("Access-Control-Allow-Origin", "*")
+			} else {
+				c.SetHeader("Access-Control-Allow-Origin", origin)
+			}
+			if config.AllowCredentials {
+				c.SetHeader("Access-Control-Allow-Credentials", "true")
+			}
+
+			if !preflight {
+				if len(config.ExposeHeaders) > 0 {
+					c.SetHeader("Access-Control-Expose-Headers", strings.Join(config.ExposeHeaders, ", "))
+				}
+				next(c)
+				return
+			}
+			p.preflight(c)
+		}
+	}
+}
+
+// allowOrigin reports whether origin matches the policy
+func (p *corsPolicy) allowOrigin(origin string) bool {
+	if p.anyOrigin {
+		return true
+	}
+	lower := strings.ToLower(origin)
+	if p.origins[lower] {
+		return true
+	}
+	for _, w := range p.wildcards {
+		if len(lower) > len(w[0])+len(w[1]) && strings.HasPrefix(lower, w[0]) && strings.HasSuffix(lower, w[1]) {
+			return true
+		}
+	}
+	for _, re := range p.patterns {
+		if re.MatchString(origin) {
+			return true
+		}
+	}
+	return p.config.AllowOriginFunc != nil && p.config.AllowOriginFunc(origin)
+}
+
+// preflight answers a preflight request whose origin is allowed
+func (p *corsPolicy) preflight(c *Context) {
+	header := c.Writer.Header()
+	header.Add("Vary", "Access-Control-Request-Method")
+	header.Add("Vary", "Access-Control-Request-Headers")
+
+	methods := p.config.AllowMethods
+	if methods == nil && c.router != nil {
+		methods = c.router.AllowedMethods(c.Request.URL.Path)
+	}
+	requested := strings.ToUpper(c.GetHeader("Access-Control-Request-Method"))
+	if !containsString(methods, requested) {
+		rejectPreflight(c, "CORS method not allowed")
+		return
+	}
+
+	var headers []string
+	for _, h := range strings.Split(c.GetHeader("Access-Control-Request-Headers"), ",") {
+		if h = strings.TrimSpace(h); h == "" {
+			continue
+		}
+		if p.allowHeaders != nil && !p.allowHeaders[http.CanonicalHeaderKey(h)] {
+			rejectPreflight(c, "CORS header not allowed: "+h)
+			return
+		}
+		headers = append(headers, h)
+	}
+	if p.allowHeaders != nil {
+		headers = p.config.AllowHeaders
+	}
+
+	c.SetHeader("Access-Control-Allow-Methods", strings.Join(methods, ", "))
+	if len(headers) > 0 {
+		c.SetHeader("Access-Control-Allow-Headers", strings.Join(headers, ", "))
+	}
+	if p.config.MaxAge > 0 {
+		c.SetHeader("Access-Control-Max-Age", strconv.Itoa(int(p.config.MaxAge.Seconds())))
+	}
+	c.AbortWithStatus(http.StatusNoContent)
+}
+
+// rejectPreflight removes the CORS headers already set and fails the
+// preflight with 403
+func rejectPreflight(c *Context, reason string) {
+	header := c.Writer.Header()
+	header.Del("Access-Control-Allow-Origin")
+	header.Del("Access-Control-Allow-Credentials")
+	c.Error(NewHTTPError(http.StatusForbidden, reason))
+}
+
+// containsString reports whether list contains s, ignoring case
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, s) {
+			return true
+		}
+	}
+	return false
+}
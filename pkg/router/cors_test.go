@@ -0,0 +1,184 @@
+package router
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sys-apps-go/gorouter/pkg/router/routertest"
+)
+
+func TestCORS(t *testing.T) {
+	r := NewRouter()
+	r.Use(CORSWithConfig(CORSConfig{
+		AllowOrigins:        []string{"https://app.example.com", "https://*.example.org"},
+		AllowOriginPatterns: []string{`https://pr-\d+\.preview\.example\.net`},
+		AllowOriginFunc:     func(origin string) bool { return origin == "https://partner.test" },
+		AllowHeaders:        []string{"Content-Type", "X-CSRF-Token"},
+		ExposeHeaders:       []string{"X-Request-Id"},
+		AllowCredentials:    true,
+		MaxAge:              10 * time.Minute,
+	}))
+	r.GET("/items", func(c *Context) {
+		c.Status(http.StatusOK)
+	})
+	r.PUT("/items", func(c *Context) {
+		c.Status(http.StatusOK)
+	})
+
+	tests := []struct {
+		name    string
+		method  string
+		origin  string
+		request map[string]string
+		status  int
+		want    map[string]string
+	}{
+		{
+			name:   "no origin",
+			method: http.MethodGet,
+			status: http.StatusOK,
+			want:   map[string]string{"Access-Control-Allow-Origin": "", "Vary": "Origin"},
+		},
+		{
+			name:   "exact origin",
+			method: http.MethodGet,
+			origin: "https://app.example.com",
+			status: http.StatusOK,
+			want: map[string]string{
+				"Access-Control-Allow-Origin":      "https://app.example.com",
+				"Access-Control-Allow-Credentials": "true",
+				"Access-Control-Expose-Headers":    "X-Request-Id",
+			},
+		},
+		{
+			name:   "wildcard subdomain",
+			method: http.MethodGet,
+			origin: "https://shop.example.org",
+			status: http.StatusOK,
+			want:   map[string]string{"Access-Control-Allow-Origin": "https://shop.example.org"},
+		},
+		{
+			name:   "wildcard needs a subdomain",
+			method: http.MethodGet,
+			origin: "https://.example.org",
+			status: http.StatusOK,
+			want:   map[string]string{"Access-Control-Allow-Origin": ""},
+		},
+		{
+			name:   "pattern",
+			method: http.MethodGet,
+			origin: "https://pr-12.preview.example.net",
+			status: http.StatusOK,
+			want:   map[string]string{"Access-Control-Allow-Origin": "https://pr-12.preview.example.net"},
+		},
+		{
+			name:   "pattern is anchored",
+			method: http.MethodGet,
+			origin: "https://pr-12.preview.example.net.evil.com",
+			status: http.StatusOK,
+			want:   map[string]string{"Access-Control-Allow-Origin": ""},
+		},
+		{
+			name:   "func",
+			method: http.MethodGet,
+			origin: "https://partner.test",
+			status: http.StatusOK,
+			want:   map[string]string{"Access-Control-Allow-Origin": "https://partner.test"},
+		},
+		{
+			name:   "disallowed origin passes without headers",
+			method: http.MethodGet,
+			origin: "https://evil.com",
+			status: http.StatusOK,
+			want:   map[string]string{"Access-Control-Allow-Origin": "", "Access-Control-Allow-Credentials": ""},
+		},
+		{
+			name:    "preflight",
+			method:  http.MethodOptions,
+			origin:  "https://app.example.com",
+			request: map[string]string{"Access-Control-Request-Method": "PUT", "Access-Control-Request-Headers": "content-type"},
+			status:  http.StatusNoContent,
+			want: map[string]string{
+				"Access-Control-Allow-Origin":      "https://app.example.com",
+				"Access-Control-Allow-Credentials": "true",
+				"Access-Control-Allow-Methods":     "GET, PUT",
+				"Access-Control-Allow-Headers":     "Content-Type, X-CSRF-Token",
+				"Access-Control-Max-Age":           "600",
+				"Access-Control-Expose-Headers":    "",
+			},
+		},
+		{
+			name:    "preflight from disallowed origin",
+			method:  http.MethodOptions,
+			origin:  "https://evil.com",
+			request: map[string]string{"Access-Control-Request-Method": "PUT"},
+			status:  http.StatusForbidden,
+			want:    map[string]string{"Access-Control-Allow-Origin": ""},
+		},
+		{
+			name:    "preflight for a method without a route",
+			method:  http.MethodOptions,
+			origin:  "https://app.example.com",
+			request: map[string]string{"Access-Control-Request-Method": "DELETE"},
+			status:  http.StatusForbidden,
+			want:    map[string]string{"Access-Control-Allow-Origin": "", "Access-Control-Allow-Credentials": ""},
+		},
+		{
+			name:    "preflight with a disallowed header",
+			method:  http.MethodOptions,
+			origin:  "https://app.example.com",
+			request: map[string]string{"Access-Control-Request-Method": "PUT", "Access-Control-Request-Headers": "X-Admin"},
+			status:  http.StatusForbidden,
+			want:    map[string]string{"Access-Control-Allow-Origin": ""},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := routertest.New(t, r).Request(tt.method, "/items")
+			if tt.origin != "" {
+				req.Header("Origin", tt.origin)
+			}
+			for key, value := range tt.request {
+				req.Header(key, value)
+			}
+			resp := req.Expect().Status(tt.status)
+			for key, value := range tt.want {
+				resp.Header(key, value)
+			}
+		})
+	}
+}
+
+func TestCORSAnyOrigin(t *testing.T) {
+	r := NewRouter()
+	r.Use(CORS())
+	r.GET("/items", func(c *Context) {
+		c.Status(http.StatusOK)
+	})
+
+	rt := routertest.New(t, r)
+	resp := rt.GET("/items").Header("Origin", "https://anywhere.test").Expect().
+		Status(http.StatusOK).
+		Header("Access-Control-Allow-Origin", "*").
+		NoHeader("Access-Control-Allow-Credentials")
+	if vary := strings.Join(resp.Response.Header.Values("Vary"), ","); strings.Contains(vary, "Origin") {
+		t.Errorf("Vary = %q, want no Origin for a wildcard policy", vary)
+	}
+	rt.OPTIONS("/items").
+		Header("Origin", "https://anywhere.test").
+		Header("Access-Control-Request-Method", "GET").
+		Header("Access-Control-Request-Headers", "Authorization").
+		Expect().
+		Status(http.StatusNoContent).
+		Header("Access-Control-Allow-Methods", "GET").
+		HeaderContains("Access-Control-Allow-Headers", "Authorization")
+
+	defer func() {
+		if recover() == nil {
+			t.Error("CORSWithConfig allowed \"*\" with credentials")
+		}
+	}()
+	CORSWithConfig(CORSConfig{AllowOrigins: []string{"*"}, AllowCredentials: true})
+}
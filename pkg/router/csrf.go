@@ -0,0 +1,269 @@
+package router
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// csrfKey is the Context key of the CSRF token of the request
+const csrfKey = "CSRFToken"
+
+// csrfTokenSize is the size of the CSRF secret in bytes
+const csrfTokenSize = 32
+
+// CSRFMode selects where CSRFWithConfig keeps the token secret
+type CSRFMode int
+
+const (
+	// CSRFDoubleSubmit keeps the secret in a cookie that must match the
+	// token submitted with the request
+	CSRFDoubleSubmit CSRFMode = iota
+	// CSRFSession keeps the secret in the session of the Sessions
+	// middleware, which must run first
+	CSRFSession
+)
+
+// CSRFConfig configures CSRFWithConfig
+type CSRFConfig struct {
+	Mode CSRFMode
+	// Header carries the token in requests from scripts, default
+	// X-CSRF-Token
+	Header string
+	// FormField carries the token in form posts, default csrf_token
+	FormField string
+	// CookieName names the secret cookie in CSRFDoubleSubmit mode, default
+	// _csrf
+	CookieName string
+	// CookiePath and CookieDomain scope the cookie; the path defaults to "/"
+	CookiePath   string
+	CookieDomain string
+	// CookieSecure sends the cookie over HTTPS only
+	CookieSecure bool
+	// CookieHTTPOnly hides the cookie from scripts. Leave it off when a
+	// single-page app reads the cookie to fill in the header.
+	CookieHTTPOnly bool
+	// CookieSameSite defaults to http.SameSiteLaxMode
+	CookieSameSite http.SameSite
+	// CookieMaxAge is the lifetime of the cookie, default 12 hours
+	CookieMaxAge time.Duration
+	// TrustedOrigins lists origins such as https://app.example.com that
+	// may send unsafe requests besides the request's own host
+	TrustedOrigins []string
+	// Skip exempts requests from the check when it returns true. Single
+	// routes can be exempted with Route.CSRFExempt.
+	Skip func(*Context) bool
+}
+
+// CSRF is a middleware that protects unsafe requests with double-submit
+// cookie tokens
+func CSRF() MiddlewareFunc {
+	return CSRFWithConfig(CSRFConfig{})
+}
+
+// CSRFWithConfig is a middleware that protects against cross-site request
+// forgery. Every request gets a token, available with c.CSRFToken() and
+// c.CSRFField(), and POST, PUT, PATCH and DELETE requests must send it
+// back in the header or form field. Unsafe requests whose Origin or
+// Referer names another host are rejected with 403 as well.
+func CSRFWithConfig(config CSRFConfig) MiddlewareFunc {
+	if config.Header == "" {
+		config.Header = "X-CSRF-Token"
+	}
+	if config.FormField == "" {
+		config.FormField = "csrf_token"
+	}
+	if config.CookieName == "" {
+		config.CookieName = "_csrf"
+	}
+	if config.CookiePath == "" {
+		config.CookiePath = "/"
+	}
+	if config.CookieSameSite == 0 {
+		config.CookieSameSite = http.SameSiteLaxMode
+	}
+	if config.CookieMaxAge <= 0 {
+		config.CookieMaxAge = 12 * time.Hour
+	}
+	trusted := make(map[string]bool)
+	for _, origin := range config.TrustedOrigins {
+		trusted[strings.ToLower(strings.TrimSuffix(origin, "/"))] = true
+	}
+
+	return func(next HandlerFunc) HandlerFunc {
+		return func(c *Context) {
+			secret, err := csrfSecret(c, &config)
+			if err != nil {
+				c.Error(err)
+				return
+			}
+			c.Set(csrfKey, csrfState{token: maskCSRFToken(secret), field: config.FormField})
+
+			if isSafeMethod(c.Request.Method) ||
+				(config.Skip != nil && config.Skip(c)) ||
+				(c.Route() != nil && c.Route().Meta.CSRFExempt) {
+				next(c)
+				return
+			}
+
+			if reason := checkCSRFOrigin(c, trusted); reason != "" {
+				c.Error(NewHTTPError(http.StatusForbidden, reason))
+				return
+			}
+			submitted := c.GetHeader(config.Header)
+			if submitted == "" {
+				submitted = c.Request.PostFormValue(config.FormField)
+			}
+			if submitted == "" {
+				c.Error(NewHTTPError(http.StatusForbidden, "CSRF token missing"))
+				return
+			}
+			if !validCSRFToken(submitted, secret) {
+				c.Error(NewHTTPError(http.StatusForbidden, "invalid CSRF token"))
+				return
+			}
+			next(c)
+		}
+	}
+}
+
+// csrfState is stored in the Context for the template helpers
+type csrfState struct {
+	token string
+	field string
+}
+
+// CSRFToken returns the CSRF token to send with unsafe requests, or "".
+// A fresh masked token is issued per request, which protects it from
+// compression side channels such as BREACH.
+func (c *Context) CSRFToken() string {
+	value, _ := c.Get(csrfKey)
+	state, _ := value.(csrfState)
+	return state.token
+}
+
+// CSRFField returns a hidden form input holding the CSRF token, for use
+// in templates: {{.CSRFField}}
+func (c *Context) CSRFField() template.HTML {
+	value, _ := c.Get(csrfKey)
+	state, ok := value.(csrfState)
+	if !ok {
+		return ""
+	}
+	return template.HTML(fmt.Sprintf(`<input type="hidden" name="%s" value="%s">`,
+		template.HTMLEscapeString(state.field), template.HTMLEscapeString(state.token)))
+}
+
+// CSRFExempt disables the CSRF check for the route, for example for
+// webhooks authenticated by signature
+func (rt *Route) CSRFExempt() *Route {
+	rt.Meta.CSRFExempt = true
+	return rt
+}
+
+// csrfSecret returns the secret of the request, creating it if needed
+func csrfSecret(c *Context, config *CSRFConfig) ([]byte, error) {
+	if config.Mode == CSRFSession {
+		session := c.Session()
+		if session == nil {
+			return nil, fmt.Errorf("router: CSRF in session mode requires the Sessions middleware")
+		}
+		if secret, err := base64.RawURLEncoding.DecodeString(session.GetString("_csrf")); err == nil && len(secret) == csrfTokenSize {
+			return secret, nil
+		}
+		secret := randomBytes(csrfTokenSize)
+		session.Set("_csrf", base64.RawURLEncoding.EncodeToString(secret))
+		return secret, nil
+	}
+
+	if cookie, err := c.Request.Cookie(config.CookieName); err == nil {
+		if secret, err := base64.RawURLEncoding.DecodeString(cookie.Value); err == nil && len(secret) == csrfTokenSize {
+			return secret, nil
+		}
+	}
+	secret := randomBytes(csrfTokenSize)
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     config.CookieName,
+		Value:    base64.RawURLEncoding.EncodeToString(secret),
+		Path:     config.CookiePath,
+		Domain:   config.CookieDomain,
+		MaxAge:   int(config.CookieMaxAge.Seconds()),
+		Secure:   config.CookieSecure,
+		HttpOnly: config.CookieHTTPOnly,
+		SameSite: config.CookieSameSite,
+	})
+	return secret, nil
+}
+
+// randomBytes returns n bytes from crypto/rand
+func randomBytes(n int) []byte {
+	b := make([]byte, n)
+	rand.Read(b)
+	return b
+}
+
+// maskCSRFToken returns a one-time pad followed by the secret XORed with it
+func maskCSRFToken(secret []byte) string {
+	pad := randomBytes(len(secret))
+	masked := make([]byte, 2*len(secret))
+	copy(masked, pad)
+	for i := range secret {
+		masked[len(secret)+i] = secret[i] ^ pad[i]
+	}
+	return base64.RawURLEncoding.EncodeToString(masked)
+}
+
+// validCSRFToken checks a submitted token, masked or the raw secret as read
+// from the cookie, against the secret
+func validCSRFToken(submitted string, secret []byte) bool {
+	token, err := base64.RawURLEncoding.DecodeString(submitted)
+	if err != nil {
+		return false
+	}
+	if len(token) == 2*len(secret) {
+		pad, masked := token[:len(secret)], token[len(secret):]
+		token = make([]byte, len(secret))
+		for i := range token {
+			token[i] = masked[i] ^ pad[i]
+		}
+	}
+	return subtle.ConstantTimeCompare(token, secret) == 1
+}
+
+// isSafeMethod reports whether method is read-only per RFC 9110
+func isSafeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return true
+	}
+	return false
+}
+
+// checkCSRFOrigin compares the Origin, or failing that the Referer, of an
+// unsafe request with its host and the trusted origins. It returns the
+// reason for rejecting the request, or "".
+func checkCSRFOrigin(c *Context, trusted map[string]bool) string {
+	source := c.GetHeader("Origin")
+	what := "origin"
+	if source == "" {
+		source = c.GetHeader("Referer")
+		what = "referer"
+	}
+	if source == "" {
+		return ""
+	}
+	u, err := url.Parse(source)
+	if err != nil || u.Host == "" {
+		return "CSRF " + what + " not allowed"
+	}
+	if strings.EqualFold(u.Host, c.Request.Host) || trusted[strings.ToLower(u.Scheme+"://"+u.Host)] {
+		return ""
+	}
+	return "CSRF " + what + " not allowed"
+}
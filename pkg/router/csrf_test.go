@@ -0,0 +1,179 @@
+package router
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/sys-apps-go/gorouter/pkg/router/routertest"
+)
+
+// csrfRouter returns a router that hands out the CSRF token on GET /form
+// and accepts posts to /submit and the exempt /webhook
+func csrfRouter(middleware ...MiddlewareFunc) *Router {
+	r := NewRouter()
+	r.Use(middleware...)
+	r.GET("/form", func(c *Context) {
+		c.String(http.StatusOK, "%s", c.CSRFToken())
+	})
+	r.GET("/field", func(c *Context) {
+		c.String(http.StatusOK, "%s", c.CSRFField())
+	})
+	r.POST("/submit", func(c *Context) {
+		c.Status(http.StatusNoContent)
+	})
+	r.POST("/webhook", func(c *Context) {
+		c.Status(http.StatusNoContent)
+	}).CSRFExempt()
+	return r
+}
+
+func TestCSRFDoubleSubmit(t *testing.T) {
+	r := csrfRouter(CSRFWithConfig(CSRFConfig{
+		TrustedOrigins: []string{"https://app.example.net"},
+		Skip:           func(c *Context) bool { return c.GetHeader("X-Internal") != "" },
+	}))
+
+	tests := []struct {
+		name   string
+		send   func(rt *routertest.Client, token, cookie string) *routertest.Response
+		status int
+		error  string
+	}{
+		{
+			name: "header token",
+			send: func(rt *routertest.Client, token, cookie string) *routertest.Response {
+				return rt.POST("/submit").Header("X-CSRF-Token", token).Expect()
+			},
+			status: http.StatusNoContent,
+		},
+		{
+			name: "form token",
+			send: func(rt *routertest.Client, token, cookie string) *routertest.Response {
+				return rt.POST("/submit").Form(url.Values{"csrf_token": {token}}).Expect()
+			},
+			status: http.StatusNoContent,
+		},
+		{
+			name: "unmasked cookie value",
+			send: func(rt *routertest.Client, token, cookie string) *routertest.Response {
+				return rt.POST("/submit").Header("X-CSRF-Token", cookie).Expect()
+			},
+			status: http.StatusNoContent,
+		},
+		{
+			name: "same origin",
+			send: func(rt *routertest.Client, token, cookie string) *routertest.Response {
+				return rt.POST("/submit").Header("Origin", "http://example.com").Header("X-CSRF-Token", token).Expect()
+			},
+			status: http.StatusNoContent,
+		},
+		{
+			name: "trusted origin",
+			send: func(rt *routertest.Client, token, cookie string) *routertest.Response {
+				return rt.POST("/submit").Header("Origin", "https://app.example.net").Header("X-CSRF-Token", token).Expect()
+			},
+			status: http.StatusNoContent,
+		},
+		{
+			name: "missing token",
+			send: func(rt *routertest.Client, token, cookie string) *routertest.Response {
+				return rt.POST("/submit").Expect()
+			},
+			status: http.StatusForbidden,
+			error:  "CSRF token missing",
+		},
+		{
+			name: "invalid token",
+			send: func(rt *routertest.Client, token, cookie string) *routertest.Response {
+				return rt.POST("/submit").Header("X-CSRF-Token", strings.Repeat("A", len(token))).Expect()
+			},
+			status: http.StatusForbidden,
+			error:  "invalid CSRF token",
+		},
+		{
+			name: "foreign origin",
+			send: func(rt *routertest.Client, token, cookie string) *routertest.Response {
+				return rt.POST("/submit").Header("Origin", "https://evil.test").Header("X-CSRF-Token", token).Expect()
+			},
+			status: http.StatusForbidden,
+			error:  "CSRF origin not allowed",
+		},
+		{
+			name: "foreign referer",
+			send: func(rt *routertest.Client, token, cookie string) *routertest.Response {
+				return rt.POST("/submit").Header("Referer", "https://evil.test/page").Header("X-CSRF-Token", token).Expect()
+			},
+			status: http.StatusForbidden,
+			error:  "CSRF referer not allowed",
+		},
+		{
+			name: "exempt route",
+			send: func(rt *routertest.Client, token, cookie string) *routertest.Response {
+				return rt.POST("/webhook").Expect()
+			},
+			status: http.StatusNoContent,
+		},
+		{
+			name: "skip",
+			send: func(rt *routertest.Client, token, cookie string) *routertest.Response {
+				return rt.POST("/submit").Header("X-Internal", "1").Expect()
+			},
+			status: http.StatusNoContent,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rt := routertest.New(t, r)
+			token := string(rt.GET("/form").Expect().Status(http.StatusOK).Body())
+			cookies := rt.Cookies("/")
+			if len(cookies) != 1 || cookies[0].Name != "_csrf" {
+				t.Fatalf("cookies = %v, want the _csrf cookie", cookies)
+			}
+			resp := tt.send(rt, token, cookies[0].Value).Status(tt.status)
+			if tt.error != "" {
+				resp.JSONPath("$.error", tt.error)
+			}
+		})
+	}
+}
+
+func TestCSRFTokens(t *testing.T) {
+	r := csrfRouter(CSRF())
+	rt := routertest.New(t, r)
+
+	// Tokens are masked differently on every request but share the secret
+	first := string(rt.GET("/form").Expect().Body())
+	second := string(rt.GET("/form").Expect().Body())
+	if first == "" || first == second {
+		t.Fatalf("tokens %q and %q, want two different tokens", first, second)
+	}
+	rt.POST("/submit").Header("X-CSRF-Token", first).Expect().Status(http.StatusNoContent)
+
+	// A token from another client does not match this client's cookie
+	other := string(routertest.New(t, r).GET("/form").Expect().Body())
+	rt.POST("/submit").Header("X-CSRF-Token", other).Expect().Status(http.StatusForbidden)
+
+	rt.GET("/field").Expect().
+		Status(http.StatusOK).
+		BodyContains(`<input type="hidden" name="csrf_token" value="`)
+}
+
+func TestCSRFSession(t *testing.T) {
+	r := csrfRouter(Sessions(SessionConfig{}), CSRFWithConfig(CSRFConfig{Mode: CSRFSession}))
+	rt := routertest.New(t, r)
+
+	token := string(rt.GET("/form").Expect().Status(http.StatusOK).Body())
+	for _, cookie := range rt.Cookies("/") {
+		if cookie.Name == "_csrf" {
+			t.Fatal("session mode set a _csrf cookie")
+		}
+	}
+	rt.POST("/submit").Header("X-CSRF-Token", token).Expect().Status(http.StatusNoContent)
+	routertest.New(t, r).POST("/submit").Header("X-CSRF-Token", token).Expect().Status(http.StatusForbidden)
+
+	// Without the Sessions middleware the secret has nowhere to live
+	routertest.New(t, csrfRouter(CSRFWithConfig(CSRFConfig{Mode: CSRFSession}))).
+		GET("/form").Expect().Status(http.StatusInternalServerError)
+}
@@ -5,9 +5,10 @@ import (
 	"database/sql"
 	"fmt"
 	"log"
+	"sync"
 	"time"
 
-	_ "github.com/lib/pq" // PostgreSQL driver
+	"github.com/lib/pq" // PostgreSQL driver
 )
 
 // DB is a wrapper around sql.DB
@@ -90,8 +91,11 @@ func (db *DB) PrepareContext(ctx context.Context, query string) (*sql.Stmt, erro
 	return db.DB.PrepareContext(ctx, query)
 }
 
-// Transaction executes a function within a database transaction
-func (db *DB) Transaction(ctx context.Context, fn func(*sql.Tx) error) error {
+// Transaction executes a function within a database transaction. The
+// transaction is rolled back if fn fails or panics, or if ctx ends first,
+// such as when the deadline set by Timeout passes; pass c.Context() to
+// tie it to the request. Commit errors are returned.
+func (db *DB) Transaction(ctx context.Context, fn func(*sql.Tx) error) (err error) {
 	tx, err := db.BeginTx(ctx)
 	if err != nil {
 		return err
@@ -121,3 +125,60 @@ func (db *DB) Middleware() MiddlewareFunc {
 		}
 	}
 }
+
+// postgresTable is the table of a PostgreSQL backed store. It creates the
+// table and deletes expired rows periodically until Close is called.
+type postgresTable struct {
+	db *DB
+	// table is the quoted table name, ready to use in statements
+	table string
+
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// newPostgresTable runs the schema statements, which should create the
+// table and its indexes if they do not exist, and returns the table
+func newPostgresTable(db *DB, table string, schema func(table string) []string) (*postgresTable, error) {
+	t := &postgresTable{
+		db:    db,
+		table: pq.QuoteIdentifier(table),
+		stop:  make(chan struct{}),
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for _, statement := range schema(t.table) {
+		if _, err := db.ExecContext(ctx, statement); err != nil {
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+// startCleanup calls deleteExpired every interval until Close is called,
+// logging failures under name. A negative interval disables the cleanup.
+func (t *postgresTable) startCleanup(name string, interval time.Duration, deleteExpired func(context.Context) error) {
+	if interval <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-t.stop:
+				return
+			case <-ticker.C:
+				if err := deleteExpired(context.Background()); err != nil {
+					log.Printf("%s: cleanup failed: %v", name, err)
+				}
+			}
+		}
+	}()
+}
+
+// Close stops the periodic cleanup. It does not close the database.
+func (t *postgresTable) Close() error {
+	t.stopOnce.Do(func() { close(t.stop) })
+	return nil
+}
@@ -0,0 +1,118 @@
+package router
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+)
+
+// HandlerE is a handler that reports failure by returning an error.
+// Returned errors are passed to the router's error handler.
+type HandlerE func(*Context) error
+
+// HandlerFunc adapts h into a HandlerFunc that routes errors through Context.Error
+func (h HandlerE) HandlerFunc() HandlerFunc {
+	return func(c *Context) {
+		if err := h(c); err != nil {
+			c.Error(err)
+		}
+	}
+}
+
+// ErrorHandlerFunc handles an error returned by a HandlerE or passed to Context.Error
+type ErrorHandlerFunc func(*Context, error)
+
+// StatusCoder is implemented by errors that carry an HTTP status code
+type StatusCoder interface {
+	StatusCode() int
+}
+
+// HTTPError is an error with an HTTP status code and a client-facing message
+type HTTPError struct {
+	Code    int
+	Message string
+	Err     error
+}
+
+// NewHTTPError returns an HTTPError with the given status code and message
+func NewHTTPError(code int, message string) *HTTPError {
+	return &HTTPError{Code: code, Message: message}
+}
+
+// WrapHTTPError returns an HTTPError with the given status code wrapping err
+func WrapHTTPError(code int, err error) *HTTPError {
+	return &HTTPError{Code: code, Err: err}
+}
+
+func (e *HTTPError) Error() string {
+	if e.Message != "" {
+		return e.Message
+	}
+	if e.Err != nil {
+		return e.Err.Error()
+	}
+	return http.StatusText(e.Code)
+}
+
+// StatusCode returns the HTTP status code of the error
+func (e *HTTPError) StatusCode() int {
+	return e.Code
+}
+
+// Unwrap returns the wrapped error
+func (e *HTTPError) Unwrap() error {
+	return e.Err
+}
+
+// StatusCodeOf returns the status code carried by err, or 500 if there is
+// none. Reading past a body limit counts as 413.
+func StatusCodeOf(err error) int {
+	var sc StatusCoder
+	if errors.As(err, &sc) && sc.StatusCode() != 0 {
+		return sc.StatusCode()
+	}
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return http.StatusRequestEntityTooLarge
+	}
+	return http.StatusInternalServerError
+}
+
+// DefaultErrorHandler aborts the request and writes the error as a JSON body
+// with the status code found in the error chain. If the chain contains an
+// HTTPError its message is used instead of the full error text, and a
+// ValidationError adds the list of invalid fields. Other errors with a 5xx
+// status, including HTTPErrors that only wrap one, are logged and the client
+// only gets the status text, so database and file system details do not leak.
+// Errors raised after the response was started are only logged.
+func DefaultErrorHandler(c *Context, err error) {
+	if c.Written() {
+		slog.ErrorContext(c.Request.Context(), "request failed after the response was written",
+			slog.String("method", c.Request.Method),
+			slog.String("path", c.Request.URL.Path),
+			slog.Int("status", c.ResponseStatus()),
+			slog.Any("error", err),
+		)
+		c.Abort()
+		return
+	}
+	code := StatusCodeOf(err)
+	body := map[string]interface{}{"error": err.Error()}
+	var he *HTTPError
+	if errors.As(err, &he) && (he.Message != "" || code < 500) {
+		body["error"] = he.Error()
+	} else if code >= 500 {
+		slog.ErrorContext(c.Request.Context(), "request failed",
+			slog.String("method", c.Request.Method),
+			slog.String("path", c.Request.URL.Path),
+			slog.Any("error", err),
+		)
+		body["error"] = http.StatusText(code)
+	}
+	var verr *ValidationError
+	if errors.As(err, &verr) {
+		body["fields"] = verr.Fields
+	}
+	c.Abort()
+	c.JSON(code, body)
+}
@@ -0,0 +1,154 @@
+package router
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/sys-apps-go/gorouter/pkg/router/routertest"
+)
+
+// teapotError carries its own status code
+type teapotError struct{}
+
+func (teapotError) Error() string   { return "short and stout" }
+func (teapotError) StatusCode() int { return http.StatusTeapot }
+
+func TestDefaultErrorHandler(t *testing.T) {
+	tests := []struct {
+		name   string
+		err    error
+		status int
+		body   string
+	}{
+		{
+			name:   "http error",
+			err:    NewHTTPError(http.StatusNotFound, "user not found"),
+			status: http.StatusNotFound,
+			body:   `{"error":"user not found"}`,
+		},
+		{
+			name:   "wrapped http error",
+			err:    fmt.Errorf("loading user: %w", NewHTTPError(http.StatusConflict, "user exists")),
+			status: http.StatusConflict,
+			body:   `{"error":"user exists"}`,
+		},
+		{
+			name:   "client error without message",
+			err:    WrapHTTPError(http.StatusBadRequest, errors.New("bad id")),
+			status: http.StatusBadRequest,
+			body:   `{"error":"bad id"}`,
+		},
+		{
+			name:   "status coder",
+			err:    teapotError{},
+			status: http.StatusTeapot,
+			body:   `{"error":"short and stout"}`,
+		},
+		{
+			name:   "plain error",
+			err:    errors.New("pq: connection refused"),
+			status: http.StatusInternalServerError,
+			body:   `{"error":"Internal Server Error"}`,
+		},
+		{
+			name:   "server error wrapping a cause",
+			err:    WrapHTTPError(http.StatusBadGateway, errors.New("dial tcp 10.0.0.1:5432")),
+			status: http.StatusBadGateway,
+			body:   `{"error":"Bad Gateway"}`,
+		},
+		{
+			name:   "server error with message",
+			err:    &HTTPError{Code: http.StatusServiceUnavailable, Message: "try later", Err: errors.New("pool exhausted")},
+			status: http.StatusServiceUnavailable,
+			body:   `{"error":"try later"}`,
+		},
+		{
+			name:   "body too large",
+			err:    fmt.Errorf("reading body: %w", &http.MaxBytesError{Limit: 10}),
+			status: http.StatusRequestEntityTooLarge,
+			body:   `{"error":"reading body: http: request body too large"}`,
+		},
+		{
+			name: "validation error",
+			err: &ValidationError{Fields: []FieldError{
+				{Field: "name", Rule: "required", Message: "name is required"},
+			}},
+			status: http.StatusBadRequest,
+			body:   `{"error":"validation failed: name is required","fields":[{"field":"name","rule":"required","message":"name is required"}]}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewRouter()
+			r.GET("/", func(c *Context) error {
+				return tt.err
+			})
+			routertest.New(t, r).GET("/").Expect().
+				Status(tt.status).
+				JSON(json.RawMessage(tt.body))
+		})
+	}
+}
+
+func TestDefaultErrorHandlerAfterWrite(t *testing.T) {
+	var logs bytes.Buffer
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(slog.New(slog.NewTextHandler(&logs, nil)))
+
+	r := NewRouter()
+	r.GET("/", func(c *Context) error {
+		c.String(http.StatusAccepted, "partial")
+		return errors.New("stream broke")
+	})
+	routertest.New(t, r).GET("/").Expect().
+		Status(http.StatusAccepted).
+		BodyEquals("partial")
+	for _, want := range []string{"request failed after the response was written", "status=202", `error="stream broke"`} {
+		if !strings.Contains(logs.String(), want) {
+			t.Errorf("log %q does not contain %q", logs.String(), want)
+		}
+	}
+}
+
+func TestErrorHandlerRegistration(t *testing.T) {
+	r := NewRouter()
+	r.SetErrorHandler(func(c *Context, err error) {
+		c.String(StatusCodeOf(err), "custom: %v", err)
+	})
+	r.GET("/handler-e", HandlerE(func(c *Context) error {
+		return NewHTTPError(http.StatusForbidden, "no")
+	}))
+	r.GET("/context-error", func(c *Context) {
+		c.Error(NewHTTPError(http.StatusUnauthorized, "who"))
+	})
+	r.GET("/nil", func(c *Context) error {
+		c.String(http.StatusOK, "fine")
+		return nil
+	})
+	group := r.Group("/group")
+	group.GET("/handler-e", func(c *Context) error {
+		return errors.New("boom")
+	})
+
+	tests := []struct {
+		path   string
+		status int
+		body   string
+	}{
+		{"/handler-e", http.StatusForbidden, "custom: no"},
+		{"/context-error", http.StatusUnauthorized, "custom: who"},
+		{"/nil", http.StatusOK, "fine"},
+		{"/group/handler-e", http.StatusInternalServerError, "custom: boom"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			routertest.New(t, r).GET(tt.path).Expect().Status(tt.status).BodyEquals(tt.body)
+		})
+	}
+}
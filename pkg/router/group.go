@@ -11,7 +11,9 @@ type RouterGroup struct {
 	prefix      string
 	parent      *RouterGroup
 	router      *Router
-	middlewares []HandlerFunc
+	middlewares []MiddlewareFunc
+	// authorization is inherited by subgroups, unlike middlewares
+	authorization Authorization
 }
 
 // Group creates a new router group
@@ -21,54 +23,60 @@ func (group *RouterGroup) Group(prefix string) *RouterGroup {
 		prefix:      group.prefix + prefix,
 		parent:      group,
 		router:      group.router,
-		middlewares: []HandlerFunc{},
+		middlewares: []MiddlewareFunc{},
 	}
 }
 
-func (group *RouterGroup) Use(middleware ...HandlerFunc) {
-	group.middlewares = append(group.middlewares, middleware...)
+// Use adds middleware to every route registered on the group afterwards.
+// A MiddlewareFunc wraps the rest of the chain; other handlers run before
+// it and stop it by aborting.
+func (group *RouterGroup) Use(middleware ...Handler) {
+	for _, m := range middleware {
+		group.middlewares = append(group.middlewares, toMiddleware(m))
+	}
 }
 
 // GET registers a new GET route for a path with handler
-func (group *RouterGroup) GET(relativePath string, handlers ...HandlerFunc) {
-	group.handle(http.MethodGet, relativePath, handlers)
+func (group *RouterGroup) GET(relativePath string, handlers ...Handler) *Route {
+	return group.handle(http.MethodGet, relativePath, handlers)
 }
 
 // POST registers a new POST route for a path with handler
-func (group *RouterGroup) POST(relativePath string, handlers ...HandlerFunc) {
-	group.handle(http.MethodPost, relativePath, handlers)
+func (group *RouterGroup) POST(relativePath string, handlers ...Handler) *Route {
+	return group.handle(http.MethodPost, relativePath, handlers)
 }
 
 // PUT registers a new PUT route for a path with handler
-func (group *RouterGroup) PUT(relativePath string, handlers ...HandlerFunc) {
-	group.handle(http.MethodPut, relativePath, handlers)
+func (group *RouterGroup) PUT(relativePath string, handlers ...Handler) *Route {
+	return group.handle(http.MethodPut, relativePath, handlers)
 }
 
 // DELETE registers a new DELETE route for a path with handler
-func (group *RouterGroup) DELETE(relativePath string, handlers ...HandlerFunc) {
-	group.handle(http.MethodDelete, relativePath, handlers)
+func (group *RouterGroup) DELETE(relativePath string, handlers ...Handler) *Route {
+	return group.handle(http.MethodDelete, relativePath, handlers)
 }
 
 // PATCH registers a new PATCH route for a path with handler
-func (group *RouterGroup) PATCH(relativePath string, handlers ...HandlerFunc) {
-	group.handle(http.MethodPatch, relativePath, handlers)
+func (group *RouterGroup) PATCH(relativePath string, handlers ...Handler) *Route {
+	return group.handle(http.MethodPatch, relativePath, handlers)
 }
 
 // HEAD registers a new HEAD route for a path with handler
-func (group *RouterGroup) HEAD(relativePath string, handlers ...HandlerFunc) {
-	group.handle(http.MethodHead, relativePath, handlers)
+func (group *RouterGroup) HEAD(relativePath string, handlers ...Handler) *Route {
+	return group.handle(http.MethodHead, relativePath, handlers)
 }
 
 // OPTIONS registers a new OPTIONS route for a path with handler
-func (group *RouterGroup) OPTIONS(relativePath string, handlers ...HandlerFunc) {
-	group.handle(http.MethodOptions, relativePath, handlers)
+func (group *RouterGroup) OPTIONS(relativePath string, handlers ...Handler) *Route {
+	return group.handle(http.MethodOptions, relativePath, handlers)
 }
 
 // handle registers a new route for a path with matching method and handlers
-func (group *RouterGroup) handle(httpMethod, relativePath string, handlers []HandlerFunc) {
+func (group *RouterGroup) handle(httpMethod, relativePath string, handlers []Handler) *Route {
 	absolutePath := group.calculateAbsolutePath(relativePath)
-	handlers = group.combineHandlers(handlers)
-	group.router.addRoute(httpMethod, absolutePath, handlers...)
+	route := group.router.addRoute(httpMethod, absolutePath, group.combineHandlers(handlers)).setHandlerTypes(handlers...)
+	route.Meta.Authorization = group.inheritedAuthorization()
+	return route
 }
 
 // calculateAbsolutePath returns absolute path of current group combined with given relative path
@@ -84,13 +92,22 @@ func (group *RouterGroup) calculateAbsolutePath(relativePath string) string {
 	return absolutePath
 }
 
-// combineHandlers returns handlers combined with group middleware
-func (group *RouterGroup) combineHandlers(handlers []HandlerFunc) []HandlerFunc {
-	finalSize := len(group.middlewares) + len(handlers)
-	mergedHandlers := make([]HandlerFunc, finalSize)
-	copy(mergedHandlers, group.middlewares)
-	copy(mergedHandlers[len(group.middlewares):], handlers)
-	return mergedHandlers
+// combineHandlers chains the group middleware and the route handlers into
+// one handler. All but the last route handler are treated as middleware,
+// so a MiddlewareFunc such as Timeout can be applied to a single route.
+func (group *RouterGroup) combineHandlers(handlers []Handler) HandlerFunc {
+	middlewares := append([]MiddlewareFunc(nil), group.middlewares...)
+	final := HandlerFunc(func(*Context) {})
+	if len(handlers) > 0 {
+		for _, h := range handlers[:len(handlers)-1] {
+			middlewares = append(middlewares, toMiddleware(h))
+		}
+		final = toHandlerFunc(handlers[len(handlers)-1])
+	}
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		final = middlewares[i](final)
+	}
+	return final
 }
 
 // Static serves files from the given file system root
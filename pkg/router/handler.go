@@ -2,6 +2,8 @@ package router
 
 import (
 	"encoding/json"
+	"encoding/xml"
+	"errors"
 	"fmt"
 	"net/http"
 	"sync"
@@ -16,6 +18,9 @@ type Context struct {
 	handlers   []HandlerFunc
 	index      int
 	Keys       map[string]interface{}
+	router     *Router
+	route      *Route
+	writer     *responseWriter
 }
 
 var (
@@ -28,12 +33,15 @@ var (
 
 func newContext(w http.ResponseWriter, req *http.Request) *Context {
 	c := contextPool.Get().(*Context)
-	c.Writer = w
+	c.writer = &responseWriter{ResponseWriter: w, status: http.StatusOK}
+	c.Writer = c.writer
 	c.Request = req
 	c.Params = make(map[string]string)
 	c.StatusCode = http.StatusOK
 	c.handlers = nil
 	c.index = -1
+	c.router = nil
+	c.route = nil
 	return c
 }
 
@@ -44,6 +52,9 @@ func (c *Context) reset() {
 	c.StatusCode = http.StatusOK
 	c.handlers = nil
 	c.index = -1
+	c.router = nil
+	c.route = nil
+	c.writer = nil
 }
 
 // Next is used to pass control to the next middleware
@@ -60,6 +71,20 @@ func (c *Context) Param(key string) string {
 	return c.Params[key]
 }
 
+// Route returns the route matched by the request, or nil if none matched
+func (c *Context) Route() *Route {
+	return c.route
+}
+
+// FullPath returns the registered path pattern of the matched route,
+// such as /api/users/:id, or "" if no route matched
+func (c *Context) FullPath() string {
+	if c.route == nil {
+		return ""
+	}
+	return c.route.Path
+}
+
 // Query returns the query param for the provided key
 func (c *Context) Query(key string) string {
 	return c.Request.URL.Query().Get(key)
@@ -78,14 +103,63 @@ func (c *Context) Set(key string, value interface{}) {
 	c.Keys[key] = value
 }
 
+// Get returns the value stored for key with Set
+func (c *Context) Get(key string) (interface{}, bool) {
+	value, ok := c.Keys[key]
+	return value, ok
+}
+
+// GetString returns the string stored for key, or "" if there is none
+func (c *Context) GetString(key string) string {
+	s, _ := c.Keys[key].(string)
+	return s
+}
+
 func (c *Context) IsAborted() bool {
 	return c.index >= len(c.handlers)
 }
 
 // In pkg/myrouter/handler.go or wherever your Context struct is defined
 
+// BindJSON decodes the JSON request body into obj. A body over the limit
+// set by BodyLimit or Decompress gives a 413 HTTPError.
 func (c *Context) BindJSON(obj interface{}) error {
-	return json.NewDecoder(c.Request.Body).Decode(obj)
+	err := json.NewDecoder(c.Request.Body).Decode(obj)
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return WrapHTTPError(http.StatusRequestEntityTooLarge, err)
+	}
+	return err
+}
+
+// BeforeWriteHeader registers fn to run just before the response header
+// is sent, so middleware can still add headers such as cookies after the
+// handler has started writing. If nothing was written the hooks run when
+// the request is finished.
+func (c *Context) BeforeWriteHeader(fn func()) {
+	c.writer.beforeWrite = append(c.writer.beforeWrite, fn)
+}
+
+// Written reports whether the response status has been sent
+func (c *Context) Written() bool {
+	return c.writer != nil && c.writer.wroteHeader
+}
+
+// ResponseStatus returns the status code sent to the client, or 200 if
+// nothing has been sent yet
+func (c *Context) ResponseStatus() int {
+	if c.writer == nil {
+		return c.StatusCode
+	}
+	return c.writer.status
+}
+
+// ResponseSize returns the number of body bytes written to the client
+func (c *Context) ResponseSize() int {
+	if c.writer == nil {
+		return 0
+	}
+	return c.writer.size
 }
 
 // Status sets the HTTP response status code
@@ -111,6 +185,29 @@ func (c *Context) JSON(code int, obj interface{}) {
 	}
 }
 
+// XML sends an XML response
+func (c *Context) XML(code int, obj interface{}) {
+	c.SetHeader("Content-Type", "application/xml")
+	c.Status(code)
+	c.Writer.Write([]byte(xml.Header))
+	if err := xml.NewEncoder(c.Writer).Encode(obj); err != nil {
+		http.Error(c.Writer, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// Negotiate renders obj as JSON, XML or plain text depending on the
+// request's Accept header. JSON is used when the client has no preference.
+func (c *Context) Negotiate(code int, obj interface{}) {
+	switch NegotiateContentType(c.GetHeader("Accept"), "application/json", "application/xml", "text/xml", "text/plain") {
+	case "application/xml", "text/xml":
+		c.XML(code, obj)
+	case "text/plain":
+		c.String(code, "%v", obj)
+	default:
+		c.JSON(code, obj)
+	}
+}
+
 // Data sends a byte slice as the response
 func (c *Context) Data(code int, contentType string, data []byte) {
 	c.SetHeader("Content-Type", contentType)
@@ -147,10 +244,14 @@ func (c *Context) AbortWithJSON(code int, obj interface{}) {
 	c.JSON(code, obj)
 }
 
-// Error is a shortcut for AbortWithStatus(500)
+// Error aborts the request and passes err to the router's error handler.
+// The response status is taken from the error chain and defaults to 500.
 func (c *Context) Error(err error) {
-	c.AbortWithStatus(http.StatusInternalServerError)
-	c.Writer.Write([]byte(err.Error()))
+	if c.router != nil && c.router.errorHandler != nil {
+		c.router.errorHandler(c, err)
+		return
+	}
+	DefaultErrorHandler(c, err)
 }
 
 // HandlersChain defines a HandlerFunc array.
@@ -0,0 +1,337 @@
+package router
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// IdempotencyRecord is the stored outcome of a request made with an
+// Idempotency-Key
+type IdempotencyRecord struct {
+	// Fingerprint identifies the request the key was first used with
+	Fingerprint string
+	// Done is false while the first request is still being handled
+	Done   bool
+	Status int
+	Header http.Header
+	Body   []byte
+}
+
+// ErrIdempotencyKeyLost is returned by IdempotencyStore.Complete when the
+// key is no longer reserved by the request, for example because its lock
+// expired and another request reserved it
+var ErrIdempotencyKeyLost = errors.New("idempotency key reservation lost")
+
+// IdempotencyStore keeps idempotency records. Begin must be atomic so
+// only one of several concurrent requests with a key gets to run.
+type IdempotencyStore interface {
+	// Begin reserves key for a request with the fingerprint until lock
+	// expires. If the key was free it returns a token that identifies the
+	// reservation, otherwise the existing record.
+	Begin(ctx context.Context, key, fingerprint string, lock time.Time) (token string, record *IdempotencyRecord, err error)
+	// Complete stores the response for key until expires. It returns
+	// ErrIdempotencyKeyLost unless key is still reserved with token.
+	Complete(ctx context.Context, key, token string, record *IdempotencyRecord, expires time.Time) error
+	// Release frees key if it is still reserved with token, so the
+	// request can be retried. Reservations taken over by another request
+	// are left alone.
+	Release(ctx context.Context, key, token string) error
+}
+
+// IdempotencyConfig configures Idempotency
+type IdempotencyConfig struct {
+	// Store keeps the records, default a new MemoryIdempotencyStore
+	Store IdempotencyStore
+	// Header carries the key, default Idempotency-Key
+	Header string
+	// Methods lists the methods the key is honored for, default POST and
+	// PATCH
+	Methods []string
+	// Required rejects requests without a key with 400
+	Required bool
+	// TTL is how long responses are replayed, default 24 hours
+	TTL time.Duration
+	// LockTimeout is how long a key stays reserved by a request that never
+	// completes, for example because the instance crashed, default one
+	// minute
+	LockTimeout time.Duration
+	// Scope returns the namespace of the keys of a request, so clients
+	// cannot replay each other's responses. The default is the
+	// Authorization header together with the SessionCookie cookie;
+	// requests carrying neither share one namespace, so set Scope when
+	// anonymous clients send keys.
+	Scope func(*Context) string
+	// SessionCookie names the session cookie of the default Scope,
+	// default "session" as used by Sessions
+	SessionCookie string
+	// Skip bypasses the middleware when it returns true
+	Skip func(*Context) bool
+}
+
+// Idempotency is a middleware that makes retries of unsafe requests safe.
+// The first request with an Idempotency-Key runs normally and its status,
+// headers and body are stored; retries with the same key get the stored
+// response with an Idempotent-Replayed header instead of running again.
+// A retry while the first request is still running is rejected with 409,
+// and reusing a key for a different request with 422. Responses with a
+// 5xx status or 429 are not stored, so those requests can be retried.
+// Set-Cookie headers are never stored or replayed.
+func Idempotency(config IdempotencyConfig) MiddlewareFunc {
+	if config.Store == nil {
+		config.Store = NewMemoryIdempotencyStore()
+	}
+	if config.Header == "" {
+		config.Header = "Idempotency-Key"
+	}
+	if config.Methods == nil {
+		config.Methods = []string{http.MethodPost, http.MethodPatch}
+	}
+	if config.TTL <= 0 {
+		config.TTL = 24 * time.Hour
+	}
+	if config.LockTimeout <= 0 {
+		config.LockTimeout = time.Minute
+	}
+	if config.SessionCookie == "" {
+		config.SessionCookie = "session"
+	}
+	if config.Scope == nil {
+		config.Scope = func(c *Context) string {
+			session := ""
+			if cookie, err := c.Request.Cookie(config.SessionCookie); err == nil {
+				session = cookie.Value
+			}
+			return c.GetHeader("Authorization") + "\x00" + session
+		}
+	}
+
+	return func(next HandlerFunc) HandlerFunc {
+		return func(c *Context) {
+			if !containsExact(config.Methods, c.Request.Method) || (config.Skip != nil && config.Skip(c)) {
+				next(c)
+				return
+			}
+			key := c.GetHeader(config.Header)
+			if key == "" {
+				if config.Required {
+					c.Error(NewHTTPError(http.StatusBadRequest, config.Header+" header required"))
+					return
+				}
+				next(c)
+				return
+			}
+			if len(key) > 255 {
+				c.Error(NewHTTPError(http.StatusBadRequest, config.Header+" header too long"))
+				return
+			}
+
+			body, err := readBody(c.Request, bodyLimit(c, DefaultMaxBodySize))
+			if err != nil {
+				c.Error(err)
+				return
+			}
+			fingerprint := idempotencyHash(c.Request.Method, c.Request.URL.RequestURI(), string(body))
+			storeKey := idempotencyHash(config.Scope(c), key)
+
+			ctx := c.Request.Context()
+			token, record, err := config.Store.Begin(ctx, storeKey, fingerprint, time.Now().Add(config.LockTimeout))
+			if err != nil {
+				c.Error(WrapHTTPError(http.StatusServiceUnavailable, fmt.Errorf("idempotency store: %w", err)))
+				return
+			}
+			if record != nil {
+				switch {
+				case record.Fingerprint != fingerprint:
+					c.Error(NewHTTPError(http.StatusUnprocessableEntity, config.Header+" was used with a different request"))
+				case !record.Done:
+					c.SetHeader("Retry-After", "1")
+					c.Error(NewHTTPError(http.StatusConflict, "a request with this "+config.Header+" is in progress"))
+				default:
+					header := c.Writer.Header()
+					for name, values := range record.Header {
+						header[name] = append([]string(nil), values...)
+					}
+					header.Set("Idempotent-Replayed", "true")
+					c.Writer.WriteHeader(record.Status)
+					c.Writer.Write(record.Body)
+				}
+				return
+			}
+
+			before := c.Writer.Header().Clone()
+			rw := &recordingWriter{ResponseWriter: c.Writer, status: http.StatusOK}
+			c.Writer = rw
+			completed := false
+			defer func() {
+				c.Writer = rw.ResponseWriter
+				if completed {
+					return
+				}
+				// The request failed or panicked; let the client retry it.
+				// The request context may be done, so use a fresh one.
+				if err := config.Store.Release(context.Background(), storeKey, token); err != nil {
+					log.Printf("Idempotency: store error: %v", err)
+				}
+			}()
+			next(c)
+
+			if !rw.wroteHeader || rw.status >= 500 || rw.status == http.StatusTooManyRequests {
+				return
+			}
+			// Cookies belong to the client that made the first request
+			header := headerChanges(before, c.Writer.Header())
+			header.Del("Set-Cookie")
+			record = &IdempotencyRecord{
+				Fingerprint: fingerprint,
+				Done:        true,
+				Status:      rw.status,
+				Header:      header,
+				Body:        rw.body.Bytes(),
+			}
+			if err := config.Store.Complete(context.Background(), storeKey, token, record, time.Now().Add(config.TTL)); err != nil {
+				log.Printf("Idempotency: store error: %v", err)
+				// A lost key is held by another request now; leave it be
+				completed = errors.Is(err, ErrIdempotencyKeyLost)
+				return
+			}
+			completed = true
+		}
+	}
+}
+
+// idempotencyHash hashes the parts of a key or fingerprint
+func idempotencyHash(parts ...string) string {
+	h := sha256.New()
+	for _, part := range parts {
+		h.Write([]byte(part))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// recordingWriter passes a response through while keeping a copy
+type recordingWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+// WriteHeader records and sends the status
+func (w *recordingWriter) WriteHeader(code int) {
+	if !w.wroteHeader && code >= 200 {
+		w.status = code
+		w.wroteHeader = true
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+// Write records and sends data
+func (w *recordingWriter) Write(data []byte) (int, error) {
+	w.wroteHeader = true
+	w.body.Write(data)
+	return w.ResponseWriter.Write(data)
+}
+
+// Flush sends buffered data to the client
+func (w *recordingWriter) Flush() {
+	w.wroteHeader = true
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack lets the caller take over the connection
+func (w *recordingWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if h, ok := w.ResponseWriter.(http.Hijacker); ok {
+		return h.Hijack()
+	}
+	return nil, nil, fmt.Errorf("router: %T does not support hijacking", w.ResponseWriter)
+}
+
+// Unwrap returns the underlying writer for http.ResponseController
+func (w *recordingWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// MemoryIdempotencyStore keeps idempotency records in memory. Records are
+// lost on restart and not shared between instances.
+type MemoryIdempotencyStore struct {
+	mu        sync.Mutex
+	records   map[string]memoryIdempotencyRecord
+	nextSweep time.Time
+}
+
+type memoryIdempotencyRecord struct {
+	record  *IdempotencyRecord
+	token   string
+	expires time.Time
+}
+
+// NewMemoryIdempotencyStore returns an empty in-memory store
+func NewMemoryIdempotencyStore() *MemoryIdempotencyStore {
+	return &MemoryIdempotencyStore{records: make(map[string]memoryIdempotencyRecord)}
+}
+
+// Begin reserves key unless it holds an unexpired record
+func (s *MemoryIdempotencyStore) Begin(ctx context.Context, key, fingerprint string, lock time.Time) (string, *IdempotencyRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	if now.After(s.nextSweep) {
+		for k, entry := range s.records {
+			if now.After(entry.expires) {
+				delete(s.records, k)
+			}
+		}
+		s.nextSweep = now.Add(time.Minute)
+	}
+	if entry, ok := s.records[key]; ok && now.Before(entry.expires) {
+		return "", entry.record, nil
+	}
+	token := newIdempotencyToken()
+	s.records[key] = memoryIdempotencyRecord{
+		record:  &IdempotencyRecord{Fingerprint: fingerprint},
+		token:   token,
+		expires: lock,
+	}
+	return token, nil, nil
+}
+
+// Complete stores the response for key if the request still holds it
+func (s *MemoryIdempotencyStore) Complete(ctx context.Context, key, token string, record *IdempotencyRecord, expires time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry, ok := s.records[key]
+	if !ok || entry.record.Done || entry.token != token {
+		return ErrIdempotencyKeyLost
+	}
+	s.records[key] = memoryIdempotencyRecord{record: record, expires: expires}
+	return nil
+}
+
+// Release frees key if the request still holds it
+func (s *MemoryIdempotencyStore) Release(ctx context.Context, key, token string) error {
+	s.mu.Lock()
+	if entry, ok := s.records[key]; ok && !entry.record.Done && entry.token == token {
+		delete(s.records, key)
+	}
+	s.mu.Unlock()
+	return nil
+}
+
+// newIdempotencyToken returns a random token identifying a reservation
+func newIdempotencyToken() string {
+	return base64.RawURLEncoding.EncodeToString(randomBytes(16))
+}
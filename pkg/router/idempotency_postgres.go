@@ -0,0 +1,147 @@
+package router
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// PostgresIdempotencyStore keeps idempotency records in a PostgreSQL
+// table, so retries are recognized by every instance
+type PostgresIdempotencyStore struct {
+	*postgresTable
+}
+
+// PostgresIdempotencyConfig configures a PostgresIdempotencyStore
+type PostgresIdempotencyConfig struct {
+	// Table is the name of the table, default idempotency_keys. It is
+	// created if it does not exist.
+	Table string
+	// CleanupInterval is how often expired records are deleted, default
+	// five minutes. A negative value disables the cleanup.
+	CleanupInterval time.Duration
+}
+
+// NewPostgresIdempotencyStore creates the idempotency table if needed and
+// starts the periodic cleanup of expired records. Call Close to stop it.
+func NewPostgresIdempotencyStore(db *DB, config PostgresIdempotencyConfig) (*PostgresIdempotencyStore, error) {
+	if config.Table == "" {
+		config.Table = "idempotency_keys"
+	}
+	if config.CleanupInterval == 0 {
+		config.CleanupInterval = 5 * time.Minute
+	}
+
+	t, err := newPostgresTable(db, config.Table, func(table string) []string {
+		return []string{
+			`CREATE TABLE IF NOT EXISTS ` + table + ` (
+				key         TEXT PRIMARY KEY,
+				fingerprint TEXT NOT NULL,
+				token       TEXT NOT NULL DEFAULT '',
+				done        BOOLEAN NOT NULL DEFAULT false,
+				status      INTEGER NOT NULL DEFAULT 0,
+				header      JSONB,
+				body        BYTEA,
+				expires_at  TIMESTAMPTZ NOT NULL
+			)`,
+			// Tables created before reservations had tokens
+			`ALTER TABLE ` + table + ` ADD COLUMN IF NOT EXISTS token TEXT NOT NULL DEFAULT ''`,
+		}
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error creating idempotency table: %w", err)
+	}
+	s := &PostgresIdempotencyStore{postgresTable: t}
+	s.startCleanup("PostgresIdempotencyStore", config.CleanupInterval, s.DeleteExpired)
+	return s, nil
+}
+
+// DeleteExpired removes expired records
+func (s *PostgresIdempotencyStore) DeleteExpired(ctx context.Context) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM `+s.table+` WHERE expires_at < now()`)
+	return err
+}
+
+// Begin reserves key with a single upsert that only replaces expired
+// records, or returns the record holding it
+func (s *PostgresIdempotencyStore) Begin(ctx context.Context, key, fingerprint string, lock time.Time) (string, *IdempotencyRecord, error) {
+	// The record may expire or be released between the two statements,
+	// in which case the reservation is attempted again
+	for attempt := 0; attempt < 3; attempt++ {
+		token := newIdempotencyToken()
+		var reserved string
+		err := s.db.QueryRowContext(ctx, `INSERT INTO `+s.table+` AS t (key, fingerprint, token, expires_at)
+			VALUES ($1, $2, $3, $4)
+			ON CONFLICT (key) DO UPDATE SET fingerprint = EXCLUDED.fingerprint, token = EXCLUDED.token,
+				done = false, status = 0, header = NULL, body = NULL, expires_at = EXCLUDED.expires_at
+			WHERE t.expires_at < now()
+			RETURNING key`,
+			key, fingerprint, token, lock,
+		).Scan(&reserved)
+		if err == nil {
+			return token, nil, nil
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			return "", nil, fmt.Errorf("error reserving idempotency key: %w", err)
+		}
+
+		var record IdempotencyRecord
+		var header []byte
+		err = s.db.QueryRowContext(ctx,
+			`SELECT fingerprint, done, status, header, body FROM `+s.table+` WHERE key = $1`,
+			key,
+		).Scan(&record.Fingerprint, &record.Done, &record.Status, &header, &record.Body)
+		if errors.Is(err, sql.ErrNoRows) {
+			continue
+		}
+		if err != nil {
+			return "", nil, fmt.Errorf("error loading idempotency record: %w", err)
+		}
+		if len(header) > 0 {
+			record.Header = make(http.Header)
+			if err := json.Unmarshal(header, &record.Header); err != nil {
+				return "", nil, err
+			}
+		}
+		return "", &record, nil
+	}
+	return "", nil, errors.New("error reserving idempotency key: too much contention")
+}
+
+// Complete stores the response for key if the request still holds it
+func (s *PostgresIdempotencyStore) Complete(ctx context.Context, key, token string, record *IdempotencyRecord, expires time.Time) error {
+	header, err := json.Marshal(record.Header)
+	if err != nil {
+		return err
+	}
+	body := record.Body
+	if body == nil {
+		body = []byte{}
+	}
+	result, err := s.db.ExecContext(ctx, `UPDATE `+s.table+`
+		SET done = true, status = $2, header = $3, body = $4, expires_at = $5
+		WHERE key = $1 AND token = $6 AND NOT done`,
+		key, record.Status, header, body, expires, token,
+	)
+	if err != nil {
+		return fmt.Errorf("error saving idempotency record: %w", err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("error saving idempotency record: %w", err)
+	}
+	if n == 0 {
+		return ErrIdempotencyKeyLost
+	}
+	return nil
+}
+
+// Release frees key if the request still holds it
+func (s *PostgresIdempotencyStore) Release(ctx context.Context, key, token string) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM `+s.table+` WHERE key = $1 AND token = $2 AND NOT done`, key, token)
+	return err
+}
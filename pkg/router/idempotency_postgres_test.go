@@ -0,0 +1,16 @@
+package router
+
+import "testing"
+
+func TestPostgresIdempotencyStore(t *testing.T) {
+	db := testPostgres(t)
+	store, err := NewPostgresIdempotencyStore(db, PostgresIdempotencyConfig{
+		Table:           testTable(t, db, "idempotency_keys_test"),
+		CleanupInterval: -1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	testIdempotencyStore(t, store)
+}
@@ -0,0 +1,384 @@
+package router
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sys-apps-go/gorouter/pkg/router/routertest"
+)
+
+// idempotencyRouter returns a router whose handlers count their calls and
+// answer with the count, so replayed responses repeat an earlier one
+func idempotencyRouter(config IdempotencyConfig) (*Router, *atomic.Int32) {
+	var calls atomic.Int32
+	r := NewRouter()
+	quiet := slog.New(slog.NewTextHandler(io.Discard, nil))
+	r.Use(RecoverWithConfig(RecoverConfig{Logger: quiet}), Idempotency(config))
+	orders := func(c *Context) {
+		n := calls.Add(1)
+		c.SetHeader("X-Order", strconv.Itoa(int(n)))
+		http.SetCookie(c.Writer, &http.Cookie{Name: "flash", Value: "created"})
+		c.JSON(http.StatusCreated, map[string]int32{"id": n})
+	}
+	r.POST("/orders", orders)
+	r.PATCH("/orders", orders)
+	r.PUT("/orders", orders)
+	r.POST("/status/:code", func(c *Context) {
+		calls.Add(1)
+		code, _ := strconv.Atoi(c.Param("code"))
+		c.String(code, "status %d", code)
+	})
+	r.POST("/panic", func(c *Context) {
+		if calls.Add(1) == 1 {
+			panic("first attempt fails")
+		}
+		c.Status(http.StatusNoContent)
+	})
+	return r, &calls
+}
+
+func TestIdempotency(t *testing.T) {
+	// step is one request; key, auth and session are sent when set and
+	// replays is the 1-based number of the step whose response it gets
+	type step struct {
+		method  string
+		path    string
+		key     string
+		body    string
+		auth    string
+		session string
+		status  int
+		replays int
+		calls   int32
+	}
+	tests := []struct {
+		name   string
+		config IdempotencyConfig
+		steps  []step
+	}{
+		{
+			name: "replay",
+			steps: []step{
+				{key: "a", body: `{"n":1}`, status: http.StatusCreated, calls: 1},
+				{key: "a", body: `{"n":1}`, status: http.StatusCreated, replays: 1, calls: 1},
+				{key: "b", body: `{"n":1}`, status: http.StatusCreated, calls: 2},
+			},
+		},
+		{
+			name: "different body",
+			steps: []step{
+				{key: "a", body: `{"n":1}`, status: http.StatusCreated, calls: 1},
+				{key: "a", body: `{"n":2}`, status: http.StatusUnprocessableEntity, calls: 1},
+			},
+		},
+		{
+			name: "different method",
+			steps: []step{
+				{key: "a", status: http.StatusCreated, calls: 1},
+				{method: http.MethodPatch, key: "a", status: http.StatusUnprocessableEntity, calls: 1},
+			},
+		},
+		{
+			name: "without key",
+			steps: []step{
+				{status: http.StatusCreated, calls: 1},
+				{status: http.StatusCreated, calls: 2},
+			},
+		},
+		{
+			name: "method not covered",
+			steps: []step{
+				{method: http.MethodPut, key: "a", status: http.StatusCreated, calls: 1},
+				{method: http.MethodPut, key: "a", status: http.StatusCreated, calls: 2},
+			},
+		},
+		{
+			name: "server errors are retried",
+			steps: []step{
+				{path: "/status/503", key: "a", status: http.StatusServiceUnavailable, calls: 1},
+				{path: "/status/503", key: "a", status: http.StatusServiceUnavailable, calls: 2},
+			},
+		},
+		{
+			name: "rate limited requests are retried",
+			steps: []step{
+				{path: "/status/429", key: "a", status: http.StatusTooManyRequests, calls: 1},
+				{path: "/status/429", key: "a", status: http.StatusTooManyRequests, calls: 2},
+			},
+		},
+		{
+			name: "client errors are replayed",
+			steps: []step{
+				{path: "/status/400", key: "a", status: http.StatusBadRequest, calls: 1},
+				{path: "/status/400", key: "a", status: http.StatusBadRequest, replays: 1, calls: 1},
+			},
+		},
+		{
+			name: "panics release the key",
+			steps: []step{
+				{path: "/panic", key: "a", status: http.StatusInternalServerError, calls: 1},
+				{path: "/panic", key: "a", status: http.StatusNoContent, calls: 2},
+				{path: "/panic", key: "a", status: http.StatusNoContent, replays: 2, calls: 2},
+			},
+		},
+		{
+			name: "scoped by Authorization",
+			steps: []step{
+				{key: "a", auth: "Bearer ann", status: http.StatusCreated, calls: 1},
+				{key: "a", auth: "Bearer bob", status: http.StatusCreated, calls: 2},
+				{key: "a", status: http.StatusCreated, calls: 3},
+				{key: "a", auth: "Bearer ann", status: http.StatusCreated, replays: 1, calls: 3},
+			},
+		},
+		{
+			name: "scoped by session",
+			steps: []step{
+				{key: "a", session: "s1", status: http.StatusCreated, calls: 1},
+				{key: "a", session: "s2", status: http.StatusCreated, calls: 2},
+				{key: "a", session: "s1", status: http.StatusCreated, replays: 1, calls: 2},
+			},
+		},
+		{
+			name:   "custom scope",
+			config: IdempotencyConfig{Scope: func(c *Context) string { return c.GetHeader("X-Tenant") }},
+			steps: []step{
+				{key: "a", session: "s1", status: http.StatusCreated, calls: 1},
+				{key: "a", session: "s2", status: http.StatusCreated, replays: 1, calls: 1},
+			},
+		},
+		{
+			name:   "required",
+			config: IdempotencyConfig{Required: true},
+			steps: []step{
+				{status: http.StatusBadRequest, calls: 0},
+				{key: "a", status: http.StatusCreated, calls: 1},
+			},
+		},
+		{
+			name: "key too long",
+			steps: []step{
+				{key: string(bytes.Repeat([]byte("k"), 256)), status: http.StatusBadRequest, calls: 0},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, calls := idempotencyRouter(tt.config)
+			var bodies [][]byte
+			for i, s := range tt.steps {
+				method, path := s.method, s.path
+				if method == "" {
+					method = http.MethodPost
+				}
+				if path == "" {
+					path = "/orders"
+				}
+				req := routertest.New(t, r).Request(method, path).Body("application/json", []byte(s.body))
+				if s.key != "" {
+					req.Header("Idempotency-Key", s.key)
+				}
+				if s.auth != "" {
+					req.Header("Authorization", s.auth)
+				}
+				if s.session != "" {
+					req.Cookie(&http.Cookie{Name: "session", Value: s.session})
+				}
+				resp := req.Expect().Status(s.status)
+				if got := calls.Load(); got != s.calls {
+					t.Fatalf("step %d: %d handler calls, want %d", i, got, s.calls)
+				}
+				bodies = append(bodies, resp.Body())
+				if s.replays == 0 {
+					resp.NoHeader("Idempotent-Replayed")
+					continue
+				}
+				// Cookies belong to the client of the first request
+				resp.Header("Idempotent-Replayed", "true").NoHeader("Set-Cookie")
+				if path == "/orders" {
+					resp.Header("X-Order", strconv.Itoa(int(tt.steps[s.replays-1].calls)))
+				}
+				if want := bodies[s.replays-1]; !bytes.Equal(resp.Body(), want) {
+					t.Fatalf("step %d: replayed body %q, want %q", i, resp.Body(), want)
+				}
+			}
+		})
+	}
+}
+
+func TestIdempotencyInFlight(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	r := NewRouter()
+	r.Use(Idempotency(IdempotencyConfig{}))
+	r.POST("/slow", func(c *Context) {
+		close(started)
+		<-release
+		c.Status(http.StatusNoContent)
+	})
+
+	done := make(chan int)
+	go func() {
+		req := httptest.NewRequest(http.MethodPost, "/slow", nil)
+		req.Header.Set("Idempotency-Key", "a")
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+		done <- rec.Code
+	}()
+	<-started
+
+	rt := routertest.New(t, r)
+	rt.POST("/slow").Header("Idempotency-Key", "a").Expect().
+		Status(http.StatusConflict).
+		Header("Retry-After", "1")
+	close(release)
+	if code := <-done; code != http.StatusNoContent {
+		t.Fatalf("first request status = %d", code)
+	}
+	rt.POST("/slow").Header("Idempotency-Key", "a").Expect().
+		Status(http.StatusNoContent).
+		Header("Idempotent-Replayed", "true")
+}
+
+func TestIdempotencyExpiry(t *testing.T) {
+	r, calls := idempotencyRouter(IdempotencyConfig{TTL: 30 * time.Millisecond})
+	rt := routertest.New(t, r)
+
+	rt.POST("/orders").Header("Idempotency-Key", "a").Expect().Status(http.StatusCreated)
+	rt.POST("/orders").Header("Idempotency-Key", "a").Expect().Header("Idempotent-Replayed", "true")
+	time.Sleep(50 * time.Millisecond)
+	rt.POST("/orders").Header("Idempotency-Key", "a").Expect().
+		Status(http.StatusCreated).
+		NoHeader("Idempotent-Replayed").
+		JSONPath("$.id", 2)
+	if calls.Load() != 2 {
+		t.Fatalf("%d handler calls, want 2", calls.Load())
+	}
+}
+
+func TestIdempotencyLockExpiry(t *testing.T) {
+	// A request that outlives its lock must not free or overwrite the
+	// reservation of the retry that took the key over
+	var calls atomic.Int32
+	started := make(chan struct{}, 2)
+	release := [2]chan struct{}{make(chan struct{}), make(chan struct{})}
+	r := NewRouter()
+	r.Use(Idempotency(IdempotencyConfig{LockTimeout: 20 * time.Millisecond}))
+	r.POST("/charge", func(c *Context) {
+		n := calls.Add(1)
+		started <- struct{}{}
+		<-release[n-1]
+		if n == 1 {
+			c.Status(http.StatusInternalServerError)
+			return
+		}
+		c.String(http.StatusCreated, "charged %d", n)
+	})
+	send := func() <-chan int {
+		result := make(chan int, 1)
+		go func() {
+			req := httptest.NewRequest(http.MethodPost, "/charge", nil)
+			req.Header.Set("Idempotency-Key", "a")
+			rec := httptest.NewRecorder()
+			r.ServeHTTP(rec, req)
+			result <- rec.Code
+		}()
+		return result
+	}
+
+	first := send()
+	<-started
+	time.Sleep(30 * time.Millisecond)
+	second := send()
+	<-started
+
+	// The first request fails after the second one took the key
+	close(release[0])
+	if code := <-first; code != http.StatusInternalServerError {
+		t.Fatalf("first request status = %d", code)
+	}
+	rt := routertest.New(t, r)
+	rt.POST("/charge").Header("Idempotency-Key", "a").Expect().Status(http.StatusConflict)
+
+	close(release[1])
+	if code := <-second; code != http.StatusCreated {
+		t.Fatalf("second request status = %d", code)
+	}
+	rt.POST("/charge").Header("Idempotency-Key", "a").Expect().
+		Status(http.StatusCreated).
+		Header("Idempotent-Replayed", "true").
+		BodyEquals("charged 2")
+	if calls.Load() != 2 {
+		t.Fatalf("%d handler calls, want 2", calls.Load())
+	}
+}
+
+func TestMemoryIdempotencyStore(t *testing.T) {
+	testIdempotencyStore(t, NewMemoryIdempotencyStore())
+}
+
+// testIdempotencyStore checks that store hands out reservations and only
+// lets their holder complete or release them
+func testIdempotencyStore(t *testing.T, store IdempotencyStore) {
+	ctx := context.Background()
+	past := time.Now().Add(-time.Second)
+	future := time.Now().Add(time.Minute)
+
+	// An expired lock is taken over by the next request
+	stale, record, err := store.Begin(ctx, "key", "fp", past)
+	if err != nil || stale == "" || record != nil {
+		t.Fatalf("Begin() = %q, %v, %v", stale, record, err)
+	}
+	token, record, err := store.Begin(ctx, "key", "fp", future)
+	if err != nil || token == "" || token == stale || record != nil {
+		t.Fatalf("Begin() after expiry = %q, %v, %v", token, record, err)
+	}
+
+	// The old holder can neither release nor complete the key
+	if err := store.Release(ctx, "key", stale); err != nil {
+		t.Fatal(err)
+	}
+	_, record, err = store.Begin(ctx, "key", "fp", future)
+	if err != nil || record == nil || record.Done || record.Fingerprint != "fp" {
+		t.Fatalf("Begin() while reserved = %+v, %v", record, err)
+	}
+	response := &IdempotencyRecord{Fingerprint: "fp", Done: true, Status: http.StatusCreated, Header: http.Header{"X-Id": {"1"}}, Body: []byte("ok")}
+	if err := store.Complete(ctx, "key", stale, response, future); !errors.Is(err, ErrIdempotencyKeyLost) {
+		t.Fatalf("Complete() with a stale token = %v, want ErrIdempotencyKeyLost", err)
+	}
+
+	if err := store.Complete(ctx, "key", token, response, future); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Complete(ctx, "key", token, response, future); !errors.Is(err, ErrIdempotencyKeyLost) {
+		t.Fatalf("second Complete() = %v, want ErrIdempotencyKeyLost", err)
+	}
+	if err := store.Release(ctx, "key", token); err != nil {
+		t.Fatal(err)
+	}
+	_, record, err = store.Begin(ctx, "key", "other", future)
+	if err != nil || record == nil || !record.Done || record.Status != http.StatusCreated ||
+		string(record.Body) != "ok" || record.Header.Get("X-Id") != "1" || record.Fingerprint != "fp" {
+		t.Fatalf("Begin() after Complete = %+v, %v", record, err)
+	}
+
+	// The holder releases its own reservation
+	token, _, err = store.Begin(ctx, "released", "fp", future)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Release(ctx, "released", token); err != nil {
+		t.Fatal(err)
+	}
+	if token, record, err = store.Begin(ctx, "released", "fp", future); err != nil || token == "" || record != nil {
+		t.Fatalf("Begin() after Release = %q, %v, %v", token, record, err)
+	}
+}
@@ -0,0 +1,86 @@
+package router
+
+import (
+	"net/http"
+	"sync/atomic"
+)
+
+// IPFilterConfig configures an IPFilter. Entries are IPs or CIDR ranges.
+type IPFilterConfig struct {
+	// Allow lists the only clients let through; empty allows everyone not
+	// denied
+	Allow []string
+	// Deny lists clients that are rejected, even if they are allowed
+	Deny []string
+	// StatusCode is sent to rejected clients, default 403
+	StatusCode int
+}
+
+// ipRules is an immutable snapshot of the lists of an IPFilter
+type ipRules struct {
+	allow ipSet
+	deny  ipSet
+}
+
+// IPFilter allows or denies requests by client IP, as returned by
+// c.ClientIP, so it sees through trusted proxies. The lists can be
+// replaced with Update while requests are being served, for example on
+// SIGHUP with lists read by ParseIPList.
+type IPFilter struct {
+	rules      atomic.Pointer[ipRules]
+	statusCode int
+}
+
+// NewIPFilter returns a filter with the given lists
+func NewIPFilter(config IPFilterConfig) (*IPFilter, error) {
+	f := &IPFilter{statusCode: config.StatusCode}
+	if f.statusCode == 0 {
+		f.statusCode = http.StatusForbidden
+	}
+	if err := f.Update(config.Allow, config.Deny); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Update replaces both lists at once. On error the old lists are kept.
+func (f *IPFilter) Update(allow, deny []string) error {
+	allowSet, err := parseIPSet(allow)
+	if err != nil {
+		return err
+	}
+	denySet, err := parseIPSet(deny)
+	if err != nil {
+		return err
+	}
+	f.rules.Store(&ipRules{allow: allowSet, deny: denySet})
+	return nil
+}
+
+// Allowed reports whether the filter lets ip through. Addresses that do
+// not parse are only allowed if there is no allow list.
+func (f *IPFilter) Allowed(ip string) bool {
+	rules := f.rules.Load()
+	addr, err := parseIP(ip)
+	if err != nil {
+		return len(rules.allow) == 0
+	}
+	if rules.deny.contains(addr) {
+		return false
+	}
+	return len(rules.allow) == 0 || rules.allow.contains(addr)
+}
+
+// Middleware returns a middleware that rejects requests from clients the
+// filter does not allow
+func (f *IPFilter) Middleware() MiddlewareFunc {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(c *Context) {
+			if !f.Allowed(c.ClientIP()) {
+				c.Error(NewHTTPError(f.statusCode, http.StatusText(f.statusCode)))
+				return
+			}
+			next(c)
+		}
+	}
+}
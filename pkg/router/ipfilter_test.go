@@ -0,0 +1,132 @@
+package router
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestIPFilterAllowed(t *testing.T) {
+	f, err := NewIPFilter(IPFilterConfig{
+		Allow: []string{"10.0.0.0/8", "2001:db8::/32", "198.51.100.7"},
+		Deny:  []string{"10.0.0.66", "2001:db8:bad::/48"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		ip   string
+		want bool
+	}{
+		{"10.1.2.3", true},
+		{"198.51.100.7", true},
+		{"198.51.100.8", false},
+		{"10.0.0.66", false},
+		{"2001:db8::1", true},
+		{"2001:db8:bad::1", false},
+		{"::ffff:10.1.2.3", true},
+		{"fe80::1%eth0", false},
+		{"not an ip", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := f.Allowed(tt.ip); got != tt.want {
+			t.Errorf("Allowed(%q) = %v, want %v", tt.ip, got, tt.want)
+		}
+	}
+
+	// A deny list alone lets through everyone else, even unparseable
+	// addresses
+	f, err = NewIPFilter(IPFilterConfig{Deny: []string{"203.0.113.0/24"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for ip, want := range map[string]bool{"203.0.113.9": false, "198.51.100.1": true, "garbage": true} {
+		if got := f.Allowed(ip); got != want {
+			t.Errorf("deny only: Allowed(%q) = %v, want %v", ip, got, want)
+		}
+	}
+
+	for _, config := range []IPFilterConfig{{Allow: []string{"10.0.0.0/40"}}, {Deny: []string{"example.com"}}} {
+		if _, err := NewIPFilter(config); err == nil {
+			t.Errorf("NewIPFilter(%+v) succeeded", config)
+		}
+	}
+}
+
+func TestIPFilterMiddleware(t *testing.T) {
+	f, err := NewIPFilter(IPFilterConfig{Allow: []string{"10.0.0.0/8"}, StatusCode: http.StatusNotFound})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := clientIPRouter()
+	r.Use(f.Middleware())
+	if err := r.SetTrustedProxies("10.0.0.5"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		remote string
+		xff    string
+		status int
+	}{
+		{"internal client", "10.1.2.3:5000", "", http.StatusOK},
+		{"external client", "203.0.113.9:5000", "", http.StatusNotFound},
+		{"spoofed from outside", "203.0.113.9:5000", "10.1.2.3", http.StatusNotFound},
+		{"internal client via proxy", "10.0.0.5:5000", "10.1.2.3", http.StatusOK},
+		{"external client via proxy", "10.0.0.5:5000", "203.0.113.9", http.StatusNotFound},
+		{"spoofed through the proxy", "10.0.0.5:5000", "10.1.2.3, 203.0.113.9", http.StatusNotFound},
+		{"garbage through the proxy", "10.0.0.5:5000", "garbage, 203.0.113.9", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/ip", nil)
+			req.RemoteAddr = tt.remote
+			if tt.xff != "" {
+				req.Header.Set("X-Forwarded-For", tt.xff)
+			}
+			rec := httptest.NewRecorder()
+			r.ServeHTTP(rec, req)
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.status)
+			}
+		})
+	}
+}
+
+func TestIPFilterUpdate(t *testing.T) {
+	f, err := NewIPFilter(IPFilterConfig{Deny: []string{"203.0.113.9"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	list, err := ParseIPList(strings.NewReader("# blocked networks\n198.51.100.0/24\n\n  192.0.2.1  # one host\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"198.51.100.0/24", "192.0.2.1"}; strings.Join(list, " ") != strings.Join(want, " ") {
+		t.Fatalf("ParseIPList() = %q, want %q", list, want)
+	}
+	if err := f.Update(nil, list); err != nil {
+		t.Fatal(err)
+	}
+	for ip, want := range map[string]bool{"203.0.113.9": true, "198.51.100.20": false, "192.0.2.1": false} {
+		if got := f.Allowed(ip); got != want {
+			t.Errorf("after update Allowed(%q) = %v, want %v", ip, got, want)
+		}
+	}
+
+	// A bad list keeps the old rules
+	if err := f.Update([]string{"10.0.0.0/8"}, []string{"bad"}); err == nil {
+		t.Fatal("Update accepted an invalid entry")
+	}
+	if !f.Allowed("203.0.113.9") || f.Allowed("192.0.2.1") {
+		t.Fatal("failed update changed the rules")
+	}
+
+	if _, err := ParseIPList(strings.NewReader("10.0.0.0/8\n10.0.0.0/99\n")); err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Fatalf("ParseIPList error = %v, want one naming line 2", err)
+	}
+}
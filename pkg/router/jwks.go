@@ -0,0 +1,315 @@
+package router
+
+import (
+	"context"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// JWKSConfig configures a JWKS loaded from a file or URL
+type JWKSConfig struct {
+	// RefreshInterval is how long keys are cached before they are loaded
+	// again, default one hour
+	RefreshInterval time.Duration
+	// MinRefreshInterval limits how often a token with an unknown kid can
+	// trigger an early reload to pick up rotated keys, default one minute
+	MinRefreshInterval time.Duration
+	// Client fetches URLs, default a client with a 10 second timeout
+	Client *http.Client
+}
+
+// JWKS is a JSON Web Key Set used as the KeySet of JWT. Keys loaded from
+// a file or URL are cached and reloaded periodically, and early when a
+// token is signed with an unknown key ID, so keys can be rotated without
+// a restart. Reloads run outside the lock and only once at a time, so a
+// slow key server never holds up tokens signed with cached keys.
+type JWKS struct {
+	config JWKSConfig
+	load   func(ctx context.Context) ([]byte, error)
+	now    func() time.Time
+	group  singleflight.Group
+
+	mu       sync.RWMutex
+	keys     []jwk
+	loadedAt time.Time
+}
+
+// jwk is a parsed JSON Web Key
+type jwk struct {
+	kid string
+	alg string
+	key interface{}
+}
+
+// NewJWKSFromURL returns a JWKS that fetches its keys from url, typically
+// an identity provider's jwks_uri. Keys are fetched on first use.
+func NewJWKSFromURL(url string, config JWKSConfig) *JWKS {
+	if config.Client == nil {
+		config.Client = &http.Client{Timeout: 10 * time.Second}
+	}
+	client := config.Client
+	return newJWKS(config, func(ctx context.Context) ([]byte, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Accept", "application/json")
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("fetching %s: %s", url, resp.Status)
+		}
+		return io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	})
+}
+
+// NewJWKSFromFile returns a JWKS that reads its keys from a JSON file. The
+// file is read immediately so configuration errors surface at startup.
+func NewJWKSFromFile(path string, config JWKSConfig) (*JWKS, error) {
+	s := newJWKS(config, func(context.Context) ([]byte, error) {
+		return os.ReadFile(path)
+	})
+	if err := s.Refresh(context.Background()); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// ParseJWKS returns a JWKS holding the keys of a JSON Web Key Set document
+func ParseJWKS(data []byte) (*JWKS, error) {
+	keys, err := parseJWKS(data)
+	if err != nil {
+		return nil, err
+	}
+	return &JWKS{keys: keys, now: time.Now}, nil
+}
+
+func newJWKS(config JWKSConfig, load func(ctx context.Context) ([]byte, error)) *JWKS {
+	if config.RefreshInterval <= 0 {
+		config.RefreshInterval = time.Hour
+	}
+	if config.MinRefreshInterval <= 0 {
+		config.MinRefreshInterval = time.Minute
+	}
+	return &JWKS{config: config, load: load, now: time.Now}
+}
+
+// Refresh loads the keys again
+func (s *JWKS) Refresh(ctx context.Context) error {
+	return s.refresh(ctx, 0)
+}
+
+// refresh loads the keys unless they were loaded less than minAge ago.
+// Concurrent calls share one load, which is not canceled when the caller
+// that started it gives up. On failure the previous keys are kept.
+func (s *JWKS) refresh(ctx context.Context, minAge time.Duration) error {
+	if s.load == nil {
+		return nil
+	}
+	result := s.group.DoChan("refresh", func() (interface{}, error) {
+		s.mu.Lock()
+		if s.keys != nil && s.now().Sub(s.loadedAt) < minAge {
+			s.mu.Unlock()
+			return nil, nil
+		}
+		s.loadedAt = s.now()
+		s.mu.Unlock()
+
+		data, err := s.load(context.WithoutCancel(ctx))
+		if err != nil {
+			return nil, fmt.Errorf("error loading JWKS: %w", err)
+		}
+		keys, err := parseJWKS(data)
+		if err != nil {
+			return nil, err
+		}
+		s.mu.Lock()
+		s.keys = keys
+		s.mu.Unlock()
+		return nil, nil
+	})
+	select {
+	case r := <-result:
+		return r.Err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Key returns the key with the given ID that can verify alg. Tokens
+// without a kid are accepted if exactly one key matches alg.
+func (s *JWKS) Key(ctx context.Context, kid, alg string) (interface{}, error) {
+	s.mu.RLock()
+	loaded, age := s.keys != nil, s.now().Sub(s.loadedAt)
+	s.mu.RUnlock()
+
+	if s.load != nil {
+		switch {
+		case !loaded:
+			if err := s.refresh(ctx, s.config.MinRefreshInterval); err != nil {
+				return nil, err
+			}
+		case age >= s.config.RefreshInterval:
+			// Serve the cached keys while they are reloaded
+			go func() {
+				if err := s.refresh(context.Background(), s.config.RefreshInterval); err != nil {
+					log.Printf("JWKS: %v; using cached keys", err)
+				}
+			}()
+		}
+	}
+
+	key, err := s.find(kid, alg)
+	if err != nil && s.load != nil {
+		// The signing key may have been rotated since the last load
+		if s.refresh(ctx, s.config.MinRefreshInterval) == nil {
+			key, err = s.find(kid, alg)
+		}
+	}
+	return key, err
+}
+
+// find looks up a key among the loaded keys
+func (s *JWKS) find(kid, alg string) (interface{}, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var match interface{}
+	count := 0
+	for _, k := range s.keys {
+		if (kid != "" && k.kid != kid) || (k.alg != "" && k.alg != alg) {
+			continue
+		}
+		if keyAlg, _ := algorithmForKey(k.key); keyAlg != alg {
+			continue
+		}
+		match = k.key
+		count++
+	}
+	switch {
+	case count == 0 && kid != "":
+		return nil, fmt.Errorf("unknown key %q", kid)
+	case count == 0:
+		return nil, fmt.Errorf("no key for %s", alg)
+	case count > 1 && kid == "":
+		return nil, errors.New("token has no kid and several keys match")
+	}
+	return match, nil
+}
+
+// jwkJSON is the JSON form of a key in a key set
+type jwkJSON struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+	K   string `json:"k"`
+}
+
+// parseJWKS parses a key set. Encryption keys and unsupported key types
+// are skipped.
+func parseJWKS(data []byte) ([]jwk, error) {
+	var set struct {
+		Keys []jwkJSON `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("error parsing JWKS: %w", err)
+	}
+	keys := make([]jwk, 0, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.publicKey()
+		if err != nil {
+			return nil, fmt.Errorf("error parsing JWKS key %q: %w", k.Kid, err)
+		}
+		if key != nil {
+			keys = append(keys, jwk{kid: k.Kid, alg: k.Alg, key: key})
+		}
+	}
+	return keys, nil
+}
+
+// publicKey decodes the key material, returning nil for unsupported types
+func (k jwkJSON) publicKey() (interface{}, error) {
+	decode := base64.RawURLEncoding.DecodeString
+	switch k.Kty {
+	case "RSA":
+		n, err := decode(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decode(k.E)
+		if err != nil {
+			return nil, err
+		}
+		exponent := new(big.Int).SetBytes(e)
+		if len(n) == 0 || !exponent.IsInt64() || exponent.Int64() < 2 || exponent.Int64() > 1<<31-1 {
+			return nil, errors.New("invalid RSA key")
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exponent.Int64())}, nil
+	case "EC":
+		if k.Crv != "P-256" {
+			return nil, nil
+		}
+		x, err := decode(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decode(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		if len(x) != 32 || len(y) != 32 {
+			return nil, errors.New("invalid P-256 key")
+		}
+		// Parse the uncompressed point to have it checked against the curve
+		if _, err := ecdh.P256().NewPublicKey(append(append([]byte{4}, x...), y...)); err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, nil
+		}
+		x, err := decode(k.X)
+		if err != nil {
+			return nil, err
+		}
+		if len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid Ed25519 key")
+		}
+		return ed25519.PublicKey(x), nil
+	case "oct":
+		secret, err := decode(k.K)
+		if err != nil {
+			return nil, err
+		}
+		return secret, nil
+	}
+	return nil, nil
+}
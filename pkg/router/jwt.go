@@ -0,0 +1,504 @@
+package router
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// JWT signing algorithms supported by JWT and SignJWT
+const (
+	HS256 = "HS256"
+	RS256 = "RS256"
+	ES256 = "ES256"
+	EdDSA = "EdDSA"
+)
+
+// ClaimsKey is the Context key under which JWT stores the verified claims
+const ClaimsKey = "Claims"
+
+// Claims are the claims of a verified JWT
+type Claims map[string]interface{}
+
+// String returns a string claim, or "" if it is missing or not a string
+func (c Claims) String(name string) string {
+	s, _ := c[name].(string)
+	return s
+}
+
+// Strings returns a claim that is a string or a list of strings
+func (c Claims) Strings(name string) []string {
+	switch v := c[name].(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		list := make([]string, 0, len(v))
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				list = append(list, s)
+			}
+		}
+		return list
+	case []string:
+		return v
+	}
+	return nil
+}
+
+// Bool returns a boolean claim, or false if it is missing
+func (c Claims) Bool(name string) bool {
+	b, _ := c[name].(bool)
+	return b
+}
+
+// Float64 returns a numeric claim and whether it was present
+func (c Claims) Float64(name string) (float64, bool) {
+	switch v := c[name].(type) {
+	case float64:
+		return v, true
+	case json.Number:
+		f, err := v.Float64()
+		return f, err == nil
+	case int64:
+		return float64(v), true
+	case int:
+		return float64(v), true
+	}
+	return 0, false
+}
+
+// Time returns a NumericDate claim such as exp, or the zero time
+func (c Claims) Time(name string) time.Time {
+	f, ok := c.Float64(name)
+	if !ok {
+		return time.Time{}
+	}
+	sec := int64(f)
+	return time.Unix(sec, int64((f-float64(sec))*1e9))
+}
+
+// Subject returns the sub claim
+func (c Claims) Subject() string { return c.String("sub") }
+
+// Issuer returns the iss claim
+func (c Claims) Issuer() string { return c.String("iss") }
+
+// Audience returns the aud claim
+func (c Claims) Audience() []string { return c.Strings("aud") }
+
+// ID returns the jti claim
+func (c Claims) ID() string { return c.String("jti") }
+
+// ExpiresAt returns the exp claim, or the zero time
+func (c Claims) ExpiresAt() time.Time { return c.Time("exp") }
+
+// NotBefore returns the nbf claim, or the zero time
+func (c Claims) NotBefore() time.Time { return c.Time("nbf") }
+
+// IssuedAt returns the iat claim, or the zero time
+func (c Claims) IssuedAt() time.Time { return c.Time("iat") }
+
+// Decode copies the claims into a struct with json tags
+func (c Claims) Decode(v interface{}) error {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// Claims returns the claims verified by the JWT middleware, or nil
+func (c *Context) Claims() Claims {
+	claims, _ := c.Get(ClaimsKey)
+	cl, _ := claims.(Claims)
+	return cl
+}
+
+// KeySet resolves the key that verifies a token from its kid and alg
+// headers. JWKS implements it.
+type KeySet interface {
+	Key(ctx context.Context, kid, alg string) (interface{}, error)
+}
+
+// TokenExtractor reads a credential from the request, returning "" if it
+// is absent
+type TokenExtractor func(*Context) string
+
+// TokenFromHeader reads a token from a header, stripping scheme if given.
+// TokenFromHeader("Authorization", "Bearer") reads bearer tokens.
+func TokenFromHeader(name, scheme string) TokenExtractor {
+	return func(c *Context) string {
+		value := c.GetHeader(name)
+		if scheme == "" {
+			return value
+		}
+		if len(value) > len(scheme) && strings.EqualFold(value[:len(scheme)], scheme) && value[len(scheme)] == ' ' {
+			return strings.TrimSpace(value[len(scheme)+1:])
+		}
+		return ""
+	}
+}
+
+// TokenFromQuery reads a token from a query parameter
+func TokenFromQuery(name string) TokenExtractor {
+	return func(c *Context) string {
+		return c.Query(name)
+	}
+}
+
+// TokenFromCookie reads a token from a cookie
+func TokenFromCookie(name string) TokenExtractor {
+	return func(c *Context) string {
+		cookie, err := c.Request.Cookie(name)
+		if err != nil {
+			return ""
+		}
+		return cookie.Value
+	}
+}
+
+// JWTConfig configures JWT
+type JWTConfig struct {
+	// Key verifies every token: a []byte secret for HS256, an
+	// *rsa.PublicKey, an *ecdsa.PublicKey or an ed25519.PublicKey
+	Key interface{}
+	// Keys resolves the key per token, for example a JWKS. It is used
+	// when Key is nil.
+	Keys KeySet
+	// Algorithms lists the accepted algorithms. By default the algorithm
+	// matching Key is accepted, or all supported ones when Keys is used.
+	Algorithms []string
+	// Issuer, if set, must equal the iss claim
+	Issuer string
+	// Audience, if set, must contain one of the aud claim values
+	Audience []string
+	// Leeway is the clock skew allowed when checking exp, nbf and iat
+	Leeway time.Duration
+	// RequireExpiry rejects tokens without an exp claim
+	RequireExpiry bool
+	// Realm is sent in the WWW-Authenticate challenge
+	Realm string
+	// TokenLookup reads the token, default the Authorization bearer token
+	TokenLookup TokenExtractor
+	// Optional passes requests without a token on without claims.
+	// Requests with an invalid token are still rejected.
+	Optional bool
+	// Skip exempts requests from authentication when it returns true
+	Skip func(*Context) bool
+}
+
+// JWT is a middleware that authenticates requests with a JSON Web Token.
+// Verified claims are available with c.Claims(). Missing or invalid tokens
+// are rejected with 401 and a Bearer WWW-Authenticate challenge.
+func JWT(config JWTConfig) MiddlewareFunc {
+	if config.Key == nil && config.Keys == nil {
+		panic("router: JWT requires a Key or Keys")
+	}
+	if secret, ok := config.Key.([]byte); ok && len(secret) == 0 {
+		panic("router: JWT HS256 secret is empty")
+	}
+	if config.TokenLookup == nil {
+		config.TokenLookup = TokenFromHeader("Authorization", "Bearer")
+	}
+	if config.Algorithms == nil {
+		if config.Key != nil {
+			alg, ok := algorithmForKey(config.Key)
+			if !ok {
+				panic(fmt.Sprintf("router: JWT key type %T is not supported", config.Key))
+			}
+			config.Algorithms = []string{alg}
+		} else {
+			config.Algorithms = []string{HS256, RS256, ES256, EdDSA}
+		}
+	}
+	verifier := &jwtVerifier{config: config, now: time.Now}
+
+	return func(next HandlerFunc) HandlerFunc {
+		return func(c *Context) {
+			if config.Skip != nil && config.Skip(c) {
+				next(c)
+				return
+			}
+			token := config.TokenLookup(c)
+			if token == "" {
+				if config.Optional {
+					next(c)
+					return
+				}
+				c.SetHeader("WWW-Authenticate", bearerChallenge(config.Realm, "", ""))
+				c.Error(NewHTTPError(http.StatusUnauthorized, "missing token"))
+				return
+			}
+
+			claims, err := verifier.verify(c.Request.Context(), token)
+			if err != nil {
+				c.SetHeader("WWW-Authenticate", bearerChallenge(config.Realm, "invalid_token", err.Error()))
+				c.Error(NewHTTPError(http.StatusUnauthorized, "invalid token: "+err.Error()))
+				return
+			}
+			c.Set(ClaimsKey, claims)
+			next(c)
+		}
+	}
+}
+
+// bearerChallenge formats a Bearer WWW-Authenticate header value
+func bearerChallenge(realm, code, description string) string {
+	var params []string
+	if realm != "" {
+		params = append(params, fmt.Sprintf("realm=%q", realm))
+	}
+	if code != "" {
+		params = append(params, fmt.Sprintf("error=%q", code))
+	}
+	if description != "" {
+		params = append(params, fmt.Sprintf("error_description=%q", description))
+	}
+	if len(params) == 0 {
+		return "Bearer"
+	}
+	return "Bearer " + strings.Join(params, ", ")
+}
+
+// jwtHeader is the JOSE header of a token
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid,omitempty"`
+	Typ string `json:"typ,omitempty"`
+}
+
+// jwtVerifier checks signatures and registered claims
+type jwtVerifier struct {
+	config JWTConfig
+	now    func() time.Time
+}
+
+// verify parses token, checks its signature and claims and returns them
+func (v *jwtVerifier) verify(ctx context.Context, token string) (Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed token")
+	}
+	headerJSON, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, errors.New("malformed header")
+	}
+	var header jwtHeader
+	if err := json.Unmarshal(headerJSON, &header); err != nil {
+		return nil, errors.New("malformed header")
+	}
+	if !containsExact(v.config.Algorithms, header.Alg) {
+		return nil, fmt.Errorf("algorithm %q not allowed", header.Alg)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errors.New("malformed signature")
+	}
+
+	key := v.config.Key
+	if key == nil {
+		if key, err = v.config.Keys.Key(ctx, header.Kid, header.Alg); err != nil {
+			return nil, err
+		}
+	}
+	if err := verifySignature(header.Alg, key, []byte(parts[0]+"."+parts[1]), signature); err != nil {
+		return nil, err
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, errors.New("malformed payload")
+	}
+	var claims Claims
+	if err := json.Unmarshal(payload, &claims); err != nil || claims == nil {
+		return nil, errors.New("malformed payload")
+	}
+	if err := v.checkClaims(claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// checkClaims validates the time, issuer and audience claims
+func (v *jwtVerifier) checkClaims(claims Claims) error {
+	now := v.now()
+	leeway := v.config.Leeway
+	if _, ok := claims.Float64("exp"); ok {
+		if !now.Before(claims.ExpiresAt().Add(leeway)) {
+			return errors.New("token has expired")
+		}
+	} else if _, present := claims["exp"]; present {
+		return errors.New("invalid exp claim")
+	} else if v.config.RequireExpiry {
+		return errors.New("token has no expiry")
+	}
+	if _, ok := claims.Float64("nbf"); ok && now.Add(leeway).Before(claims.NotBefore()) {
+		return errors.New("token is not valid yet")
+	}
+	if _, ok := claims.Float64("iat"); ok && now.Add(leeway).Before(claims.IssuedAt()) {
+		return errors.New("token was issued in the future")
+	}
+	if v.config.Issuer != "" && claims.Issuer() != v.config.Issuer {
+		return errors.New("invalid issuer")
+	}
+	if len(v.config.Audience) > 0 {
+		matched := false
+		for _, aud := range claims.Audience() {
+			if containsExact(v.config.Audience, aud) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return errors.New("invalid audience")
+		}
+	}
+	return nil
+}
+
+// containsExact reports whether list contains s
+func containsExact(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// algorithmForKey returns the algorithm a verification or signing key is
+// used with
+func algorithmForKey(key interface{}) (string, bool) {
+	switch k := key.(type) {
+	case []byte:
+		return HS256, true
+	case *rsa.PublicKey, *rsa.PrivateKey:
+		return RS256, true
+	case *ecdsa.PublicKey:
+		return ES256, k.Curve == elliptic.P256()
+	case *ecdsa.PrivateKey:
+		return ES256, k.Curve == elliptic.P256()
+	case ed25519.PublicKey, ed25519.PrivateKey:
+		return EdDSA, true
+	}
+	return "", false
+}
+
+// errSignature is returned for signatures that do not verify
+var errSignature = errors.New("invalid signature")
+
+// verifySignature checks signature over signed with key. The key type must
+// match the algorithm, so a public key can never be used as an HMAC secret.
+func verifySignature(alg string, key interface{}, signed, signature []byte) error {
+	if keyAlg, ok := algorithmForKey(key); !ok || keyAlg != alg {
+		return fmt.Errorf("key type %T cannot verify %s", key, alg)
+	}
+	switch alg {
+	case HS256:
+		if len(key.([]byte)) == 0 {
+			return errors.New("empty HS256 secret")
+		}
+		mac := hmac.New(sha256.New, key.([]byte))
+		mac.Write(signed)
+		if !hmac.Equal(signature, mac.Sum(nil)) {
+			return errSignature
+		}
+	case RS256:
+		pub, ok := key.(*rsa.PublicKey)
+		if !ok {
+			pub = &key.(*rsa.PrivateKey).PublicKey
+		}
+		digest := sha256.Sum256(signed)
+		if rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], signature) != nil {
+			return errSignature
+		}
+	case ES256:
+		pub, ok := key.(*ecdsa.PublicKey)
+		if !ok {
+			pub = &key.(*ecdsa.PrivateKey).PublicKey
+		}
+		if len(signature) != 64 {
+			return errSignature
+		}
+		digest := sha256.Sum256(signed)
+		r := new(big.Int).SetBytes(signature[:32])
+		s := new(big.Int).SetBytes(signature[32:])
+		if !ecdsa.Verify(pub, digest[:], r, s) {
+			return errSignature
+		}
+	case EdDSA:
+		pub, ok := key.(ed25519.PublicKey)
+		if !ok {
+			pub = key.(ed25519.PrivateKey).Public().(ed25519.PublicKey)
+		}
+		if !ed25519.Verify(pub, signed, signature) {
+			return errSignature
+		}
+	default:
+		return fmt.Errorf("algorithm %q not supported", alg)
+	}
+	return nil
+}
+
+// SignJWT encodes claims as a JWT signed with key: a []byte secret for
+// HS256, an *rsa.PrivateKey, an *ecdsa.PrivateKey on P-256 or an
+// ed25519.PrivateKey. kid is put in the header if it is not empty.
+func SignJWT(key interface{}, kid string, claims interface{}) (string, error) {
+	alg, ok := algorithmForKey(key)
+	if !ok {
+		return "", fmt.Errorf("key type %T is not supported", key)
+	}
+	headerJSON, err := json.Marshal(jwtHeader{Alg: alg, Kid: kid, Typ: "JWT"})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	signed := base64.RawURLEncoding.EncodeToString(headerJSON) + "." + base64.RawURLEncoding.EncodeToString(payload)
+
+	var signature []byte
+	switch k := key.(type) {
+	case []byte:
+		if len(k) == 0 {
+			return "", errors.New("empty HS256 secret")
+		}
+		mac := hmac.New(sha256.New, k)
+		mac.Write([]byte(signed))
+		signature = mac.Sum(nil)
+	case *rsa.PrivateKey:
+		digest := sha256.Sum256([]byte(signed))
+		signature, err = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, digest[:])
+	case *ecdsa.PrivateKey:
+		digest := sha256.Sum256([]byte(signed))
+		var r, s *big.Int
+		if r, s, err = ecdsa.Sign(rand.Reader, k, digest[:]); err == nil {
+			signature = make([]byte, 64)
+			r.FillBytes(signature[:32])
+			s.FillBytes(signature[32:])
+		}
+	case ed25519.PrivateKey:
+		signature = ed25519.Sign(k, []byte(signed))
+	default:
+		return "", fmt.Errorf("key type %T cannot sign", key)
+	}
+	if err != nil {
+		return "", err
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
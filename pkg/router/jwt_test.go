@@ -0,0 +1,330 @@
+package router
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sys-apps-go/gorouter/pkg/router/routertest"
+)
+
+// testKeys are signing keys for every supported algorithm
+type testKeys struct {
+	secret []byte
+	rsa    *rsa.PrivateKey
+	ec     *ecdsa.PrivateKey
+	ed     ed25519.PrivateKey
+}
+
+var (
+	testKeysOnce sync.Once
+	testKeysVal  testKeys
+)
+
+// newTestKeys generates the keys once per test binary
+func newTestKeys(t *testing.T) testKeys {
+	t.Helper()
+	testKeysOnce.Do(func() {
+		rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, edKey, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		testKeysVal = testKeys{secret: []byte("0123456789abcdef0123456789abcdef"), rsa: rsaKey, ec: ecKey, ed: edKey}
+	})
+	return testKeysVal
+}
+
+// signTestJWT signs claims or fails the test
+func signTestJWT(t *testing.T, key interface{}, kid string, claims map[string]interface{}) string {
+	t.Helper()
+	token, err := SignJWT(key, kid, claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+// jwkOf returns the public JWK of a private key
+func jwkOf(kid string, key interface{}) map[string]string {
+	encode := base64.RawURLEncoding.EncodeToString
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		return map[string]string{"kty": "RSA", "kid": kid, "alg": RS256, "n": encode(k.N.Bytes()), "e": encode(big.NewInt(int64(k.E)).Bytes())}
+	case *ecdsa.PrivateKey:
+		x, y := make([]byte, 32), make([]byte, 32)
+		k.X.FillBytes(x)
+		k.Y.FillBytes(y)
+		return map[string]string{"kty": "EC", "kid": kid, "crv": "P-256", "x": encode(x), "y": encode(y)}
+	case ed25519.PrivateKey:
+		return map[string]string{"kty": "OKP", "kid": kid, "crv": "Ed25519", "x": encode(k.Public().(ed25519.PublicKey))}
+	}
+	panic("unsupported key")
+}
+
+// jwksDocument encodes a key set
+func jwksDocument(t *testing.T, keys ...map[string]string) []byte {
+	t.Helper()
+	data, err := json.Marshal(map[string]interface{}{"keys": keys})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// jwtRouter returns a router whose /me route requires a token and echoes
+// the subject
+func jwtRouter(config JWTConfig) *Router {
+	r := NewRouter()
+	r.Use(JWT(config))
+	r.GET("/me", func(c *Context) {
+		c.String(http.StatusOK, "%s", c.Claims().Subject())
+	})
+	return r
+}
+
+func TestJWTAlgorithms(t *testing.T) {
+	keys := newTestKeys(t)
+	claims := map[string]interface{}{"sub": "ann", "exp": time.Now().Add(time.Hour).Unix()}
+	tests := []struct {
+		name   string
+		sign   interface{}
+		verify interface{}
+	}{
+		{HS256, keys.secret, keys.secret},
+		{RS256, keys.rsa, &keys.rsa.PublicKey},
+		{ES256, keys.ec, &keys.ec.PublicKey},
+		{EdDSA, keys.ed, keys.ed.Public()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rt := routertest.New(t, jwtRouter(JWTConfig{Key: tt.verify}))
+			token := signTestJWT(t, tt.sign, "", claims)
+			rt.GET("/me").Header("Authorization", "Bearer "+token).Expect().
+				Status(http.StatusOK).
+				BodyEquals("ann")
+
+			// Flip a bit of the signature
+			parts := strings.Split(token, ".")
+			signature, _ := base64.RawURLEncoding.DecodeString(parts[2])
+			signature[0] ^= 1
+			tampered := parts[0] + "." + parts[1] + "." + base64.RawURLEncoding.EncodeToString(signature)
+			rt.GET("/me").Header("Authorization", "Bearer "+tampered).Expect().
+				Status(http.StatusUnauthorized).
+				JSONPath("$.error", "invalid token: invalid signature")
+		})
+	}
+}
+
+func TestJWTRejects(t *testing.T) {
+	keys := newTestKeys(t)
+	now := time.Now()
+	hour := time.Hour
+	valid := func(extra map[string]interface{}) map[string]interface{} {
+		claims := map[string]interface{}{"sub": "ann", "iss": "https://issuer.test", "aud": []string{"api"}, "exp": now.Add(hour).Unix()}
+		for k, v := range extra {
+			claims[k] = v
+		}
+		return claims
+	}
+	r := jwtRouter(JWTConfig{
+		Key:           keys.secret,
+		Issuer:        "https://issuer.test",
+		Audience:      []string{"api"},
+		Leeway:        time.Minute,
+		RequireExpiry: true,
+		Realm:         "api",
+	})
+
+	tests := []struct {
+		name          string
+		authorization string
+		status        int
+		error         string
+	}{
+		{"valid", "Bearer " + signTestJWT(t, keys.secret, "", valid(nil)), http.StatusOK, ""},
+		{"missing token", "", http.StatusUnauthorized, "missing token"},
+		{"other scheme", "Basic YW5uOnB3", http.StatusUnauthorized, "missing token"},
+		{"malformed", "Bearer abc.def", http.StatusUnauthorized, "invalid token: malformed token"},
+		{"expired", "Bearer " + signTestJWT(t, keys.secret, "", valid(map[string]interface{}{"exp": now.Add(-2 * time.Minute).Unix()})), http.StatusUnauthorized, "invalid token: token has expired"},
+		{"expired within leeway", "Bearer " + signTestJWT(t, keys.secret, "", valid(map[string]interface{}{"exp": now.Add(-30 * time.Second).Unix()})), http.StatusOK, ""},
+		{"no expiry", "Bearer " + signTestJWT(t, keys.secret, "", map[string]interface{}{"sub": "ann", "iss": "https://issuer.test", "aud": "api"}), http.StatusUnauthorized, "invalid token: token has no expiry"},
+		{"not yet valid", "Bearer " + signTestJWT(t, keys.secret, "", valid(map[string]interface{}{"nbf": now.Add(10 * time.Minute).Unix()})), http.StatusUnauthorized, "invalid token: token is not valid yet"},
+		{"issuer", "Bearer " + signTestJWT(t, keys.secret, "", valid(map[string]interface{}{"iss": "https://evil.test"})), http.StatusUnauthorized, "invalid token: invalid issuer"},
+		{"audience", "Bearer " + signTestJWT(t, keys.secret, "", valid(map[string]interface{}{"aud": "other"})), http.StatusUnauthorized, "invalid token: invalid audience"},
+		{"algorithm", "Bearer " + signTestJWT(t, keys.rsa, "", valid(nil)), http.StatusUnauthorized, `invalid token: algorithm "RS256" not allowed`},
+		{"wrong secret", "Bearer " + signTestJWT(t, []byte("another secret"), "", valid(nil)), http.StatusUnauthorized, "invalid token: invalid signature"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := routertest.New(t, r).GET("/me")
+			if tt.authorization != "" {
+				req.Header("Authorization", tt.authorization)
+			}
+			resp := req.Expect().Status(tt.status)
+			if tt.error != "" {
+				resp.JSONPath("$.error", tt.error).HeaderContains("WWW-Authenticate", `Bearer realm="api"`)
+			}
+		})
+	}
+}
+
+func TestJWTAlgorithmConfusion(t *testing.T) {
+	keys := newTestKeys(t)
+	// An HS256 token signed with the public key as secret must not verify
+	// against that public key
+	publicAsSecret := keys.rsa.PublicKey.N.Bytes()
+	token := signTestJWT(t, publicAsSecret, "", map[string]interface{}{"sub": "mallory"})
+	r := jwtRouter(JWTConfig{Key: &keys.rsa.PublicKey, Algorithms: []string{RS256, HS256}})
+	routertest.New(t, r).GET("/me").Header("Authorization", "Bearer "+token).Expect().
+		Status(http.StatusUnauthorized).
+		JSONPath("$.error", "invalid token: key type *rsa.PublicKey cannot verify HS256")
+}
+
+func TestJWTTokenLookupAndOptional(t *testing.T) {
+	keys := newTestKeys(t)
+	token := signTestJWT(t, keys.secret, "", map[string]interface{}{"sub": "ann"})
+	r := jwtRouter(JWTConfig{Key: keys.secret, TokenLookup: TokenFromCookie("token"), Optional: true})
+
+	rt := routertest.New(t, r)
+	rt.GET("/me").Expect().Status(http.StatusOK).BodyEquals("")
+	rt.GET("/me").Cookie(&http.Cookie{Name: "token", Value: token}).Expect().Status(http.StatusOK).BodyEquals("ann")
+	rt.GET("/me").Cookie(&http.Cookie{Name: "token", Value: "garbage"}).Expect().Status(http.StatusUnauthorized)
+}
+
+func TestJWKS(t *testing.T) {
+	keys := newTestKeys(t)
+	set, err := ParseJWKS(jwksDocument(t, jwkOf("rsa", keys.rsa), jwkOf("ec", keys.ec), jwkOf("ed", keys.ed)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := jwtRouter(JWTConfig{Keys: set})
+
+	tests := []struct {
+		name   string
+		token  string
+		status int
+	}{
+		{"rsa", signTestJWT(t, keys.rsa, "rsa", map[string]interface{}{"sub": "ann"}), http.StatusOK},
+		{"ec", signTestJWT(t, keys.ec, "ec", map[string]interface{}{"sub": "ann"}), http.StatusOK},
+		{"ed25519", signTestJWT(t, keys.ed, "ed", map[string]interface{}{"sub": "ann"}), http.StatusOK},
+		{"without kid", signTestJWT(t, keys.ed, "", map[string]interface{}{"sub": "ann"}), http.StatusOK},
+		{"kid of another algorithm", signTestJWT(t, keys.ec, "rsa", map[string]interface{}{"sub": "ann"}), http.StatusUnauthorized},
+		{"unknown kid", signTestJWT(t, keys.rsa, "old", map[string]interface{}{"sub": "ann"}), http.StatusUnauthorized},
+		{"hmac", signTestJWT(t, keys.secret, "rsa", map[string]interface{}{"sub": "ann"}), http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			routertest.New(t, r).GET("/me").Header("Authorization", "Bearer "+tt.token).Expect().Status(tt.status)
+		})
+	}
+}
+
+func TestJWKSRotation(t *testing.T) {
+	keys := newTestKeys(t)
+	var (
+		mu       sync.Mutex
+		document = jwksDocument(t, jwkOf("one", keys.rsa))
+		loads    atomic.Int32
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		loads.Add(1)
+		mu.Lock()
+		defer mu.Unlock()
+		w.Write(document)
+	}))
+	defer server.Close()
+
+	set := NewJWKSFromURL(server.URL, JWKSConfig{MinRefreshInterval: time.Hour})
+	clock := time.Now()
+	set.now = func() time.Time {
+		mu.Lock()
+		defer mu.Unlock()
+		return clock
+	}
+	ctx := context.Background()
+
+	// Keys are loaded on first use, once for concurrent callers
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := set.Key(ctx, "one", RS256); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := loads.Load(); n != 1 {
+		t.Fatalf("%d loads for concurrent first use, want 1", n)
+	}
+
+	// A new kid triggers an early reload, but only once per
+	// MinRefreshInterval
+	mu.Lock()
+	document = jwksDocument(t, jwkOf("one", keys.rsa), jwkOf("two", keys.ec))
+	clock = clock.Add(2 * time.Hour)
+	mu.Unlock()
+	if _, err := set.Key(ctx, "two", ES256); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := set.Key(ctx, "three", ES256); err == nil {
+		t.Fatal("unknown kid accepted")
+	}
+	if n := loads.Load(); n != 2 {
+		t.Fatalf("%d loads after rotation, want 2", n)
+	}
+
+	// Failed reloads keep the cached keys
+	server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		http.Error(w, "down", http.StatusInternalServerError)
+	})
+	if err := set.Refresh(ctx); err == nil {
+		t.Fatal("Refresh succeeded against a failing server")
+	}
+	if _, err := set.Key(ctx, "two", ES256); err != nil {
+		t.Fatalf("cached key lost after failed refresh: %v", err)
+	}
+}
+
+func TestJWTConfigPanics(t *testing.T) {
+	tests := []struct {
+		name   string
+		config JWTConfig
+	}{
+		{"no key", JWTConfig{}},
+		{"empty secret", JWTConfig{Key: []byte{}}},
+		{"unsupported key", JWTConfig{Key: "secret"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Fatal("JWT did not panic")
+				}
+			}()
+			JWT(tt.config)
+		})
+	}
+}
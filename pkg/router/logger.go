@@ -0,0 +1,171 @@
+package router
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"os"
+	"strings"
+	"time"
+)
+
+// LogFormat selects how LoggerWithConfig writes access log entries
+type LogFormat int
+
+const (
+	// LogFormatSlog sends entries to the configured slog.Logger
+	LogFormatSlog LogFormat = iota
+	// LogFormatJSON writes one JSON object per request to Output
+	LogFormatJSON
+	// LogFormatText writes slog key=value lines to Output
+	LogFormatText
+	// LogFormatCommon writes the Apache Common Log Format to Output
+	LogFormatCommon
+	// LogFormatCombined writes the Apache Combined Log Format to Output
+	LogFormatCombined
+)
+
+// LoggerConfig configures LoggerWithConfig
+type LoggerConfig struct {
+	Format LogFormat
+	// Logger receives entries in LogFormatSlog, default slog.Default()
+	Logger *slog.Logger
+	// Output receives entries in the other formats, default os.Stdout
+	Output io.Writer
+	// SkipPaths lists request paths that are not logged. A trailing *
+	// matches any path with that prefix.
+	SkipPaths []string
+	// Skip leaves requests out of the log when it returns true
+	Skip func(*Context) bool
+	// SampleRates maps route patterns such as /api/users/:id to the
+	// fraction of successful requests that are logged. Requests with a
+	// status of 400 or more are always logged.
+	SampleRates map[string]float64
+}
+
+// Logger is a middleware that logs every request with slog.Default()
+func Logger() MiddlewareFunc {
+	return LoggerWithConfig(LoggerConfig{})
+}
+
+// LoggerWithConfig is a middleware that writes an access log entry for
+// every request with its method, path, route pattern, status, response
+// size, latency, client IP, request ID and user agent
+func LoggerWithConfig(config LoggerConfig) MiddlewareFunc {
+	if config.Output == nil {
+		config.Output = os.Stdout
+	}
+	logger := config.Logger
+	switch config.Format {
+	case LogFormatJSON:
+		logger = slog.New(slog.NewJSONHandler(config.Output, nil))
+	case LogFormatText:
+		logger = slog.New(slog.NewTextHandler(config.Output, nil))
+	}
+
+	skip := make(map[string]bool)
+	var skipPrefixes []string
+	for _, path := range config.SkipPaths {
+		if prefix, ok := strings.CutSuffix(path, "*"); ok {
+			skipPrefixes = append(skipPrefixes, prefix)
+		} else {
+			skip[path] = true
+		}
+	}
+
+	return func(next HandlerFunc) HandlerFunc {
+		return func(c *Context) {
+			path := c.Request.URL.Path
+			if skip[path] || hasAnyPrefix(path, skipPrefixes) || (config.Skip != nil && config.Skip(c)) {
+				next(c)
+				return
+			}
+
+			start := time.Now()
+			next(c)
+			latency := time.Since(start)
+
+			status := c.ResponseStatus()
+			if rate, ok := config.SampleRates[c.FullPath()]; ok && status < 400 && rand.Float64() >= rate {
+				return
+			}
+
+			switch config.Format {
+			case LogFormatCommon, LogFormatCombined:
+				fmt.Fprintln(config.Output, apacheLogLine(c, start, config.Format == LogFormatCombined))
+			default:
+				l := logger
+				if l == nil {
+					l = slog.Default()
+				}
+				l.LogAttrs(c.Request.Context(), logLevel(status), "request",
+					slog.String("method", c.Request.Method),
+					slog.String("path", path),
+					slog.String("route", c.FullPath()),
+					slog.Int("status", status),
+					slog.Int("bytes", c.ResponseSize()),
+					slog.Duration("latency", latency),
+					slog.String("client_ip", c.ClientIP()),
+					slog.String("request_id", c.RequestID()),
+					slog.String("user_agent", c.Request.UserAgent()),
+				)
+			}
+		}
+	}
+}
+
+// logLevel returns Error for 5xx, Warn for 4xx and Info otherwise
+func logLevel(status int) slog.Level {
+	switch {
+	case status >= 500:
+		return slog.LevelError
+	case status >= 400:
+		return slog.LevelWarn
+	}
+	return slog.LevelInfo
+}
+
+// apacheLogLine formats the request in Common or Combined Log Format
+func apacheLogLine(c *Context, start time.Time, combined bool) string {
+	user := "-"
+	if c.Request.URL.User != nil && c.Request.URL.User.Username() != "" {
+		user = c.Request.URL.User.Username()
+	} else if name, _, ok := c.Request.BasicAuth(); ok && name != "" {
+		user = name
+	}
+	size := "-"
+	if n := c.ResponseSize(); n > 0 {
+		size = fmt.Sprint(n)
+	}
+	line := fmt.Sprintf("%s - %s [%s] %q %d %s",
+		c.ClientIP(),
+		user,
+		start.Format("02/Jan/2006:15:04:05 -0700"),
+		c.Request.Method+" "+c.Request.RequestURI+" "+c.Request.Proto,
+		c.ResponseStatus(),
+		size,
+	)
+	if combined {
+		line += fmt.Sprintf(" %q %q", dashIfEmpty(c.Request.Referer()), dashIfEmpty(c.Request.UserAgent()))
+	}
+	return line
+}
+
+// dashIfEmpty returns "-" for empty log fields
+func dashIfEmpty(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// hasAnyPrefix reports whether s starts with one of the prefixes
+func hasAnyPrefix(s string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(s, prefix) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,209 @@
+package router
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// loggerRouter returns a router that logs with config; /status/:code
+// answers with the status code in its path
+func loggerRouter(config LoggerConfig) *Router {
+	r := NewRouter()
+	r.Use(RequestIDWithConfig(RequestIDConfig{Generator: func() string { return "req-1" }}), LoggerWithConfig(config))
+	r.GET("/users/:id", func(c *Context) {
+		c.String(http.StatusOK, "hello")
+	})
+	r.GET("/status/:code", func(c *Context) {
+		switch c.Param("code") {
+		case "204":
+			c.Status(http.StatusNoContent)
+		case "404":
+			c.String(http.StatusNotFound, "gone")
+		case "500":
+			c.String(http.StatusInternalServerError, "broken")
+		}
+	})
+	r.GET("/health", func(c *Context) { c.String(http.StatusOK, "ok") })
+	r.GET("/static/*file", func(c *Context) { c.String(http.StatusOK, "file") })
+	return r
+}
+
+// serveLogged sends a GET request for target with the headers
+func serveLogged(r *Router, target string, header http.Header) {
+	req := httptest.NewRequest(http.MethodGet, target, nil)
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	r.ServeHTTP(httptest.NewRecorder(), req)
+}
+
+func TestLoggerJSON(t *testing.T) {
+	var out bytes.Buffer
+	r := loggerRouter(LoggerConfig{Format: LogFormatJSON, Output: &out})
+	serveLogged(r, "/users/42?x=1", http.Header{"User-Agent": {"test/1.0"}})
+
+	var entry map[string]interface{}
+	if err := json.Unmarshal(out.Bytes(), &entry); err != nil {
+		t.Fatalf("log %q is not JSON: %v", out.String(), err)
+	}
+	want := map[string]interface{}{
+		"level":      "INFO",
+		"msg":        "request",
+		"method":     "GET",
+		"path":       "/users/42",
+		"route":      "/users/:id",
+		"status":     float64(200),
+		"bytes":      float64(5),
+		"client_ip":  "192.0.2.1",
+		"request_id": "req-1",
+		"user_agent": "test/1.0",
+	}
+	for key, value := range want {
+		if entry[key] != value {
+			t.Errorf("%s = %#v, want %#v", key, entry[key], value)
+		}
+	}
+	if _, ok := entry["latency"].(float64); !ok {
+		t.Errorf("latency = %#v, want a number", entry["latency"])
+	}
+}
+
+func TestLoggerLevels(t *testing.T) {
+	tests := []struct {
+		path  string
+		level string
+	}{
+		{"/users/1", "level=INFO"},
+		{"/status/404", "level=WARN"},
+		{"/status/500", "level=ERROR"},
+		{"/missing", "level=WARN"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			var out bytes.Buffer
+			serveLogged(loggerRouter(LoggerConfig{Format: LogFormatText, Output: &out}), tt.path, nil)
+			if !strings.HasPrefix(out.String(), "time=") || !strings.Contains(out.String(), tt.level) {
+				t.Fatalf("log %q does not contain %s", out.String(), tt.level)
+			}
+		})
+	}
+}
+
+func TestLoggerSlog(t *testing.T) {
+	var out bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&out, nil)).With("service", "api")
+	serveLogged(loggerRouter(LoggerConfig{Logger: logger}), "/users/1", nil)
+	for _, want := range []string{"service=api", "msg=request", "route=/users/:id", "request_id=req-1"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("log %q does not contain %s", out.String(), want)
+		}
+	}
+
+	// Without a Logger entries go to slog.Default()
+	out.Reset()
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(slog.New(slog.NewTextHandler(&out, nil)))
+	serveLogged(loggerRouter(LoggerConfig{}), "/users/1", nil)
+	if !strings.Contains(out.String(), "path=/users/1") {
+		t.Fatalf("default logger got %q", out.String())
+	}
+}
+
+func TestLoggerApache(t *testing.T) {
+	tests := []struct {
+		name   string
+		format LogFormat
+		target string
+		header http.Header
+		want   string
+	}{
+		{
+			name:   "common",
+			format: LogFormatCommon,
+			target: "/users/1?x=1",
+			want:   `^192\.0\.2\.1 - - \[\d{2}/\w{3}/\d{4}:\d{2}:\d{2}:\d{2} [+-]\d{4}\] "GET /users/1\?x=1 HTTP/1\.1" 200 5$`,
+		},
+		{
+			name:   "common with user",
+			format: LogFormatCommon,
+			target: "/users/1",
+			header: http.Header{"Authorization": {"Basic YW5uOnNlY3JldA=="}},
+			want:   `^192\.0\.2\.1 - ann \[.+\] "GET /users/1 HTTP/1\.1" 200 5$`,
+		},
+		{
+			name:   "common without body",
+			format: LogFormatCommon,
+			target: "/status/204",
+			want:   `^192\.0\.2\.1 - - \[.+\] "GET /status/204 HTTP/1\.1" 204 -$`,
+		},
+		{
+			name:   "combined",
+			format: LogFormatCombined,
+			target: "/status/404",
+			header: http.Header{"Referer": {"https://example.com/"}, "User-Agent": {"test/1.0"}},
+			want:   `^192\.0\.2\.1 - - \[.+\] "GET /status/404 HTTP/1\.1" 404 4 "https://example\.com/" "test/1\.0"$`,
+		},
+		{
+			name:   "combined without headers",
+			format: LogFormatCombined,
+			target: "/users/1",
+			want:   `^192\.0\.2\.1 - - \[.+\] "GET /users/1 HTTP/1\.1" 200 5 "-" "-"$`,
+		},
+		{
+			name:   "quotes are escaped",
+			format: LogFormatCombined,
+			target: "/users/1",
+			header: http.Header{"User-Agent": {`evil" 200 1 "x`}},
+			want:   `^192\.0\.2\.1 - - \[.+\] "GET /users/1 HTTP/1\.1" 200 5 "-" "evil\\" 200 1 \\"x"$`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			serveLogged(loggerRouter(LoggerConfig{Format: tt.format, Output: &out}), tt.target, tt.header)
+			line := strings.TrimSuffix(out.String(), "\n")
+			if !regexp.MustCompile(tt.want).MatchString(line) {
+				t.Fatalf("log line %q does not match %s", line, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoggerSkip(t *testing.T) {
+	var out bytes.Buffer
+	r := loggerRouter(LoggerConfig{
+		Format:      LogFormatCommon,
+		Output:      &out,
+		SkipPaths:   []string{"/health", "/static/*"},
+		Skip:        func(c *Context) bool { return c.GetHeader("X-Internal") != "" },
+		SampleRates: map[string]float64{"/status/:code": 0},
+	})
+	tests := []struct {
+		target string
+		header http.Header
+		logged bool
+	}{
+		{"/health", nil, false},
+		{"/health/deep", nil, true},
+		{"/static/app.js", nil, false},
+		{"/users/1", http.Header{"X-Internal": {"1"}}, false},
+		{"/users/1", nil, true},
+		// Sampling drops successful requests only
+		{"/status/204", nil, false},
+		{"/status/404", nil, true},
+		{"/status/500", nil, true},
+	}
+	for _, tt := range tests {
+		out.Reset()
+		serveLogged(r, tt.target, tt.header)
+		if logged := out.Len() > 0; logged != tt.logged {
+			t.Errorf("%s logged = %v, want %v", tt.target, logged, tt.logged)
+		}
+	}
+}
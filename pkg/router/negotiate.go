@@ -0,0 +1,72 @@
+package router
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// acceptRange is a single media range from an Accept header
+type acceptRange struct {
+	mediaType string
+	q         float64
+}
+
+// parseAccept parses an Accept header into media ranges ordered by preference
+func parseAccept(header string) []acceptRange {
+	var ranges []acceptRange
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(part, ";")
+		mediaType := strings.ToLower(strings.TrimSpace(fields[0]))
+		if mediaType == "" {
+			continue
+		}
+		q := 1.0
+		for _, param := range fields[1:] {
+			key, value, ok := strings.Cut(strings.TrimSpace(param), "=")
+			if ok && strings.TrimSpace(key) == "q" {
+				if parsed, err := strconv.ParseFloat(strings.TrimSpace(value), 64); err == nil {
+					q = parsed
+				}
+			}
+		}
+		ranges = append(ranges, acceptRange{mediaType: mediaType, q: q})
+	}
+	sort.SliceStable(ranges, func(i, j int) bool {
+		return ranges[i].q > ranges[j].q
+	})
+	return ranges
+}
+
+// NegotiateContentType returns the offer that best matches the Accept header.
+// An empty header selects the first offer; if nothing matches "" is returned.
+func NegotiateContentType(accept string, offers ...string) string {
+	if len(offers) == 0 {
+		return ""
+	}
+	if strings.TrimSpace(accept) == "" {
+		return offers[0]
+	}
+	for _, r := range parseAccept(accept) {
+		if r.q <= 0 {
+			continue
+		}
+		for _, offer := range offers {
+			if mediaTypeMatches(r.mediaType, offer) {
+				return offer
+			}
+		}
+	}
+	return ""
+}
+
+// mediaTypeMatches reports whether offer falls within the media range pattern
+func mediaTypeMatches(pattern, offer string) bool {
+	if pattern == "*/*" || pattern == offer {
+		return true
+	}
+	if prefix, ok := strings.CutSuffix(pattern, "/*"); ok {
+		return strings.HasPrefix(offer, prefix+"/")
+	}
+	return false
+}
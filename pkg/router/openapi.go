@@ -0,0 +1,715 @@
+package router
+
+import (
+	"embed"
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// OpenAPIDocument is an OpenAPI 3 document. It is produced by Router.OpenAPI
+// and can also be parsed from an existing specification.
+type OpenAPIDocument struct {
+	OpenAPI    string               `json:"openapi"`
+	Info       OpenAPIInfo          `json:"info"`
+	Servers    []OpenAPIServer      `json:"servers,omitempty"`
+	Paths      map[string]*PathItem `json:"paths"`
+	Components *Components          `json:"components,omitempty"`
+}
+
+// OpenAPIInfo is the info object of an OpenAPI document
+type OpenAPIInfo struct {
+	Title       string `json:"title"`
+	Version     string `json:"version"`
+	Description string `json:"description,omitempty"`
+}
+
+// OpenAPIServer is a server object of an OpenAPI document
+type OpenAPIServer struct {
+	URL         string `json:"url"`
+	Description string `json:"description,omitempty"`
+}
+
+// PathItem holds the operations available on a single path
+type PathItem struct {
+	Parameters []*Parameter `json:"parameters,omitempty"`
+	Get        *Operation   `json:"get,omitempty"`
+	Put        *Operation   `json:"put,omitempty"`
+	Post       *Operation   `json:"post,omitempty"`
+	Delete     *Operation   `json:"delete,omitempty"`
+	Options    *Operation   `json:"options,omitempty"`
+	Head       *Operation   `json:"head,omitempty"`
+	Patch      *Operation   `json:"patch,omitempty"`
+}
+
+// Operation returns the operation for an HTTP method, or nil
+func (p *PathItem) Operation(method string) *Operation {
+	switch method {
+	case http.MethodGet:
+		return p.Get
+	case http.MethodPut:
+		return p.Put
+	case http.MethodPost:
+		return p.Post
+	case http.MethodDelete:
+		return p.Delete
+	case http.MethodOptions:
+		return p.Options
+	case http.MethodHead:
+		return p.Head
+	case http.MethodPatch:
+		return p.Patch
+	}
+	return nil
+}
+
+// SetOperation sets the operation for an HTTP method
+func (p *PathItem) SetOperation(method string, op *Operation) {
+	switch method {
+	case http.MethodGet:
+		p.Get = op
+	case http.MethodPut:
+		p.Put = op
+	case http.MethodPost:
+		p.Post = op
+	case http.MethodDelete:
+		p.Delete = op
+	case http.MethodOptions:
+		p.Options = op
+	case http.MethodHead:
+		p.Head = op
+	case http.MethodPatch:
+		p.Patch = op
+	}
+}
+
+// Operation describes a single API operation on a path
+type Operation struct {
+	OperationID string               `json:"operationId,omitempty"`
+	Summary     string               `json:"summary,omitempty"`
+	Description string               `json:"description,omitempty"`
+	Tags        []string             `json:"tags,omitempty"`
+	Parameters  []*Parameter         `json:"parameters,omitempty"`
+	RequestBody *RequestBody         `json:"requestBody,omitempty"`
+	Responses   map[string]*Response `json:"responses"`
+	Deprecated  bool                 `json:"deprecated,omitempty"`
+}
+
+// Parameter describes a path, query, header or cookie parameter
+type Parameter struct {
+	Ref         string  `json:"$ref,omitempty"`
+	Name        string  `json:"name,omitempty"`
+	In          string  `json:"in,omitempty"`
+	Description string  `json:"description,omitempty"`
+	Required    bool    `json:"required,omitempty"`
+	Schema      *Schema `json:"schema,omitempty"`
+}
+
+// RequestBody describes the body of a request
+type RequestBody struct {
+	Ref         string                `json:"$ref,omitempty"`
+	Description string                `json:"description,omitempty"`
+	Required    bool                  `json:"required,omitempty"`
+	Content     map[string]*MediaType `json:"content,omitempty"`
+}
+
+// Response describes a single response of an operation
+type Response struct {
+	Ref         string                `json:"$ref,omitempty"`
+	Description string                `json:"description"`
+	Content     map[string]*MediaType `json:"content,omitempty"`
+}
+
+// MediaType holds the schema for a content type
+type MediaType struct {
+	Schema *Schema `json:"schema,omitempty"`
+}
+
+// Components holds reusable objects referenced from the document
+type Components struct {
+	Schemas       map[string]*Schema      `json:"schemas,omitempty"`
+	Parameters    map[string]*Parameter   `json:"parameters,omitempty"`
+	RequestBodies map[string]*RequestBody `json:"requestBodies,omitempty"`
+	Responses     map[string]*Response    `json:"responses,omitempty"`
+}
+
+// Schema is the subset of JSON Schema used by OpenAPI documents. Nullable is
+// the OpenAPI 3.0 keyword, accepted in loaded documents; generated 3.1
+// documents list "null" in Type instead.
+type Schema struct {
+	Ref                  string                `json:"$ref,omitempty"`
+	Type                 SchemaType            `json:"type,omitempty"`
+	Format               string                `json:"format,omitempty"`
+	Description          string                `json:"description,omitempty"`
+	Properties           map[string]*Schema    `json:"properties,omitempty"`
+	Required             []string              `json:"required,omitempty"`
+	AdditionalProperties *AdditionalProperties `json:"additionalProperties,omitempty"`
+	Items                *Schema               `json:"items,omitempty"`
+	Enum                 []interface{}         `json:"enum,omitempty"`
+	Minimum              *float64              `json:"minimum,omitempty"`
+	Maximum              *float64              `json:"maximum,omitempty"`
+	ExclusiveMinimum     interface{}           `json:"exclusiveMinimum,omitempty"`
+	ExclusiveMaximum     interface{}           `json:"exclusiveMaximum,omitempty"`
+	MinLength            *int                  `json:"minLength,omitempty"`
+	MaxLength            *int                  `json:"maxLength,omitempty"`
+	Pattern              string                `json:"pattern,omitempty"`
+	MinItems             *int                  `json:"minItems,omitempty"`
+	MaxItems             *int                  `json:"maxItems,omitempty"`
+	Nullable             bool                  `json:"nullable,omitempty"`
+	OneOf                []*Schema             `json:"oneOf,omitempty"`
+	AnyOf                []*Schema             `json:"anyOf,omitempty"`
+	AllOf                []*Schema             `json:"allOf,omitempty"`
+	Default              interface{}           `json:"default,omitempty"`
+}
+
+// SchemaType is the type keyword of a schema. OpenAPI 3.1 allows a list of
+// types while 3.0 only allows a single one.
+type SchemaType []string
+
+// Has reports whether t includes the given type name
+func (t SchemaType) Has(name string) bool {
+	for _, n := range t {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// MarshalJSON writes a single type as a string and several as an array
+func (t SchemaType) MarshalJSON() ([]byte, error) {
+	if len(t) == 1 {
+		return json.Marshal(t[0])
+	}
+	return json.Marshal([]string(t))
+}
+
+// UnmarshalJSON accepts either a string or an array of strings
+func (t *SchemaType) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*t = SchemaType{single}
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return err
+	}
+	*t = list
+	return nil
+}
+
+// AdditionalProperties is either a boolean or a schema
+type AdditionalProperties struct {
+	Allowed bool
+	Schema  *Schema
+}
+
+// MarshalJSON writes the schema if there is one, otherwise the boolean
+func (a *AdditionalProperties) MarshalJSON() ([]byte, error) {
+	if a.Schema != nil {
+		return json.Marshal(a.Schema)
+	}
+	return json.Marshal(a.Allowed)
+}
+
+// UnmarshalJSON accepts either a boolean or a schema
+func (a *AdditionalProperties) UnmarshalJSON(data []byte) error {
+	if err := json.Unmarshal(data, &a.Allowed); err == nil {
+		return nil
+	}
+	a.Allowed = true
+	return json.Unmarshal(data, &a.Schema)
+}
+
+// OpenAPI generates an OpenAPI 3.1 document describing every route that is
+// not hidden. Request and response schemas are reflected from the types of
+// typed handlers and from the types set with Route.Request and Route.Response.
+func (r *Router) OpenAPI(info OpenAPIInfo) *OpenAPIDocument {
+	doc := &OpenAPIDocument{
+		OpenAPI: "3.1.0",
+		Info:    info,
+		Paths:   make(map[string]*PathItem),
+	}
+	gen := &schemaGenerator{
+		schemas: make(map[string]*Schema),
+		names:   make(map[reflect.Type]string),
+		owners:  map[string]reflect.Type{errorSchemaName: nil},
+	}
+
+	for _, route := range r.routes {
+		if route.Meta.Hidden {
+			continue
+		}
+		path, pathParams := openAPIPath(route.Path)
+		item, ok := doc.Paths[path]
+		if !ok {
+			item = &PathItem{}
+			doc.Paths[path] = item
+		}
+		item.SetOperation(route.Method, gen.operation(route, pathParams))
+	}
+
+	if len(gen.schemas) > 0 {
+		doc.Components = &Components{Schemas: gen.schemas}
+	}
+	return doc
+}
+
+// openAPIPath converts a route pattern such as /users/:id into /users/{id}
+// and returns the names of its path parameters
+func openAPIPath(pattern string) (string, []string) {
+	var params []string
+	parts := strings.Split(pattern, "/")
+	for i, part := range parts {
+		if strings.HasPrefix(part, ":") {
+			params = append(params, part[1:])
+			parts[i] = "{" + part[1:] + "}"
+		}
+	}
+	path := strings.Join(parts, "/")
+	if path == "" {
+		path = "/"
+	}
+	return path, params
+}
+
+// errorSchemaName is the component name of the body written by DefaultErrorHandler
+const errorSchemaName = "Error"
+
+// schemaGenerator reflects Go types into schemas, collecting named struct
+// types as components
+type schemaGenerator struct {
+	schemas map[string]*Schema
+	// names holds the component name of each registered type and owners
+	// the type of each name, so types with the same name from different
+	// packages get different components
+	names  map[reflect.Type]string
+	owners map[string]reflect.Type
+}
+
+// operation builds the operation object for a route
+func (g *schemaGenerator) operation(route *Route, pathParams []string) *Operation {
+	meta := route.Meta
+	op := &Operation{
+		OperationID: meta.OperationID,
+		Summary:     meta.Summary,
+		Description: meta.Description,
+		Tags:        meta.Tags,
+		Deprecated:  meta.Deprecated,
+		Responses:   make(map[string]*Response),
+	}
+
+	declared := make(map[string]bool)
+	if meta.RequestType != nil {
+		for _, p := range g.parameters(derefType(meta.RequestType)) {
+			declared[p.In+":"+p.Name] = true
+			op.Parameters = append(op.Parameters, p)
+		}
+		if route.Method != http.MethodGet && route.Method != http.MethodHead && hasBodyFields(derefType(meta.RequestType)) {
+			op.RequestBody = &RequestBody{
+				Required: true,
+				Content:  map[string]*MediaType{"application/json": {Schema: g.schema(meta.RequestType)}},
+			}
+		}
+	}
+	for _, name := range pathParams {
+		if !declared["path:"+name] {
+			op.Parameters = append(op.Parameters, &Parameter{
+				Name:     name,
+				In:       "path",
+				Required: true,
+				Schema:   &Schema{Type: SchemaType{"string"}},
+			})
+		}
+	}
+
+	if meta.ResponseType != nil {
+		rt := derefType(meta.ResponseType)
+		if rt.Kind() == reflect.Struct && rt.NumField() == 0 {
+			op.Responses["204"] = &Response{Description: http.StatusText(http.StatusNoContent)}
+		} else {
+			op.Responses["200"] = g.response(http.StatusOK, meta.ResponseType)
+		}
+		op.Responses["default"] = g.errorResponse()
+	}
+	for code, t := range meta.Responses {
+		op.Responses[strconv.Itoa(code)] = g.response(code, t)
+	}
+	if len(op.Responses) == 0 {
+		op.Responses["200"] = &Response{Description: http.StatusText(http.StatusOK)}
+	}
+	return op
+}
+
+// response builds a response object with a JSON body of type t, if any
+func (g *schemaGenerator) response(code int, t reflect.Type) *Response {
+	resp := &Response{Description: http.StatusText(code)}
+	if t != nil {
+		resp.Content = map[string]*MediaType{"application/json": {Schema: g.schema(t)}}
+	}
+	return resp
+}
+
+// errorResponse returns the response written by DefaultErrorHandler
+func (g *schemaGenerator) errorResponse() *Response {
+	if _, ok := g.schemas[errorSchemaName]; !ok {
+		g.schemas[errorSchemaName] = &Schema{
+			Type:       SchemaType{"object"},
+			Properties: map[string]*Schema{"error": {Type: SchemaType{"string"}}},
+			Required:   []string{"error"},
+		}
+	}
+	return &Response{
+		Description: "Error",
+		Content: map[string]*MediaType{"application/json": {
+			Schema: &Schema{Ref: "#/components/schemas/" + errorSchemaName},
+		}},
+	}
+}
+
+// parameters returns the path, query and header parameters declared by the
+// tagged fields of the struct type t
+func (g *schemaGenerator) parameters(t reflect.Type) []*Parameter {
+	if t.Kind() != reflect.Struct {
+		return nil
+	}
+	var params []*Parameter
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		if field.Anonymous && derefType(field.Type).Kind() == reflect.Struct {
+			params = append(params, g.parameters(derefType(field.Type))...)
+			continue
+		}
+		for _, in := range []string{"path", "query", "header"} {
+			name := field.Tag.Get(in)
+			if name == "" {
+				continue
+			}
+			schema := g.schema(field.Type)
+			required := applyValidateTag(schema, field.Tag.Get("validate"))
+			params = append(params, &Parameter{
+				Name:     name,
+				In:       in,
+				Required: required || in == "path",
+				Schema:   schema,
+			})
+			break
+		}
+	}
+	return params
+}
+
+// schema returns the schema for t, registering named structs as components
+func (g *schemaGenerator) schema(t reflect.Type) *Schema {
+	t = derefType(t)
+	switch {
+	case t == timeType:
+		return &Schema{Type: SchemaType{"string"}, Format: "date-time"}
+	case t == durationType:
+		return &Schema{Type: SchemaType{"string"}}
+	case t.Implements(textUnmarshalerType) || reflect.PointerTo(t).Implements(textUnmarshalerType):
+		return &Schema{Type: SchemaType{"string"}}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return &Schema{Type: SchemaType{"boolean"}}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return &Schema{Type: SchemaType{"integer"}, Format: "int32"}
+	case reflect.Int64, reflect.Uint64:
+		return &Schema{Type: SchemaType{"integer"}, Format: "int64"}
+	case reflect.Float32:
+		return &Schema{Type: SchemaType{"number"}, Format: "float"}
+	case reflect.Float64:
+		return &Schema{Type: SchemaType{"number"}, Format: "double"}
+	case reflect.String:
+		return &Schema{Type: SchemaType{"string"}}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &Schema{Type: SchemaType{"string"}, Format: "byte"}
+		}
+		return &Schema{Type: SchemaType{"array"}, Items: g.schema(t.Elem())}
+	case reflect.Map:
+		return &Schema{
+			Type:                 SchemaType{"object"},
+			AdditionalProperties: &AdditionalProperties{Allowed: true, Schema: g.schema(t.Elem())},
+		}
+	case reflect.Struct:
+		name := g.componentName(t)
+		if name == "" {
+			return g.structSchema(t)
+		}
+		if _, ok := g.schemas[name]; !ok {
+			// Register a placeholder first so recursive types terminate
+			g.schemas[name] = &Schema{}
+			*g.schemas[name] = *g.structSchema(t)
+		}
+		return &Schema{Ref: "#/components/schemas/" + name}
+	}
+	return &Schema{}
+}
+
+// componentName returns the component name of the named type t. The
+// first type with a name gets it as is; other types with the same name are
+// qualified with their package path.
+func (g *schemaGenerator) componentName(t reflect.Type) string {
+	if name, ok := g.names[t]; ok {
+		return name
+	}
+	name := schemaName(t)
+	if name == "" {
+		return ""
+	}
+	if owner, taken := g.owners[name]; taken && owner != t {
+		name = qualifiedSchemaName(t)
+		for i := 2; g.owners[name] != nil; i++ {
+			name = qualifiedSchemaName(t) + "_" + strconv.Itoa(i)
+		}
+	}
+	g.names[t] = name
+	g.owners[name] = t
+	return name
+}
+
+// structSchema builds an object schema from the JSON fields of a struct
+func (g *schemaGenerator) structSchema(t reflect.Type) *Schema {
+	schema := &Schema{Type: SchemaType{"object"}, Properties: make(map[string]*Schema)}
+	g.addFields(schema, t)
+	sort.Strings(schema.Required)
+	return schema
+}
+
+// addFields adds the JSON fields of t to schema, flattening embedded structs
+func (g *schemaGenerator) addFields(schema *Schema, t reflect.Type) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() || isParamField(field) {
+			continue
+		}
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name := strings.Split(tag, ",")[0]
+		if field.Anonymous && name == "" && derefType(field.Type).Kind() == reflect.Struct {
+			g.addFields(schema, derefType(field.Type))
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+
+		prop := g.schema(field.Type)
+		if prop.Ref != "" && field.Tag.Get("validate") != "" {
+			prop = &Schema{AllOf: []*Schema{prop}}
+		}
+		if applyValidateTag(prop, field.Tag.Get("validate")) {
+			schema.Required = append(schema.Required, name)
+		}
+		// A nil pointer is encoded as null unless the field is omitted
+		if field.Type.Kind() == reflect.Ptr && !strings.Contains(tag, ",omitempty") {
+			prop = nullableSchema(prop)
+		}
+		schema.Properties[name] = prop
+	}
+}
+
+// nullableSchema returns schema extended to also allow null
+func nullableSchema(schema *Schema) *Schema {
+	if len(schema.Type) == 0 {
+		return &Schema{AnyOf: []*Schema{schema, {Type: SchemaType{"null"}}}}
+	}
+	if !schema.Type.Has("null") {
+		schema.Type = append(schema.Type, "null")
+	}
+	if len(schema.Enum) > 0 {
+		schema.Enum = append(schema.Enum, nil)
+	}
+	return schema
+}
+
+// applyValidateTag copies the rules of a `validate` tag onto schema and
+// reports whether the field is required
+func applyValidateTag(schema *Schema, tag string) bool {
+	required := false
+	if tag == "" || tag == "-" {
+		return false
+	}
+	isString := schema.Type.Has("string")
+	isArray := schema.Type.Has("array")
+	for _, rule := range strings.Split(tag, ",") {
+		name, arg, _ := strings.Cut(strings.TrimSpace(rule), "=")
+		switch name {
+		case "required":
+			required = true
+		case "min", "max", "len":
+			f, err := strconv.ParseFloat(arg, 64)
+			if err != nil {
+				continue
+			}
+			n := int(f)
+			switch {
+			case isString:
+				if name != "max" {
+					schema.MinLength = &n
+				}
+				if name != "min" {
+					schema.MaxLength = &n
+				}
+			case isArray:
+				if name != "max" {
+					schema.MinItems = &n
+				}
+				if name != "min" {
+					schema.MaxItems = &n
+				}
+			default:
+				if name == "min" {
+					schema.Minimum = &f
+				} else if name == "max" {
+					schema.Maximum = &f
+				}
+			}
+		case "oneof":
+			for _, option := range strings.Fields(arg) {
+				schema.Enum = append(schema.Enum, option)
+			}
+		}
+	}
+	return required
+}
+
+// isParamField reports whether a struct field is bound from the URL or headers
+func isParamField(field reflect.StructField) bool {
+	return field.Tag.Get("path") != "" || field.Tag.Get("query") != "" || field.Tag.Get("header") != ""
+}
+
+// hasBodyFields reports whether the struct type t has fields read from the body
+func hasBodyFields(t reflect.Type) bool {
+	if t.Kind() != reflect.Struct {
+		return true
+	}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() || isParamField(field) || field.Tag.Get("json") == "-" {
+			continue
+		}
+		if field.Anonymous && derefType(field.Type).Kind() == reflect.Struct {
+			if hasBodyFields(derefType(field.Type)) {
+				return true
+			}
+			continue
+		}
+		return true
+	}
+	return false
+}
+
+// derefType strips pointer indirections from t
+func derefType(t reflect.Type) reflect.Type {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t
+}
+
+var schemaNameReplacer = regexp.MustCompile(`[^A-Za-z0-9_.-]+`)
+
+// schemaName returns the component name for a named type, or "" for anonymous types
+func schemaName(t reflect.Type) string {
+	if t.Name() == "" {
+		return ""
+	}
+	return strings.Trim(schemaNameReplacer.ReplaceAllString(t.Name(), "_"), "_")
+}
+
+// qualifiedSchemaName returns the component name for a named type including
+// its package path, such as example.com_app_billing.User
+func qualifiedSchemaName(t reflect.Type) string {
+	return strings.Trim(schemaNameReplacer.ReplaceAllString(t.PkgPath()+"."+t.Name(), "_"), "_")
+}
+
+//go:embed openapi_ui/*.html
+var openAPIUI embed.FS
+
+// OpenAPIConfig configures ServeOpenAPI
+type OpenAPIConfig struct {
+	Info OpenAPIInfo
+	// SpecPath is where the JSON document is served, default /openapi.json
+	SpecPath string
+	// UIPath is where the documentation page is served, default /docs.
+	// Set it to "-" to serve only the document.
+	UIPath string
+	// UI selects the documentation page, "redoc" (default) or "swagger"
+	UI string
+	// AssetsURL is where the page loads the UI's scripts and styles from,
+	// default a pinned release on a public CDN. For offline use, serve
+	// redoc.standalone.js or swagger-ui.css and swagger-ui-bundle.js from
+	// your own server, for example with Static, and set it to their path.
+	AssetsURL string
+	// Integrity holds Subresource Integrity hashes of the asset files,
+	// keyed by file name, such as {"redoc.standalone.js": "sha384-..."}
+	Integrity map[string]string
+}
+
+// Pinned releases of the documentation UIs, loaded when
+// OpenAPIConfig.AssetsURL is not set
+const (
+	redocAssetsURL     = "https://cdn.jsdelivr.net/npm/redoc@2.1.5/bundles"
+	swaggerUIAssetsURL = "https://cdn.jsdelivr.net/npm/swagger-ui-dist@5.17.14"
+)
+
+// ServeOpenAPI registers routes that serve the generated OpenAPI document
+// and a documentation UI. The document is generated on every request so
+// it always reflects the current route table.
+func (r *Router) ServeOpenAPI(config OpenAPIConfig) {
+	if config.SpecPath == "" {
+		config.SpecPath = "/openapi.json"
+	}
+	if config.UIPath == "" {
+		config.UIPath = "/docs"
+	}
+
+	r.GET(config.SpecPath, func(c *Context) {
+		c.JSON(http.StatusOK, r.OpenAPI(config.Info))
+	}).Hide()
+
+	if config.UIPath == "-" {
+		return
+	}
+	page, assets := "openapi_ui/redoc.html", redocAssetsURL
+	if config.UI == "swagger" {
+		page, assets = "openapi_ui/swagger.html", swaggerUIAssetsURL
+	}
+	if config.AssetsURL != "" {
+		assets = strings.TrimSuffix(config.AssetsURL, "/")
+	}
+	tmpl := template.Must(template.ParseFS(openAPIUI, page))
+	title := config.Info.Title
+	if title == "" {
+		title = "API documentation"
+	}
+
+	r.GET(config.UIPath, func(c *Context) {
+		c.SetHeader("Content-Type", "text/html; charset=utf-8")
+		c.Status(http.StatusOK)
+		tmpl.Execute(c.Writer, map[string]interface{}{
+			"Title":     title,
+			"SpecURL":   config.SpecPath,
+			"AssetsURL": assets,
+			"Integrity": config.Integrity,
+			// Lets a nonce based Content-Security-Policy from Secure allow
+			// the page's scripts
+			"Nonce": c.CSPNonce(),
+		})
+	}).Hide()
+}
@@ -0,0 +1,334 @@
+package router
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/sys-apps-go/gorouter/pkg/router/routertest"
+)
+
+// page is generic, so its reflected name includes its type argument
+type page[T any] struct {
+	Items []T     `json:"items"`
+	Next  *string `json:"next,omitempty"`
+}
+
+type nullableFields struct {
+	Count    *int             `json:"count"`
+	Limit    *int             `json:"limit,omitempty"`
+	Role     *string          `json:"role" validate:"oneof=admin user"`
+	Owner    *userResponse    `json:"owner"`
+	Manager  *userResponse    `json:"manager,omitempty"`
+	Parent   *nullableFields  `json:"parent"`
+	Children []nullableFields `json:"children"`
+}
+
+func TestOpenAPI(t *testing.T) {
+	r := NewRouter()
+	r.PUT("/users/:id", Typed(func(ctx context.Context, req updateUserRequest) (userResponse, error) {
+		return userResponse{}, nil
+	})).Name("updateUser").Doc("Update a user", "Replaces the user's name and role").Tag("users").Deprecate()
+	r.GET("/users/:id/avatar", func(c *Context) {}).Response(http.StatusOK, nil).Response(http.StatusNotFound, createdResponse{})
+	r.POST("/users", func(c *Context) {}).Request(createdResponse{}).Response(http.StatusCreated, createdResponse{})
+	r.GET("/internal", func(c *Context) {}).Hide()
+	r.GET("/", func(c *Context) {})
+
+	doc := r.OpenAPI(OpenAPIInfo{Title: "Users", Version: "1.0"})
+	if doc.OpenAPI != "3.1.0" || doc.Info.Title != "Users" {
+		t.Fatalf("document header = %q %+v", doc.OpenAPI, doc.Info)
+	}
+	if _, ok := doc.Paths["/internal"]; ok {
+		t.Fatal("hidden route documented")
+	}
+	if doc.Paths["/"] == nil || doc.Paths["/"].Get.Responses["200"] == nil {
+		t.Fatal("plain route not documented with a 200 response")
+	}
+
+	op := doc.Paths["/users/{id}"].Operation(http.MethodPut)
+	if op == nil {
+		t.Fatalf("paths = %v, want /users/{id}", reflect.ValueOf(doc.Paths).MapKeys())
+	}
+	if op.OperationID != "updateUser" || op.Summary != "Update a user" || !op.Deprecated || !reflect.DeepEqual(op.Tags, []string{"users"}) {
+		t.Fatalf("operation metadata = %+v", op)
+	}
+
+	params := make(map[string]*Parameter)
+	for _, p := range op.Parameters {
+		params[p.In+":"+p.Name] = p
+	}
+	wantParams := map[string]string{
+		"path:id":           "integer",
+		"query:notify":      "boolean",
+		"query:tag":         "array",
+		"query:timeout":     "string",
+		"header:X-Trace-Id": "string",
+	}
+	if len(params) != len(wantParams) {
+		t.Fatalf("parameters = %v, want %v", params, wantParams)
+	}
+	for key, typ := range wantParams {
+		if p := params[key]; p == nil || !p.Schema.Type.Has(typ) {
+			t.Errorf("parameter %s = %+v, want type %s", key, p, typ)
+		}
+	}
+	if !params["path:id"].Required || params["query:notify"].Required {
+		t.Error("only the path parameter should be required")
+	}
+
+	body := op.RequestBody.Content["application/json"].Schema
+	if body.Ref != "#/components/schemas/updateUserRequest" {
+		t.Fatalf("request body schema = %+v", body)
+	}
+	req := doc.Components.Schemas["updateUserRequest"]
+	if !reflect.DeepEqual(req.Required, []string{"name"}) {
+		t.Errorf("required = %v, want [name]", req.Required)
+	}
+	if len(req.Properties) != 3 {
+		t.Errorf("body properties = %v, want name, role and age only", reflect.ValueOf(req.Properties).MapKeys())
+	}
+	if name := req.Properties["name"]; name.MaxLength == nil || *name.MaxLength != 10 {
+		t.Errorf("name = %+v, want maxLength 10", name)
+	}
+	if role := req.Properties["role"]; !reflect.DeepEqual(role.Enum, []interface{}{"admin", "user"}) {
+		t.Errorf("role enum = %v", role.Enum)
+	}
+	if age := req.Properties["age"]; age.Minimum == nil || *age.Minimum != 18 {
+		t.Errorf("age = %+v, want minimum 18", age)
+	}
+
+	if ref := op.Responses["200"].Content["application/json"].Schema.Ref; ref != "#/components/schemas/userResponse" {
+		t.Errorf("200 response schema = %q", ref)
+	}
+	if ref := op.Responses["default"].Content["application/json"].Schema.Ref; ref != "#/components/schemas/Error" {
+		t.Errorf("default response schema = %q", ref)
+	}
+
+	avatar := doc.Paths["/users/{id}/avatar"].Get
+	if len(avatar.Parameters) != 1 || avatar.Parameters[0].Name != "id" || !avatar.Parameters[0].Required {
+		t.Errorf("undeclared path parameter = %+v", avatar.Parameters)
+	}
+	if avatar.Responses["200"].Content != nil {
+		t.Error("response without a body has content")
+	}
+
+	create := doc.Paths["/users"].Post
+	if create.RequestBody == nil || create.Responses["201"] == nil {
+		t.Errorf("POST /users = %+v", create)
+	}
+}
+
+func TestOpenAPISchemaNameCollisions(t *testing.T) {
+	// Error has the same name as the component written for DefaultErrorHandler
+	type Error struct {
+		Code string `json:"code"`
+	}
+	type userResponse struct {
+		Email string `json:"email"`
+	}
+	r := NewRouter()
+	r.GET("/a", Typed(func(ctx context.Context, req struct{}) (Error, error) {
+		return Error{}, nil
+	}))
+	r.GET("/b", Typed(func(ctx context.Context, req struct{}) (userResponseAlias, error) {
+		return userResponseAlias{}, nil
+	}))
+	r.GET("/c", func(c *Context) {}).
+		Response(http.StatusOK, userResponse{}).
+		Response(http.StatusAccepted, page[userResponse]{})
+	r.GET("/d", func(c *Context) {}).Response(http.StatusOK, otherUserResponse)
+	doc := r.OpenAPI(OpenAPIInfo{})
+	schemas := doc.Components.Schemas
+
+	// The error response keeps its name; the package's own Error type is
+	// qualified with its package path
+	if !schemas["Error"].Type.Has("object") || schemas["Error"].Properties["error"] == nil {
+		t.Errorf("Error = %+v, want the error handler's body", schemas["Error"])
+	}
+	if ref := doc.Paths["/a"].Get.Responses["200"].Content["application/json"].Schema.Ref; ref != "#/components/schemas/github.com_sys-apps-go_gorouter_pkg_router.Error" {
+		t.Errorf("Error type reference = %q", ref)
+	}
+	if code := schemas["github.com_sys-apps-go_gorouter_pkg_router.Error"]; code == nil || code.Properties["code"] == nil {
+		t.Errorf("qualified Error = %+v", code)
+	}
+
+	// Three types named userResponse: the first keeps the name, the second
+	// gets its package path and the third, from the same package, a suffix
+	tests := []struct {
+		path   string
+		schema string
+		field  string
+	}{
+		{"/b", "userResponse", "role"},
+		{"/c", "github.com_sys-apps-go_gorouter_pkg_router.userResponse", "email"},
+		{"/d", "github.com_sys-apps-go_gorouter_pkg_router.userResponse_2", "phone"},
+	}
+	for _, tt := range tests {
+		ref := doc.Paths[tt.path].Get.Responses["200"].Content["application/json"].Schema.Ref
+		if ref != "#/components/schemas/"+tt.schema {
+			t.Errorf("%s reference = %q, want %s", tt.path, ref, tt.schema)
+		}
+		if schema := schemas[tt.schema]; schema == nil || schema.Properties[tt.field] == nil {
+			t.Errorf("%s = %+v, want a %s property", tt.schema, schema, tt.field)
+		}
+	}
+
+	// Component names may only use the characters OpenAPI allows, also for
+	// generic types
+	valid := regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+	for name := range schemas {
+		if !valid.MatchString(name) {
+			t.Errorf("invalid component name %q", name)
+		}
+	}
+	ref := doc.Paths["/c"].Get.Responses["202"].Content["application/json"].Schema.Ref
+	generic := schemas[strings.TrimPrefix(ref, "#/components/schemas/")]
+	if !strings.HasPrefix(ref, "#/components/schemas/page_") || generic == nil {
+		t.Fatalf("generic type reference = %q", ref)
+	}
+	if items := generic.Properties["items"].Items; items.Ref != "#/components/schemas/"+tests[1].schema {
+		t.Errorf("generic items = %+v, want the local userResponse", items)
+	}
+}
+
+// userResponseAlias is the package's userResponse, registered first
+type userResponseAlias = userResponse
+
+// otherUserResponse has a third type named userResponse, declared in a
+// function so it shares the package path of the test's local one
+var otherUserResponse = func() interface{} {
+	type userResponse struct {
+		Phone string `json:"phone"`
+	}
+	return userResponse{}
+}()
+
+func TestOpenAPINullable(t *testing.T) {
+	r := NewRouter()
+	r.GET("/", func(c *Context) {}).Response(http.StatusOK, nullableFields{})
+	schemas := r.OpenAPI(OpenAPIInfo{}).Components.Schemas
+	props := schemas["nullableFields"].Properties
+
+	tests := []struct {
+		field string
+		want  string
+	}{
+		// Nil pointers are encoded as null unless omitted
+		{"count", `{"type":["integer","null"],"format":"int32"}`},
+		{"limit", `{"type":"integer","format":"int32"}`},
+		{"role", `{"type":["string","null"],"enum":["admin","user",null]}`},
+		// References cannot carry a type, so they are combined with null
+		{"owner", `{"anyOf":[{"$ref":"#/components/schemas/userResponse"},{"type":"null"}]}`},
+		{"manager", `{"$ref":"#/components/schemas/userResponse"}`},
+		{"parent", `{"anyOf":[{"$ref":"#/components/schemas/nullableFields"},{"type":"null"}]}`},
+		{"children", `{"type":"array","items":{"$ref":"#/components/schemas/nullableFields"}}`},
+	}
+	for _, tt := range tests {
+		got, err := json.Marshal(props[tt.field])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != tt.want {
+			t.Errorf("%s = %s, want %s", tt.field, got, tt.want)
+		}
+	}
+}
+
+func TestSchemaType(t *testing.T) {
+	var s Schema
+	if err := json.Unmarshal([]byte(`{"type":"string","nullable":true}`), &s); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(s.Type, SchemaType{"string"}) || !s.Nullable {
+		t.Fatalf("3.0 schema = %+v", s)
+	}
+	if err := json.Unmarshal([]byte(`{"type":["string","null"]}`), &s); err != nil {
+		t.Fatal(err)
+	}
+	if !s.Type.Has("null") || !s.Type.Has("string") || len(s.Type) != 2 {
+		t.Fatalf("3.1 schema type = %v", s.Type)
+	}
+	if err := json.Unmarshal([]byte(`{"type":1}`), &s); err == nil {
+		t.Fatal("numeric type accepted")
+	}
+
+	var ap struct {
+		Bool   *AdditionalProperties `json:"bool"`
+		Schema *AdditionalProperties `json:"schema"`
+	}
+	if err := json.Unmarshal([]byte(`{"bool":false,"schema":{"type":"integer"}}`), &ap); err != nil {
+		t.Fatal(err)
+	}
+	if ap.Bool.Allowed || ap.Bool.Schema != nil || !ap.Schema.Allowed || !ap.Schema.Schema.Type.Has("integer") {
+		t.Fatalf("additionalProperties = %+v %+v", ap.Bool, ap.Schema)
+	}
+	got, _ := json.Marshal(ap)
+	if string(got) != `{"bool":false,"schema":{"type":"integer"}}` {
+		t.Fatalf("additionalProperties encoded as %s", got)
+	}
+}
+
+func TestServeOpenAPI(t *testing.T) {
+	tests := []struct {
+		name   string
+		config OpenAPIConfig
+		page   []string
+	}{
+		{
+			name:   "redoc",
+			config: OpenAPIConfig{Info: OpenAPIInfo{Title: "Users"}},
+			page:   []string{"<title>Users</title>", `spec-url="/openapi.json"`, redocAssetsURL + "/redoc.standalone.js"},
+		},
+		{
+			name: "swagger with local assets",
+			config: OpenAPIConfig{
+				SpecPath:  "/spec.json",
+				UIPath:    "/api-docs",
+				UI:        "swagger",
+				AssetsURL: "/assets/",
+				Integrity: map[string]string{"swagger-ui-bundle.js": "sha384-abc"},
+			},
+			page: []string{
+				"<title>API documentation</title>",
+				`href="/assets/swagger-ui.css">`,
+				`src="/assets/swagger-ui-bundle.js" integrity="sha384-abc" crossorigin="anonymous"`,
+				"/spec.json",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewRouter()
+			r.GET("/users", func(c *Context) {})
+			r.ServeOpenAPI(tt.config)
+			rt := routertest.New(t, r)
+
+			spec, ui := tt.config.SpecPath, tt.config.UIPath
+			if spec == "" {
+				spec, ui = "/openapi.json", "/docs"
+			}
+			var doc OpenAPIDocument
+			rt.GET(spec).Expect().Status(http.StatusOK).Decode(&doc)
+			// The document and UI routes describe themselves out of it
+			if len(doc.Paths) != 1 || doc.Paths["/users"] == nil {
+				t.Fatalf("paths = %v, want only /users", reflect.ValueOf(doc.Paths).MapKeys())
+			}
+
+			resp := rt.GET(ui).Expect().Status(http.StatusOK).Header("Content-Type", "text/html; charset=utf-8")
+			for _, want := range tt.page {
+				resp.BodyContains(want)
+			}
+		})
+	}
+
+	// UIPath "-" serves only the document
+	r := NewRouter()
+	r.ServeOpenAPI(OpenAPIConfig{UIPath: "-"})
+	routertest.New(t, r).GET("/openapi.json").Expect().Status(http.StatusOK)
+	routertest.New(t, r).GET("/docs").Expect().Status(http.StatusNotFound)
+	routertest.New(t, r).GET("/-").Expect().Status(http.StatusNotFound)
+}
@@ -0,0 +1,718 @@
+package router
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"mime"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// LoadOpenAPI reads an OpenAPI 3 document from a JSON or YAML file
+func LoadOpenAPI(path string) (*OpenAPIDocument, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading OpenAPI document: %w", err)
+	}
+	return ParseOpenAPI(data)
+}
+
+// ParseOpenAPI parses an OpenAPI 3 document in JSON or YAML form. Every
+// $ref must point to a component in the document, such as
+// #/components/schemas/User; other references are rejected since they
+// cannot be validated.
+func ParseOpenAPI(data []byte) (*OpenAPIDocument, error) {
+	var tree interface{}
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '{' {
+		if err := json.Unmarshal(data, &tree); err != nil {
+			return nil, fmt.Errorf("error parsing OpenAPI document: %w", err)
+		}
+	} else {
+		if err := yaml.Unmarshal(data, &tree); err != nil {
+			return nil, fmt.Errorf("error parsing OpenAPI document: %w", err)
+		}
+		var err error
+		if tree, err = yamlToJSON(tree); err != nil {
+			return nil, fmt.Errorf("error parsing OpenAPI document: %w", err)
+		}
+		if data, err = json.Marshal(tree); err != nil {
+			return nil, fmt.Errorf("error parsing OpenAPI document: %w", err)
+		}
+	}
+
+	var doc OpenAPIDocument
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("error parsing OpenAPI document: %w", err)
+	}
+	if !strings.HasPrefix(doc.OpenAPI, "3.") {
+		return nil, fmt.Errorf("unsupported OpenAPI version %q", doc.OpenAPI)
+	}
+	if err := checkRefs(tree, tree); err != nil {
+		return nil, err
+	}
+	return &doc, nil
+}
+
+// yamlToJSON converts a decoded YAML value into the types encoding/json
+// uses, turning non-string map keys such as response codes into strings
+func yamlToJSON(value interface{}) (interface{}, error) {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		for k, v := range value {
+			converted, err := yamlToJSON(v)
+			if err != nil {
+				return nil, err
+			}
+			value[k] = converted
+		}
+		return value, nil
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(value))
+		for k, v := range value {
+			converted, err := yamlToJSON(v)
+			if err != nil {
+				return nil, err
+			}
+			m[fmt.Sprint(k)] = converted
+		}
+		return m, nil
+	case []interface{}:
+		for i, v := range value {
+			converted, err := yamlToJSON(v)
+			if err != nil {
+				return nil, err
+			}
+			value[i] = converted
+		}
+		return value, nil
+	case time.Time:
+		// Unquoted dates, for example in examples, stay strings in JSON
+		return value.Format(time.RFC3339), nil
+	case float64:
+		if math.IsInf(value, 0) || math.IsNaN(value) {
+			return nil, fmt.Errorf("unsupported number %v", value)
+		}
+	}
+	return value, nil
+}
+
+// checkRefs reports an error for any $ref in value that does not point to
+// a component of the document root
+func checkRefs(root, value interface{}) error {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		for k, v := range value {
+			if ref, ok := v.(string); ok && k == "$ref" {
+				if !refResolves(root, ref) {
+					return fmt.Errorf("unsupported OpenAPI reference %q: only references to existing components of the document are supported", ref)
+				}
+				continue
+			}
+			if err := checkRefs(root, v); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		for _, v := range value {
+			if err := checkRefs(root, v); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// refResolves reports whether ref has the form #/components/{type}/{name}
+// and names an existing component
+func refResolves(root interface{}, ref string) bool {
+	parts := strings.Split(ref, "/")
+	if len(parts) != 4 || parts[0] != "#" || parts[1] != "components" {
+		return false
+	}
+	unescape := strings.NewReplacer("~1", "/", "~0", "~")
+	node := root
+	for _, part := range parts[1:] {
+		m, ok := node.(map[string]interface{})
+		if !ok {
+			return false
+		}
+		if node, ok = m[unescape.Replace(part)]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// OpenAPIValidatorConfig configures OpenAPIValidator
+type OpenAPIValidatorConfig struct {
+	Document *OpenAPIDocument
+	// RejectUnknown rejects requests whose route or method is not described
+	// by the document with 404 or 405. By default they are passed through.
+	RejectUnknown bool
+	// SkipBody disables request body validation
+	SkipBody bool
+	// MaxBodySize limits the body read for validation, default 10 MB
+	MaxBodySize int64
+}
+
+// openAPIOperation is an operation indexed by the validator
+type openAPIOperation struct {
+	op         *Operation
+	parameters []*Parameter
+	// pathNames maps the spec's path parameter names to route param names
+	pathNames map[string]string
+}
+
+// openAPIValidator validates requests against an OpenAPI document
+type openAPIValidator struct {
+	config OpenAPIValidatorConfig
+	doc    *OpenAPIDocument
+	// paths indexes path items by their template with parameter names removed
+	paths   map[string]*PathItem
+	names   map[string][]string
+	regexps sync.Map
+}
+
+// OpenAPIValidator returns a middleware that validates the path parameters,
+// query string, headers and JSON body of each request against the operation
+// in the document that matches the request's route. Invalid requests are
+// passed to the error handler as a *ValidationError with status 400, and
+// bodies larger than MaxBodySize are rejected with 413.
+//
+// Routes are matched by their registered pattern, so /users/:id matches the
+// document path /users/{userId}. Paths are matched after stripping the path
+// of the first server URL.
+func OpenAPIValidator(config OpenAPIValidatorConfig) MiddlewareFunc {
+	if config.Document == nil {
+		panic("router: OpenAPIValidator requires a document")
+	}
+	if config.MaxBodySize <= 0 {
+		config.MaxBodySize = 10 << 20
+	}
+	v := &openAPIValidator{
+		config: config,
+		doc:    config.Document,
+		paths:  make(map[string]*PathItem),
+		names:  make(map[string][]string),
+	}
+
+	base := ""
+	if len(v.doc.Servers) > 0 {
+		if u, err := url.Parse(v.doc.Servers[0].URL); err == nil {
+			base = strings.TrimSuffix(u.Path, "/")
+		}
+	}
+	for path, item := range v.doc.Paths {
+		key, names := templateKey(base + path)
+		v.paths[key] = item
+		v.names[key] = names
+	}
+
+	return func(next HandlerFunc) HandlerFunc {
+		return func(c *Context) {
+			op, status := v.operation(c)
+			if op == nil {
+				if status != 0 && config.RejectUnknown {
+					c.Error(NewHTTPError(status, http.StatusText(status)))
+					return
+				}
+				next(c)
+				return
+			}
+			if err := v.validateRequest(c, op); err != nil {
+				c.Error(err)
+				return
+			}
+			next(c)
+		}
+	}
+}
+
+// templateKey replaces the parameter names of a path template with {} and
+// returns the names in order
+func templateKey(path string) (string, []string) {
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	var names []string
+	for i, part := range parts {
+		if strings.HasPrefix(part, "{") && strings.HasSuffix(part, "}") {
+			names = append(names, part[1:len(part)-1])
+			parts[i] = "{}"
+		} else if strings.HasPrefix(part, ":") {
+			names = append(names, part[1:])
+			parts[i] = "{}"
+		}
+	}
+	return "/" + strings.Join(parts, "/"), names
+}
+
+// operation finds the operation for the request's route. If there is none
+// it returns the status to reject the request with.
+func (v *openAPIValidator) operation(c *Context) (*openAPIOperation, int) {
+	if c.Route() == nil {
+		return nil, http.StatusNotFound
+	}
+	key, routeNames := templateKey(c.FullPath())
+	item, ok := v.paths[key]
+	if !ok {
+		return nil, http.StatusNotFound
+	}
+	op := item.Operation(c.Request.Method)
+	if op == nil {
+		return nil, http.StatusMethodNotAllowed
+	}
+
+	pathNames := make(map[string]string)
+	for i, name := range v.names[key] {
+		if i < len(routeNames) {
+			pathNames[name] = routeNames[i]
+		}
+	}
+
+	// Operation parameters override path item parameters with the same name and location
+	var params []*Parameter
+	seen := make(map[string]bool)
+	for _, p := range op.Parameters {
+		p = v.resolveParameter(p)
+		seen[p.In+":"+p.Name] = true
+		params = append(params, p)
+	}
+	for _, p := range item.Parameters {
+		p = v.resolveParameter(p)
+		if !seen[p.In+":"+p.Name] {
+			params = append(params, p)
+		}
+	}
+	return &openAPIOperation{op: op, parameters: params, pathNames: pathNames}, 0
+}
+
+// validateRequest checks the parameters and body of the request
+func (v *openAPIValidator) validateRequest(c *Context, op *openAPIOperation) error {
+	verr := &ValidationError{}
+	var query url.Values
+
+	for _, p := range op.parameters {
+		var values []string
+		switch p.In {
+		case "path":
+			if value, ok := c.Params[op.pathNames[p.Name]]; ok {
+				values = []string{value}
+			}
+		case "query":
+			if query == nil {
+				query = c.Request.URL.Query()
+			}
+			values = query[p.Name]
+		case "header":
+			values = c.Request.Header.Values(p.Name)
+		case "cookie":
+			if cookie, err := c.Request.Cookie(p.Name); err == nil {
+				values = []string{cookie.Value}
+			}
+		default:
+			continue
+		}
+
+		field := p.In + "." + p.Name
+		if len(values) == 0 {
+			if p.Required {
+				verr.add(field, "required", "is required")
+			}
+			continue
+		}
+		if p.Schema != nil {
+			v.validateValue(p.Schema, coerceParam(v.resolveSchema(p.Schema), values), field, verr)
+		}
+	}
+
+	if !v.config.SkipBody {
+		if err := v.validateBody(c, op.op.RequestBody, verr); err != nil {
+			return err
+		}
+	}
+
+	if len(verr.Fields) > 0 {
+		return verr
+	}
+	return nil
+}
+
+// validateBody checks the request body against the operation's request
+// body. It returns an error if the body cannot be read or is too large.
+func (v *openAPIValidator) validateBody(c *Context, body *RequestBody, verr *ValidationError) error {
+	if body == nil {
+		return nil
+	}
+	body = v.resolveRequestBody(body)
+
+	var data []byte
+	if c.Request.Body != nil && c.Request.Body != http.NoBody {
+		var err error
+		data, err = io.ReadAll(io.LimitReader(c.Request.Body, v.config.MaxBodySize+1))
+		c.Request.Body.Close()
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) || int64(len(data)) > v.config.MaxBodySize {
+			return NewHTTPError(http.StatusRequestEntityTooLarge, "request body too large")
+		}
+		if err != nil {
+			return WrapHTTPError(http.StatusBadRequest, fmt.Errorf("error reading request body: %w", err))
+		}
+		// Restore the body for the handler
+		c.Request.Body = io.NopCloser(bytes.NewReader(data))
+	}
+
+	if len(data) == 0 {
+		if body.Required {
+			verr.add("body", "required", "is required")
+		}
+		return nil
+	}
+	if len(body.Content) == 0 {
+		return nil
+	}
+
+	contentType, _, err := mime.ParseMediaType(c.GetHeader("Content-Type"))
+	if err != nil {
+		contentType = "application/json"
+	}
+	media, ok := matchMediaType(body.Content, contentType)
+	if !ok {
+		verr.add("body", "content-type", fmt.Sprintf("has unsupported content type %q", contentType))
+		return nil
+	}
+	if media == nil || media.Schema == nil || !isJSONMediaType(contentType) {
+		return nil
+	}
+
+	var value interface{}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&value); err != nil {
+		verr.add("body", "json", "is not valid JSON")
+		return nil
+	}
+	v.validateValue(media.Schema, value, "body", verr)
+	return nil
+}
+
+// matchMediaType finds the media type for contentType, allowing wildcards
+func matchMediaType(content map[string]*MediaType, contentType string) (*MediaType, bool) {
+	if media, ok := content[contentType]; ok {
+		return media, true
+	}
+	for pattern, media := range content {
+		if mediaTypeMatches(strings.ToLower(pattern), contentType) {
+			return media, true
+		}
+	}
+	return nil, false
+}
+
+// isJSONMediaType reports whether contentType is JSON or a +json type
+func isJSONMediaType(contentType string) bool {
+	return contentType == "application/json" || strings.HasSuffix(contentType, "+json")
+}
+
+// coerceParam converts parameter strings to the JSON value the schema expects
+func coerceParam(schema *Schema, values []string) interface{} {
+	if schema.Type.Has("array") {
+		if len(values) == 1 && strings.Contains(values[0], ",") {
+			values = strings.Split(values[0], ",")
+		}
+		items := make([]interface{}, len(values))
+		for i, value := range values {
+			items[i] = values[i]
+			if schema.Items != nil {
+				items[i] = coerceScalar(schema.Items, value)
+			}
+		}
+		return items
+	}
+	return coerceScalar(schema, values[0])
+}
+
+// coerceScalar converts a string to a number or boolean if the schema allows it
+func coerceScalar(schema *Schema, value string) interface{} {
+	switch {
+	case schema.Type.Has("integer"), schema.Type.Has("number"):
+		if _, err := strconv.ParseFloat(value, 64); err == nil {
+			return json.Number(value)
+		}
+	case schema.Type.Has("boolean"):
+		if b, err := strconv.ParseBool(value); err == nil {
+			return b
+		}
+	}
+	return value
+}
+
+// resolveParameter follows a $ref to a parameter component
+func (v *openAPIValidator) resolveParameter(p *Parameter) *Parameter {
+	for i := 0; p.Ref != "" && i < 10; i++ {
+		name := strings.TrimPrefix(p.Ref, "#/components/parameters/")
+		if v.doc.Components == nil || v.doc.Components.Parameters[name] == nil {
+			break
+		}
+		p = v.doc.Components.Parameters[name]
+	}
+	return p
+}
+
+// resolveRequestBody follows a $ref to a request body component
+func (v *openAPIValidator) resolveRequestBody(b *RequestBody) *RequestBody {
+	for i := 0; b.Ref != "" && i < 10; i++ {
+		name := strings.TrimPrefix(b.Ref, "#/components/requestBodies/")
+		if v.doc.Components == nil || v.doc.Components.RequestBodies[name] == nil {
+			break
+		}
+		b = v.doc.Components.RequestBodies[name]
+	}
+	return b
+}
+
+// resolveSchema follows a $ref to a schema component
+func (v *openAPIValidator) resolveSchema(s *Schema) *Schema {
+	for i := 0; s.Ref != "" && i < 10; i++ {
+		name := strings.TrimPrefix(s.Ref, "#/components/schemas/")
+		if v.doc.Components == nil || v.doc.Components.Schemas[name] == nil {
+			break
+		}
+		s = v.doc.Components.Schemas[name]
+	}
+	return s
+}
+
+// validateValue checks a decoded JSON value against a schema, adding an
+// error for every violation to verr
+func (v *openAPIValidator) validateValue(schema *Schema, value interface{}, field string, verr *ValidationError) {
+	schema = v.resolveSchema(schema)
+
+	if value == nil {
+		if !schema.Nullable && len(schema.Type) > 0 && !schema.Type.Has("null") {
+			verr.add(field, "type", "must not be null")
+		}
+		return
+	}
+
+	for _, sub := range schema.AllOf {
+		v.validateValue(sub, value, field, verr)
+	}
+	if len(schema.AnyOf) > 0 && v.countMatches(schema.AnyOf, value, field) == 0 {
+		verr.add(field, "anyOf", "does not match any allowed schema")
+	}
+	if len(schema.OneOf) > 0 && v.countMatches(schema.OneOf, value, field) != 1 {
+		verr.add(field, "oneOf", "must match exactly one allowed schema")
+	}
+
+	if len(schema.Type) > 0 && !matchesType(schema.Type, value) {
+		verr.add(field, "type", "must be of type "+strings.Join(schema.Type, " or "))
+		return
+	}
+
+	if len(schema.Enum) > 0 && !inEnum(schema.Enum, value) {
+		verr.add(field, "enum", fmt.Sprintf("must be one of %v", schema.Enum))
+	}
+
+	switch value := value.(type) {
+	case string:
+		v.validateString(schema, value, field, verr)
+	case json.Number:
+		f, _ := value.Float64()
+		validateNumber(schema, f, field, verr)
+	case float64:
+		validateNumber(schema, value, field, verr)
+	case []interface{}:
+		if schema.MinItems != nil && len(value) < *schema.MinItems {
+			verr.add(field, "minItems", fmt.Sprintf("must have at least %d items", *schema.MinItems))
+		}
+		if schema.MaxItems != nil && len(value) > *schema.MaxItems {
+			verr.add(field, "maxItems", fmt.Sprintf("must have at most %d items", *schema.MaxItems))
+		}
+		if schema.Items != nil {
+			for i, item := range value {
+				v.validateValue(schema.Items, item, fmt.Sprintf("%s[%d]", field, i), verr)
+			}
+		}
+	case map[string]interface{}:
+		for _, name := range schema.Required {
+			if _, ok := value[name]; !ok {
+				verr.add(field+"."+name, "required", "is required")
+			}
+		}
+		for name, item := range value {
+			if prop, ok := schema.Properties[name]; ok {
+				v.validateValue(prop, item, field+"."+name, verr)
+			} else if ap := schema.AdditionalProperties; ap != nil {
+				if ap.Schema != nil {
+					v.validateValue(ap.Schema, item, field+"."+name, verr)
+				} else if !ap.Allowed {
+					verr.add(field+"."+name, "additionalProperties", "is not allowed")
+				}
+			}
+		}
+	}
+}
+
+// countMatches returns how many of the schemas value is valid against
+func (v *openAPIValidator) countMatches(schemas []*Schema, value interface{}, field string) int {
+	matches := 0
+	for _, sub := range schemas {
+		sverr := &ValidationError{}
+		v.validateValue(sub, value, field, sverr)
+		if len(sverr.Fields) == 0 {
+			matches++
+		}
+	}
+	return matches
+}
+
+// validateString checks the string keywords of a schema
+func (v *openAPIValidator) validateString(schema *Schema, value, field string, verr *ValidationError) {
+	length := len([]rune(value))
+	if schema.MinLength != nil && length < *schema.MinLength {
+		verr.add(field, "minLength", fmt.Sprintf("must have length at least %d", *schema.MinLength))
+	}
+	if schema.MaxLength != nil && length > *schema.MaxLength {
+		verr.add(field, "maxLength", fmt.Sprintf("must have length at most %d", *schema.MaxLength))
+	}
+	if schema.Pattern != "" {
+		if re := v.compile(schema.Pattern); re != nil && !re.MatchString(value) {
+			verr.add(field, "pattern", fmt.Sprintf("must match pattern %s", schema.Pattern))
+		}
+	}
+	if schema.Format != "" && !validFormat(schema.Format, value) {
+		verr.add(field, "format", "must be a valid "+schema.Format)
+	}
+}
+
+// compile returns the cached regular expression for a schema pattern
+func (v *openAPIValidator) compile(pattern string) *regexp.Regexp {
+	if re, ok := v.regexps.Load(pattern); ok {
+		return re.(*regexp.Regexp)
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil
+	}
+	v.regexps.Store(pattern, re)
+	return re
+}
+
+// validateNumber checks the numeric keywords of a schema
+func validateNumber(schema *Schema, value float64, field string, verr *ValidationError) {
+	if schema.Type.Has("integer") && !schema.Type.Has("number") && value != math.Trunc(value) {
+		verr.add(field, "type", "must be of type integer")
+	}
+	if schema.Minimum != nil {
+		exclusive, _ := schema.ExclusiveMinimum.(bool)
+		if value < *schema.Minimum || (exclusive && value == *schema.Minimum) {
+			verr.add(field, "minimum", fmt.Sprintf("must be at least %v", *schema.Minimum))
+		}
+	}
+	if schema.Maximum != nil {
+		exclusive, _ := schema.ExclusiveMaximum.(bool)
+		if value > *schema.Maximum || (exclusive && value == *schema.Maximum) {
+			verr.add(field, "maximum", fmt.Sprintf("must be at most %v", *schema.Maximum))
+		}
+	}
+	// OpenAPI 3.1 uses numeric exclusive bounds
+	if limit, ok := schema.ExclusiveMinimum.(float64); ok && value <= limit {
+		verr.add(field, "exclusiveMinimum", fmt.Sprintf("must be greater than %v", limit))
+	}
+	if limit, ok := schema.ExclusiveMaximum.(float64); ok && value >= limit {
+		verr.add(field, "exclusiveMaximum", fmt.Sprintf("must be less than %v", limit))
+	}
+}
+
+// matchesType reports whether a decoded JSON value has one of the types
+func matchesType(types SchemaType, value interface{}) bool {
+	for _, t := range types {
+		switch t {
+		case "string":
+			if _, ok := value.(string); ok {
+				return true
+			}
+		case "number":
+			if _, ok := value.(json.Number); ok {
+				return true
+			}
+			if _, ok := value.(float64); ok {
+				return true
+			}
+		case "integer":
+			if n, ok := value.(json.Number); ok {
+				if _, err := n.Int64(); err == nil {
+					return true
+				}
+				if f, err := n.Float64(); err == nil && f == math.Trunc(f) {
+					return true
+				}
+			}
+			if f, ok := value.(float64); ok && f == math.Trunc(f) {
+				return true
+			}
+		case "boolean":
+			if _, ok := value.(bool); ok {
+				return true
+			}
+		case "array":
+			if _, ok := value.([]interface{}); ok {
+				return true
+			}
+		case "object":
+			if _, ok := value.(map[string]interface{}); ok {
+				return true
+			}
+		case "null":
+			if value == nil {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// inEnum reports whether value equals one of the enum values
+func inEnum(enum []interface{}, value interface{}) bool {
+	for _, e := range enum {
+		if fmt.Sprint(e) == fmt.Sprint(value) {
+			return true
+		}
+	}
+	return false
+}
+
+var uuidPattern = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+// validFormat checks the common string formats; unknown formats are accepted
+func validFormat(format, value string) bool {
+	switch format {
+	case "date-time":
+		_, err := time.Parse(time.RFC3339, value)
+		return err == nil
+	case "date":
+		_, err := time.Parse("2006-01-02", value)
+		return err == nil
+	case "email":
+		_, err := mail.ParseAddress(value)
+		return err == nil
+	case "uuid":
+		return uuidPattern.MatchString(value)
+	case "uri":
+		u, err := url.Parse(value)
+		return err == nil && u.Scheme != ""
+	}
+	return true
+}
@@ -0,0 +1,364 @@
+package router
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/sys-apps-go/gorouter/pkg/router/routertest"
+)
+
+// petstoreYAML is a 3.0 document in YAML, with integer response codes,
+// references to components and nullable fields
+const petstoreYAML = `
+openapi: 3.0.3
+info:
+  title: Pets
+  version: "1.0"
+servers:
+  - url: https://api.example.com/v1
+paths:
+  /pets/{petId}:
+    parameters:
+      - $ref: '#/components/parameters/Trace'
+    get:
+      parameters:
+        - name: petId
+          in: path
+          required: true
+          schema: {type: integer, minimum: 1}
+        - name: fields
+          in: query
+          schema:
+            type: array
+            items: {type: string, enum: [name, tag]}
+        - name: verbose
+          in: query
+          schema: {type: boolean}
+        - name: session
+          in: cookie
+          schema: {type: string, minLength: 4}
+      responses:
+        200:
+          description: OK
+    put:
+      requestBody:
+        $ref: '#/components/requestBodies/Pet'
+      responses:
+        204:
+          description: Updated
+  /pets:
+    post:
+      requestBody:
+        required: true
+        content:
+          application/json:
+            schema: {$ref: '#/components/schemas/Pet'}
+          text/plain: {}
+      responses:
+        201:
+          description: Created
+components:
+  parameters:
+    Trace:
+      name: X-Trace-Id
+      in: header
+      required: true
+      schema: {type: string, format: uuid}
+  requestBodies:
+    Pet:
+      required: true
+      content:
+        application/*:
+          schema: {$ref: '#/components/schemas/Pet'}
+  schemas:
+    Pet:
+      type: object
+      required: [name]
+      additionalProperties: false
+      properties:
+        name: {type: string, maxLength: 8}
+        tag:
+          type: string
+          nullable: true
+        born: {type: string, format: date}
+        weight: {type: number, exclusiveMinimum: true, minimum: 0}
+        owner:
+          oneOf:
+            - {type: string, format: email}
+            - {type: integer}
+      example:
+        born: 2020-01-02
+`
+
+// validatorRouter returns a router mounted under /v1 that validates
+// requests against the pet store document
+func validatorRouter(t *testing.T, config OpenAPIValidatorConfig) *Router {
+	t.Helper()
+	if config.Document == nil {
+		doc, err := ParseOpenAPI([]byte(petstoreYAML))
+		if err != nil {
+			t.Fatal(err)
+		}
+		config.Document = doc
+	}
+	r := NewRouter()
+	r.Use(OpenAPIValidator(config))
+	v1 := r.Group("/v1")
+	v1.GET("/pets/:id", func(c *Context) { c.String(http.StatusOK, "pet %s", c.Param("id")) })
+	v1.PUT("/pets/:id", func(c *Context) {
+		// The body read by the validator is still readable
+		body, _ := io.ReadAll(c.Request.Body)
+		c.Data(http.StatusOK, "application/json", body)
+	})
+	v1.DELETE("/pets/:id", func(c *Context) { c.Status(http.StatusNoContent) })
+	v1.POST("/pets", func(c *Context) { c.Status(http.StatusCreated) })
+	v1.GET("/health", func(c *Context) { c.String(http.StatusOK, "ok") })
+	return r
+}
+
+// invalidFields returns the sorted field:rule pairs of a validation error response
+func invalidFields(resp *routertest.Response) []string {
+	var body struct {
+		Fields []FieldError `json:"fields"`
+	}
+	resp.Decode(&body)
+	var fields []string
+	for _, f := range body.Fields {
+		fields = append(fields, f.Field+":"+f.Rule)
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+const traceID = "0190b1a4-7c2e-7d3f-9a1b-2c3d4e5f6a7b"
+
+func TestOpenAPIValidator(t *testing.T) {
+	tests := []struct {
+		name        string
+		method      string
+		path        string
+		header      map[string]string
+		cookie      string
+		contentType string
+		body        string
+		status      int
+		fields      []string
+	}{
+		{name: "valid", method: "GET", path: "/v1/pets/7?fields=name,tag&verbose=true", header: map[string]string{"X-Trace-Id": traceID}, cookie: "abcd", status: http.StatusOK},
+		{name: "missing path item header", method: "GET", path: "/v1/pets/7", status: http.StatusBadRequest, fields: []string{"header.X-Trace-Id:required"}},
+		{name: "bad header format", method: "GET", path: "/v1/pets/7", header: map[string]string{"X-Trace-Id": "x"}, status: http.StatusBadRequest, fields: []string{"header.X-Trace-Id:format"}},
+		{
+			name:   "bad parameters",
+			method: "GET",
+			path:   "/v1/pets/0?fields=name,age&verbose=maybe",
+			header: map[string]string{"X-Trace-Id": traceID},
+			cookie: "abc",
+			status: http.StatusBadRequest,
+			fields: []string{"cookie.session:minLength", "path.petId:minimum", "query.fields[1]:enum", "query.verbose:type"},
+		},
+		{name: "non-integer path", method: "GET", path: "/v1/pets/x", header: map[string]string{"X-Trace-Id": traceID}, status: http.StatusBadRequest, fields: []string{"path.petId:type"}},
+		{name: "valid body", method: "POST", path: "/v1/pets", contentType: "application/json", body: `{"name":"rex","tag":null,"born":"2020-01-02","weight":3.5,"owner":"ann@example.com"}`, status: http.StatusCreated},
+		{name: "missing body", method: "POST", path: "/v1/pets", status: http.StatusBadRequest, fields: []string{"body:required"}},
+		{
+			name:        "invalid body",
+			method:      "POST",
+			path:        "/v1/pets",
+			contentType: "application/json; charset=utf-8",
+			body:        `{"name":"a very long name","born":"yesterday","weight":0,"owner":true,"color":"red"}`,
+			status:      http.StatusBadRequest,
+			fields:      []string{"body.born:format", "body.color:additionalProperties", "body.name:maxLength", "body.owner:oneOf", "body.weight:minimum"},
+		},
+		{name: "missing required property", method: "POST", path: "/v1/pets", contentType: "application/json", body: `{"tag":"x"}`, status: http.StatusBadRequest, fields: []string{"body.name:required"}},
+		{name: "null without nullable", method: "POST", path: "/v1/pets", contentType: "application/json", body: `{"name":null}`, status: http.StatusBadRequest, fields: []string{"body.name:type"}},
+		{name: "not JSON", method: "POST", path: "/v1/pets", contentType: "application/json", body: `{"name":`, status: http.StatusBadRequest, fields: []string{"body:json"}},
+		{name: "unsupported content type", method: "POST", path: "/v1/pets", contentType: "application/xml", body: `<pet/>`, status: http.StatusBadRequest, fields: []string{"body:content-type"}},
+		{name: "non-JSON content type is not parsed", method: "POST", path: "/v1/pets", contentType: "text/plain", body: `rex`, status: http.StatusCreated},
+		{name: "referenced body with wildcard", method: "PUT", path: "/v1/pets/7", header: map[string]string{"X-Trace-Id": traceID}, contentType: "application/merge-patch+json", body: `{"name":1}`, status: http.StatusBadRequest, fields: []string{"body.name:type"}},
+		{name: "undocumented method passes", method: "DELETE", path: "/v1/pets/7", status: http.StatusNoContent},
+		{name: "undocumented route passes", method: "GET", path: "/v1/health", status: http.StatusOK},
+	}
+	r := validatorRouter(t, OpenAPIValidatorConfig{})
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := routertest.New(t, r).Request(tt.method, tt.path)
+			for name, value := range tt.header {
+				req.Header(name, value)
+			}
+			if tt.cookie != "" {
+				req.Cookie(&http.Cookie{Name: "session", Value: tt.cookie})
+			}
+			if tt.body != "" {
+				req.Body(tt.contentType, []byte(tt.body))
+			}
+			resp := req.Expect().Status(tt.status)
+			var fields []string
+			if tt.status == http.StatusBadRequest {
+				fields = invalidFields(resp)
+			}
+			if !reflect.DeepEqual(fields, tt.fields) {
+				t.Fatalf("fields = %v, want %v", fields, tt.fields)
+			}
+		})
+	}
+}
+
+func TestOpenAPIValidatorBody(t *testing.T) {
+	r := validatorRouter(t, OpenAPIValidatorConfig{MaxBodySize: 32})
+	rt := routertest.New(t, r)
+	body := `{"name":"rex"}`
+	rt.PUT("/v1/pets/7").Header("X-Trace-Id", traceID).Body("application/json", []byte(body)).Expect().
+		Status(http.StatusOK).
+		BodyEquals(body)
+
+	large := `{"name":"rex","tag":"` + strings.Repeat("x", 32) + `"}`
+	rt.POST("/v1/pets").Body("application/json", []byte(large)).Expect().
+		Status(http.StatusRequestEntityTooLarge).
+		JSONPath("$.error", "request body too large")
+
+	// Without body validation the size limit is not applied either
+	r = validatorRouter(t, OpenAPIValidatorConfig{MaxBodySize: 32, SkipBody: true})
+	routertest.New(t, r).POST("/v1/pets").Body("application/json", []byte(large)).Expect().Status(http.StatusCreated)
+}
+
+func TestOpenAPIValidatorRejectUnknown(t *testing.T) {
+	r := validatorRouter(t, OpenAPIValidatorConfig{RejectUnknown: true})
+	rt := routertest.New(t, r)
+	rt.GET("/v1/health").Expect().Status(http.StatusNotFound)
+	rt.DELETE("/v1/pets/7").Expect().Status(http.StatusMethodNotAllowed)
+	rt.GET("/v1/pets/7").Header("X-Trace-Id", traceID).Expect().Status(http.StatusOK).BodyEquals("pet 7")
+}
+
+func TestOpenAPIValidatorNullTypes(t *testing.T) {
+	// 3.1 documents list null in the type instead of using nullable
+	doc, err := ParseOpenAPI([]byte(`{
+		"openapi": "3.1.0",
+		"info": {"title": "Pets", "version": "1"},
+		"paths": {"/pets": {"post": {
+			"requestBody": {"content": {"application/json": {"schema": {
+				"type": "object",
+				"properties": {
+					"tag": {"type": ["string", "null"]},
+					"name": {"type": "string"},
+					"weight": {"type": "number", "exclusiveMinimum": 0}
+				}
+			}}}},
+			"responses": {"201": {"description": "Created"}}
+		}}}
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := NewRouter()
+	r.Use(OpenAPIValidator(OpenAPIValidatorConfig{Document: doc}))
+	r.POST("/pets", func(c *Context) { c.Status(http.StatusCreated) })
+	rt := routertest.New(t, r)
+
+	rt.POST("/pets").Body("application/json", []byte(`{"tag":null,"name":"rex","weight":1}`)).Expect().Status(http.StatusCreated)
+	rt.POST("/pets").Body("application/json", []byte(`{"name":null}`)).Expect().
+		Status(http.StatusBadRequest).
+		JSONPath("$.fields[0].field", "body.name")
+	rt.POST("/pets").Body("application/json", []byte(`{"weight":0}`)).Expect().
+		Status(http.StatusBadRequest).
+		JSONPath("$.fields[0].rule", "exclusiveMinimum")
+	// The body is optional
+	rt.POST("/pets").Expect().Status(http.StatusCreated)
+}
+
+func TestParseOpenAPI(t *testing.T) {
+	doc, err := ParseOpenAPI([]byte(petstoreYAML))
+	if err != nil {
+		t.Fatal(err)
+	}
+	op := doc.Paths["/pets/{petId}"].Get
+	if op == nil || op.Responses["200"] == nil {
+		t.Fatalf("integer response codes not read: %+v", op)
+	}
+	pet := doc.Components.Schemas["Pet"]
+	if !pet.Properties["tag"].Nullable || pet.AdditionalProperties == nil || pet.AdditionalProperties.Allowed {
+		t.Fatalf("Pet = %+v", pet)
+	}
+
+	tests := []struct {
+		name string
+		doc  string
+		err  string
+	}{
+		{"swagger 2", `{"swagger": "2.0", "paths": {}}`, `unsupported OpenAPI version ""`},
+		{"version 4", "openapi: 4.0.0\npaths: {}\n", `unsupported OpenAPI version "4.0.0"`},
+		{"invalid JSON", `{"openapi": "3.1.0",`, "error parsing OpenAPI document"},
+		{"invalid YAML", "openapi: [3.1.0\n", "error parsing OpenAPI document"},
+		{"infinite number", "openapi: 3.1.0\ncomponents: {schemas: {N: {maximum: .inf}}}\n", "unsupported number"},
+		{"missing component", "openapi: 3.1.0\npaths:\n  /a:\n    get:\n      responses:\n        200: {$ref: '#/components/responses/Missing'}\n", `unsupported OpenAPI reference "#/components/responses/Missing"`},
+		{"external file", "openapi: 3.1.0\ncomponents: {schemas: {A: {$ref: 'other.yaml#/B'}}}\n", `unsupported OpenAPI reference "other.yaml#/B"`},
+		{"non-component pointer", "openapi: 3.1.0\ncomponents: {schemas: {A: {$ref: '#/paths/~1a'}}}\npaths: {/a: {}}\n", `unsupported OpenAPI reference "#/paths/~1a"`},
+		{"nested pointer", "openapi: 3.1.0\ncomponents: {schemas: {A: {type: object, properties: {b: {}}}, C: {$ref: '#/components/schemas/A/properties/b'}}}\n", "unsupported OpenAPI reference"},
+		{"escaped name", "openapi: 3.1.0\ncomponents: {schemas: {a/b: {}, C: {$ref: '#/components/schemas/a~1b'}}}\n", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseOpenAPI([]byte(tt.doc))
+			if tt.err == "" {
+				if err != nil {
+					t.Fatalf("ParseOpenAPI() error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("ParseOpenAPI() error = %v, want %s", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestLoadOpenAPI(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "openapi.yaml")
+	if err := os.WriteFile(path, []byte(petstoreYAML), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	doc, err := LoadOpenAPI(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if doc.Info.Title != "Pets" {
+		t.Fatalf("title = %q", doc.Info.Title)
+	}
+	if _, err := LoadOpenAPI(filepath.Join(t.TempDir(), "missing.yaml")); err == nil || !strings.Contains(err.Error(), "error reading OpenAPI document") {
+		t.Fatalf("LoadOpenAPI() error = %v for a missing file", err)
+	}
+}
+
+func TestOpenAPIValidatorGenerated(t *testing.T) {
+	// A document generated from the router validates the router's requests
+	r := NewRouter()
+	r.PUT("/users/:id", Typed(func(ctx context.Context, req updateUserRequest) (userResponse, error) {
+		return userResponse{ID: req.ID, Name: req.Name}, nil
+	}))
+	doc := r.OpenAPI(OpenAPIInfo{Title: "Users"})
+
+	v := NewRouter()
+	v.Use(OpenAPIValidator(OpenAPIValidatorConfig{Document: doc}))
+	v.PUT("/users/:id", func(c *Context) { c.Status(http.StatusNoContent) })
+	rt := routertest.New(t, v)
+	rt.PUT("/users/1?notify=true").JSON(map[string]interface{}{"name": "ann", "role": "admin", "age": nil}).Expect().
+		Status(http.StatusNoContent)
+	resp := rt.PUT("/users/x").JSON(map[string]interface{}{"role": "owner"}).Expect().Status(http.StatusBadRequest)
+	if fields, want := invalidFields(resp), []string{"body.name:required", "body.role:enum", "path.id:type"}; !reflect.DeepEqual(fields, want) {
+		t.Fatalf("fields = %v, want %v", fields, want)
+	}
+}
+
+func TestOpenAPIValidatorPanics(t *testing.T) {
+	defer func() {
+		if recovered := recover(); recovered != "router: OpenAPIValidator requires a document" {
+			t.Fatalf("recovered %#v", recovered)
+		}
+	}()
+	OpenAPIValidator(OpenAPIValidatorConfig{})
+}
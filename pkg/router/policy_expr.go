@@ -0,0 +1,501 @@
+package router
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Expr compiles an attribute-based policy from a boolean expression over
+// the request and subject, for example
+//
+//	subject.id == param.user_id || "admin" in subject.roles
+//
+// Names are resolved as follows:
+//
+//	subject.id, subject.roles, subject.scopes, subject.permissions
+//	subject.<attr>    an entry of Subject.Attrs, such as a JWT claim
+//	param.<name>      a path parameter
+//	query.<name>      a query parameter
+//	header.<name>     a request header
+//	request.method, request.path, request.ip
+//
+// Literals are double-quoted strings, numbers, true, false, null and
+// lists like ["a", "b"]. The operators are == != < <= > >= in, && || and
+// ! with parentheses for grouping. Strings that hold numbers compare
+// numerically with numbers, and in tests membership of a list.
+//
+// Names that are missing or empty, such as an absent header or claim, make
+// every comparison they take part in unknown, including != and in. Unknown
+// stays unknown through !, and through && and || unless the other operand
+// decides the result, as in false && unknown or true || unknown. Policies
+// that evaluate to unknown deny access, so a policy cannot be passed by
+// leaving out an attribute.
+func Expr(expression string) (Policy, error) {
+	p := &exprParser{source: expression}
+	if err := p.tokenize(); err != nil {
+		return nil, fmt.Errorf("policy %q: %w", expression, err)
+	}
+	root, err := p.parseOr()
+	if err == nil && p.pos < len(p.tokens) {
+		err = fmt.Errorf("unexpected %q", p.tokens[p.pos].text)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("policy %q: %w", expression, err)
+	}
+	return exprPolicy{root: root}, nil
+}
+
+// MustExpr is like Expr but panics if the expression is invalid
+func MustExpr(expression string) Policy {
+	policy, err := Expr(expression)
+	if err != nil {
+		panic("router: " + err.Error())
+	}
+	return policy
+}
+
+// exprPolicy is a compiled expression
+type exprPolicy struct {
+	root exprNode
+}
+
+// Allow evaluates the expression; results other than true deny access
+func (p exprPolicy) Allow(c *Context, subject *Subject) (bool, error) {
+	allowed, _ := p.root(&exprEnv{c: c, subject: subject}).(bool)
+	return allowed, nil
+}
+
+// exprEnv is what an expression is evaluated against
+type exprEnv struct {
+	c       *Context
+	subject *Subject
+}
+
+// exprNode evaluates part of an expression
+type exprNode func(env *exprEnv) interface{}
+
+type exprTokenKind int
+
+const (
+	tokenIdent exprTokenKind = iota
+	tokenString
+	tokenNumber
+	tokenOperator
+)
+
+type exprToken struct {
+	kind exprTokenKind
+	text string
+}
+
+// exprParser is a recursive descent parser for policy expressions
+type exprParser struct {
+	source string
+	tokens []exprToken
+	pos    int
+}
+
+// tokenize splits the source into tokens
+func (p *exprParser) tokenize() error {
+	s := p.source
+	for i := 0; i < len(s); {
+		ch := rune(s[i])
+		switch {
+		case unicode.IsSpace(ch):
+			i++
+		case ch == '"':
+			end := i + 1
+			for end < len(s) && s[end] != '"' {
+				if s[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(s) {
+				return fmt.Errorf("unterminated string")
+			}
+			text, err := strconv.Unquote(s[i : end+1])
+			if err != nil {
+				return fmt.Errorf("invalid string %s", s[i:end+1])
+			}
+			p.tokens = append(p.tokens, exprToken{tokenString, text})
+			i = end + 1
+		case unicode.IsDigit(ch) || (ch == '-' && i+1 < len(s) && unicode.IsDigit(rune(s[i+1]))):
+			end := i + 1
+			for end < len(s) && (unicode.IsDigit(rune(s[end])) || s[end] == '.') {
+				end++
+			}
+			p.tokens = append(p.tokens, exprToken{tokenNumber, s[i:end]})
+			i = end
+		case unicode.IsLetter(ch) || ch == '_':
+			end := i + 1
+			for end < len(s) && (unicode.IsLetter(rune(s[end])) || unicode.IsDigit(rune(s[end])) || strings.IndexByte("_.-", s[end]) >= 0) {
+				end++
+			}
+			p.tokens = append(p.tokens, exprToken{tokenIdent, s[i:end]})
+			i = end
+		default:
+			op := ""
+			for _, candidate := range []string{"==", "!=", "<=", ">=", "&&", "||", "<", ">", "!", "(", ")", "[", "]", ","} {
+				if strings.HasPrefix(s[i:], candidate) {
+					op = candidate
+					break
+				}
+			}
+			if op == "" {
+				return fmt.Errorf("unexpected character %q", ch)
+			}
+			p.tokens = append(p.tokens, exprToken{tokenOperator, op})
+			i += len(op)
+		}
+	}
+	return nil
+}
+
+// peek returns the current token text, or "" at the end
+func (p *exprParser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos].text
+	}
+	return ""
+}
+
+// accept consumes the current token if it is the operator op
+func (p *exprParser) accept(op string) bool {
+	if p.pos < len(p.tokens) && p.tokens[p.pos].kind != tokenString && p.tokens[p.pos].text == op {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *exprParser) parseOr() (exprNode, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("||") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(env *exprEnv) interface{} {
+			return exprOr(l(env), right(env))
+		}
+	}
+	return left, nil
+}
+
+func (p *exprParser) parseAnd() (exprNode, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("&&") {
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(env *exprEnv) interface{} {
+			return exprAnd(l(env), right(env))
+		}
+	}
+	return left, nil
+}
+
+func (p *exprParser) parseNot() (exprNode, error) {
+	if p.accept("!") {
+		operand, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return func(env *exprEnv) interface{} {
+			value := operand(env)
+			if value == (exprMissing{}) {
+				return exprMissing{}
+			}
+			return !truthy(value)
+		}, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *exprParser) parseComparison() (exprNode, error) {
+	left, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	op := p.peek()
+	switch op {
+	case "==", "!=", "<", "<=", ">", ">=", "in":
+		if p.tokens[p.pos].kind == tokenString {
+			return left, nil
+		}
+		p.pos++
+	default:
+		return left, nil
+	}
+	right, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	return func(env *exprEnv) interface{} {
+		a, b := left(env), right(env)
+		if a == (exprMissing{}) || b == (exprMissing{}) {
+			return exprMissing{}
+		}
+		return compareValues(op, a, b)
+	}, nil
+}
+
+func (p *exprParser) parsePrimary() (exprNode, error) {
+	if p.pos >= len(p.tokens) {
+		return nil, fmt.Errorf("unexpected end of expression")
+	}
+	tok := p.tokens[p.pos]
+	p.pos++
+	switch tok.kind {
+	case tokenString:
+		return constant(tok.text), nil
+	case tokenNumber:
+		f, err := strconv.ParseFloat(tok.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q", tok.text)
+		}
+		return constant(f), nil
+	case tokenIdent:
+		switch tok.text {
+		case "true":
+			return constant(true), nil
+		case "false":
+			return constant(false), nil
+		case "null":
+			return constant(nil), nil
+		}
+		return resolver(tok.text)
+	}
+
+	switch tok.text {
+	case "(":
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if !p.accept(")") {
+			return nil, fmt.Errorf("missing )")
+		}
+		return inner, nil
+	case "[":
+		var items []exprNode
+		for !p.accept("]") {
+			if len(items) > 0 && !p.accept(",") {
+				return nil, fmt.Errorf("expected , or ] in list")
+			}
+			item, err := p.parsePrimary()
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+		}
+		return func(env *exprEnv) interface{} {
+			list := make([]interface{}, len(items))
+			for i, item := range items {
+				list[i] = item(env)
+			}
+			return list
+		}, nil
+	}
+	return nil, fmt.Errorf("unexpected %q", tok.text)
+}
+
+// exprMissing is the value of names that are missing or empty, and the
+// unknown result of comparisons and logic over them
+type exprMissing struct{}
+
+// present returns s, or exprMissing{} if it is empty
+func present(s string) interface{} {
+	if s == "" {
+		return exprMissing{}
+	}
+	return s
+}
+
+// constant returns a node that always evaluates to v
+func constant(v interface{}) exprNode {
+	return func(*exprEnv) interface{} { return v }
+}
+
+// resolver returns a node that looks up a dotted name
+func resolver(name string) (exprNode, error) {
+	scope, key, _ := strings.Cut(name, ".")
+	if key == "" {
+		return nil, fmt.Errorf("unknown name %q", name)
+	}
+	switch scope {
+	case "subject":
+		return func(env *exprEnv) interface{} {
+			s := env.subject
+			if s == nil {
+				return exprMissing{}
+			}
+			switch key {
+			case "id":
+				return present(s.ID)
+			case "roles":
+				return s.Roles
+			case "scopes":
+				return s.Scopes
+			case "permissions":
+				return s.Permissions
+			}
+			value, ok := s.Attrs[key]
+			if !ok || value == "" {
+				return exprMissing{}
+			}
+			return value
+		}, nil
+	case "param":
+		return func(env *exprEnv) interface{} { return present(env.c.Param(key)) }, nil
+	case "query":
+		return func(env *exprEnv) interface{} { return present(env.c.Query(key)) }, nil
+	case "header":
+		return func(env *exprEnv) interface{} { return present(env.c.GetHeader(key)) }, nil
+	case "request":
+		switch key {
+		case "method":
+			return func(env *exprEnv) interface{} { return env.c.Request.Method }, nil
+		case "path":
+			return func(env *exprEnv) interface{} { return env.c.Request.URL.Path }, nil
+		case "ip":
+			return func(env *exprEnv) interface{} { return env.c.ClientIP() }, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown name %q", name)
+}
+
+// truthy reports whether v is the boolean true
+func truthy(v interface{}) bool {
+	b, _ := v.(bool)
+	return b
+}
+
+// exprAnd is && over true, false and unknown: false if either operand is
+// false, otherwise unknown if either is unknown
+func exprAnd(a, b interface{}) interface{} {
+	if (a != (exprMissing{}) && !truthy(a)) || (b != (exprMissing{}) && !truthy(b)) {
+		return false
+	}
+	if a == (exprMissing{}) || b == (exprMissing{}) {
+		return exprMissing{}
+	}
+	return true
+}
+
+// exprOr is || over true, false and unknown: true if either operand is
+// true, otherwise unknown if either is unknown
+func exprOr(a, b interface{}) interface{} {
+	if truthy(a) || truthy(b) {
+		return true
+	}
+	if a == (exprMissing{}) || b == (exprMissing{}) {
+		return exprMissing{}
+	}
+	return false
+}
+
+// compareValues applies a comparison operator to two present values
+func compareValues(op string, a, b interface{}) bool {
+	switch op {
+	case "==":
+		return looseEqual(a, b)
+	case "!=":
+		return !looseEqual(a, b)
+	case "in":
+		rv := reflect.ValueOf(b)
+		if rv.Kind() != reflect.Slice {
+			return false
+		}
+		for i := 0; i < rv.Len(); i++ {
+			if looseEqual(a, rv.Index(i).Interface()) {
+				return true
+			}
+		}
+		return false
+	}
+
+	var cmp int
+	if x, ok := toNumber(a); ok {
+		y, ok := toNumber(b)
+		if !ok {
+			return false
+		}
+		switch {
+		case x < y:
+			cmp = -1
+		case x > y:
+			cmp = 1
+		}
+	} else {
+		x, ok1 := a.(string)
+		y, ok2 := b.(string)
+		if !ok1 || !ok2 {
+			return false
+		}
+		cmp = strings.Compare(x, y)
+	}
+	switch op {
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	}
+	return cmp >= 0
+}
+
+// looseEqual compares values, treating numeric strings as numbers when
+// compared with a number
+func looseEqual(a, b interface{}) bool {
+	if _, ok := a.(float64); ok {
+		if y, ok := toNumber(b); ok {
+			x, _ := toNumber(a)
+			return x == y
+		}
+	}
+	if _, ok := b.(float64); ok {
+		if x, ok := toNumber(a); ok {
+			y, _ := toNumber(b)
+			return x == y
+		}
+	}
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	ta, tb := reflect.TypeOf(a), reflect.TypeOf(b)
+	if !ta.Comparable() || !tb.Comparable() {
+		return false
+	}
+	return a == b
+}
+
+// toNumber converts numbers and numeric strings to float64
+func toNumber(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case string:
+		f, err := strconv.ParseFloat(n, 64)
+		return f, err == nil
+	}
+	return 0, false
+}
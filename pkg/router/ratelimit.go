@@ -0,0 +1,394 @@
+package router
+
+import (
+	"context"
+	"hash/fnv"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// RateLimitAlgorithm selects how requests are counted against a limit
+type RateLimitAlgorithm int
+
+const (
+	// SlidingWindow counts requests in the current and previous window and
+	// weights the previous one by how much of it still overlaps
+	SlidingWindow RateLimitAlgorithm = iota
+	// TokenBucket refills Burst tokens at Rate per Period; each request takes one
+	TokenBucket
+	// GCRA is the generic cell rate algorithm, a token bucket that only
+	// stores a single timestamp per key
+	GCRA
+)
+
+// String returns the name of the algorithm
+func (a RateLimitAlgorithm) String() string {
+	switch a {
+	case SlidingWindow:
+		return "sliding-window"
+	case TokenBucket:
+		return "token-bucket"
+	case GCRA:
+		return "gcra"
+	}
+	return "unknown"
+}
+
+// Limit describes how many requests a key may make
+type Limit struct {
+	Algorithm RateLimitAlgorithm
+	// Rate is the number of requests allowed per Period
+	Rate   int
+	Period time.Duration
+	// Burst is the number of requests that may be made at once by the token
+	// bucket and GCRA algorithms. It defaults to Rate.
+	Burst int
+}
+
+// burst returns the effective burst size
+func (l Limit) burst() int {
+	if l.Burst > 0 {
+		return l.Burst
+	}
+	return l.Rate
+}
+
+// interval returns the time it takes to earn one request
+func (l Limit) interval() time.Duration {
+	return l.Period / time.Duration(l.Rate)
+}
+
+// RateLimitResult is the outcome of counting a request against a limit
+type RateLimitResult struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// ResetAfter is the time until the key is back at its full allowance
+	ResetAfter time.Duration
+	// RetryAfter is the time until the next request would be allowed; it
+	// is zero for allowed requests
+	RetryAfter time.Duration
+}
+
+// RateLimitStore keeps rate limit state. Implementations must apply Take
+// atomically so one store can be shared by many goroutines or instances.
+type RateLimitStore interface {
+	Take(ctx context.Context, key string, limit Limit) (RateLimitResult, error)
+}
+
+// rateState is the per-key state of every algorithm. Stamp is the window
+// start for the sliding window, the last refill for the token bucket and
+// the theoretical arrival time for GCRA.
+type rateState struct {
+	Stamp     time.Time
+	Count     int64
+	PrevCount int64
+	Tokens    float64
+}
+
+// take counts one request at time now against state and returns the
+// decision together with the time the state can be discarded
+func (l Limit) take(state *rateState, now time.Time) (RateLimitResult, time.Time) {
+	switch l.Algorithm {
+	case TokenBucket:
+		return l.takeTokenBucket(state, now)
+	case GCRA:
+		return l.takeGCRA(state, now)
+	default:
+		return l.takeSlidingWindow(state, now)
+	}
+}
+
+// takeSlidingWindow applies the sliding window counter algorithm
+func (l Limit) takeSlidingWindow(state *rateState, now time.Time) (RateLimitResult, time.Time) {
+	windowStart := now.Truncate(l.Period)
+	if !state.Stamp.Equal(windowStart) {
+		if state.Stamp.Add(l.Period).Equal(windowStart) {
+			state.PrevCount = state.Count
+		} else {
+			state.PrevCount = 0
+		}
+		state.Count = 0
+		state.Stamp = windowStart
+	}
+
+	elapsed := now.Sub(windowStart)
+	weight := 1 - float64(elapsed)/float64(l.Period)
+	estimated := float64(state.PrevCount)*weight + float64(state.Count)
+	resetAfter := l.Period - elapsed
+	expires := windowStart.Add(2 * l.Period)
+
+	result := RateLimitResult{Limit: l.Rate, ResetAfter: resetAfter}
+	if estimated+1 > float64(l.Rate) {
+		// Wait until enough of the previous window has slid out, or for
+		// the next window if the current one alone is full
+		retry := resetAfter
+		if free := float64(l.Rate-1) - float64(state.Count); state.PrevCount > 0 && free >= 0 {
+			t := time.Duration(float64(l.Period) * (1 - free/float64(state.PrevCount)))
+			if t > elapsed {
+				retry = t - elapsed
+			}
+		}
+		result.RetryAfter = retry
+		return result, expires
+	}
+
+	state.Count++
+	result.Allowed = true
+	result.Remaining = int(math.Max(0, math.Floor(float64(l.Rate)-estimated-1)))
+	return result, expires
+}
+
+// takeTokenBucket applies the token bucket algorithm
+func (l Limit) takeTokenBucket(state *rateState, now time.Time) (RateLimitResult, time.Time) {
+	capacity := float64(l.burst())
+	perToken := float64(l.interval())
+
+	if state.Stamp.IsZero() {
+		state.Tokens = capacity
+	} else if elapsed := now.Sub(state.Stamp); elapsed > 0 {
+		state.Tokens = math.Min(capacity, state.Tokens+float64(elapsed)/perToken)
+	}
+	state.Stamp = now
+
+	result := RateLimitResult{Limit: l.burst()}
+	if state.Tokens < 1 {
+		result.RetryAfter = time.Duration((1 - state.Tokens) * perToken)
+	} else {
+		state.Tokens--
+		result.Allowed = true
+	}
+	result.Remaining = int(state.Tokens)
+	result.ResetAfter = time.Duration((capacity - state.Tokens) * perToken)
+	return result, now.Add(result.ResetAfter)
+}
+
+// takeGCRA applies the generic cell rate algorithm
+func (l Limit) takeGCRA(state *rateState, now time.Time) (RateLimitResult, time.Time) {
+	interval := l.interval()
+	burstOffset := interval * time.Duration(l.burst())
+
+	tat := state.Stamp
+	if tat.Before(now) {
+		tat = now
+	}
+	newTAT := tat.Add(interval)
+	allowAt := newTAT.Add(-burstOffset)
+
+	result := RateLimitResult{Limit: l.burst()}
+	if now.Before(allowAt) {
+		result.RetryAfter = allowAt.Sub(now)
+		result.ResetAfter = tat.Sub(now)
+		return result, tat
+	}
+
+	state.Stamp = newTAT
+	result.Allowed = true
+	result.Remaining = int((burstOffset - newTAT.Sub(now)) / interval)
+	result.ResetAfter = newTAT.Sub(now)
+	return result, newTAT
+}
+
+// rateLimitShards is the number of independently locked shards in a
+// MemoryRateLimitStore
+const rateLimitShards = 64
+
+// MemoryRateLimitStore keeps rate limit state in memory. Keys are spread
+// over sharded maps so concurrent requests rarely contend for a lock, and
+// expired keys are swept lazily without a background goroutine.
+type MemoryRateLimitStore struct {
+	shards [rateLimitShards]rateLimitShard
+	now    func() time.Time
+}
+
+type rateLimitShard struct {
+	mu        sync.Mutex
+	entries   map[string]*rateEntry
+	nextSweep time.Time
+}
+
+type rateEntry struct {
+	state   rateState
+	expires time.Time
+}
+
+// NewMemoryRateLimitStore returns an empty in-memory store
+func NewMemoryRateLimitStore() *MemoryRateLimitStore {
+	s := &MemoryRateLimitStore{now: time.Now}
+	for i := range s.shards {
+		s.shards[i].entries = make(map[string]*rateEntry)
+	}
+	return s
+}
+
+// Take counts one request for key against limit
+func (s *MemoryRateLimitStore) Take(ctx context.Context, key string, limit Limit) (RateLimitResult, error) {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	shard := &s.shards[h.Sum32()%rateLimitShards]
+	now := s.now()
+
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	if now.After(shard.nextSweep) {
+		for k, e := range shard.entries {
+			if now.After(e.expires) {
+				delete(shard.entries, k)
+			}
+		}
+		shard.nextSweep = now.Add(time.Minute)
+	}
+
+	entry, ok := shard.entries[key]
+	if !ok || now.After(entry.expires) {
+		entry = &rateEntry{}
+		shard.entries[key] = entry
+	}
+	result, expires := limit.take(&entry.state, now)
+	entry.expires = expires
+	return result, nil
+}
+
+// KeyFunc extracts the key a request is rate limited by
+type KeyFunc func(*Context) string
+
+// KeyByIP keys requests by client IP address, as returned by Context.ClientIP
+func KeyByIP() KeyFunc {
+	return func(c *Context) string {
+		return c.ClientIP()
+	}
+}
+
+// KeyByHeader keys requests by the value of a request header
+func KeyByHeader(name string) KeyFunc {
+	return func(c *Context) string {
+		return c.GetHeader(name)
+	}
+}
+
+// KeyByAPIKey keys requests by an API key read from a header or, if the
+// header is absent, from a query parameter
+func KeyByAPIKey(header, queryParam string) KeyFunc {
+	return func(c *Context) string {
+		if key := c.GetHeader(header); key != "" {
+			return key
+		}
+		if queryParam != "" {
+			return c.Query(queryParam)
+		}
+		return ""
+	}
+}
+
+// KeyByRoute keys requests by method and route pattern, so every route has
+// its own limit shared by all clients
+func KeyByRoute() KeyFunc {
+	return func(c *Context) string {
+		return c.Request.Method + " " + c.FullPath()
+	}
+}
+
+// CombineKeys joins the keys of several extractors, for example
+// CombineKeys(KeyByIP(), KeyByRoute()) for a limit per client and route
+func CombineKeys(funcs ...KeyFunc) KeyFunc {
+	return func(c *Context) string {
+		key := ""
+		for i, fn := range funcs {
+			if i > 0 {
+				key += "|"
+			}
+			key += fn(c)
+		}
+		return key
+	}
+}
+
+// RateLimitConfig configures RateLimit
+type RateLimitConfig struct {
+	Limit
+	// KeyFunc extracts the key requests are counted by, default KeyByIP.
+	// Requests with an empty key are not limited.
+	KeyFunc KeyFunc
+	// Store keeps the counters, default a new MemoryRateLimitStore
+	Store RateLimitStore
+	// Prefix is prepended to keys so several limiters can share a store
+	Prefix string
+	// FailClosed rejects requests with 503 when the store fails. By default
+	// they are allowed and the error is logged.
+	FailClosed bool
+	// DisableHeaders omits the RateLimit-* response headers
+	DisableHeaders bool
+	// Skip exempts requests from the limit when it returns true
+	Skip func(*Context) bool
+}
+
+// RateLimit is a middleware that limits the request rate per key. It sets
+// the RateLimit-Limit, RateLimit-Remaining, RateLimit-Reset and
+// RateLimit-Policy headers and rejects requests over the limit with 429
+// and a Retry-After header.
+func RateLimit(config RateLimitConfig) MiddlewareFunc {
+	if config.Rate <= 0 || config.Period <= 0 {
+		panic("router: RateLimit requires a positive Rate and Period")
+	}
+	if config.interval() <= 0 {
+		panic("router: RateLimit Period is too short for its Rate; use a longer Period")
+	}
+	if config.KeyFunc == nil {
+		config.KeyFunc = KeyByIP()
+	}
+	if config.Store == nil {
+		config.Store = NewMemoryRateLimitStore()
+	}
+	policy := strconv.Itoa(config.burst()) + ";w=" + strconv.Itoa(int(math.Ceil(config.Period.Seconds())))
+	if config.Algorithm == SlidingWindow {
+		policy = strconv.Itoa(config.Rate) + ";w=" + strconv.Itoa(int(math.Ceil(config.Period.Seconds())))
+	}
+
+	return func(next HandlerFunc) HandlerFunc {
+		return func(c *Context) {
+			if config.Skip != nil && config.Skip(c) {
+				next(c)
+				return
+			}
+			key := config.KeyFunc(c)
+			if key == "" {
+				next(c)
+				return
+			}
+
+			result, err := config.Store.Take(c.Request.Context(), config.Prefix+key, config.Limit)
+			if err != nil {
+				if config.FailClosed {
+					c.Error(WrapHTTPError(http.StatusServiceUnavailable, err))
+					return
+				}
+				log.Printf("RateLimit: store error: %v", err)
+				next(c)
+				return
+			}
+
+			if !config.DisableHeaders {
+				c.SetHeader("RateLimit-Limit", strconv.Itoa(result.Limit))
+				c.SetHeader("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+				c.SetHeader("RateLimit-Reset", strconv.Itoa(ceilSeconds(result.ResetAfter)))
+				c.SetHeader("RateLimit-Policy", policy)
+			}
+			if !result.Allowed {
+				c.SetHeader("Retry-After", strconv.Itoa(ceilSeconds(result.RetryAfter)))
+				c.Error(NewHTTPError(http.StatusTooManyRequests, "rate limit exceeded"))
+				return
+			}
+			next(c)
+		}
+	}
+}
+
+// ceilSeconds rounds a duration up to whole seconds
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
@@ -0,0 +1,155 @@
+package router
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// PostgresRateLimitStore keeps rate limit state in a PostgreSQL table so
+// several instances can enforce one shared limit. Each Take is a single
+// atomic upsert. The token bucket algorithm is evaluated as GCRA, which
+// makes the same decisions while storing only one timestamp per key.
+//
+// Timestamps come from the application, so the clocks of all instances
+// sharing a table should be kept in sync.
+type PostgresRateLimitStore struct {
+	*postgresTable
+	now func() time.Time
+}
+
+// PostgresRateLimitConfig configures a PostgresRateLimitStore
+type PostgresRateLimitConfig struct {
+	// Table is the name of the table, default rate_limits. It is created
+	// if it does not exist.
+	Table string
+	// CleanupInterval is how often expired keys are deleted, default one
+	// minute. A negative value disables the cleanup.
+	CleanupInterval time.Duration
+}
+
+// NewPostgresRateLimitStore creates the rate limit table if needed and
+// starts the periodic cleanup of expired keys. Call Close to stop it.
+func NewPostgresRateLimitStore(db *DB, config PostgresRateLimitConfig) (*PostgresRateLimitStore, error) {
+	if config.Table == "" {
+		config.Table = "rate_limits"
+	}
+	if config.CleanupInterval == 0 {
+		config.CleanupInterval = time.Minute
+	}
+
+	t, err := newPostgresTable(db, config.Table, func(table string) []string {
+		return []string{
+			`CREATE TABLE IF NOT EXISTS ` + table + ` (
+				key        TEXT PRIMARY KEY,
+				stamp      BIGINT NOT NULL,
+				count      BIGINT NOT NULL DEFAULT 0,
+				prev_count BIGINT NOT NULL DEFAULT 0,
+				allowed    BOOLEAN NOT NULL DEFAULT TRUE,
+				expires_at BIGINT NOT NULL
+			)`,
+		}
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error creating rate limit table: %w", err)
+	}
+	s := &PostgresRateLimitStore{postgresTable: t, now: time.Now}
+	s.startCleanup("PostgresRateLimitStore", config.CleanupInterval, s.DeleteExpired)
+	return s, nil
+}
+
+// DeleteExpired removes keys whose state has expired
+func (s *PostgresRateLimitStore) DeleteExpired(ctx context.Context) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM `+s.table+` WHERE expires_at < $1`, s.now().UnixMicro())
+	return err
+}
+
+// Take counts one request for key against limit
+func (s *PostgresRateLimitStore) Take(ctx context.Context, key string, limit Limit) (RateLimitResult, error) {
+	now := s.now()
+	if limit.Algorithm == SlidingWindow {
+		// Windows are stored in microseconds, so drop finer precision up front
+		return s.takeSlidingWindow(ctx, key, limit, now.Truncate(time.Microsecond))
+	}
+	return s.takeGCRA(ctx, key, limit, now)
+}
+
+// takeSlidingWindow rolls the window and increments the counter in one
+// statement, only counting the request if it fits in the limit
+func (s *PostgresRateLimitStore) takeSlidingWindow(ctx context.Context, key string, limit Limit, now time.Time) (RateLimitResult, error) {
+	windowStart := now.Truncate(limit.Period)
+	prevStart := windowStart.Add(-limit.Period)
+	weight := 1 - float64(now.Sub(windowStart))/float64(limit.Period)
+	expires := windowStart.Add(2 * limit.Period)
+
+	// Parameters are cast explicitly because they are used in several places
+	const prev = `(CASE WHEN t.stamp = $2::bigint THEN t.prev_count WHEN t.stamp = $3::bigint THEN t.count ELSE 0 END)`
+	const base = `(CASE WHEN t.stamp = $2::bigint THEN t.count ELSE 0 END)`
+	const fits = `(` + prev + ` * $4::float8 + ` + base + ` + 1 <= $5::bigint)`
+	query := `INSERT INTO ` + s.table + ` AS t (key, stamp, count, prev_count, allowed, expires_at)
+		VALUES ($1, $2::bigint, CASE WHEN $5::bigint >= 1 THEN 1 ELSE 0 END, 0, $5::bigint >= 1, $6::bigint)
+		ON CONFLICT (key) DO UPDATE SET
+			count = ` + base + ` + CASE WHEN ` + fits + ` THEN 1 ELSE 0 END,
+			prev_count = ` + prev + `,
+			allowed = ` + fits + `,
+			stamp = $2::bigint,
+			expires_at = $6::bigint
+		RETURNING count, prev_count, allowed`
+
+	var count, prevCount int64
+	var allowed bool
+	err := s.db.QueryRowContext(ctx, query,
+		key, windowStart.UnixMicro(), prevStart.UnixMicro(), weight, limit.Rate, expires.UnixMicro(),
+	).Scan(&count, &prevCount, &allowed)
+	if err != nil {
+		return RateLimitResult{}, fmt.Errorf("error updating rate limit: %w", err)
+	}
+
+	// Replay the decision on the state before this request to compute the headers
+	before := rateState{Stamp: windowStart, Count: count, PrevCount: prevCount}
+	if allowed {
+		before.Count--
+	}
+	result, _ := limit.takeSlidingWindow(&before, now)
+	result.Allowed = allowed
+	return result, nil
+}
+
+// takeGCRA advances the theoretical arrival time in one statement if the
+// request conforms. The arrival time is stored in nanoseconds, since the
+// interval between requests may be shorter than a microsecond, while
+// expires_at stays in microseconds like the other rows.
+func (s *PostgresRateLimitStore) takeGCRA(ctx context.Context, key string, limit Limit, now time.Time) (RateLimitResult, error) {
+	interval := limit.interval()
+	burstOffset := interval * time.Duration(limit.burst())
+
+	const newTAT = `(GREATEST(t.stamp, $2::bigint) + $3::bigint)`
+	const conforms = `(` + newTAT + ` - $4::bigint <= $2::bigint)`
+	query := `INSERT INTO ` + s.table + ` AS t (key, stamp, allowed, expires_at)
+		VALUES ($1, $2::bigint + $3::bigint, TRUE, ($2::bigint + $3::bigint) / 1000)
+		ON CONFLICT (key) DO UPDATE SET
+			stamp = CASE WHEN ` + conforms + ` THEN ` + newTAT + ` ELSE t.stamp END,
+			allowed = ` + conforms + `,
+			expires_at = (CASE WHEN ` + conforms + ` THEN ` + newTAT + ` ELSE t.stamp END) / 1000
+		RETURNING stamp, allowed`
+
+	var stamp int64
+	var allowed bool
+	err := s.db.QueryRowContext(ctx, query,
+		key, now.UnixNano(), interval.Nanoseconds(), burstOffset.Nanoseconds(),
+	).Scan(&stamp, &allowed)
+	if err != nil {
+		return RateLimitResult{}, fmt.Errorf("error updating rate limit: %w", err)
+	}
+
+	// Replay the decision on the state before this request to compute the headers
+	before := rateState{Stamp: time.Unix(0, stamp)}
+	if allowed {
+		before.Stamp = before.Stamp.Add(-interval)
+	}
+	gcra := limit
+	gcra.Algorithm = GCRA
+	result, _ := gcra.takeGCRA(&before, now)
+	result.Allowed = allowed
+	return result, nil
+}
@@ -0,0 +1,54 @@
+package router
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// testPostgres connects to the database named by GOROUTER_TEST_POSTGRES_DSN,
+// for example "postgres://postgres@localhost/gorouter_test?sslmode=disable",
+// and skips the test if it is not set
+func testPostgres(t *testing.T) *DB {
+	t.Helper()
+	dsn := os.Getenv("GOROUTER_TEST_POSTGRES_DSN")
+	if dsn == "" {
+		t.Skip("GOROUTER_TEST_POSTGRES_DSN not set")
+	}
+	sqlDB, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sqlDB.Ping(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sqlDB.Close() })
+	return &DB{sqlDB}
+}
+
+// testTable returns a table name unique to the test and drops the table
+// when the test ends
+func testTable(t *testing.T, db *DB, prefix string) string {
+	table := fmt.Sprintf("%s_%d", prefix, time.Now().UnixNano())
+	t.Cleanup(func() {
+		db.Exec(`DROP TABLE IF EXISTS ` + pq.QuoteIdentifier(table))
+	})
+	return table
+}
+
+func TestPostgresRateLimitStore(t *testing.T) {
+	db := testPostgres(t)
+	store, err := NewPostgresRateLimitStore(db, PostgresRateLimitConfig{
+		Table:           testTable(t, db, "rate_limits_test"),
+		CleanupInterval: -1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	testRateLimitStore(t, store, func(now func() time.Time) { store.now = now })
+}
@@ -0,0 +1,264 @@
+package router
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sys-apps-go/gorouter/pkg/router/routertest"
+)
+
+func TestMemoryRateLimitStore(t *testing.T) {
+	store := NewMemoryRateLimitStore()
+	testRateLimitStore(t, store, func(now func() time.Time) { store.now = now })
+}
+
+// testRateLimitStore checks that store enforces every algorithm and counts
+// concurrent takes atomically. setNow replaces the store's clock.
+func testRateLimitStore(t *testing.T, store RateLimitStore, setNow func(func() time.Time)) {
+	algorithms := []RateLimitAlgorithm{SlidingWindow, TokenBucket, GCRA}
+	for _, algorithm := range algorithms {
+		t.Run(algorithm.String(), func(t *testing.T) {
+			ctx := context.Background()
+			clock := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+			setNow(func() time.Time { return clock })
+			limit := Limit{Algorithm: algorithm, Rate: 3, Period: time.Minute}
+			key := "sequential-" + algorithm.String()
+
+			for i, want := range []int{2, 1, 0} {
+				result, err := store.Take(ctx, key, limit)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !result.Allowed || result.Remaining != want || result.Limit != 3 {
+					t.Fatalf("take %d: got %+v, want allowed with %d remaining", i+1, result, want)
+				}
+			}
+			result, err := store.Take(ctx, key, limit)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.Allowed {
+				t.Fatalf("take 4: got %+v, want rejected", result)
+			}
+			if result.RetryAfter <= 0 || result.RetryAfter > limit.Period {
+				t.Fatalf("take 4: RetryAfter %v out of range", result.RetryAfter)
+			}
+
+			// Past the previous window of the sliding window the full
+			// allowance is back for every algorithm
+			clock = clock.Add(2 * limit.Period)
+			result, err = store.Take(ctx, key, limit)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !result.Allowed || result.Remaining != 2 {
+				t.Fatalf("take after reset: got %+v, want allowed with 2 remaining", result)
+			}
+		})
+
+		if algorithm != SlidingWindow {
+			// Intervals shorter than a microsecond keep their precision
+			t.Run(algorithm.String()+"/sub-microsecond", func(t *testing.T) {
+				clock := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+				setNow(func() time.Time { return clock })
+				limit := Limit{Algorithm: algorithm, Rate: 4_000_000, Period: time.Second, Burst: 1}
+				key := "sub-microsecond-" + algorithm.String()
+
+				for i, step := range []struct {
+					advance time.Duration
+					allowed bool
+				}{{0, true}, {0, false}, {250, true}, {150, false}, {100, true}} {
+					clock = clock.Add(step.advance)
+					result, err := store.Take(context.Background(), key, limit)
+					if err != nil {
+						t.Fatal(err)
+					}
+					if result.Allowed != step.allowed {
+						t.Fatalf("take %d: got %+v, want allowed %v", i+1, result, step.allowed)
+					}
+				}
+			})
+		}
+
+		t.Run(algorithm.String()+"/concurrent", func(t *testing.T) {
+			setNow(time.Now)
+			limit := Limit{Algorithm: algorithm, Rate: 10, Period: time.Hour}
+			key := "concurrent-" + algorithm.String()
+
+			var wg sync.WaitGroup
+			var mu sync.Mutex
+			allowed := 0
+			for i := 0; i < 40; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					result, err := store.Take(context.Background(), key, limit)
+					if err != nil {
+						t.Error(err)
+						return
+					}
+					if result.Allowed {
+						mu.Lock()
+						allowed++
+						mu.Unlock()
+					}
+				}()
+			}
+			wg.Wait()
+			if allowed != limit.Rate {
+				t.Fatalf("allowed %d of 40 concurrent takes, want %d", allowed, limit.Rate)
+			}
+		})
+	}
+}
+
+func TestRateLimitAlgorithms(t *testing.T) {
+	start := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name  string
+		limit Limit
+		// offsets are the times of the requests after start
+		offsets []time.Duration
+		allowed []bool
+	}{
+		{
+			name:    "sliding window weights the previous window",
+			limit:   Limit{Algorithm: SlidingWindow, Rate: 4, Period: time.Minute},
+			offsets: []time.Duration{0, 0, 0, 0, time.Minute + 15*time.Second, time.Minute + 45*time.Second, time.Minute + 45*time.Second},
+			// At 1m15s three quarters of the four previous requests still
+			// count, at 1m45s one quarter
+			allowed: []bool{true, true, true, true, true, true, true},
+		},
+		{
+			name:    "sliding window rejects while the previous window overlaps",
+			limit:   Limit{Algorithm: SlidingWindow, Rate: 4, Period: time.Minute},
+			offsets: []time.Duration{0, 0, 0, 0, time.Minute + 10*time.Second},
+			allowed: []bool{true, true, true, true, false},
+		},
+		{
+			name:    "token bucket refills one token per interval",
+			limit:   Limit{Algorithm: TokenBucket, Rate: 2, Period: time.Second},
+			offsets: []time.Duration{0, 0, 0, 500 * time.Millisecond, 500 * time.Millisecond},
+			allowed: []bool{true, true, false, true, false},
+		},
+		{
+			name:    "token bucket burst",
+			limit:   Limit{Algorithm: TokenBucket, Rate: 1, Period: time.Second, Burst: 3},
+			offsets: []time.Duration{0, 0, 0, 0, time.Second},
+			allowed: []bool{true, true, true, false, true},
+		},
+		{
+			name:    "gcra spaces requests",
+			limit:   Limit{Algorithm: GCRA, Rate: 2, Period: time.Second, Burst: 1},
+			offsets: []time.Duration{0, 100 * time.Millisecond, 500 * time.Millisecond},
+			allowed: []bool{true, false, true},
+		},
+		{
+			name:    "gcra burst",
+			limit:   Limit{Algorithm: GCRA, Rate: 10, Period: time.Second, Burst: 2},
+			offsets: []time.Duration{0, 0, 0, 100 * time.Millisecond},
+			allowed: []bool{true, true, false, true},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var state rateState
+			for i, offset := range tt.offsets {
+				result, _ := tt.limit.take(&state, start.Add(offset))
+				if result.Allowed != tt.allowed[i] {
+					t.Fatalf("request %d at %v: allowed = %v, want %v (%+v)", i+1, offset, result.Allowed, tt.allowed[i], result)
+				}
+				if !result.Allowed && result.RetryAfter <= 0 {
+					t.Fatalf("request %d: rejected without RetryAfter", i+1)
+				}
+			}
+		})
+	}
+}
+
+// failingRateLimitStore fails every Take
+type failingRateLimitStore struct{}
+
+func (failingRateLimitStore) Take(ctx context.Context, key string, limit Limit) (RateLimitResult, error) {
+	return RateLimitResult{}, errors.New("store down")
+}
+
+func TestRateLimitMiddleware(t *testing.T) {
+	newRouter := func(config RateLimitConfig) *Router {
+		r := NewRouter()
+		r.Use(RateLimit(config))
+		r.GET("/", func(c *Context) {
+			c.Status(http.StatusNoContent)
+		})
+		return r
+	}
+	limit := Limit{Rate: 2, Period: time.Minute}
+
+	t.Run("headers and rejection", func(t *testing.T) {
+		rt := routertest.New(t, newRouter(RateLimitConfig{Limit: limit}))
+		rt.GET("/").Expect().Status(http.StatusNoContent).
+			Header("RateLimit-Limit", "2").
+			Header("RateLimit-Remaining", "1").
+			Header("RateLimit-Policy", "2;w=60")
+		rt.GET("/").Expect().Status(http.StatusNoContent).Header("RateLimit-Remaining", "0")
+		resp := rt.GET("/").Expect().Status(http.StatusTooManyRequests).Header("RateLimit-Remaining", "0")
+		if resp.Response.Header.Get("Retry-After") == "" {
+			t.Error("no Retry-After header")
+		}
+	})
+
+	t.Run("keys", func(t *testing.T) {
+		r := newRouter(RateLimitConfig{Limit: Limit{Rate: 1, Period: time.Minute}, KeyFunc: KeyByHeader("X-API-Key")})
+		rt := routertest.New(t, r)
+		rt.GET("/").Header("X-API-Key", "a").Expect().Status(http.StatusNoContent)
+		rt.GET("/").Header("X-API-Key", "a").Expect().Status(http.StatusTooManyRequests)
+		rt.GET("/").Header("X-API-Key", "b").Expect().Status(http.StatusNoContent)
+		// Requests without a key are not limited
+		rt.GET("/").Expect().Status(http.StatusNoContent)
+		rt.GET("/").Expect().Status(http.StatusNoContent).NoHeader("RateLimit-Limit")
+	})
+
+	t.Run("skip and disabled headers", func(t *testing.T) {
+		r := newRouter(RateLimitConfig{
+			Limit:          Limit{Rate: 1, Period: time.Minute},
+			DisableHeaders: true,
+			Skip:           func(c *Context) bool { return c.GetHeader("X-Internal") != "" },
+		})
+		rt := routertest.New(t, r)
+		rt.GET("/").Expect().Status(http.StatusNoContent).NoHeader("RateLimit-Limit")
+		rt.GET("/").Header("X-Internal", "1").Expect().Status(http.StatusNoContent)
+		rt.GET("/").Expect().Status(http.StatusTooManyRequests)
+	})
+
+	t.Run("store failure", func(t *testing.T) {
+		routertest.New(t, newRouter(RateLimitConfig{Limit: limit, Store: failingRateLimitStore{}})).
+			GET("/").Expect().Status(http.StatusNoContent)
+		routertest.New(t, newRouter(RateLimitConfig{Limit: limit, Store: failingRateLimitStore{}, FailClosed: true})).
+			GET("/").Expect().Status(http.StatusServiceUnavailable)
+	})
+}
+
+func TestRateLimitConfigPanics(t *testing.T) {
+	tests := []struct {
+		name  string
+		limit Limit
+	}{
+		{"zero rate", Limit{Period: time.Second}},
+		{"zero period", Limit{Rate: 1}},
+		{"period too short", Limit{Rate: 10, Period: 5 * time.Nanosecond}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Fatal("RateLimit did not panic")
+				}
+			}()
+			RateLimit(RateLimitConfig{Limit: tt.limit})
+		})
+	}
+}
@@ -0,0 +1,144 @@
+package router
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"runtime/debug"
+	"syscall"
+)
+
+// PanicStackKey is the Context key under which Recover stores the stack
+// trace of a recovered panic
+const PanicStackKey = "PanicStack"
+
+// RecoverConfig configures RecoverWithConfig
+type RecoverConfig struct {
+	// PanicHandler writes the response for a recovered panic. The stack
+	// trace is available with c.GetString(PanicStackKey). By default the
+	// panic is passed to the error handler as a 500 error, unless the
+	// response has already been started.
+	PanicHandler func(c *Context, recovered interface{})
+	// Logger receives the panic and stack trace, default slog.Default()
+	Logger *slog.Logger
+	// DisableStack leaves the stack trace out of the log
+	DisableStack bool
+	// DevMode renders the panic and stack trace in the response body.
+	// Never enable it in production.
+	DevMode bool
+}
+
+// PanicError wraps a recovered panic value so error handlers can detect it
+type PanicError struct {
+	Value interface{}
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic: %v", e.Value)
+}
+
+// Unwrap returns the panic value if it is an error
+func (e *PanicError) Unwrap() error {
+	err, _ := e.Value.(error)
+	return err
+}
+
+// Recover is a middleware that recovers from panics, logs them with a
+// stack trace and responds with 500
+func Recover() MiddlewareFunc {
+	return RecoverWithConfig(RecoverConfig{})
+}
+
+// RecoverWithConfig is a middleware that recovers from panics in later
+// handlers. Panics with errors wrapping http.ErrAbortHandler are re-raised
+// as http.ErrAbortHandler so net/http aborts the response. Panics caused by the client going away, such as a
+// broken pipe, are logged without a stack trace and get no response.
+func RecoverWithConfig(config RecoverConfig) MiddlewareFunc {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(c *Context) {
+			defer func() {
+				recovered := recover()
+				if recovered == nil {
+					return
+				}
+				if err, ok := recovered.(error); ok && errors.Is(err, http.ErrAbortHandler) {
+					// net/http only recognizes the sentinel itself
+					panic(http.ErrAbortHandler)
+				}
+				var stack []byte
+				if pe, ok := recovered.(*PanicError); ok {
+					// Re-raised from the goroutine of a handler under Timeout
+					recovered, stack = pe.Value, pe.Stack
+				}
+
+				logger := config.Logger
+				if logger == nil {
+					logger = slog.Default()
+				}
+				attrs := []interface{}{
+					slog.String("method", c.Request.Method),
+					slog.String("path", c.Request.URL.Path),
+					slog.String("request_id", c.RequestID()),
+				}
+
+				if isBrokenConnection(recovered) {
+					logger.Warn("connection closed by client", append(attrs, slog.Any("error", recovered))...)
+					c.Abort()
+					return
+				}
+
+				if stack == nil {
+					stack = debug.Stack()
+				}
+				c.Set(PanicStackKey, string(stack))
+				attrs = append(attrs, slog.Any("panic", recovered))
+				if !config.DisableStack {
+					attrs = append(attrs, slog.String("stack", string(stack)))
+				}
+				logger.Error("panic recovered", attrs...)
+
+				switch {
+				case config.PanicHandler != nil:
+					config.PanicHandler(c, recovered)
+				case c.Written():
+					// The status has been sent, so only stop the chain
+					c.Abort()
+				case config.DevMode:
+					c.Abort()
+					c.String(http.StatusInternalServerError, "panic: %v\n\n%s", recovered, stack)
+				default:
+					c.Error(&HTTPError{
+						Code:    http.StatusInternalServerError,
+						Message: http.StatusText(http.StatusInternalServerError),
+						Err:     &PanicError{Value: recovered, Stack: stack},
+					})
+				}
+			}()
+			next(c)
+		}
+	}
+}
+
+// isBrokenConnection reports whether a panic value is an error caused by
+// the client closing the connection
+func isBrokenConnection(recovered interface{}) bool {
+	err, ok := recovered.(error)
+	if !ok {
+		return false
+	}
+	if errors.Is(err, syscall.EPIPE) || errors.Is(err, syscall.ECONNRESET) {
+		return true
+	}
+	var opErr *net.OpError
+	if errors.As(err, &opErr) {
+		var sysErr *os.SyscallError
+		if errors.As(opErr.Err, &sysErr) {
+			return errors.Is(sysErr.Err, syscall.EPIPE) || errors.Is(sysErr.Err, syscall.ECONNRESET)
+		}
+	}
+	return false
+}
@@ -0,0 +1,234 @@
+package router
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/sys-apps-go/gorouter/pkg/router/routertest"
+)
+
+// brokenPipe is the error a write to a connection closed by the client
+// fails with
+var brokenPipe = &net.OpError{Op: "write", Net: "tcp", Err: &os.SyscallError{Syscall: "write", Err: syscall.EPIPE}}
+
+// recoverRouter returns a router with Recover configured by config whose
+// routes panic in different ways
+func recoverRouter(config RecoverConfig) *Router {
+	r := NewRouter()
+	r.Use(RecoverWithConfig(config))
+	r.GET("/panic", func(c *Context) {
+		panic("boom")
+	})
+	r.GET("/error", func(c *Context) {
+		panic(errors.New("nil map"))
+	})
+	r.GET("/written", func(c *Context) {
+		c.String(http.StatusOK, "partial")
+		panic("late boom")
+	})
+	r.GET("/broken-pipe", func(c *Context) {
+		panic(brokenPipe)
+	})
+	r.GET("/reset", func(c *Context) {
+		panic(fmt.Errorf("copying body: %w", syscall.ECONNRESET))
+	})
+	r.GET("/abort", func(c *Context) {
+		panic(http.ErrAbortHandler)
+	})
+	r.GET("/wrapped-abort", func(c *Context) {
+		panic(fmt.Errorf("stream: %w", http.ErrAbortHandler))
+	})
+	return r
+}
+
+// textLogger returns a logger writing text lines to buf
+func textLogger(buf *bytes.Buffer) *slog.Logger {
+	return slog.New(slog.NewTextHandler(buf, nil))
+}
+
+func TestRecover(t *testing.T) {
+	tests := []struct {
+		name   string
+		config RecoverConfig
+		path   string
+		status int
+		body   string
+		logs   []string
+		noLogs []string
+	}{
+		{
+			name:   "default",
+			path:   "/panic",
+			status: http.StatusInternalServerError,
+			body:   `{"error":"Internal Server Error"}` + "\n",
+			logs:   []string{"level=ERROR", `msg="panic recovered"`, "path=/panic", "panic=boom", "stack=", "recover_test.go"},
+		},
+		{
+			name:   "error value",
+			path:   "/error",
+			status: http.StatusInternalServerError,
+			body:   `{"error":"Internal Server Error"}` + "\n",
+			logs:   []string{`panic="nil map"`},
+		},
+		{
+			name:   "without stack",
+			config: RecoverConfig{DisableStack: true},
+			path:   "/panic",
+			status: http.StatusInternalServerError,
+			body:   `{"error":"Internal Server Error"}` + "\n",
+			logs:   []string{"panic=boom"},
+			noLogs: []string{"stack="},
+		},
+		{
+			name:   "dev mode",
+			config: RecoverConfig{DevMode: true},
+			path:   "/panic",
+			status: http.StatusInternalServerError,
+			body:   "panic: boom\n\ngoroutine ",
+		},
+		{
+			name:   "response already started",
+			path:   "/written",
+			status: http.StatusOK,
+			body:   "partial",
+			logs:   []string{`panic="late boom"`},
+		},
+		{
+			name:   "broken pipe",
+			path:   "/broken-pipe",
+			status: http.StatusOK,
+			body:   "",
+			logs:   []string{"level=WARN", `msg="connection closed by client"`, "broken pipe"},
+			noLogs: []string{"stack=", "level=ERROR"},
+		},
+		{
+			name:   "connection reset",
+			path:   "/reset",
+			status: http.StatusOK,
+			body:   "",
+			logs:   []string{"level=WARN", "connection reset by peer"},
+			noLogs: []string{"stack="},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var logs bytes.Buffer
+			config := tt.config
+			config.Logger = textLogger(&logs)
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			rec := httptest.NewRecorder()
+			recoverRouter(config).ServeHTTP(rec, req)
+
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.status)
+			}
+			if tt.config.DevMode {
+				if !strings.HasPrefix(rec.Body.String(), tt.body) {
+					t.Fatalf("body = %q, want prefix %q", rec.Body.String(), tt.body)
+				}
+			} else if rec.Body.String() != tt.body {
+				t.Fatalf("body = %q, want %q", rec.Body.String(), tt.body)
+			}
+			for _, want := range tt.logs {
+				if !strings.Contains(logs.String(), want) {
+					t.Errorf("log %q does not contain %s", logs.String(), want)
+				}
+			}
+			for _, unwanted := range tt.noLogs {
+				if strings.Contains(logs.String(), unwanted) {
+					t.Errorf("log %q contains %s", logs.String(), unwanted)
+				}
+			}
+		})
+	}
+}
+
+func TestRecoverPanicHandler(t *testing.T) {
+	var logs bytes.Buffer
+	var got interface{}
+	r := recoverRouter(RecoverConfig{
+		Logger: textLogger(&logs),
+		PanicHandler: func(c *Context, recovered interface{}) {
+			got = recovered
+			stack := c.GetString(PanicStackKey)
+			if !strings.Contains(stack, "recover_test.go") {
+				t.Errorf("stack %q does not show the panicking handler", stack)
+			}
+			c.String(http.StatusServiceUnavailable, "sorry")
+		},
+	})
+	routertest.New(t, r).GET("/panic").Expect().
+		Status(http.StatusServiceUnavailable).
+		BodyEquals("sorry")
+	if got != "boom" {
+		t.Fatalf("PanicHandler got %#v", got)
+	}
+}
+
+func TestRecoverErrorHandler(t *testing.T) {
+	var logs bytes.Buffer
+	r := recoverRouter(RecoverConfig{Logger: textLogger(&logs)})
+	r.SetErrorHandler(func(c *Context, err error) {
+		var pe *PanicError
+		if !errors.As(err, &pe) {
+			t.Errorf("error %v does not carry the panic", err)
+			return
+		}
+		if len(pe.Stack) == 0 {
+			t.Error("PanicError without stack")
+		}
+		// The panic value is the cause when it is an error
+		c.String(StatusCodeOf(err), "%v|%v", pe.Value, errors.Unwrap(pe))
+	})
+	routertest.New(t, r).GET("/panic").Expect().Status(http.StatusInternalServerError).BodyEquals("boom|<nil>")
+	routertest.New(t, r).GET("/error").Expect().Status(http.StatusInternalServerError).BodyEquals("nil map|nil map")
+}
+
+func TestRecoverAbortHandler(t *testing.T) {
+	var logs bytes.Buffer
+	r := recoverRouter(RecoverConfig{Logger: textLogger(&logs)})
+	for _, path := range []string{"/abort", "/wrapped-abort"} {
+		t.Run(path, func(t *testing.T) {
+			defer func() {
+				if recovered := recover(); recovered != http.ErrAbortHandler {
+					t.Fatalf("recovered %#v, want http.ErrAbortHandler", recovered)
+				}
+			}()
+			r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+			t.Fatal("the abort was swallowed")
+		})
+	}
+	if logs.Len() != 0 {
+		t.Fatalf("aborts were logged: %q", logs.String())
+	}
+}
+
+func TestIsBrokenConnection(t *testing.T) {
+	tests := []struct {
+		value interface{}
+		want  bool
+	}{
+		{brokenPipe, true},
+		{&net.OpError{Op: "write", Err: &os.SyscallError{Syscall: "write", Err: syscall.ECONNRESET}}, true},
+		{syscall.EPIPE, true},
+		{fmt.Errorf("flush: %w", syscall.ECONNRESET), true},
+		{&net.OpError{Op: "dial", Err: &os.SyscallError{Syscall: "connect", Err: syscall.ECONNREFUSED}}, false},
+		{errors.New("broken pipe"), false},
+		{"broken pipe", false},
+		{nil, false},
+	}
+	for _, tt := range tests {
+		if got := isBrokenConnection(tt.value); got != tt.want {
+			t.Errorf("isBrokenConnection(%v) = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+}
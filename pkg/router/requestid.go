@@ -0,0 +1,191 @@
+package router
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"log/slog"
+	"time"
+)
+
+// requestIDKey is the context.Context key of the request ID
+type requestIDKey struct{}
+
+// RequestIDConfig configures RequestIDWithConfig
+type RequestIDConfig struct {
+	// Header is the request and response header carrying the ID, default
+	// X-Request-ID
+	Header string
+	// Generator creates new IDs, default NewUUIDv7
+	Generator func() string
+	// TrustInbound decides whether the ID sent by the client is reused.
+	// By default inbound IDs are always replaced; set it to return true
+	// for requests from trusted proxies or services.
+	TrustInbound func(*Context) bool
+	// Validate checks a trusted inbound ID before it is reused, default
+	// ValidRequestID. Invalid IDs are replaced with a new one.
+	Validate func(id string) bool
+}
+
+// RequestID is a middleware that gives each request a UUIDv7 request ID.
+// An X-Request-ID sent by the client is replaced, so clients cannot put
+// arbitrary IDs into the logs; use RequestIDWithConfig with TrustInbound to
+// keep the IDs of trusted proxies.
+func RequestID() MiddlewareFunc {
+	return RequestIDWithConfig(RequestIDConfig{})
+}
+
+// RequestIDWithConfig is a middleware that assigns a request ID, sets it
+// as a response header and stores it in the Context under the RequestID
+// key and in the request's context.Context, where RequestIDFromContext
+// finds it
+func RequestIDWithConfig(config RequestIDConfig) MiddlewareFunc {
+	if config.Header == "" {
+		config.Header = "X-Request-ID"
+	}
+	if config.Generator == nil {
+		config.Generator = NewUUIDv7
+	}
+	if config.Validate == nil {
+		config.Validate = ValidRequestID
+	}
+
+	return func(next HandlerFunc) HandlerFunc {
+		return func(c *Context) {
+			id := ""
+			if config.TrustInbound != nil && config.TrustInbound(c) {
+				if inbound := c.GetHeader(config.Header); inbound != "" && config.Validate(inbound) {
+					id = inbound
+				}
+			}
+			if id == "" {
+				id = config.Generator()
+			}
+			c.SetHeader(config.Header, id)
+			c.Set("RequestID", id)
+			c.Request = c.Request.WithContext(ContextWithRequestID(c.Request.Context(), id))
+			next(c)
+		}
+	}
+}
+
+// RequestID returns the ID assigned by the RequestID middleware, or ""
+func (c *Context) RequestID() string {
+	if id := c.GetString("RequestID"); id != "" {
+		return id
+	}
+	if c.Request != nil {
+		return RequestIDFromContext(c.Request.Context())
+	}
+	return ""
+}
+
+// ContextWithRequestID returns a copy of ctx carrying the request ID
+func ContextWithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestIDFromContext returns the request ID carried by ctx, or "". Pass
+// c.Request.Context() on to downstream calls to keep the ID available.
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// ValidRequestID reports whether an inbound request ID is safe to reuse:
+// 1 to 128 printable ASCII characters without spaces
+func ValidRequestID(id string) bool {
+	if len(id) == 0 || len(id) > 128 {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// NewUUIDv7 returns a random, time-ordered RFC 9562 version 7 UUID
+func NewUUIDv7() string {
+	var b [16]byte
+	rand.Read(b[:])
+	ms := uint64(time.Now().UnixMilli())
+	binary.BigEndian.PutUint16(b[0:2], uint16(ms>>32))
+	binary.BigEndian.PutUint32(b[2:6], uint32(ms))
+	b[6] = b[6]&0x0f | 0x70
+	b[8] = b[8]&0x3f | 0x80
+
+	var s [36]byte
+	hex.Encode(s[0:8], b[0:4])
+	s[8] = '-'
+	hex.Encode(s[9:13], b[4:6])
+	s[13] = '-'
+	hex.Encode(s[14:18], b[6:8])
+	s[18] = '-'
+	hex.Encode(s[19:23], b[8:10])
+	s[23] = '-'
+	hex.Encode(s[24:], b[10:])
+	return string(s[:])
+}
+
+// crockford is the Crockford base32 alphabet used by ULIDs
+const crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// NewULID returns a random, lexicographically sortable ULID
+func NewULID() string {
+	var b [16]byte
+	rand.Read(b[6:])
+	ms := uint64(time.Now().UnixMilli())
+	binary.BigEndian.PutUint16(b[0:2], uint16(ms>>32))
+	binary.BigEndian.PutUint32(b[2:6], uint32(ms))
+
+	// 128 bits in 26 characters of 5 bits, the first holding only 3
+	hi := binary.BigEndian.Uint64(b[0:8])
+	lo := binary.BigEndian.Uint64(b[8:16])
+	var s [26]byte
+	for i := 25; i >= 0; i-- {
+		s[i] = crockford[lo&0x1f]
+		lo = lo>>5 | hi<<59
+		hi >>= 5
+	}
+	return string(s[:])
+}
+
+// RequestIDLogHandler wraps a slog.Handler and adds a request_id attribute
+// to records logged with a context carrying a request ID, for example
+// slog.InfoContext(c.Request.Context(), ...)
+type RequestIDLogHandler struct {
+	slog.Handler
+}
+
+// NewRequestIDLogHandler returns a RequestIDLogHandler wrapping h
+func NewRequestIDLogHandler(h slog.Handler) *RequestIDLogHandler {
+	return &RequestIDLogHandler{Handler: h}
+}
+
+// Handle adds the request ID of ctx to the record unless it already has one
+func (h *RequestIDLogHandler) Handle(ctx context.Context, r slog.Record) error {
+	if id := RequestIDFromContext(ctx); id != "" {
+		present := false
+		r.Attrs(func(a slog.Attr) bool {
+			present = a.Key == "request_id"
+			return !present
+		})
+		if !present {
+			r.AddAttrs(slog.String("request_id", id))
+		}
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+// WithAttrs returns a RequestIDLogHandler wrapping h.Handler.WithAttrs
+func (h *RequestIDLogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &RequestIDLogHandler{Handler: h.Handler.WithAttrs(attrs)}
+}
+
+// WithGroup returns a RequestIDLogHandler wrapping h.Handler.WithGroup
+func (h *RequestIDLogHandler) WithGroup(name string) slog.Handler {
+	return &RequestIDLogHandler{Handler: h.Handler.WithGroup(name)}
+}
@@ -0,0 +1,234 @@
+package router
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sys-apps-go/gorouter/pkg/router/routertest"
+)
+
+var uuidv7Pattern = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-7[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+
+func TestNewUUIDv7(t *testing.T) {
+	before := time.Now().UnixMilli()
+	seen := make(map[string]bool)
+	prev := ""
+	for i := 0; i < 1000; i++ {
+		id := NewUUIDv7()
+		if !uuidv7Pattern.MatchString(id) {
+			t.Fatalf("%q is not a version 7 UUID", id)
+		}
+		if seen[id] {
+			t.Fatalf("duplicate ID %q", id)
+		}
+		seen[id] = true
+		// The leading 48-bit timestamp keeps IDs in creation order
+		if id[:13] < prev {
+			t.Fatalf("%q sorts before the earlier %q", id, prev)
+		}
+		prev = id[:13]
+	}
+	after := time.Now().UnixMilli()
+
+	raw, err := hex.DecodeString(strings.ReplaceAll(prev, "-", ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ms int64
+	for _, b := range raw[:6] {
+		ms = ms<<8 | int64(b)
+	}
+	if ms < before || ms > after {
+		t.Fatalf("timestamp %d not between %d and %d", ms, before, after)
+	}
+}
+
+func TestNewULID(t *testing.T) {
+	pattern := regexp.MustCompile(`^[0-7][0-9A-HJKMNP-TV-Z]{25}$`)
+	before := time.Now().UnixMilli()
+	seen := make(map[string]bool)
+	for i := 0; i < 1000; i++ {
+		id := NewULID()
+		if !pattern.MatchString(id) {
+			t.Fatalf("%q is not a ULID", id)
+		}
+		if seen[id] {
+			t.Fatalf("duplicate ID %q", id)
+		}
+		seen[id] = true
+	}
+	last := NewULID()
+	after := time.Now().UnixMilli()
+
+	// The first 10 characters encode the millisecond timestamp
+	var ms int64
+	for _, ch := range last[:10] {
+		ms = ms<<5 | int64(strings.IndexRune(crockford, ch))
+	}
+	if ms < before || ms > after {
+		t.Fatalf("timestamp %d not between %d and %d", ms, before, after)
+	}
+}
+
+func TestValidRequestID(t *testing.T) {
+	tests := []struct {
+		id   string
+		want bool
+	}{
+		{"0190b1a4-7c2e-7d3f-9a1b-2c3d4e5f6a7b", true},
+		{"trace:abc/123_x", true},
+		{strings.Repeat("a", 128), true},
+		{strings.Repeat("a", 129), false},
+		{"", false},
+		{"with space", false},
+		{"new\nline", false},
+		{"tab\t", false},
+		{"caf\xc3\xa9", false},
+		{"\x7f", false},
+	}
+	for _, tt := range tests {
+		if got := ValidRequestID(tt.id); got != tt.want {
+			t.Errorf("ValidRequestID(%q) = %v, want %v", tt.id, got, tt.want)
+		}
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	fromProxy := func(c *Context) bool { return c.GetHeader("X-From-Proxy") != "" }
+	tests := []struct {
+		name    string
+		config  RequestIDConfig
+		header  map[string]string
+		want    string
+		replace bool
+	}{
+		{name: "generated", replace: true},
+		{name: "inbound replaced by default", header: map[string]string{"X-Request-ID": "client-id"}, replace: true},
+		{
+			name:   "trusted inbound kept",
+			config: RequestIDConfig{TrustInbound: fromProxy},
+			header: map[string]string{"X-Request-ID": "proxy-id", "X-From-Proxy": "1"},
+			want:   "proxy-id",
+		},
+		{
+			name:    "untrusted inbound replaced",
+			config:  RequestIDConfig{TrustInbound: fromProxy},
+			header:  map[string]string{"X-Request-ID": "client-id"},
+			replace: true,
+		},
+		{
+			name:    "invalid trusted inbound replaced",
+			config:  RequestIDConfig{TrustInbound: fromProxy},
+			header:  map[string]string{"X-Request-ID": "bad id", "X-From-Proxy": "1"},
+			replace: true,
+		},
+		{
+			name: "custom validation",
+			config: RequestIDConfig{
+				TrustInbound: fromProxy,
+				Validate:     func(id string) bool { return strings.HasPrefix(id, "req-") },
+			},
+			header:  map[string]string{"X-Request-ID": "proxy-id", "X-From-Proxy": "1"},
+			replace: true,
+		},
+		{
+			name: "custom header and generator",
+			config: RequestIDConfig{
+				Header:       "X-Correlation-ID",
+				Generator:    func() string { return "generated" },
+				TrustInbound: fromProxy,
+			},
+			header: map[string]string{"X-Request-ID": "ignored", "X-From-Proxy": "1"},
+			want:   "generated",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewRouter()
+			r.Use(RequestIDWithConfig(tt.config))
+			r.GET("/", func(c *Context) {
+				c.JSON(http.StatusOK, map[string]string{
+					"context": c.RequestID(),
+					"request": RequestIDFromContext(c.Request.Context()),
+				})
+			})
+			req := routertest.New(t, r).GET("/")
+			for name, value := range tt.header {
+				req.Header(name, value)
+			}
+			resp := req.Expect().Status(http.StatusOK)
+
+			header := tt.config.Header
+			if header == "" {
+				header = "X-Request-ID"
+			}
+			id := resp.Response.Header.Get(header)
+			if tt.replace {
+				if !uuidv7Pattern.MatchString(id) {
+					t.Fatalf("%s = %q, want a new UUIDv7", header, id)
+				}
+			} else if id != tt.want {
+				t.Fatalf("%s = %q, want %q", header, id, tt.want)
+			}
+			resp.JSONPath("$.context", id).JSONPath("$.request", id)
+		})
+	}
+}
+
+func TestRequestIDContext(t *testing.T) {
+	ctx := context.Background()
+	if id := RequestIDFromContext(ctx); id != "" {
+		t.Fatalf("RequestIDFromContext() = %q without an ID", id)
+	}
+	if id := RequestIDFromContext(ContextWithRequestID(ctx, "abc")); id != "abc" {
+		t.Fatalf("RequestIDFromContext() = %q, want abc", id)
+	}
+
+	// Without the middleware the Context falls back to the request's
+	// context.Context
+	r := NewRouter()
+	r.GET("/", func(c *Context) {
+		c.String(http.StatusOK, "%s", c.RequestID())
+	})
+	r.Use(func(next HandlerFunc) HandlerFunc {
+		return func(c *Context) {
+			c.Request = c.Request.WithContext(ContextWithRequestID(c.Request.Context(), "upstream"))
+			next(c)
+		}
+	})
+	routertest.New(t, r).GET("/").Expect().BodyEquals("upstream")
+}
+
+func TestRequestIDLogHandler(t *testing.T) {
+	var out bytes.Buffer
+	logger := slog.New(NewRequestIDLogHandler(slog.NewTextHandler(&out, nil)))
+	ctx := ContextWithRequestID(context.Background(), "req-1")
+
+	tests := []struct {
+		name string
+		log  func()
+		want string
+	}{
+		{"with ID", func() { logger.InfoContext(ctx, "hello") }, "msg=hello request_id=req-1\n"},
+		{"without ID", func() { logger.InfoContext(context.Background(), "hello") }, "msg=hello\n"},
+		{"explicit ID kept", func() { logger.InfoContext(ctx, "hello", "request_id", "other") }, "msg=hello request_id=other\n"},
+		{"with attrs", func() { logger.With("service", "api").InfoContext(ctx, "hello") }, "msg=hello service=api request_id=req-1\n"},
+		{"with group", func() { logger.WithGroup("http").InfoContext(ctx, "hello", "status", 200) }, "msg=hello http.status=200 http.request_id=req-1\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out.Reset()
+			tt.log()
+			if !strings.HasSuffix(out.String(), tt.want) {
+				t.Fatalf("log %q does not end with %q", out.String(), tt.want)
+			}
+		})
+	}
+}
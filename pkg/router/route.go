@@ -0,0 +1,107 @@
+package router
+
+import (
+	"reflect"
+	"time"
+)
+
+// Route describes a registered route. The registration methods return the
+// route so documentation metadata can be attached to it:
+//
+//	users.GET("/:id", router.Typed(getUser)).
+//		Doc("Get a user", "Returns a single user by email").
+//		Tag("users")
+type Route struct {
+	Method string
+	Path   string
+	Meta   RouteMeta
+}
+
+// RouteMeta holds the documentation metadata of a route
+type RouteMeta struct {
+	OperationID string
+	Summary     string
+	Description string
+	Tags        []string
+	Deprecated  bool
+	// Hidden routes are left out of generated documents
+	Hidden bool
+	// RequestType and ResponseType are set automatically for typed handlers
+	RequestType  reflect.Type
+	ResponseType reflect.Type
+	// Responses holds additional response types keyed by status code
+	Responses map[int]reflect.Type
+	// Authorization lists the requirements enforced by Authorize
+	Authorization Authorization
+	// CSRFExempt routes are not checked by the CSRF middleware
+	CSRFExempt bool
+	// MaxBodySize overrides the request body limit of BodyLimit and
+	// Decompress when positive
+	MaxBodySize int64
+	// Timeout overrides the duration of the Timeout middleware when
+	// positive
+	Timeout time.Duration
+	// MaxConcurrency overrides the per-route limit of ConcurrencyLimiter
+	// when positive
+	MaxConcurrency int
+}
+
+// Doc sets the summary and description of the route
+func (rt *Route) Doc(summary, description string) *Route {
+	rt.Meta.Summary = summary
+	rt.Meta.Description = description
+	return rt
+}
+
+// Tag adds tags used to group the route in generated documents
+func (rt *Route) Tag(tags ...string) *Route {
+	rt.Meta.Tags = append(rt.Meta.Tags, tags...)
+	return rt
+}
+
+// Name sets the operation ID of the route
+func (rt *Route) Name(operationID string) *Route {
+	rt.Meta.OperationID = operationID
+	return rt
+}
+
+// Deprecate marks the route as deprecated
+func (rt *Route) Deprecate() *Route {
+	rt.Meta.Deprecated = true
+	return rt
+}
+
+// Hide leaves the route out of generated documents
+func (rt *Route) Hide() *Route {
+	rt.Meta.Hidden = true
+	return rt
+}
+
+// Request sets the request body type from an example value such as User{}
+func (rt *Route) Request(v interface{}) *Route {
+	rt.Meta.RequestType = reflect.TypeOf(v)
+	return rt
+}
+
+// Response sets the response type for a status code from an example value.
+// A nil value documents a response without a body.
+func (rt *Route) Response(code int, v interface{}) *Route {
+	if rt.Meta.Responses == nil {
+		rt.Meta.Responses = make(map[int]reflect.Type)
+	}
+	rt.Meta.Responses[code] = reflect.TypeOf(v)
+	return rt
+}
+
+// setHandlerTypes copies the request and response types of the last typed
+// handler in handlers into the route metadata
+func (rt *Route) setHandlerTypes(handlers ...Handler) *Route {
+	for i := len(handlers) - 1; i >= 0; i-- {
+		if th, ok := handlers[i].(*TypedHandler); ok {
+			rt.Meta.RequestType = th.RequestType()
+			rt.Meta.ResponseType = th.ResponseType()
+			break
+		}
+	}
+	return rt
+}
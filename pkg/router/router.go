@@ -3,12 +3,59 @@ package router
 import (
 	"fmt"
 	"net/http"
+	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 )
 
 type HandlerFunc func(*Context)
 
+// Handler is any value accepted by the route registration methods:
+// a HandlerFunc, a HandlerE, a *TypedHandler, or a plain func(*Context) or
+// func(*Context) error. Group and route middleware may also be a
+// MiddlewareFunc.
+type Handler interface{}
+
+// toHandlerFunc converts a Handler into a HandlerFunc, panicking on unsupported types
+func toHandlerFunc(h Handler) HandlerFunc {
+	switch h := h.(type) {
+	case HandlerFunc:
+		return h
+	case func(*Context):
+		return h
+	case HandlerE:
+		return h.HandlerFunc()
+	case func(*Context) error:
+		return HandlerE(h).HandlerFunc()
+	case *TypedHandler:
+		return h.HandlerFunc()
+	default:
+		panic(fmt.Sprintf("router: unsupported handler type %T", h))
+	}
+}
+
+// toMiddleware converts a Handler used as middleware into a MiddlewareFunc.
+// MiddlewareFuncs are returned as is; other handlers run before the next
+// one, which is skipped if they abort.
+func toMiddleware(h Handler) MiddlewareFunc {
+	switch m := h.(type) {
+	case MiddlewareFunc:
+		return m
+	case func(HandlerFunc) HandlerFunc:
+		return m
+	}
+	handler := toHandlerFunc(h)
+	return func(next HandlerFunc) HandlerFunc {
+		return func(c *Context) {
+			handler(c)
+			if !c.IsAborted() {
+				next(c)
+			}
+		}
+	}
+}
+
 func NewHandlerCache() *HandlerCache {
 	return &HandlerCache{
 		cache: make(map[string]CachedHandler),
@@ -18,6 +65,7 @@ func NewHandlerCache() *HandlerCache {
 type node struct {
 	children   map[string]*node
 	handler    map[string]HandlerFunc
+	routes     map[string]*Route
 	paramName  string
 	isParam    bool
 	isWildcard bool
@@ -28,7 +76,10 @@ type Router struct {
 	middlewares      []MiddlewareFunc
 	notFound         HandlerFunc
 	methodNotAllowed HandlerFunc
+	errorHandler     ErrorHandlerFunc
 	cache            *HandlerCache
+	routes           []*Route
+	clientIP         atomic.Pointer[clientIPConfig]
 }
 
 type CachedHandler struct {
@@ -55,10 +106,11 @@ func (hc *HandlerCache) Set(path string, handler HandlerFunc, params map[string]
 }
 
 func NewRouter() *Router {
-	return &Router{
+	r := &Router{
 		tree: &node{
 			children: make(map[string]*node),
 			handler:  make(map[string]HandlerFunc),
+			routes:   make(map[string]*Route),
 		},
 		notFound: func(c *Context) {
 			c.String(http.StatusNotFound, "404 page not found")
@@ -66,11 +118,14 @@ func NewRouter() *Router {
 		methodNotAllowed: func(c *Context) {
 			c.String(http.StatusMethodNotAllowed, "405 method not allowed")
 		},
-		cache: NewHandlerCache(),
+		errorHandler: DefaultErrorHandler,
+		cache:        NewHandlerCache(),
 	}
+	r.clientIP.Store(&clientIPConfig{headers: DefaultClientIPHeaders})
+	return r
 }
 
-func (r *Router) addRoute(method, path string, handlers ...HandlerFunc) {
+func (r *Router) addRoute(method, path string, handlers ...HandlerFunc) *Route {
 	parts := strings.Split(strings.Trim(path, "/"), "/")
 	current := r.tree
 	for i, part := range parts {
@@ -84,6 +139,7 @@ func (r *Router) addRoute(method, path string, handlers ...HandlerFunc) {
 				current.children["*param"] = &node{
 					children: make(map[string]*node),
 					handler:  make(map[string]HandlerFunc),
+					routes:   make(map[string]*Route),
 				}
 			}
 			current = current.children["*param"]
@@ -98,6 +154,7 @@ func (r *Router) addRoute(method, path string, handlers ...HandlerFunc) {
 				current.children[part] = &node{
 					children: make(map[string]*node),
 					handler:  make(map[string]HandlerFunc),
+					routes:   make(map[string]*Route),
 				}
 			}
 			current = current.children[part]
@@ -117,26 +174,53 @@ func (r *Router) addRoute(method, path string, handlers ...HandlerFunc) {
 			}
 		}
 	}
+
+	route := &Route{Method: method, Path: path}
+	current.routes[method] = route
+	r.routes = append(r.routes, route)
+	return route
+}
+
+func (r *Router) GET(path string, handler Handler) *Route {
+	return r.addRoute(http.MethodGet, path, toHandlerFunc(handler)).setHandlerTypes(handler)
+}
+
+func (r *Router) POST(path string, handler Handler) *Route {
+	return r.addRoute(http.MethodPost, path, toHandlerFunc(handler)).setHandlerTypes(handler)
 }
 
-func (r *Router) GET(path string, handler HandlerFunc) {
-	r.addRoute(http.MethodGet, path, handler)
+func (r *Router) PUT(path string, handler Handler) *Route {
+	return r.addRoute(http.MethodPut, path, toHandlerFunc(handler)).setHandlerTypes(handler)
 }
 
-func (r *Router) POST(path string, handler HandlerFunc) {
-	r.addRoute(http.MethodPost, path, handler)
+func (r *Router) DELETE(path string, handler Handler) *Route {
+	return r.addRoute(http.MethodDelete, path, toHandlerFunc(handler)).setHandlerTypes(handler)
 }
 
-func (r *Router) PUT(path string, handler HandlerFunc) {
-	r.addRoute(http.MethodPut, path, handler)
+func (r *Router) PATCH(path string, handler Handler) *Route {
+	return r.addRoute(http.MethodPatch, path, toHandlerFunc(handler)).setHandlerTypes(handler)
 }
 
-func (r *Router) DELETE(path string, handler HandlerFunc) {
-	r.addRoute(http.MethodDelete, path, handler)
+// SetErrorHandler sets the handler used for errors returned by a HandlerE
+// or passed to Context.Error
+func (r *Router) SetErrorHandler(handler ErrorHandlerFunc) {
+	r.errorHandler = handler
 }
 
-func (r *Router) PATCH(path string, handler HandlerFunc) {
-	r.addRoute(http.MethodPatch, path, handler)
+// Routes returns every route registered on the router in registration order
+func (r *Router) Routes() []*Route {
+	return r.routes
+}
+
+// NotFound sets the handler for requests that match no route
+func (r *Router) NotFound(handler Handler) {
+	r.notFound = toHandlerFunc(handler)
+}
+
+// MethodNotAllowed sets the handler for requests whose path matches a
+// route but whose method does not
+func (r *Router) MethodNotAllowed(handler Handler) {
+	r.methodNotAllowed = toHandlerFunc(handler)
 }
 
 func (r *Router) Group(prefix string) *RouterGroup {
@@ -150,7 +234,9 @@ func (r *Router) Use(middleware ...MiddlewareFunc) {
 	r.middlewares = append(r.middlewares, middleware...)
 }
 
-func (r *Router) find(method, path string) (HandlerFunc, map[string]string) {
+// lookup walks the tree and returns the node matching path with its params,
+// or nil if no route matches
+func (r *Router) lookup(path string) (*node, map[string]string) {
 	parts := strings.Split(strings.Trim(path, "/"), "/")
 	current := r.tree
 	params := make(map[string]string)
@@ -162,20 +248,37 @@ func (r *Router) find(method, path string) (HandlerFunc, map[string]string) {
 			params[current.paramName] = part
 			current = current.children["*param"]
 		} else if current.isWildcard {
-			if handler, ok := current.handler[method]; ok {
-				return handler, params
-			}
-			return nil, params
+			return current, params
 		} else {
-			fmt.Println("No match found")
 			return nil, nil
 		}
 	}
+	return current, params
+}
 
+func (r *Router) find(method, path string) (HandlerFunc, map[string]string, *Route) {
+	current, params := r.lookup(path)
+	if current == nil {
+		return nil, nil, nil
+	}
 	if handler, ok := current.handler[method]; ok {
-		return handler, params
+		return handler, params, current.routes[method]
 	}
-	return nil, params
+	return nil, params, nil
+}
+
+// AllowedMethods returns the sorted methods that have a route for path
+func (r *Router) AllowedMethods(path string) []string {
+	current, _ := r.lookup(path)
+	if current == nil {
+		return nil
+	}
+	methods := make([]string, 0, len(current.handler))
+	for method := range current.handler {
+		methods = append(methods, method)
+	}
+	sort.Strings(methods)
+	return methods
 }
 
 func (r *Router) ServeHTTP(w http.ResponseWriter, req *http.Request) {
@@ -183,22 +286,30 @@ func (r *Router) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	var params map[string]string
 
 	c := newContext(w, req)
+	c.router = r
 
 	// If not in cache, find the handler and params
-	handler, params = r.find(req.Method, req.URL.Path)
+	handler, params, c.route = r.find(req.Method, req.URL.Path)
 	// Cache the handler and params for future use
 	//r.cache.Set(req.URL.Path, handler, params)
 
 	c.Params = params
 
+	// Unmatched requests still run through the middleware so that CORS
+	// preflights, logging and the like see them
 	if handler == nil {
-		r.methodNotAllowed(c)
-		return
+		if allowed := r.AllowedMethods(req.URL.Path); len(allowed) > 0 {
+			c.SetHeader("Allow", strings.Join(allowed, ", "))
+			handler = r.methodNotAllowed
+		} else {
+			handler = r.notFound
+		}
 	}
 
 	if handler = r.applyMiddleware(handler); handler != nil {
 		handler(c)
 	}
+	c.writer.runBeforeWrite()
 }
 
 func (r *Router) applyMiddleware(handler HandlerFunc) HandlerFunc {
@@ -0,0 +1,120 @@
+package router
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/sys-apps-go/gorouter/pkg/router/routertest"
+)
+
+// trace returns a middleware that appends name to the X-Trace response
+// header before and after the rest of the chain
+func trace(name string) MiddlewareFunc {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(c *Context) {
+			c.Writer.Header().Add("X-Trace", name)
+			next(c)
+			c.Writer.Header().Add("X-Trace", "/"+name)
+		}
+	}
+}
+
+func TestMiddlewareChain(t *testing.T) {
+	r := NewRouter()
+	r.Use(trace("router"))
+
+	api := r.Group("/api")
+	api.Use(trace("group"), func(c *Context) {
+		c.Writer.Header().Add("X-Trace", "handler-mw")
+		if c.Query("deny") != "" {
+			c.AbortWithStatus(http.StatusForbidden)
+		}
+	})
+	api.GET("/items", func(c *Context) {
+		c.Writer.Header().Add("X-Trace", "items")
+		c.Status(http.StatusOK)
+	})
+	api.GET("/route", trace("route"), func(c *Context) {
+		c.Writer.Header().Add("X-Trace", "route-handler")
+		c.Status(http.StatusOK)
+	})
+	r.GET("/plain", func(c *Context) {
+		c.Writer.Header().Add("X-Trace", "plain")
+		c.Status(http.StatusOK)
+	})
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		status int
+		trace  string
+	}{
+		{"group", http.MethodGet, "/api/items", http.StatusOK, "router,group,handler-mw,items"},
+		{"route middleware", http.MethodGet, "/api/route", http.StatusOK, "router,group,handler-mw,route,route-handler"},
+		{"abort", http.MethodGet, "/api/items?deny=1", http.StatusForbidden, "router,group,handler-mw"},
+		{"router only", http.MethodGet, "/plain", http.StatusOK, "router,plain"},
+		{"not found", http.MethodGet, "/missing", http.StatusNotFound, "router"},
+		{"method not allowed", http.MethodPost, "/plain", http.StatusMethodNotAllowed, "router"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := routertest.New(t, r).Request(tt.method, tt.path).Expect().Status(tt.status)
+			// Headers added after the status was written are not sent
+			if got := strings.Join(resp.Response.Header.Values("X-Trace"), ","); got != tt.trace {
+				t.Fatalf("X-Trace = %q, want %q", got, tt.trace)
+			}
+		})
+	}
+
+	routertest.New(t, r).POST("/plain").Expect().Header("Allow", "GET")
+}
+
+func TestSecureOnGroup(t *testing.T) {
+	r := NewRouter()
+	r.Use(Secure())
+	admin := r.Group("/admin")
+	admin.Use(SecureWithConfig(SecureConfig{
+		FrameOptions:          "SAMEORIGIN",
+		ContentSecurityPolicy: "script-src 'nonce-{nonce}'",
+	}))
+	admin.GET("/page", func(c *Context) {
+		c.String(http.StatusOK, "%s", c.CSPNonce())
+	})
+	r.GET("/page", func(c *Context) {
+		c.String(http.StatusOK, "%s", c.CSPNonce())
+	})
+	internal := r.Group("/internal")
+	internal.Use(SecureWithConfig(SecureConfig{AllowedHosts: []string{"internal.example.com"}}))
+	internal.GET("/status", func(c *Context) {
+		c.Status(http.StatusNoContent)
+	})
+
+	rt := routertest.New(t, r)
+	rt.GET("/page").Expect().
+		Status(http.StatusOK).
+		Header("X-Frame-Options", "DENY").
+		Header("X-Content-Type-Options", "nosniff").
+		NoHeader("Content-Security-Policy").
+		NoHeader("Strict-Transport-Security").
+		BodyEquals("")
+
+	resp := rt.GET("/admin/page").Expect().
+		Status(http.StatusOK).
+		Header("X-Frame-Options", "SAMEORIGIN")
+	nonce := string(resp.Body())
+	if nonce == "" {
+		t.Fatal("no CSP nonce")
+	}
+	resp.Header("Content-Security-Policy", "script-src 'nonce-"+nonce+"'")
+	if again := rt.GET("/admin/page").Expect().Body(); string(again) == nonce {
+		t.Fatal("CSP nonce reused between requests")
+	}
+
+	rt.GET("https://example.com/page").Expect().
+		Header("Strict-Transport-Security", "max-age=31536000; includeSubDomains")
+
+	rt.GET("/internal/status").Expect().Status(http.StatusBadRequest)
+	rt.GET("http://internal.example.com/internal/status").Expect().Status(http.StatusNoContent)
+}
@@ -0,0 +1,80 @@
+package routertest
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// lookup evaluates a JSONPath expression against a decoded JSON document.
+// It supports the root $, .name, ['name'] and [index] with negative indexes
+// counting from the end.
+func lookup(doc interface{}, path string) (interface{}, error) {
+	rest, ok := strings.CutPrefix(strings.TrimSpace(path), "$")
+	if !ok {
+		return nil, fmt.Errorf("path must start with $")
+	}
+
+	current := doc
+	for rest != "" {
+		var key string
+		index, isIndex := 0, false
+
+		switch rest[0] {
+		case '.':
+			rest = rest[1:]
+			end := strings.IndexAny(rest, ".[")
+			if end < 0 {
+				end = len(rest)
+			}
+			key, rest = rest[:end], rest[end:]
+			if key == "" {
+				return nil, fmt.Errorf("empty field name")
+			}
+		case '[':
+			end := strings.Index(rest, "]")
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated [")
+			}
+			token := strings.TrimSpace(rest[1:end])
+			rest = rest[end+1:]
+			if len(token) >= 2 && (token[0] == '\'' || token[0] == '"') && token[len(token)-1] == token[0] {
+				key = token[1 : len(token)-1]
+			} else {
+				n, err := strconv.Atoi(token)
+				if err != nil {
+					return nil, fmt.Errorf("invalid index %q", token)
+				}
+				index, isIndex = n, true
+			}
+		default:
+			return nil, fmt.Errorf("unexpected %q", rest)
+		}
+
+		if isIndex {
+			list, ok := current.([]interface{})
+			if !ok {
+				return nil, fmt.Errorf("cannot index %T", current)
+			}
+			if index < 0 {
+				index += len(list)
+			}
+			if index < 0 || index >= len(list) {
+				return nil, fmt.Errorf("index %d out of range (length %d)", index, len(list))
+			}
+			current = list[index]
+			continue
+		}
+
+		object, ok := current.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("cannot select field %q of %T", key, current)
+		}
+		value, ok := object[key]
+		if !ok {
+			return nil, fmt.Errorf("field %q not found", key)
+		}
+		current = value
+	}
+	return current, nil
+}
@@ -0,0 +1,71 @@
+package routertest
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLookup(t *testing.T) {
+	var doc interface{}
+	err := json.Unmarshal([]byte(`{
+		"users": [{"name": "John", "tags": ["a", "b"]}, {"name": "Jane", "tags": []}],
+		"content-type": "json",
+		"a.b": 1,
+		"nested": {"empty": null, "zero": 0}
+	}`), &doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path string
+		want interface{}
+		err  string
+	}{
+		{path: "$", want: doc},
+		{path: " $ ", want: doc},
+		{path: "$.users[0].name", want: "John"},
+		{path: "$.users[1].name", want: "Jane"},
+		{path: "$.users[-1].name", want: "Jane"},
+		{path: "$.users[0].tags[-2]", want: "a"},
+		{path: "$.users[ 0 ].tags[1]", want: "b"},
+		{path: "$['content-type']", want: "json"},
+		{path: `$["content-type"]`, want: "json"},
+		{path: "$['a.b']", want: float64(1)},
+		{path: "$.nested['empty']", want: nil},
+		{path: "$.nested.zero", want: float64(0)},
+		{path: "$.users[1].tags", want: []interface{}{}},
+		{path: "users", err: "path must start with $"},
+		{path: "$.", err: "empty field name"},
+		{path: "$..name", err: "empty field name"},
+		{path: "$.users[0", err: "unterminated ["},
+		{path: "$.users[x]", err: `invalid index "x"`},
+		{path: "$.users['x]", err: `invalid index "'x"`},
+		{path: "$.users[2]", err: "index 2 out of range (length 2)"},
+		{path: "$.users[-3]", err: "index -1 out of range (length 2)"},
+		{path: "$.missing", err: `field "missing" not found`},
+		{path: "$.users.name", err: `cannot select field "name" of []interface {}`},
+		{path: "$.nested[0]", err: "cannot index map[string]interface {}"},
+		{path: "$.users[0].name.first", err: `cannot select field "first" of string`},
+		{path: "$users", err: `unexpected "users"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			got, err := lookup(doc, tt.path)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("lookup() error = %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("lookup() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
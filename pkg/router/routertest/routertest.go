@@ -0,0 +1,367 @@
+// Package routertest exercises an http.Handler such as *router.Router
+// in-process, without starting a server.
+//
+//	rt := routertest.New(t, r)
+//	rt.GET("/api/users").Expect().
+//		Status(http.StatusOK).
+//		JSONPath("$.users[0].name", "John")
+//
+// A Client keeps cookies between requests, so a sequence of requests made
+// with the same client behaves like a browser session.
+package routertest
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// DefaultBaseURL is the URL requests are made against when none is set
+const DefaultBaseURL = "http://example.com"
+
+// Client sends requests to a handler and records the responses
+type Client struct {
+	t       testing.TB
+	handler http.Handler
+	baseURL *url.URL
+	jar     http.CookieJar
+	header  http.Header
+}
+
+// New returns a client for handler with an empty cookie jar
+func New(t testing.TB, handler http.Handler) *Client {
+	t.Helper()
+	base, _ := url.Parse(DefaultBaseURL)
+	return &Client{
+		t:       t,
+		handler: handler,
+		baseURL: base,
+		jar:     newJar(t),
+		header:  make(http.Header),
+	}
+}
+
+// newJar returns an empty cookie jar
+func newJar(t testing.TB) http.CookieJar {
+	t.Helper()
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatalf("routertest: creating cookie jar: %v", err)
+	}
+	return jar
+}
+
+// Session returns a client for the same handler and default headers with a
+// new, empty cookie jar
+func (cl *Client) Session() *Client {
+	cl.t.Helper()
+	return &Client{
+		t:       cl.t,
+		handler: cl.handler,
+		baseURL: cl.baseURL,
+		jar:     newJar(cl.t),
+		header:  cl.header.Clone(),
+	}
+}
+
+// BaseURL sets the scheme and host requests are made against
+func (cl *Client) BaseURL(rawURL string) *Client {
+	cl.t.Helper()
+	base, err := url.Parse(rawURL)
+	if err != nil {
+		cl.t.Fatalf("routertest: invalid base URL %q: %v", rawURL, err)
+	}
+	cl.baseURL = base
+	return cl
+}
+
+// SetHeader sets a header sent with every request of the client
+func (cl *Client) SetHeader(key, value string) *Client {
+	cl.header.Set(key, value)
+	return cl
+}
+
+// Cookies returns the cookies the client would send to path
+func (cl *Client) Cookies(path string) []*http.Cookie {
+	return cl.jar.Cookies(cl.baseURL.ResolveReference(&url.URL{Path: path}))
+}
+
+// GET starts a GET request
+func (cl *Client) GET(path string) *Request {
+	return cl.Request(http.MethodGet, path)
+}
+
+// POST starts a POST request
+func (cl *Client) POST(path string) *Request {
+	return cl.Request(http.MethodPost, path)
+}
+
+// PUT starts a PUT request
+func (cl *Client) PUT(path string) *Request {
+	return cl.Request(http.MethodPut, path)
+}
+
+// PATCH starts a PATCH request
+func (cl *Client) PATCH(path string) *Request {
+	return cl.Request(http.MethodPatch, path)
+}
+
+// DELETE starts a DELETE request
+func (cl *Client) DELETE(path string) *Request {
+	return cl.Request(http.MethodDelete, path)
+}
+
+// HEAD starts a HEAD request
+func (cl *Client) HEAD(path string) *Request {
+	return cl.Request(http.MethodHead, path)
+}
+
+// OPTIONS starts an OPTIONS request
+func (cl *Client) OPTIONS(path string) *Request {
+	return cl.Request(http.MethodOptions, path)
+}
+
+// Request starts a request with the given method and path. The path may
+// include a query string.
+func (cl *Client) Request(method, path string) *Request {
+	cl.t.Helper()
+	ref, err := url.Parse(path)
+	if err != nil {
+		cl.t.Fatalf("routertest: invalid path %q: %v", path, err)
+	}
+	return &Request{
+		client: cl,
+		method: method,
+		url:    cl.baseURL.ResolveReference(ref),
+		header: cl.header.Clone(),
+	}
+}
+
+// Request is a request being built. Its methods return the request so they
+// can be chained; Expect sends it.
+type Request struct {
+	client  *Client
+	method  string
+	url     *url.URL
+	header  http.Header
+	body    []byte
+	cookies []*http.Cookie
+}
+
+// Header sets a request header
+func (r *Request) Header(key, value string) *Request {
+	r.header.Set(key, value)
+	return r
+}
+
+// Query adds a query string parameter
+func (r *Request) Query(key, value string) *Request {
+	q := r.url.Query()
+	q.Add(key, value)
+	r.url.RawQuery = q.Encode()
+	return r
+}
+
+// Cookie adds a cookie in addition to those in the client's jar
+func (r *Request) Cookie(cookie *http.Cookie) *Request {
+	r.cookies = append(r.cookies, cookie)
+	return r
+}
+
+// JSON sets the body to v encoded as JSON
+func (r *Request) JSON(v interface{}) *Request {
+	r.client.t.Helper()
+	data, err := json.Marshal(v)
+	if err != nil {
+		r.client.t.Fatalf("routertest: encoding JSON body: %v", err)
+	}
+	return r.Body("application/json", data)
+}
+
+// Form sets the body to the URL-encoded form values
+func (r *Request) Form(values url.Values) *Request {
+	return r.Body("application/x-www-form-urlencoded", []byte(values.Encode()))
+}
+
+// Body sets the raw body and its content type
+func (r *Request) Body(contentType string, body []byte) *Request {
+	r.header.Set("Content-Type", contentType)
+	r.body = body
+	return r
+}
+
+// Expect sends the request and returns the recorded response for assertions
+func (r *Request) Expect() *Response {
+	r.client.t.Helper()
+	req := httptest.NewRequest(r.method, r.url.String(), bytes.NewReader(r.body))
+	req.Header = r.header.Clone()
+	if len(r.body) == 0 {
+		req.Body = http.NoBody
+		req.ContentLength = 0
+	}
+	for _, cookie := range r.client.jar.Cookies(r.url) {
+		req.AddCookie(cookie)
+	}
+	for _, cookie := range r.cookies {
+		req.AddCookie(cookie)
+	}
+
+	rec := httptest.NewRecorder()
+	r.client.handler.ServeHTTP(rec, req)
+	result := rec.Result()
+	r.client.jar.SetCookies(r.url, result.Cookies())
+
+	body, _ := io.ReadAll(result.Body)
+	return &Response{t: r.client.t, Response: result, body: body}
+}
+
+// Response is a recorded response. Assertion failures are reported with
+// t.Errorf so several can be checked in one chain.
+type Response struct {
+	t        testing.TB
+	Response *http.Response
+	body     []byte
+}
+
+// Body returns the raw response body
+func (r *Response) Body() []byte {
+	return r.body
+}
+
+// Decode unmarshals the JSON response body into v
+func (r *Response) Decode(v interface{}) *Response {
+	r.t.Helper()
+	if err := json.Unmarshal(r.body, v); err != nil {
+		r.t.Errorf("routertest: decoding JSON body: %v\nbody: %s", err, r.body)
+	}
+	return r
+}
+
+// Status asserts the response status code
+func (r *Response) Status(code int) *Response {
+	r.t.Helper()
+	if r.Response.StatusCode != code {
+		r.t.Errorf("routertest: status = %d, want %d\nbody: %s", r.Response.StatusCode, code, r.body)
+	}
+	return r
+}
+
+// Header asserts the value of a response header
+func (r *Response) Header(key, value string) *Response {
+	r.t.Helper()
+	if got := r.Response.Header.Get(key); got != value {
+		r.t.Errorf("routertest: header %s = %q, want %q", key, got, value)
+	}
+	return r
+}
+
+// HeaderContains asserts that a response header contains substr
+func (r *Response) HeaderContains(key, substr string) *Response {
+	r.t.Helper()
+	if got := r.Response.Header.Get(key); !strings.Contains(got, substr) {
+		r.t.Errorf("routertest: header %s = %q, want it to contain %q", key, got, substr)
+	}
+	return r
+}
+
+// NoHeader asserts that a response header is absent
+func (r *Response) NoHeader(key string) *Response {
+	r.t.Helper()
+	if values := r.Response.Header.Values(key); len(values) > 0 {
+		r.t.Errorf("routertest: header %s = %q, want none", key, values)
+	}
+	return r
+}
+
+// BodyEquals asserts the exact response body
+func (r *Response) BodyEquals(body string) *Response {
+	r.t.Helper()
+	if string(r.body) != body {
+		r.t.Errorf("routertest: body = %q, want %q", r.body, body)
+	}
+	return r
+}
+
+// BodyContains asserts that the response body contains substr
+func (r *Response) BodyContains(substr string) *Response {
+	r.t.Helper()
+	if !strings.Contains(string(r.body), substr) {
+		r.t.Errorf("routertest: body %q does not contain %q", r.body, substr)
+	}
+	return r
+}
+
+// JSON asserts that the response body is JSON equal to v
+func (r *Response) JSON(v interface{}) *Response {
+	r.t.Helper()
+	var got interface{}
+	if err := json.Unmarshal(r.body, &got); err != nil {
+		r.t.Errorf("routertest: body is not JSON: %v\nbody: %s", err, r.body)
+		return r
+	}
+	if !jsonEqual(got, v) {
+		want, _ := json.Marshal(v)
+		r.t.Errorf("routertest: JSON body = %s, want %s", r.body, want)
+	}
+	return r
+}
+
+// JSONPath asserts the value found at a JSONPath expression in the body.
+// Expressions use dot and bracket notation, such as $.users[0].name or
+// $['content-type'].
+func (r *Response) JSONPath(path string, want interface{}) *Response {
+	r.t.Helper()
+	var doc interface{}
+	if err := json.Unmarshal(r.body, &doc); err != nil {
+		r.t.Errorf("routertest: body is not JSON: %v\nbody: %s", err, r.body)
+		return r
+	}
+	got, err := lookup(doc, path)
+	if err != nil {
+		r.t.Errorf("routertest: %s: %v\nbody: %s", path, err, r.body)
+		return r
+	}
+	if !jsonEqual(got, want) {
+		gotJSON, _ := json.Marshal(got)
+		wantJSON, _ := json.Marshal(want)
+		r.t.Errorf("routertest: %s = %s, want %s", path, gotJSON, wantJSON)
+	}
+	return r
+}
+
+// Cookie asserts that the response sets a cookie with the given value
+func (r *Response) Cookie(name, value string) *Response {
+	r.t.Helper()
+	for _, cookie := range r.Response.Cookies() {
+		if cookie.Name == name {
+			if cookie.Value != value {
+				r.t.Errorf("routertest: cookie %s = %q, want %q", name, cookie.Value, value)
+			}
+			return r
+		}
+	}
+	r.t.Errorf("routertest: cookie %s not set", name)
+	return r
+}
+
+// jsonEqual compares a decoded JSON value with any Go value by round
+// tripping the latter through encoding/json
+func jsonEqual(got, want interface{}) bool {
+	data, err := json.Marshal(want)
+	if err != nil {
+		return false
+	}
+	var normalized interface{}
+	if err := json.Unmarshal(data, &normalized); err != nil {
+		return false
+	}
+	gotData, _ := json.Marshal(got)
+	wantData, _ := json.Marshal(normalized)
+	return bytes.Equal(gotData, wantData)
+}
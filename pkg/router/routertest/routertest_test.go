@@ -0,0 +1,199 @@
+package routertest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// echo is a handler that reports the request it received as JSON and
+// sets the cookies named in the set query parameter
+func echo(w http.ResponseWriter, r *http.Request) {
+	for _, spec := range r.URL.Query()["set"] {
+		// name=value;path;secure or name=;delete
+		parts := strings.Split(spec, ";")
+		name, value, _ := strings.Cut(parts[0], "=")
+		cookie := &http.Cookie{Name: name, Value: value, Path: "/"}
+		for _, attr := range parts[1:] {
+			switch {
+			case attr == "secure":
+				cookie.Secure = true
+			case attr == "delete":
+				cookie.MaxAge = -1
+			case strings.HasPrefix(attr, "/"):
+				cookie.Path = attr
+			}
+		}
+		http.SetCookie(w, cookie)
+	}
+	var cookies []string
+	for _, cookie := range r.Cookies() {
+		cookies = append(cookies, cookie.Name+"="+cookie.Value)
+	}
+	sort.Strings(cookies)
+	body, _ := io.ReadAll(r.Body)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"method":      r.Method,
+		"url":         r.URL.String(),
+		"host":        r.Host,
+		"query":       r.URL.Query(),
+		"cookies":     cookies,
+		"contentType": r.Header.Get("Content-Type"),
+		"header":      r.Header.Get("X-Test"),
+		"body":        string(body),
+	})
+}
+
+func TestCookieJar(t *testing.T) {
+	rt := New(t, http.HandlerFunc(echo))
+
+	rt.GET("/").Query("set", "session=abc").Query("set", "admin=1;/admin").Expect().
+		Status(http.StatusOK).
+		Cookie("session", "abc")
+	if got := cookieNames(rt.Cookies("/")); got != "session" {
+		t.Fatalf("cookies for / = %q, want session", got)
+	}
+	if got := cookieNames(rt.Cookies("/admin/users")); got != "admin session" {
+		t.Fatalf("cookies for /admin = %q, want admin and session", got)
+	}
+
+	// The jar sends the cookies back, scoped by path, together with
+	// cookies added to a single request
+	rt.GET("/").Expect().JSONPath("$.cookies", []string{"session=abc"})
+	rt.GET("/admin").Cookie(&http.Cookie{Name: "extra", Value: "x"}).Expect().
+		JSONPath("$.cookies", []string{"admin=1", "extra=x", "session=abc"})
+	rt.GET("/").Expect().JSONPath("$.cookies", []string{"session=abc"})
+
+	// Cookies the handler deletes leave the jar
+	rt.GET("/").Query("set", "session=;delete").Expect()
+	if got := cookieNames(rt.Cookies("/")); got != "" {
+		t.Fatalf("cookies after delete = %q, want none", got)
+	}
+
+	// Secure cookies are only sent over https
+	rt.GET("/").Query("set", "token=t;secure").Expect()
+	rt.GET("/").Expect().JSONPath("$.cookies", nil)
+	secure := New(t, http.HandlerFunc(echo)).BaseURL("https://example.com")
+	secure.GET("/").Query("set", "token=t;secure").Expect()
+	secure.GET("/").Expect().
+		JSONPath("$.cookies", []string{"token=t"}).
+		JSONPath("$.host", "example.com")
+}
+
+// cookieNames returns the sorted names of cookies separated by spaces
+func cookieNames(cookies []*http.Cookie) string {
+	names := make([]string, len(cookies))
+	for i, cookie := range cookies {
+		names[i] = cookie.Name
+	}
+	sort.Strings(names)
+	return strings.Join(names, " ")
+}
+
+func TestSession(t *testing.T) {
+	rt := New(t, http.HandlerFunc(echo)).SetHeader("X-Test", "shared")
+	rt.GET("/").Query("set", "session=abc").Expect()
+
+	other := rt.Session()
+	if got := cookieNames(other.Cookies("/")); got != "" {
+		t.Fatalf("new session has cookies %q", got)
+	}
+	other.GET("/").Expect().
+		JSONPath("$.cookies", nil).
+		JSONPath("$.header", "shared")
+
+	// The sessions keep separate jars and headers from here on
+	other.SetHeader("X-Test", "other")
+	other.GET("/").Query("set", "session=xyz").Expect()
+	rt.GET("/").Expect().
+		JSONPath("$.cookies", []string{"session=abc"}).
+		JSONPath("$.header", "shared")
+	other.GET("/").Expect().
+		JSONPath("$.cookies", []string{"session=xyz"}).
+		JSONPath("$.header", "other")
+}
+
+func TestRequestBuilders(t *testing.T) {
+	rt := New(t, http.HandlerFunc(echo))
+
+	rt.GET("/items?page=1").Query("sort", "name").Query("page", "2").Expect().
+		JSONPath("$.method", "GET").
+		JSONPath("$.query.page", []string{"1", "2"}).
+		JSONPath("$.query.sort[0]", "name")
+	rt.POST("/items").JSON(map[string]int{"n": 1}).Expect().
+		JSONPath("$.contentType", "application/json").
+		JSONPath("$.body", `{"n":1}`)
+	rt.PUT("/items").Form(url.Values{"name": {"a b"}}).Expect().
+		JSONPath("$.method", "PUT").
+		JSONPath("$.contentType", "application/x-www-form-urlencoded").
+		JSONPath("$.body", "name=a+b")
+	rt.PATCH("/items").Body("text/plain", []byte("raw")).Header("X-Test", "1").Expect().
+		JSONPath("$.body", "raw").
+		JSONPath("$.header", "1")
+	rt.DELETE("/items").Expect().JSONPath("$.method", http.MethodDelete)
+	rt.OPTIONS("/items").Expect().JSONPath("$.method", http.MethodOptions)
+	rt.HEAD("/items").Expect().JSONPath("$.method", http.MethodHead)
+	rt.Request("PURGE", "/items").Expect().JSONPath("$.method", "PURGE")
+}
+
+// recorder is a testing.TB that records failures instead of failing
+type recorder struct {
+	testing.TB
+	errors []string
+}
+
+func (r *recorder) Helper() {}
+
+func (r *recorder) Errorf(format string, args ...interface{}) {
+	r.errors = append(r.errors, fmt.Sprintf(format, args...))
+}
+
+func TestAssertions(t *testing.T) {
+	tests := []struct {
+		name   string
+		assert func(*Response)
+		fails  bool
+	}{
+		{"status", func(r *Response) { r.Status(http.StatusOK) }, false},
+		{"wrong status", func(r *Response) { r.Status(http.StatusCreated) }, true},
+		{"header", func(r *Response) { r.Header("Content-Type", "application/json") }, false},
+		{"absent header", func(r *Response) { r.Header("X-Missing", "") }, false},
+		{"wrong header", func(r *Response) { r.Header("Content-Type", "text/plain") }, true},
+		{"header contains", func(r *Response) { r.HeaderContains("Content-Type", "json") }, false},
+		{"header does not contain", func(r *Response) { r.HeaderContains("Content-Type", "xml") }, true},
+		{"no header", func(r *Response) { r.NoHeader("X-Missing") }, false},
+		{"unexpected header", func(r *Response) { r.NoHeader("Content-Type") }, true},
+		{"body contains", func(r *Response) { r.BodyContains(`"method":"GET"`) }, false},
+		{"body does not contain", func(r *Response) { r.BodyContains("POST") }, true},
+		{"wrong body", func(r *Response) { r.BodyEquals("") }, true},
+		{"json path", func(r *Response) { r.JSONPath("$.query.a[0]", "1") }, false},
+		{"json path null", func(r *Response) { r.JSONPath("$.cookies", nil) }, false},
+		{"wrong json path", func(r *Response) { r.JSONPath("$.method", "POST") }, true},
+		{"missing json path", func(r *Response) { r.JSONPath("$.nope", nil) }, true},
+		{"cookie", func(r *Response) { r.Cookie("c", "1") }, false},
+		{"wrong cookie", func(r *Response) { r.Cookie("c", "2") }, true},
+		{"missing cookie", func(r *Response) { r.Cookie("d", "1") }, true},
+		{"json", func(r *Response) {
+			var body map[string]interface{}
+			r.Decode(&body)
+			r.JSON(body)
+		}, false},
+		{"wrong json", func(r *Response) { r.JSON(map[string]string{"method": "GET"}) }, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := &recorder{TB: t}
+			resp := New(rec, http.HandlerFunc(echo)).GET("/?a=1&set=c=1").Expect()
+			tt.assert(resp)
+			if failed := len(rec.errors) > 0; failed != tt.fails {
+				t.Fatalf("failed = %v, want %v; errors: %q", failed, tt.fails, rec.errors)
+			}
+		})
+	}
+}
@@ -0,0 +1,188 @@
+package router
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cspNonceKey is the Context key of the Content-Security-Policy nonce
+const cspNonceKey = "CSPNonce"
+
+// SecureConfig configures SecureWithConfig. Empty fields leave their
+// header unset, so start from DefaultSecureConfig to keep the defaults.
+type SecureConfig struct {
+	// HSTSMaxAge sets Strict-Transport-Security on HTTPS responses
+	HSTSMaxAge            time.Duration
+	HSTSIncludeSubdomains bool
+	HSTSPreload           bool
+	// ContentSecurityPolicy is sent as Content-Security-Policy. Every
+	// {nonce} in it is replaced with a fresh nonce per request, available
+	// with c.CSPNonce().
+	ContentSecurityPolicy string
+	// CSPReportOnly sends the policy as Content-Security-Policy-Report-Only
+	CSPReportOnly bool
+	// FrameOptions is sent as X-Frame-Options, for example DENY
+	FrameOptions string
+	// ContentTypeNosniff sends X-Content-Type-Options: nosniff
+	ContentTypeNosniff bool
+	// ReferrerPolicy is sent as Referrer-Policy
+	ReferrerPolicy string
+	// PermissionsPolicy is sent as Permissions-Policy
+	PermissionsPolicy string
+	// CrossOriginOpenerPolicy, CrossOriginEmbedderPolicy and
+	// CrossOriginResourcePolicy are sent as the COOP, COEP and CORP headers
+	CrossOriginOpenerPolicy   string
+	CrossOriginEmbedderPolicy string
+	CrossOriginResourcePolicy string
+	// SSLRedirect redirects plain HTTP requests to HTTPS
+	SSLRedirect bool
+	// SSLHost is the host redirected to, default the request host
+	SSLHost string
+	// SSLProxyHeaders marks requests as HTTPS when a header has the given
+	// value, for example {"X-Forwarded-Proto": "https"} behind a proxy
+	// that terminates TLS. Only set it if the proxy overwrites the header.
+	SSLProxyHeaders map[string]string
+	// AllowedHosts lists the accepted Host header values; entries like
+	// *.example.com match subdomains. Other hosts are rejected with 400.
+	AllowedHosts []string
+}
+
+// DefaultSecureConfig is the configuration used by Secure
+var DefaultSecureConfig = SecureConfig{
+	HSTSMaxAge:                365 * 24 * time.Hour,
+	HSTSIncludeSubdomains:     true,
+	FrameOptions:              "DENY",
+	ContentTypeNosniff:        true,
+	ReferrerPolicy:            "strict-origin-when-cross-origin",
+	CrossOriginOpenerPolicy:   "same-origin",
+	CrossOriginResourcePolicy: "same-origin",
+}
+
+// Secure is a middleware that sets security headers with
+// DefaultSecureConfig
+func Secure() MiddlewareFunc {
+	return SecureWithConfig(DefaultSecureConfig)
+}
+
+// SecureWithConfig is a middleware that checks the Host header, redirects
+// to HTTPS and sets security headers. It can be used on the router and on
+// groups; a group's headers replace those set by the router.
+func SecureWithConfig(config SecureConfig) MiddlewareFunc {
+	hsts := ""
+	if config.HSTSMaxAge > 0 {
+		hsts = "max-age=" + strconv.Itoa(int(config.HSTSMaxAge.Seconds()))
+		if config.HSTSIncludeSubdomains {
+			hsts += "; includeSubDomains"
+		}
+		if config.HSTSPreload {
+			hsts += "; preload"
+		}
+	}
+	cspHeader := "Content-Security-Policy"
+	if config.CSPReportOnly {
+		cspHeader = "Content-Security-Policy-Report-Only"
+	}
+	useNonce := strings.Contains(config.ContentSecurityPolicy, "{nonce}")
+	headers := [][2]string{
+		{"X-Frame-Options", config.FrameOptions},
+		{"Referrer-Policy", config.ReferrerPolicy},
+		{"Permissions-Policy", config.PermissionsPolicy},
+		{"Cross-Origin-Opener-Policy", config.CrossOriginOpenerPolicy},
+		{"Cross-Origin-Embedder-Policy", config.CrossOriginEmbedderPolicy},
+		{"Cross-Origin-Resource-Policy", config.CrossOriginResourcePolicy},
+	}
+	if config.ContentTypeNosniff {
+		headers = append(headers, [2]string{"X-Content-Type-Options", "nosniff"})
+	}
+
+	return func(next HandlerFunc) HandlerFunc {
+		return func(c *Context) {
+			if len(config.AllowedHosts) > 0 && !hostAllowed(c.Request.Host, config.AllowedHosts) {
+				c.Error(NewHTTPError(http.StatusBadRequest, "host not allowed"))
+				return
+			}
+
+			secure := isHTTPS(c.Request, config.SSLProxyHeaders)
+			if config.SSLRedirect && !secure {
+				host := config.SSLHost
+				if host == "" {
+					host = c.Request.Host
+				}
+				code := http.StatusMovedPermanently
+				if !isSafeMethod(c.Request.Method) {
+					code = http.StatusPermanentRedirect
+				}
+				c.Redirect(code, "https://"+host+c.Request.URL.RequestURI())
+				c.Abort()
+				return
+			}
+
+			header := c.Writer.Header()
+			for _, h := range headers {
+				if h[1] != "" {
+					header.Set(h[0], h[1])
+				}
+			}
+			if hsts != "" && secure {
+				header.Set("Strict-Transport-Security", hsts)
+			}
+			if config.ContentSecurityPolicy != "" {
+				policy := config.ContentSecurityPolicy
+				if useNonce {
+					nonce := make([]byte, 16)
+					rand.Read(nonce)
+					encoded := base64.StdEncoding.EncodeToString(nonce)
+					c.Set(cspNonceKey, encoded)
+					policy = strings.ReplaceAll(policy, "{nonce}", encoded)
+				}
+				header.Set(cspHeader, policy)
+			}
+			next(c)
+		}
+	}
+}
+
+// CSPNonce returns the Content-Security-Policy nonce of the request for
+// use in <script nonce="..."> tags, or "" if the policy has none
+func (c *Context) CSPNonce() string {
+	return c.GetString(cspNonceKey)
+}
+
+// isHTTPS reports whether the request arrived over TLS, directly or as
+// reported by a trusted proxy header
+func isHTTPS(req *http.Request, proxyHeaders map[string]string) bool {
+	if req.TLS != nil {
+		return true
+	}
+	for name, value := range proxyHeaders {
+		if strings.EqualFold(req.Header.Get(name), value) {
+			return true
+		}
+	}
+	return false
+}
+
+// hostAllowed reports whether host, without its port, matches one of the
+// allowed hosts
+func hostAllowed(host string, allowed []string) bool {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.ToLower(host)
+	for _, pattern := range allowed {
+		pattern = strings.ToLower(pattern)
+		if suffix, ok := strings.CutPrefix(pattern, "*"); ok {
+			if strings.HasSuffix(host, suffix) && len(host) > len(suffix) {
+				return true
+			}
+		} else if host == pattern {
+			return true
+		}
+	}
+	return false
+}
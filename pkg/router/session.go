@@ -0,0 +1,442 @@
+package router
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// SessionKey is the Context key of the *Session set by Sessions
+const SessionKey = "Session"
+
+// flashKey is the session value holding pending flash messages
+const flashKey = "_flash"
+
+// SessionRecord is the stored form of a session
+type SessionRecord struct {
+	ID        string                 `json:"id"`
+	Values    map[string]interface{} `json:"values"`
+	CreatedAt time.Time              `json:"created_at"`
+	LastSeen  time.Time              `json:"last_seen"`
+}
+
+// SessionStore persists sessions. The token is the cookie value; stores
+// keeping sessions server side use the session ID, the cookie store the
+// encrypted record itself.
+type SessionStore interface {
+	// Load returns the record for token, or nil if there is none
+	Load(ctx context.Context, token string) (*SessionRecord, error)
+	// Save stores the record until expires and returns its token. Records
+	// with an empty ID get a new one.
+	Save(ctx context.Context, record *SessionRecord, expires time.Time) (string, error)
+	// Delete removes the session of token
+	Delete(ctx context.Context, token string) error
+}
+
+// Session holds the values of a client session. Values are stored as
+// JSON, so after a reload numbers come back as float64 and structs as
+// maps.
+type Session struct {
+	record    SessionRecord
+	token     string
+	changed   bool
+	rotated   bool
+	destroyed bool
+}
+
+// Session returns the session of the request, or nil without the Sessions
+// middleware
+func (c *Context) Session() *Session {
+	value, _ := c.Get(SessionKey)
+	session, _ := value.(*Session)
+	return session
+}
+
+// ID returns the session ID, which is empty until the session is saved
+func (s *Session) ID() string {
+	return s.record.ID
+}
+
+// CreatedAt returns when the session was created
+func (s *Session) CreatedAt() time.Time {
+	return s.record.CreatedAt
+}
+
+// Get returns a session value
+func (s *Session) Get(key string) (interface{}, bool) {
+	value, ok := s.record.Values[key]
+	return value, ok
+}
+
+// GetString returns a session value if it is a string, or ""
+func (s *Session) GetString(key string) string {
+	value, _ := s.record.Values[key].(string)
+	return value
+}
+
+// Set stores a session value
+func (s *Session) Set(key string, value interface{}) {
+	if s.record.Values == nil {
+		s.record.Values = make(map[string]interface{})
+	}
+	s.record.Values[key] = value
+	s.changed = true
+}
+
+// Delete removes a session value
+func (s *Session) Delete(key string) {
+	if _, ok := s.record.Values[key]; ok {
+		delete(s.record.Values, key)
+		s.changed = true
+	}
+}
+
+// Clear removes all session values
+func (s *Session) Clear() {
+	if len(s.record.Values) > 0 {
+		s.record.Values = nil
+		s.changed = true
+	}
+}
+
+// AddFlash adds a message that is kept until it is read with Flashes,
+// typically on the next request
+func (s *Session) AddFlash(message interface{}) {
+	flashes, _ := s.record.Values[flashKey].([]interface{})
+	s.Set(flashKey, append(flashes, message))
+}
+
+// Flashes returns and removes the pending flash messages
+func (s *Session) Flashes() []interface{} {
+	flashes, _ := s.record.Values[flashKey].([]interface{})
+	s.Delete(flashKey)
+	return flashes
+}
+
+// Rotate gives the session a new ID and invalidates the old one while
+// keeping its values. Call it on login and privilege changes to prevent
+// session fixation.
+func (s *Session) Rotate() {
+	s.rotated = true
+	s.changed = true
+}
+
+// Destroy deletes the session and expires its cookie
+func (s *Session) Destroy() {
+	s.record.Values = nil
+	s.destroyed = true
+}
+
+// SessionConfig configures Sessions
+type SessionConfig struct {
+	// Store keeps the sessions, default a new MemorySessionStore
+	Store SessionStore
+	// CookieName is the name of the session cookie, default "session"
+	CookieName string
+	// Path and Domain scope the cookie; Path defaults to "/"
+	Path   string
+	Domain string
+	// Secure sends the cookie over HTTPS only
+	Secure bool
+	// SameSite defaults to http.SameSiteLaxMode. The cookie is always
+	// HttpOnly.
+	SameSite http.SameSite
+	// IdleTimeout ends sessions that are not used for this long, default
+	// 30 minutes
+	IdleTimeout time.Duration
+	// AbsoluteTimeout ends sessions this long after they were created,
+	// however active, default 24 hours
+	AbsoluteTimeout time.Duration
+	// TouchInterval limits how often an unchanged session is saved just to
+	// extend its idle timeout, default one minute
+	TouchInterval time.Duration
+}
+
+// Sessions is a middleware that loads the session of the request from its
+// cookie and saves it before the response is sent. A session without
+// values is not stored and sets no cookie. The session is available with
+// c.Session().
+func Sessions(config SessionConfig) MiddlewareFunc {
+	if config.Store == nil {
+		config.Store = NewMemorySessionStore()
+	}
+	if config.CookieName == "" {
+		config.CookieName = "session"
+	}
+	if config.Path == "" {
+		config.Path = "/"
+	}
+	if config.SameSite == 0 {
+		config.SameSite = http.SameSiteLaxMode
+	}
+	if config.IdleTimeout <= 0 {
+		config.IdleTimeout = 30 * time.Minute
+	}
+	if config.AbsoluteTimeout <= 0 {
+		config.AbsoluteTimeout = 24 * time.Hour
+	}
+	if config.TouchInterval <= 0 {
+		config.TouchInterval = time.Minute
+	}
+
+	return func(next HandlerFunc) HandlerFunc {
+		return func(c *Context) {
+			ctx := c.Request.Context()
+			now := time.Now()
+			session := &Session{}
+			if cookie, err := c.Request.Cookie(config.CookieName); err == nil && cookie.Value != "" {
+				record, err := config.Store.Load(ctx, cookie.Value)
+				if err != nil {
+					log.Printf("Sessions: error loading session: %v", err)
+				}
+				switch {
+				case record == nil:
+				case now.Sub(record.LastSeen) > config.IdleTimeout, now.Sub(record.CreatedAt) > config.AbsoluteTimeout:
+					config.Store.Delete(ctx, cookie.Value)
+				default:
+					session.record = *record
+				}
+				session.token = cookie.Value
+			}
+			if session.record.CreatedAt.IsZero() {
+				session.record.CreatedAt = now
+			}
+			c.Set(SessionKey, session)
+
+			c.BeforeWriteHeader(func() {
+				saveSession(c, &config, session, now)
+			})
+			next(c)
+		}
+	}
+}
+
+// saveSession stores the session and sets or expires its cookie
+func saveSession(c *Context, config *SessionConfig, s *Session, now time.Time) {
+	ctx := c.Request.Context()
+	cookie := &http.Cookie{
+		Name:     config.CookieName,
+		Path:     config.Path,
+		Domain:   config.Domain,
+		Secure:   config.Secure,
+		HttpOnly: true,
+		SameSite: config.SameSite,
+	}
+	// Responses to requests carrying a session depend on the cookie
+	if s.token != "" {
+		c.Writer.Header().Add("Vary", "Cookie")
+	}
+
+	if s.destroyed || (len(s.record.Values) == 0 && s.record.ID == "") {
+		if s.token != "" {
+			if err := config.Store.Delete(ctx, s.token); err != nil {
+				log.Printf("Sessions: error deleting session: %v", err)
+			}
+			cookie.MaxAge = -1
+			http.SetCookie(c.Writer, cookie)
+		}
+		return
+	}
+	if !s.changed && now.Sub(s.record.LastSeen) < config.TouchInterval {
+		return
+	}
+
+	if s.rotated && s.token != "" {
+		if err := config.Store.Delete(ctx, s.token); err != nil {
+			log.Printf("Sessions: error deleting session: %v", err)
+		}
+		s.record.ID = ""
+	}
+	s.record.LastSeen = now
+	expires := now.Add(config.IdleTimeout)
+	if end := s.record.CreatedAt.Add(config.AbsoluteTimeout); end.Before(expires) {
+		expires = end
+	}
+	token, err := config.Store.Save(ctx, &s.record, expires)
+	if err != nil {
+		log.Printf("Sessions: error saving session: %v", err)
+		return
+	}
+	if s.token == "" {
+		c.Writer.Header().Add("Vary", "Cookie")
+	}
+	s.token = token
+	cookie.Value = token
+	cookie.Expires = expires
+	http.SetCookie(c.Writer, cookie)
+}
+
+// newSessionID returns a random 256-bit session ID
+func newSessionID() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// MemorySessionStore keeps sessions in memory. Sessions are lost on
+// restart and not shared between instances.
+type MemorySessionStore struct {
+	mu        sync.Mutex
+	sessions  map[string]memorySession
+	nextSweep time.Time
+}
+
+type memorySession struct {
+	data    []byte
+	expires time.Time
+}
+
+// NewMemorySessionStore returns an empty in-memory store
+func NewMemorySessionStore() *MemorySessionStore {
+	return &MemorySessionStore{sessions: make(map[string]memorySession)}
+}
+
+// Load returns the session with ID token
+func (s *MemorySessionStore) Load(ctx context.Context, token string) (*SessionRecord, error) {
+	s.mu.Lock()
+	entry, ok := s.sessions[token]
+	s.mu.Unlock()
+	if !ok || time.Now().After(entry.expires) {
+		return nil, nil
+	}
+	var record SessionRecord
+	if err := json.Unmarshal(entry.data, &record); err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
+
+// Save stores the record under its ID
+func (s *MemorySessionStore) Save(ctx context.Context, record *SessionRecord, expires time.Time) (string, error) {
+	if record.ID == "" {
+		record.ID = newSessionID()
+	}
+	data, err := json.Marshal(record)
+	if err != nil {
+		return "", err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	if now.After(s.nextSweep) {
+		for id, entry := range s.sessions {
+			if now.After(entry.expires) {
+				delete(s.sessions, id)
+			}
+		}
+		s.nextSweep = now.Add(time.Minute)
+	}
+	s.sessions[record.ID] = memorySession{data: data, expires: expires}
+	return record.ID, nil
+}
+
+// Delete removes the session with ID token
+func (s *MemorySessionStore) Delete(ctx context.Context, token string) error {
+	s.mu.Lock()
+	delete(s.sessions, token)
+	s.mu.Unlock()
+	return nil
+}
+
+// CookieSessionStore keeps the whole session in the cookie, encrypted and
+// authenticated with AES-256-GCM. Nothing is stored on the server, so old
+// cookies of a rotated or destroyed session stay valid until they expire,
+// and the encoded session must fit in a 4 KB cookie.
+type CookieSessionStore struct {
+	aeads []cipher.AEAD
+}
+
+// cookieSession is the sealed content of a session cookie
+type cookieSession struct {
+	Record  SessionRecord `json:"r"`
+	Expires int64         `json:"e"`
+}
+
+// NewCookieSessionStore returns a store sealing cookies with keys derived
+// from the given secrets, which must be random and at least 32 bytes
+// long. The first secret seals new cookies; the others still open old
+// ones, so secrets can be rotated.
+func NewCookieSessionStore(secrets ...[]byte) *CookieSessionStore {
+	if len(secrets) == 0 {
+		panic("router: NewCookieSessionStore requires a secret")
+	}
+	s := &CookieSessionStore{}
+	for _, secret := range secrets {
+		// Hashing does not add entropy, so short secrets stay guessable
+		if len(secret) < 32 {
+			panic("router: NewCookieSessionStore requires secrets of at least 32 bytes")
+		}
+		key := sha256.Sum256(secret)
+		block, err := aes.NewCipher(key[:])
+		if err != nil {
+			panic(err)
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			panic(err)
+		}
+		s.aeads = append(s.aeads, aead)
+	}
+	return s
+}
+
+// Load opens a session cookie; tampered and expired cookies yield nil
+func (s *CookieSessionStore) Load(ctx context.Context, token string) (*SessionRecord, error) {
+	sealed, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, nil
+	}
+	for _, aead := range s.aeads {
+		if len(sealed) < aead.NonceSize() {
+			return nil, nil
+		}
+		nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+		plain, err := aead.Open(nil, nonce, ciphertext, nil)
+		if err != nil {
+			continue
+		}
+		var cs cookieSession
+		if err := json.Unmarshal(plain, &cs); err != nil {
+			return nil, err
+		}
+		if time.Now().Unix() > cs.Expires {
+			return nil, nil
+		}
+		return &cs.Record, nil
+	}
+	return nil, nil
+}
+
+// Save seals the record into a cookie value
+func (s *CookieSessionStore) Save(ctx context.Context, record *SessionRecord, expires time.Time) (string, error) {
+	if record.ID == "" {
+		record.ID = newSessionID()
+	}
+	plain, err := json.Marshal(cookieSession{Record: *record, Expires: expires.Unix()})
+	if err != nil {
+		return "", err
+	}
+	aead := s.aeads[0]
+	nonce := make([]byte, aead.NonceSize())
+	rand.Read(nonce)
+	token := base64.RawURLEncoding.EncodeToString(aead.Seal(nonce, nonce, plain, nil))
+	if len(token) > 3800 {
+		return "", errors.New("session too large for a cookie")
+	}
+	return token, nil
+}
+
+// Delete does nothing; the middleware expires the cookie
+func (s *CookieSessionStore) Delete(ctx context.Context, token string) error {
+	return nil
+}
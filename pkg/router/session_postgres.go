@@ -0,0 +1,119 @@
+package router
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// PostgresSessionStore keeps sessions in a PostgreSQL table so they
+// survive restarts and are shared by all instances. Only a SHA-256 hash
+// of each session ID is stored, so the table contents cannot be used to
+// hijack sessions.
+type PostgresSessionStore struct {
+	*postgresTable
+}
+
+// PostgresSessionConfig configures a PostgresSessionStore
+type PostgresSessionConfig struct {
+	// Table is the name of the table, default sessions. It is created if
+	// it does not exist.
+	Table string
+	// CleanupInterval is how often expired sessions are deleted, default
+	// five minutes. A negative value disables the cleanup.
+	CleanupInterval time.Duration
+}
+
+// NewPostgresSessionStore creates the session table if needed and starts
+// the periodic cleanup of expired sessions. Call Close to stop it.
+func NewPostgresSessionStore(db *DB, config PostgresSessionConfig) (*PostgresSessionStore, error) {
+	if config.Table == "" {
+		config.Table = "sessions"
+	}
+	if config.CleanupInterval == 0 {
+		config.CleanupInterval = 5 * time.Minute
+	}
+
+	t, err := newPostgresTable(db, config.Table, func(table string) []string {
+		return []string{
+			`CREATE TABLE IF NOT EXISTS ` + table + ` (
+				id_hash    TEXT PRIMARY KEY,
+				data       JSONB NOT NULL,
+				expires_at TIMESTAMPTZ NOT NULL
+			)`,
+		}
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error creating session table: %w", err)
+	}
+	s := &PostgresSessionStore{postgresTable: t}
+	s.startCleanup("PostgresSessionStore", config.CleanupInterval, s.DeleteExpired)
+	return s, nil
+}
+
+// DeleteExpired removes expired sessions
+func (s *PostgresSessionStore) DeleteExpired(ctx context.Context) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM `+s.table+` WHERE expires_at < now()`)
+	return err
+}
+
+// hashSessionID returns the key a session is stored under
+func hashSessionID(id string) string {
+	sum := sha256.Sum256([]byte(id))
+	return hex.EncodeToString(sum[:])
+}
+
+// Load returns the session with ID token
+func (s *PostgresSessionStore) Load(ctx context.Context, token string) (*SessionRecord, error) {
+	var data []byte
+	err := s.db.QueryRowContext(ctx,
+		`SELECT data FROM `+s.table+` WHERE id_hash = $1 AND expires_at > now()`,
+		hashSessionID(token),
+	).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error loading session: %w", err)
+	}
+	var record SessionRecord
+	if err := json.Unmarshal(data, &record); err != nil {
+		return nil, err
+	}
+	record.ID = token
+	return &record, nil
+}
+
+// Save stores the record under the hash of its ID
+func (s *PostgresSessionStore) Save(ctx context.Context, record *SessionRecord, expires time.Time) (string, error) {
+	if record.ID == "" {
+		record.ID = newSessionID()
+	}
+	// The ID itself is never written to the table
+	stored := *record
+	stored.ID = ""
+	data, err := json.Marshal(stored)
+	if err != nil {
+		return "", err
+	}
+	_, err = s.db.ExecContext(ctx, `INSERT INTO `+s.table+` (id_hash, data, expires_at)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (id_hash) DO UPDATE SET data = EXCLUDED.data, expires_at = EXCLUDED.expires_at`,
+		hashSessionID(record.ID), data, expires,
+	)
+	if err != nil {
+		return "", fmt.Errorf("error saving session: %w", err)
+	}
+	return record.ID, nil
+}
+
+// Delete removes the session with ID token
+func (s *PostgresSessionStore) Delete(ctx context.Context, token string) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM `+s.table+` WHERE id_hash = $1`, hashSessionID(token))
+	return err
+}
@@ -0,0 +1,40 @@
+package router
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPostgresSessionStore(t *testing.T) {
+	db := testPostgres(t)
+	table := testTable(t, db, "sessions_test")
+	store, err := NewPostgresSessionStore(db, PostgresSessionConfig{Table: table, CleanupInterval: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	testSessionStore(t, store, true)
+
+	// Only hashes of session IDs reach the table
+	ctx := context.Background()
+	token, err := store.Save(ctx, &SessionRecord{Values: map[string]interface{}{"user": "ann"}}, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var idHash, data string
+	if err := db.QueryRowContext(ctx, `SELECT id_hash, data FROM `+store.table+` WHERE id_hash = $1`, hashSessionID(token)).Scan(&idHash, &data); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(idHash, token) || strings.Contains(data, token) {
+		t.Fatal("session ID stored in the table")
+	}
+
+	if err := store.DeleteExpired(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if record, err := store.Load(ctx, token); err != nil || record == nil {
+		t.Fatalf("DeleteExpired removed a live session: %v, %v", record, err)
+	}
+}
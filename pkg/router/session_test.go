@@ -0,0 +1,371 @@
+package router
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sys-apps-go/gorouter/pkg/router/routertest"
+)
+
+// testSessionSecret is a 32-byte secret for cookie stores
+var testSessionSecret = []byte("0123456789abcdef0123456789abcdef")
+
+// sessionRouter returns a router whose handlers read and change the
+// session as named by their path
+func sessionRouter(config SessionConfig) *Router {
+	r := NewRouter()
+	r.Use(Sessions(config))
+	r.GET("/get", func(c *Context) {
+		value, _ := c.Session().Get(c.Query("key"))
+		c.JSON(http.StatusOK, map[string]interface{}{"value": value, "id": c.Session().ID()})
+	})
+	r.POST("/set", func(c *Context) {
+		c.Session().Set(c.Query("key"), c.Query("value"))
+		c.Status(http.StatusNoContent)
+	})
+	r.POST("/count", func(c *Context) {
+		n, _ := c.Session().Get("n")
+		count, _ := n.(float64)
+		c.Session().Set("n", count+1)
+		c.Status(http.StatusNoContent)
+	})
+	r.POST("/flash", func(c *Context) {
+		c.Session().AddFlash(c.Query("message"))
+		c.Status(http.StatusNoContent)
+	})
+	r.GET("/flashes", func(c *Context) {
+		c.JSON(http.StatusOK, c.Session().Flashes())
+	})
+	r.POST("/delete", func(c *Context) {
+		c.Session().Delete(c.Query("key"))
+		c.Status(http.StatusNoContent)
+	})
+	r.POST("/clear", func(c *Context) {
+		c.Session().Clear()
+		c.Status(http.StatusNoContent)
+	})
+	r.POST("/rotate", func(c *Context) {
+		c.Session().Rotate()
+		c.Status(http.StatusNoContent)
+	})
+	r.POST("/destroy", func(c *Context) {
+		c.Session().Destroy()
+		c.Status(http.StatusNoContent)
+	})
+	return r
+}
+
+// sessionCookie returns the session cookie set by a response, or nil
+func sessionCookie(resp *routertest.Response) *http.Cookie {
+	for _, cookie := range resp.Response.Cookies() {
+		if cookie.Name == "session" {
+			return cookie
+		}
+	}
+	return nil
+}
+
+// sessionStores are the stores the middleware tests run against;
+// serverSide stores invalidate old cookies on Rotate and Destroy
+var sessionStores = []struct {
+	name       string
+	serverSide bool
+	new        func() SessionStore
+}{
+	{"memory", true, func() SessionStore { return NewMemorySessionStore() }},
+	{"cookie", false, func() SessionStore { return NewCookieSessionStore(testSessionSecret) }},
+}
+
+func TestSessions(t *testing.T) {
+	for _, store := range sessionStores {
+		t.Run(store.name, func(t *testing.T) {
+			r := sessionRouter(SessionConfig{Store: store.new(), Secure: true})
+			// The jar only sends Secure cookies over https
+			rt := routertest.New(t, r).BaseURL("https://example.com")
+
+			// Empty sessions set no cookie
+			rt.GET("/get?key=user").Expect().NoHeader("Set-Cookie").JSONPath("$.id", "")
+
+			resp := rt.POST("/set?key=user&value=ann").Expect().
+				Status(http.StatusNoContent).
+				Header("Vary", "Cookie")
+			cookie := sessionCookie(resp)
+			if cookie == nil {
+				t.Fatal("no session cookie")
+			}
+			if !cookie.HttpOnly || !cookie.Secure || cookie.SameSite != http.SameSiteLaxMode || cookie.Path != "/" {
+				t.Errorf("cookie attributes = %+v", cookie)
+			}
+			if until := time.Until(cookie.Expires); until < 29*time.Minute || until > 31*time.Minute {
+				t.Errorf("cookie expires in %v, want 30m", until)
+			}
+
+			// The session comes back with the cookie; unchanged sessions
+			// are not saved again
+			resp = rt.GET("/get?key=user").Expect().
+				JSONPath("$.value", "ann").
+				NoHeader("Set-Cookie").
+				Header("Vary", "Cookie")
+			var body struct{ ID string }
+			resp.Decode(&body)
+			if body.ID == "" {
+				t.Error("loaded session has no ID")
+			}
+
+			// Numbers are stored as JSON
+			rt.POST("/count").Expect()
+			rt.POST("/count").Expect()
+			rt.GET("/get?key=n").Expect().JSONPath("$.value", 2)
+
+			rt.POST("/delete?key=n").Expect()
+			rt.GET("/get?key=n").Expect().JSONPath("$.value", nil)
+			rt.GET("/get?key=user").Expect().JSONPath("$.value", "ann")
+
+			// Clients without the cookie get their own session
+			rt.Session().GET("/get?key=user").Expect().JSONPath("$.value", nil)
+
+			// Clearing keeps the session but none of its values
+			rt.POST("/clear").Expect()
+			rt.GET("/get?key=user").Expect().JSONPath("$.value", nil).JSONPath("$.id", body.ID)
+		})
+	}
+}
+
+func TestSessionFlashes(t *testing.T) {
+	rt := routertest.New(t, sessionRouter(SessionConfig{}))
+	rt.POST("/flash?message=saved").Expect()
+	rt.POST("/flash?message=sent").Expect()
+	rt.GET("/flashes").Expect().JSON([]string{"saved", "sent"})
+	rt.GET("/flashes").Expect().BodyEquals("null\n")
+}
+
+func TestSessionRotate(t *testing.T) {
+	for _, store := range sessionStores {
+		t.Run(store.name, func(t *testing.T) {
+			rt := routertest.New(t, sessionRouter(SessionConfig{Store: store.new()}))
+			old := sessionCookie(rt.POST("/set?key=user&value=ann").Expect())
+			var before struct{ ID string }
+			rt.GET("/get").Expect().Decode(&before)
+
+			resp := rt.POST("/rotate").Expect()
+			rotated := sessionCookie(resp)
+			if rotated == nil || rotated.Value == old.Value {
+				t.Fatalf("rotation kept the cookie %+v", rotated)
+			}
+			var after struct{ ID string }
+			rt.GET("/get?key=user").Expect().JSONPath("$.value", "ann").Decode(&after)
+			if after.ID == before.ID {
+				t.Fatal("rotation kept the session ID")
+			}
+
+			// The old cookie is invalid where the server keeps sessions
+			var want interface{}
+			if !store.serverSide {
+				want = "ann"
+			}
+			rt.Session().GET("/get?key=user").Cookie(old).Expect().JSONPath("$.value", want)
+		})
+	}
+}
+
+func TestSessionDestroy(t *testing.T) {
+	for _, store := range sessionStores {
+		t.Run(store.name, func(t *testing.T) {
+			rt := routertest.New(t, sessionRouter(SessionConfig{Store: store.new()}))
+			old := sessionCookie(rt.POST("/set?key=user&value=ann").Expect())
+
+			resp := rt.POST("/destroy").Expect()
+			if cookie := sessionCookie(resp); cookie == nil || cookie.MaxAge >= 0 {
+				t.Fatalf("cookie not expired: %+v", cookie)
+			}
+			if len(rt.Cookies("/")) != 0 {
+				t.Fatal("session cookie left in the jar")
+			}
+			rt.GET("/get?key=user").Expect().JSONPath("$.value", nil)
+
+			var want interface{}
+			if !store.serverSide {
+				want = "ann"
+			}
+			rt.Session().GET("/get?key=user").Cookie(old).Expect().JSONPath("$.value", want)
+		})
+	}
+}
+
+func TestSessionTimeouts(t *testing.T) {
+	for _, store := range sessionStores {
+		t.Run(store.name, func(t *testing.T) {
+			r := sessionRouter(SessionConfig{
+				Store:           store.new(),
+				IdleTimeout:     60 * time.Millisecond,
+				AbsoluteTimeout: 150 * time.Millisecond,
+				TouchInterval:   time.Millisecond,
+			})
+
+			// The jar would drop cookies expiring within the second, so the
+			// cookie is passed on by hand
+			get := func(cookie *http.Cookie, want interface{}) *http.Cookie {
+				t.Helper()
+				resp := routertest.New(t, r).GET("/get?key=user").Cookie(cookie).Expect().JSONPath("$.value", want)
+				return sessionCookie(resp)
+			}
+
+			// Idle sessions end
+			cookie := sessionCookie(routertest.New(t, r).POST("/set?key=user&value=ann").Expect())
+			time.Sleep(90 * time.Millisecond)
+			get(cookie, nil)
+
+			// Active sessions are extended by reads, up to the absolute
+			// timeout
+			cookie = sessionCookie(routertest.New(t, r).POST("/set?key=user&value=ann").Expect())
+			for i := 0; i < 3; i++ {
+				time.Sleep(40 * time.Millisecond)
+				if cookie = get(cookie, "ann"); cookie == nil {
+					t.Fatal("read did not extend the session")
+				}
+			}
+			time.Sleep(40 * time.Millisecond)
+			get(cookie, nil)
+		})
+	}
+}
+
+func TestSessionCookieAttributes(t *testing.T) {
+	rt := routertest.New(t, sessionRouter(SessionConfig{
+		CookieName: "sid",
+		Path:       "/app",
+		Domain:     "example.com",
+		SameSite:   http.SameSiteStrictMode,
+	}))
+	resp := rt.POST("/set?key=user&value=ann").Expect()
+	cookies := resp.Response.Cookies()
+	if len(cookies) != 1 {
+		t.Fatalf("cookies = %+v, want one", cookies)
+	}
+	cookie := cookies[0]
+	if cookie.Name != "sid" || cookie.Path != "/app" || cookie.Domain != "example.com" ||
+		cookie.SameSite != http.SameSiteStrictMode || !cookie.HttpOnly || cookie.Secure {
+		t.Fatalf("cookie = %+v", cookie)
+	}
+}
+
+func TestMemorySessionStore(t *testing.T) {
+	testSessionStore(t, NewMemorySessionStore(), true)
+}
+
+func TestCookieSessionStore(t *testing.T) {
+	testSessionStore(t, NewCookieSessionStore(testSessionSecret), false)
+
+	ctx := context.Background()
+	oldSecret := bytes.Repeat([]byte("o"), 32)
+	old := NewCookieSessionStore(oldSecret)
+	token, err := old.Save(ctx, &SessionRecord{Values: map[string]interface{}{"user": "ann"}}, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Rotated secrets still open old cookies; unrelated ones do not
+	rotated := NewCookieSessionStore(testSessionSecret, oldSecret)
+	if record, err := rotated.Load(ctx, token); err != nil || record == nil || record.Values["user"] != "ann" {
+		t.Fatalf("Load() with the old secret second = %+v, %v", record, err)
+	}
+	if record, _ := NewCookieSessionStore(testSessionSecret).Load(ctx, token); record != nil {
+		t.Fatal("Load() opened a cookie sealed with another secret")
+	}
+
+	// Tampered and garbage cookies are ignored
+	tampered := []byte(token)
+	tampered[len(tampered)/2] ^= 1
+	for _, token := range []string{string(tampered), "not base64!", "", "AAAA"} {
+		if record, err := old.Load(ctx, token); record != nil || err != nil {
+			t.Errorf("Load(%q) = %+v, %v", token, record, err)
+		}
+	}
+
+	big := &SessionRecord{Values: map[string]interface{}{"data": strings.Repeat("x", 4000)}}
+	if _, err := old.Save(ctx, big, time.Now().Add(time.Hour)); err == nil {
+		t.Fatal("Save() accepted a session too large for a cookie")
+	}
+}
+
+func TestNewCookieSessionStorePanics(t *testing.T) {
+	for name, secrets := range map[string][][]byte{
+		"no secret":           nil,
+		"short secret":        {[]byte("password")},
+		"short second secret": {testSessionSecret, testSessionSecret[:31]},
+	} {
+		t.Run(name, func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Fatal("NewCookieSessionStore did not panic")
+				}
+			}()
+			NewCookieSessionStore(secrets...)
+		})
+	}
+}
+
+// testSessionStore checks that store saves, loads, expires and, if it is
+// serverSide, deletes sessions
+func testSessionStore(t *testing.T, store SessionStore, serverSide bool) {
+	ctx := context.Background()
+	created := time.Now().Add(-time.Minute).Truncate(time.Second)
+	record := &SessionRecord{
+		Values:    map[string]interface{}{"user": "ann", "n": 1},
+		CreatedAt: created,
+		LastSeen:  created,
+	}
+	token, err := store.Save(ctx, record, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record.ID == "" || token == "" {
+		t.Fatalf("Save() gave ID %q and token %q", record.ID, token)
+	}
+
+	loaded, err := store.Load(ctx, token)
+	if err != nil || loaded == nil {
+		t.Fatalf("Load() = %v, %v", loaded, err)
+	}
+	if loaded.ID != record.ID || loaded.Values["user"] != "ann" || loaded.Values["n"] != float64(1) || !loaded.CreatedAt.Equal(created) {
+		t.Fatalf("Load() = %+v, want %+v", loaded, record)
+	}
+
+	// Saving again updates the session under the same ID
+	record.Values["user"] = "bob"
+	updated, err := store.Save(ctx, record, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if serverSide && updated != token {
+		t.Fatalf("Save() of an existing session gave token %q, want %q", updated, token)
+	}
+	if loaded, err := store.Load(ctx, updated); err != nil || loaded == nil || loaded.ID != record.ID || loaded.Values["user"] != "bob" {
+		t.Fatalf("Load() after update = %+v, %v", loaded, err)
+	}
+
+	if loaded, err := store.Load(ctx, "unknown"); err != nil || loaded != nil {
+		t.Fatalf("Load() of an unknown token = %+v, %v", loaded, err)
+	}
+
+	expired, err := store.Save(ctx, &SessionRecord{Values: map[string]interface{}{"user": "eve"}}, time.Now().Add(-2*time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded, err := store.Load(ctx, expired); err != nil || loaded != nil {
+		t.Fatalf("Load() of an expired session = %+v, %v", loaded, err)
+	}
+
+	if err := store.Delete(ctx, updated); err != nil {
+		t.Fatal(err)
+	}
+	if serverSide {
+		if loaded, err := store.Load(ctx, updated); err != nil || loaded != nil {
+			t.Fatalf("Load() after Delete = %+v, %v", loaded, err)
+		}
+	}
+}
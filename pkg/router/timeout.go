@@ -0,0 +1,300 @@
+package router
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+// TimeoutConfig configures TimeoutWithConfig
+type TimeoutConfig struct {
+	// Timeout is the time a request may take. Route.Timeout overrides it
+	// per route.
+	Timeout time.Duration
+	// StatusCode is sent when the time is up, default 503. Use 504 when
+	// the router acts as a gateway.
+	StatusCode int
+	// Message is the error message passed to the error handler, default
+	// the status text
+	Message string
+	// Skip runs requests without a deadline when it returns true
+	Skip func(*Context) bool
+	// Logger receives panics of handlers that are still running when the
+	// time is up, default slog.Default()
+	Logger *slog.Logger
+}
+
+// Timeout sets the time a route may take, overriding the duration of the
+// Timeout middleware. Zero or less keeps the middleware's duration.
+func (rt *Route) Timeout(d time.Duration) *Route {
+	rt.Meta.Timeout = d
+	return rt
+}
+
+// Timeout is a middleware that gives every request d to complete
+func Timeout(d time.Duration) MiddlewareFunc {
+	return TimeoutWithConfig(TimeoutConfig{Timeout: d})
+}
+
+// TimeoutWithConfig is a middleware that puts a deadline on the request
+// context, available with c.Context(), and runs the rest of the chain in
+// its own goroutine. Handlers should pass the context to database and
+// outbound calls so they stop when the deadline passes. The response is
+// buffered until the handler returns; if the time runs out first an
+// HTTPError with StatusCode goes to the error handler and later writes
+// by the handler fail with http.ErrHandlerTimeout. A response that was
+// flushed has been sent already, so it is cut off instead.
+//
+// The handler runs on a copy of the Context. Values it sets are copied
+// back when it finishes in time. Panics are re-raised in the calling
+// goroutine so Recover sees them; panics after the time is up are logged.
+func TimeoutWithConfig(config TimeoutConfig) MiddlewareFunc {
+	if config.Timeout <= 0 {
+		panic("router: Timeout requires a positive duration")
+	}
+	if config.StatusCode == 0 {
+		config.StatusCode = http.StatusServiceUnavailable
+	}
+	if config.Message == "" {
+		config.Message = http.StatusText(config.StatusCode)
+	}
+	if config.Logger == nil {
+		config.Logger = slog.Default()
+	}
+
+	return func(next HandlerFunc) HandlerFunc {
+		return func(c *Context) {
+			if config.Skip != nil && config.Skip(c) {
+				next(c)
+				return
+			}
+			d := config.Timeout
+			if rt := c.Route(); rt != nil && rt.Meta.Timeout > 0 {
+				d = rt.Meta.Timeout
+			}
+			ctx, cancel := context.WithTimeout(c.Request.Context(), d)
+			defer cancel()
+
+			tw := &timeoutWriter{ResponseWriter: c.Writer, ctx: ctx, header: c.Writer.Header().Clone(), status: http.StatusOK}
+			inner := c.copyWithWriter(tw)
+			inner.Request = c.Request.WithContext(ctx)
+
+			done := make(chan struct{})
+			panicked := make(chan interface{}, 1)
+			go func() {
+				defer func() {
+					if p := recover(); p != nil {
+						stack := debug.Stack()
+						tw.mu.Lock()
+						defer tw.mu.Unlock()
+						if tw.expired() {
+							// Nobody waits for the handler any more
+							if p != http.ErrAbortHandler {
+								config.Logger.Error("panic after timeout",
+									slog.String("method", inner.Request.Method),
+									slog.String("path", inner.Request.URL.Path),
+									slog.String("request_id", inner.RequestID()),
+									slog.Any("panic", p),
+									slog.String("stack", string(stack)),
+								)
+							}
+							return
+						}
+						if p != http.ErrAbortHandler {
+							p = &PanicError{Value: p, Stack: stack}
+						}
+						panicked <- p
+					}
+				}()
+				next(inner)
+				inner.writer.runBeforeWrite()
+				tw.mu.Lock()
+				tw.finished = !tw.expired()
+				tw.mu.Unlock()
+				close(done)
+			}()
+
+			select {
+			case p := <-panicked:
+				panic(p)
+			case <-done:
+			case <-ctx.Done():
+			}
+			tw.mu.Lock()
+			if tw.finished {
+				// The handler returned before the deadline, even if the
+				// deadline passed before this goroutine noticed
+				defer tw.mu.Unlock()
+				c.Keys = inner.Keys
+				c.StatusCode = inner.StatusCode
+				tw.finish()
+				return
+			}
+			tw.timedOut = true
+			committed := tw.committed
+			tw.mu.Unlock()
+			select {
+			case p := <-panicked:
+				// The handler panicked just before the deadline
+				panic(p)
+			default:
+			}
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) && !committed {
+				c.Error(NewHTTPError(config.StatusCode, config.Message))
+			} else {
+				// The client went away or the response is already under way
+				c.Abort()
+			}
+		}
+	}
+}
+
+// copyWithWriter returns a copy of the Context that writes to w, so a
+// handler can run in another goroutine without sharing mutable state
+func (c *Context) copyWithWriter(w http.ResponseWriter) *Context {
+	cp := *c
+	cp.writer = &responseWriter{ResponseWriter: w, status: http.StatusOK}
+	cp.Writer = cp.writer
+	if c.Keys != nil {
+		cp.Keys = make(map[string]interface{}, len(c.Keys))
+		for k, v := range c.Keys {
+			cp.Keys[k] = v
+		}
+	}
+	return &cp
+}
+
+// Context returns the context of the request, which carries the deadline
+// set by Timeout and is canceled when the client goes away
+func (c *Context) Context() context.Context {
+	return c.Request.Context()
+}
+
+// timeoutWriter buffers the response of a handler running under Timeout
+// and refuses writes once the time is up
+type timeoutWriter struct {
+	http.ResponseWriter
+	header http.Header
+
+	ctx context.Context
+
+	mu          sync.Mutex
+	buf         bytes.Buffer
+	status      int
+	wroteHeader bool
+	committed   bool
+	timedOut    bool
+	finished    bool
+}
+
+// expired reports whether the time is up. The handler sees the deadline
+// through the context before the middleware does, so the context decides
+// once the handler has not finished; w.mu must be held.
+func (w *timeoutWriter) expired() bool {
+	if !w.timedOut && !w.finished && w.ctx.Err() != nil {
+		w.timedOut = true
+	}
+	return w.timedOut
+}
+
+// Header returns the handler's own header map; it is copied to the
+// response when the handler finishes in time
+func (w *timeoutWriter) Header() http.Header {
+	return w.header
+}
+
+// WriteHeader records the status
+func (w *timeoutWriter) WriteHeader(code int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.expired() || w.wroteHeader {
+		return
+	}
+	w.status = code
+	w.wroteHeader = true
+}
+
+// Write buffers data, or fails with http.ErrHandlerTimeout once the time
+// is up
+func (w *timeoutWriter) Write(data []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.expired() {
+		return 0, http.ErrHandlerTimeout
+	}
+	w.wroteHeader = true
+	if w.committed {
+		return w.ResponseWriter.Write(data)
+	}
+	return w.buf.Write(data)
+}
+
+// Flush sends the response so far; from then on the timeout can no
+// longer replace it
+func (w *timeoutWriter) Flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.expired() {
+		return
+	}
+	w.commit()
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack lets the caller take over the connection
+func (w *timeoutWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return nil, nil, errors.New("router: hijacking is not supported under Timeout")
+}
+
+// Unwrap returns the underlying writer for http.ResponseController
+func (w *timeoutWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// commit copies the header and status to the response and sends the
+// buffered body; w.mu must be held
+func (w *timeoutWriter) commit() {
+	if w.committed {
+		return
+	}
+	w.committed = true
+	dst := w.ResponseWriter.Header()
+	for name := range dst {
+		if _, ok := w.header[name]; !ok {
+			delete(dst, name)
+		}
+	}
+	for name, values := range w.header {
+		dst[name] = values
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+	if w.buf.Len() > 0 {
+		w.ResponseWriter.Write(w.buf.Bytes())
+		w.buf.Reset()
+	}
+}
+
+// finish sends the response of a handler that completed in time; w.mu
+// must be held
+func (w *timeoutWriter) finish() {
+	if w.committed {
+		return
+	}
+	if !w.wroteHeader {
+		// Nothing was written; keep the headers for the default response
+		for name, values := range w.header {
+			w.ResponseWriter.Header()[name] = values
+		}
+		return
+	}
+	w.commit()
+}
@@ -0,0 +1,179 @@
+package router
+
+import (
+	"bytes"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sys-apps-go/gorouter/pkg/router/routertest"
+)
+
+// waitDone blocks until the request context ends, as a slow handler
+// passing it to a database call would
+func waitDone(c *Context) {
+	<-c.Context().Done()
+}
+
+func TestTimeout(t *testing.T) {
+	lateWrite := make(chan error, 1)
+	// handled is the value the handler left in the Context, as seen by
+	// the middleware around Timeout
+	var handled string
+
+	r := NewRouter()
+	r.Use(Recover(), func(next HandlerFunc) HandlerFunc {
+		return func(c *Context) {
+			handled = ""
+			next(c)
+			if value, ok := c.Get("handled"); ok {
+				handled = value.(string)
+			}
+		}
+	}, TimeoutWithConfig(TimeoutConfig{
+		Timeout: 20 * time.Millisecond,
+		Skip:    func(c *Context) bool { return c.Query("unbounded") != "" },
+	}))
+	r.GET("/fast", func(c *Context) {
+		c.Set("handled", "fast")
+		c.SetHeader("X-Route", "fast")
+		c.String(http.StatusCreated, "done")
+	})
+	r.GET("/deadline", func(c *Context) {
+		if _, ok := c.Context().Deadline(); !ok {
+			c.String(http.StatusInternalServerError, "no deadline")
+			return
+		}
+		c.Status(http.StatusNoContent)
+	})
+	r.GET("/slow", func(c *Context) {
+		c.Set("handled", "slow")
+		c.SetHeader("X-Route", "slow")
+		waitDone(c)
+		_, err := c.Writer.Write([]byte("too late"))
+		lateWrite <- err
+	})
+	r.GET("/sleep", func(c *Context) {
+		time.Sleep(60 * time.Millisecond)
+		c.String(http.StatusOK, "slept")
+	}).Timeout(time.Second)
+	r.GET("/flushed", func(c *Context) {
+		c.String(http.StatusOK, "partial")
+		c.Writer.(http.Flusher).Flush()
+		waitDone(c)
+	})
+	r.GET("/panic", func(c *Context) {
+		panic("boom")
+	})
+
+	tests := []struct {
+		name    string
+		path    string
+		status  int
+		body    string
+		handled string
+	}{
+		{"in time", "/fast", http.StatusCreated, "done", "fast"},
+		{"deadline on the context", "/deadline", http.StatusNoContent, "", ""},
+		{"time is up", "/slow", http.StatusServiceUnavailable, "", ""},
+		{"route override", "/sleep", http.StatusOK, "slept", ""},
+		{"skip", "/sleep?unbounded=1", http.StatusOK, "slept", ""},
+		{"flushed response is cut off", "/flushed", http.StatusOK, "partial", ""},
+		{"panic reaches Recover", "/panic", http.StatusInternalServerError, "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := routertest.New(t, r).GET(tt.path).Expect().Status(tt.status)
+			if tt.body != "" {
+				resp.BodyEquals(tt.body)
+			}
+			if tt.status == http.StatusServiceUnavailable {
+				// Headers of the abandoned handler are not sent
+				resp.JSONPath("$.error", "Service Unavailable").NoHeader("X-Route")
+			}
+			if handled != tt.handled {
+				t.Errorf("handled = %q, want %q", handled, tt.handled)
+			}
+		})
+	}
+
+	select {
+	case err := <-lateWrite:
+		if err != http.ErrHandlerTimeout {
+			t.Errorf("late write error = %v, want http.ErrHandlerTimeout", err)
+		}
+	case <-time.After(time.Second):
+		t.Error("slow handler never returned")
+	}
+}
+
+func TestTimeoutStatusCode(t *testing.T) {
+	r := NewRouter()
+	r.Use(TimeoutWithConfig(TimeoutConfig{
+		Timeout:    10 * time.Millisecond,
+		StatusCode: http.StatusGatewayTimeout,
+		Message:    "upstream took too long",
+	}))
+	r.GET("/slow", waitDone)
+
+	routertest.New(t, r).GET("/slow").Expect().
+		Status(http.StatusGatewayTimeout).
+		JSONPath("$.error", "upstream took too long")
+
+	defer func() {
+		if recover() == nil {
+			t.Error("Timeout accepted a zero duration")
+		}
+	}()
+	Timeout(0)
+}
+
+// syncBuffer is a bytes.Buffer safe for the logger and the test to share
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestTimeoutLatePanic(t *testing.T) {
+	var logs syncBuffer
+	r := NewRouter()
+	r.Use(Recover(), TimeoutWithConfig(TimeoutConfig{
+		Timeout: 10 * time.Millisecond,
+		Logger:  slog.New(slog.NewTextHandler(io.Writer(&logs), nil)),
+	}))
+	r.GET("/late", func(c *Context) {
+		waitDone(c)
+		panic("late boom")
+	})
+
+	routertest.New(t, r).GET("/late").Expect().Status(http.StatusServiceUnavailable)
+
+	deadline := time.Now().Add(time.Second)
+	for !strings.Contains(logs.String(), "panic after timeout") {
+		if time.Now().After(deadline) {
+			t.Fatalf("late panic was not logged, logs: %q", logs.String())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	for _, want := range []string{"path=/late", "late boom", "stack="} {
+		if !strings.Contains(logs.String(), want) {
+			t.Errorf("log %q does not contain %q", logs.String(), want)
+		}
+	}
+}
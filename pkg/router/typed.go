@@ -0,0 +1,87 @@
+package router
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+)
+
+// TypedHandler is a handler built by Typed. It keeps the request and
+// response types of the wrapped function so they can be inspected after
+// registration.
+type TypedHandler struct {
+	requestType  reflect.Type
+	responseType reflect.Type
+	handle       HandlerE
+}
+
+// Typed adapts fn into a handler. The request is bound into a new Req with
+// Context.Bind, checked with Validate, and passed to fn together with the
+// request context. The returned Resp is rendered with Context.Negotiate.
+//
+// The response status is 200, or 204 if Resp is an empty struct. A Resp that
+// implements StatusCoder chooses its own status. Errors from binding,
+// validation and fn are passed to the router's error handler.
+func Typed[Req, Resp any](fn func(ctx context.Context, req Req) (Resp, error)) *TypedHandler {
+	requestType := reflect.TypeOf((*Req)(nil)).Elem()
+	responseType := reflect.TypeOf((*Resp)(nil)).Elem()
+
+	return &TypedHandler{
+		requestType:  requestType,
+		responseType: responseType,
+		handle: func(c *Context) error {
+			var req Req
+			target := reflect.ValueOf(&req)
+			if requestType.Kind() == reflect.Ptr {
+				target = reflect.New(requestType.Elem())
+				reflect.ValueOf(&req).Elem().Set(target)
+			}
+			if target.Elem().Kind() == reflect.Struct {
+				if err := c.Bind(target.Interface()); err != nil {
+					return err
+				}
+				if err := Validate(target.Interface()); err != nil {
+					return err
+				}
+			}
+
+			resp, err := fn(c.Request.Context(), req)
+			if err != nil {
+				return err
+			}
+
+			if sc, ok := any(resp).(StatusCoder); ok && !isNilPointer(resp) && sc.StatusCode() != 0 {
+				c.Negotiate(sc.StatusCode(), resp)
+				return nil
+			}
+			if responseType.Kind() == reflect.Struct && responseType.NumField() == 0 {
+				c.Status(http.StatusNoContent)
+				return nil
+			}
+			c.Negotiate(http.StatusOK, resp)
+			return nil
+		},
+	}
+}
+
+// isNilPointer reports whether v is a nil pointer, whose methods may not be
+// safe to call
+func isNilPointer(v any) bool {
+	rv := reflect.ValueOf(v)
+	return rv.Kind() == reflect.Ptr && rv.IsNil()
+}
+
+// RequestType returns the type the handler binds the request into
+func (h *TypedHandler) RequestType() reflect.Type {
+	return h.requestType
+}
+
+// ResponseType returns the type the handler renders as the response
+func (h *TypedHandler) ResponseType() reflect.Type {
+	return h.responseType
+}
+
+// HandlerFunc returns the handler as a HandlerFunc
+func (h *TypedHandler) HandlerFunc() HandlerFunc {
+	return h.handle.HandlerFunc()
+}
@@ -0,0 +1,232 @@
+package router
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/sys-apps-go/gorouter/pkg/router/routertest"
+)
+
+type updateUserRequest struct {
+	ID      int           `path:"id"`
+	Notify  bool          `query:"notify"`
+	Tags    []string      `query:"tag"`
+	Timeout time.Duration `query:"timeout"`
+	Trace   string        `header:"X-Trace-Id"`
+	Name    string        `json:"name" validate:"required,max=10"`
+	Role    string        `json:"role" validate:"oneof=admin user"`
+	Age     *int          `json:"age" validate:"min=18"`
+}
+
+type userResponse struct {
+	ID      int      `json:"id"`
+	Name    string   `json:"name"`
+	Role    string   `json:"role"`
+	Notify  bool     `json:"notify"`
+	Tags    []string `json:"tags"`
+	Timeout string   `json:"timeout"`
+	Trace   string   `json:"trace"`
+}
+
+type createdResponse struct {
+	ID int `json:"id"`
+}
+
+func (createdResponse) StatusCode() int { return http.StatusCreated }
+
+// passwordRequest checks its own invariants
+type passwordRequest struct {
+	Password string `json:"password"`
+	Confirm  string `json:"confirm"`
+}
+
+func (r passwordRequest) Validate() error {
+	if r.Password != r.Confirm {
+		return errors.New("passwords do not match")
+	}
+	return nil
+}
+
+func TestTypedHandlers(t *testing.T) {
+	r := NewRouter()
+	r.PUT("/users/:id", Typed(func(ctx context.Context, req updateUserRequest) (userResponse, error) {
+		if req.ID == 404 {
+			return userResponse{}, NewHTTPError(http.StatusNotFound, "user not found")
+		}
+		return userResponse{
+			ID:      req.ID,
+			Name:    req.Name,
+			Role:    req.Role,
+			Notify:  req.Notify,
+			Tags:    req.Tags,
+			Timeout: req.Timeout.String(),
+			Trace:   req.Trace,
+		}, nil
+	}))
+	r.POST("/users", Typed(func(ctx context.Context, req *updateUserRequest) (createdResponse, error) {
+		return createdResponse{ID: 7}, nil
+	}))
+	r.DELETE("/users/:id", Typed(func(ctx context.Context, req struct {
+		ID int `path:"id"`
+	}) (struct{}, error) {
+		return struct{}{}, nil
+	}))
+	r.POST("/password", Typed(func(ctx context.Context, req passwordRequest) (*createdResponse, error) {
+		return nil, nil
+	}))
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		header map[string]string
+		body   interface{}
+		status int
+		want   string
+	}{
+		{
+			name:   "binds path, query, header and body",
+			method: http.MethodPut,
+			path:   "/users/42?notify=true&tag=a&tag=b&timeout=1m30s",
+			header: map[string]string{"X-Trace-Id": "abc"},
+			body:   map[string]interface{}{"name": "Ann", "role": "admin"},
+			status: http.StatusOK,
+			want:   `{"id":42,"name":"Ann","role":"admin","notify":true,"tags":["a","b"],"timeout":"1m30s","trace":"abc"}`,
+		},
+		{
+			name:   "invalid path param",
+			method: http.MethodPut,
+			path:   "/users/abc",
+			body:   map[string]interface{}{"name": "Ann", "role": "admin"},
+			status: http.StatusBadRequest,
+			want:   `{"error":"invalid value for id: strconv.ParseInt: parsing \"abc\": invalid syntax"}`,
+		},
+		{
+			name:   "invalid query param",
+			method: http.MethodPut,
+			path:   "/users/1?notify=maybe",
+			body:   map[string]interface{}{"name": "Ann", "role": "admin"},
+			status: http.StatusBadRequest,
+			want:   `{"error":"invalid value for notify: strconv.ParseBool: parsing \"maybe\": invalid syntax"}`,
+		},
+		{
+			name:   "invalid JSON",
+			method: http.MethodPut,
+			path:   "/users/1",
+			body:   json.RawMessage(`{"name": 1}`),
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "validation",
+			method: http.MethodPut,
+			path:   "/users/1",
+			body:   map[string]interface{}{"role": "root", "age": 12},
+			status: http.StatusBadRequest,
+			want: `{"error":"validation failed: name is required; role must be one of [admin user]; age must be at least 18",` +
+				`"fields":[{"field":"name","rule":"required","message":"name is required"},` +
+				`{"field":"role","rule":"oneof=admin user","message":"role must be one of [admin user]"},` +
+				`{"field":"age","rule":"min=18","message":"age must be at least 18"}]}`,
+		},
+		{
+			name:   "length rule",
+			method: http.MethodPut,
+			path:   "/users/1",
+			body:   map[string]interface{}{"name": "Bartholomew", "role": "user"},
+			status: http.StatusBadRequest,
+			want: `{"error":"validation failed: name must have length at most 10",` +
+				`"fields":[{"field":"name","rule":"max=10","message":"name must have length at most 10"}]}`,
+		},
+		{
+			name:   "handler error",
+			method: http.MethodPut,
+			path:   "/users/404",
+			body:   map[string]interface{}{"name": "Ann", "role": "user"},
+			status: http.StatusNotFound,
+			want:   `{"error":"user not found"}`,
+		},
+		{
+			name:   "pointer request and status coder response",
+			method: http.MethodPost,
+			path:   "/users",
+			body:   map[string]interface{}{"name": "Ann", "role": "user", "age": 30},
+			status: http.StatusCreated,
+			want:   `{"id":7}`,
+		},
+		{
+			name:   "empty response",
+			method: http.MethodDelete,
+			path:   "/users/1",
+			status: http.StatusNoContent,
+		},
+		{
+			name:   "validator",
+			method: http.MethodPost,
+			path:   "/password",
+			body:   map[string]interface{}{"password": "a", "confirm": "b"},
+			status: http.StatusBadRequest,
+			want:   `{"error":"passwords do not match"}`,
+		},
+		{
+			name:   "nil pointer response",
+			method: http.MethodPost,
+			path:   "/password",
+			body:   map[string]interface{}{"password": "a", "confirm": "a"},
+			status: http.StatusOK,
+			want:   `null`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := routertest.New(t, r).Request(tt.method, tt.path)
+			for key, value := range tt.header {
+				req.Header(key, value)
+			}
+			if tt.body != nil {
+				req.JSON(tt.body)
+			}
+			resp := req.Expect().Status(tt.status)
+			if tt.want != "" {
+				resp.JSON(json.RawMessage(tt.want))
+			}
+		})
+	}
+}
+
+func TestTypedNegotiation(t *testing.T) {
+	r := NewRouter()
+	r.GET("/user", Typed(func(ctx context.Context, req struct{}) (createdResponse, error) {
+		return createdResponse{ID: 1}, nil
+	}))
+
+	tests := []struct {
+		accept      string
+		contentType string
+	}{
+		{"", "application/json"},
+		{"application/xml", "application/xml"},
+		{"text/html, application/json;q=0.5", "application/json"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.accept, func(t *testing.T) {
+			routertest.New(t, r).GET("/user").Header("Accept", tt.accept).Expect().
+				Status(http.StatusCreated).
+				HeaderContains("Content-Type", tt.contentType)
+		})
+	}
+}
+
+func TestTypedHandlerTypes(t *testing.T) {
+	h := Typed(func(ctx context.Context, req *updateUserRequest) (userResponse, error) {
+		return userResponse{}, nil
+	})
+	if got := h.RequestType().String(); got != "*router.updateUserRequest" {
+		t.Errorf("RequestType = %s", got)
+	}
+	if got := h.ResponseType().String(); got != "router.userResponse" {
+		t.Errorf("ResponseType = %s", got)
+	}
+}
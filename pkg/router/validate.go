@@ -0,0 +1,184 @@
+package router
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Validator is implemented by types that check their own invariants.
+// Validate calls it after the `validate` struct tags have been checked.
+type Validator interface {
+	Validate() error
+}
+
+// FieldError describes a single field that failed validation
+type FieldError struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// ValidationError is returned by Validate when one or more fields are invalid
+type ValidationError struct {
+	Fields []FieldError `json:"fields"`
+}
+
+func (e *ValidationError) Error() string {
+	messages := make([]string, len(e.Fields))
+	for i, f := range e.Fields {
+		messages[i] = f.Message
+	}
+	return "validation failed: " + strings.Join(messages, "; ")
+}
+
+// add appends a field error to the validation error
+func (e *ValidationError) add(field, rule, message string) {
+	e.Fields = append(e.Fields, FieldError{Field: field, Rule: rule, Message: field + " " + message})
+}
+
+// StatusCode returns 400 so validation errors map to Bad Request
+func (e *ValidationError) StatusCode() int {
+	return http.StatusBadRequest
+}
+
+// Validate checks obj against its `validate` struct tags and, if obj
+// implements Validator, its Validate method. Supported rules are required,
+// min=N, max=N, len=N and oneof=a b c. For strings, slices and maps the
+// numeric rules apply to the length, for numbers to the value.
+func Validate(obj interface{}) error {
+	v := reflect.ValueOf(obj)
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+
+	verr := &ValidationError{}
+	if v.Kind() == reflect.Struct {
+		validateStruct(v, "", verr)
+	}
+	if len(verr.Fields) > 0 {
+		return verr
+	}
+
+	if validator, ok := obj.(Validator); ok {
+		if err := validator.Validate(); err != nil {
+			if StatusCodeOf(err) == http.StatusInternalServerError {
+				return WrapHTTPError(http.StatusBadRequest, err)
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// validateStruct checks every tagged field of v, recursing into nested structs
+func validateStruct(v reflect.Value, prefix string, verr *ValidationError) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		fv := v.Field(i)
+		name := prefix + fieldName(field)
+		if field.Anonymous {
+			name = strings.TrimSuffix(prefix, ".")
+		}
+
+		if tag := field.Tag.Get("validate"); tag != "" && tag != "-" {
+			for _, rule := range strings.Split(tag, ",") {
+				if msg := checkRule(fv, rule); msg != "" {
+					verr.add(name, rule, msg)
+				}
+			}
+		}
+
+		for fv.Kind() == reflect.Ptr && !fv.IsNil() {
+			fv = fv.Elem()
+		}
+		if fv.Kind() == reflect.Struct && fv.Type() != timeType {
+			nested := name + "."
+			if field.Anonymous {
+				nested = prefix
+			}
+			validateStruct(fv, nested, verr)
+		}
+	}
+}
+
+// checkRule returns a message describing why fv violates rule, or "" if it does not
+func checkRule(fv reflect.Value, rule string) string {
+	name, arg, _ := strings.Cut(strings.TrimSpace(rule), "=")
+	if name == "required" {
+		if fv.IsZero() {
+			return "is required"
+		}
+		return ""
+	}
+
+	if fv.Kind() == reflect.Ptr {
+		if fv.IsNil() {
+			return ""
+		}
+		fv = fv.Elem()
+	}
+
+	switch name {
+	case "min", "max", "len":
+		limit, err := strconv.ParseFloat(arg, 64)
+		if err != nil {
+			return fmt.Sprintf("has invalid rule %q", rule)
+		}
+		n, isLength := measure(fv)
+		if isLength && name == "len" && n != limit {
+			return fmt.Sprintf("must have length %s", arg)
+		}
+		if name == "min" && n < limit {
+			if isLength {
+				return fmt.Sprintf("must have length at least %s", arg)
+			}
+			return fmt.Sprintf("must be at least %s", arg)
+		}
+		if name == "max" && n > limit {
+			if isLength {
+				return fmt.Sprintf("must have length at most %s", arg)
+			}
+			return fmt.Sprintf("must be at most %s", arg)
+		}
+	case "oneof":
+		value := fmt.Sprint(fv.Interface())
+		for _, option := range strings.Fields(arg) {
+			if value == option {
+				return ""
+			}
+		}
+		return fmt.Sprintf("must be one of [%s]", arg)
+	default:
+		return fmt.Sprintf("has unknown rule %q", rule)
+	}
+	return ""
+}
+
+// measure returns the length of strings, slices and maps or the numeric value
+// of numbers; the boolean reports whether the result is a length
+func measure(fv reflect.Value) (float64, bool) {
+	switch fv.Kind() {
+	case reflect.String:
+		return float64(utf8.RuneCountInString(fv.String())), true
+	case reflect.Slice, reflect.Map, reflect.Array:
+		return float64(fv.Len()), true
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(fv.Int()), false
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(fv.Uint()), false
+	case reflect.Float32, reflect.Float64:
+		return fv.Float(), false
+	}
+	return 0, false
+}
@@ -0,0 +1,72 @@
+package router
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/http"
+)
+
+// responseWriter wraps the http.ResponseWriter of a request and records the
+// status code and number of body bytes written
+type responseWriter struct {
+	http.ResponseWriter
+	status      int
+	size        int
+	wroteHeader bool
+	// beforeWrite holds hooks run once just before the header is sent
+	beforeWrite []func()
+}
+
+// WriteHeader sends the status code; later calls are ignored
+func (w *responseWriter) WriteHeader(code int) {
+	if w.wroteHeader {
+		return
+	}
+	w.runBeforeWrite()
+	w.status = code
+	w.wroteHeader = true
+	w.ResponseWriter.WriteHeader(code)
+}
+
+// runBeforeWrite runs the pending BeforeWriteHeader hooks once
+func (w *responseWriter) runBeforeWrite() {
+	hooks := w.beforeWrite
+	w.beforeWrite = nil
+	for _, fn := range hooks {
+		fn()
+	}
+}
+
+// Write writes the body, sending a 200 status first if none was sent
+func (w *responseWriter) Write(data []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	n, err := w.ResponseWriter.Write(data)
+	w.size += n
+	return n, err
+}
+
+// Flush sends buffered data to the client if the underlying writer supports it
+func (w *responseWriter) Flush() {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack lets the caller take over the connection
+func (w *responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if h, ok := w.ResponseWriter.(http.Hijacker); ok {
+		return h.Hijack()
+	}
+	return nil, nil, fmt.Errorf("router: %T does not support hijacking", w.ResponseWriter)
+}
+
+// Unwrap returns the underlying writer for http.ResponseController
+func (w *responseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}